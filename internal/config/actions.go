@@ -0,0 +1,135 @@
+package config
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/format"
+	"github.com/UniversityRadioYork/CaptainHook/internal/github"
+)
+
+// The actions announced for each event type when Config.Events doesn't say.
+// Types not listed here have all their actions announced.
+var defaultActions = map[string][]string{
+	"pull_request": {"opened", "closed", "reopened"},
+	"issues":       {"opened", "closed", "reopened"},
+	"repository":   {"created"},
+	// Comments are chatty, so they're only announced if asked for.
+	"issue_comment":               nil,
+	"pull_request_review_comment": nil,
+	// So are workflow runs, by how they went: success, failure...
+	"workflow_run": nil,
+	// And pings, or ManageHooks setting up hooks would send one for each.
+	"ping": nil,
+}
+
+// Actions each event type can have, so we can point out typos in
+// Config.Events: those github.Events has for it, GitHub's and ours, plus
+// "conflicted" for our own conflict announcements.
+var knownActions = func() map[string][]string {
+	known := make(map[string][]string)
+	for ev, h := range github.Events {
+		known[ev] = append(append([]string(nil), h.Actions...), h.Also...)
+	}
+	known["conflict"] = []string{"conflicted"}
+	for _, actions := range known {
+		sort.Strings(actions)
+	}
+	return known
+}()
+
+// Whether an Event's action should be announced. Config.Events lists the
+// actions wanted for each type, "*" meaning all of them and "none" none; a
+// missing or empty entry means the defaults above. "closed" covers merged
+// pull requests too, "merged" covers only those.
+func (c *Config) WantsAction(e *format.Event) bool {
+	var events map[string][]string
+	if c != nil {
+		events = c.Events
+	}
+	return wantsAction(events, e)
+}
+
+func wantsAction(events map[string][]string, e *format.Event) bool {
+	wanted := events[e.Type]
+	if len(wanted) == 0 {
+		var ok bool
+		if wanted, ok = defaultActions[e.Type]; !ok {
+			return true
+		}
+	}
+	for _, a := range wanted {
+		if a == "*" || a == e.Action || (a == "merged" && e.Merged) {
+			return true
+		}
+	}
+	return false
+}
+
+func Contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Point out anything in Config.Events or Config.Verbs, or a repo's, that
+// will never match.
+func (c *Config) CheckEvents() []string {
+	warnings := append(checkEvents("Events", c.Events), checkVerbs("verbs", c.Verbs)...)
+	for _, repo := range c.RepoNames() {
+		warnings = append(warnings, checkEvents("repos."+repo+".Events", c.Repos[repo].Events)...)
+		warnings = append(warnings, checkVerbs("repos."+repo+".verbs", c.Repos[repo].Verbs)...)
+	}
+	sort.Strings(warnings)
+	return warnings
+}
+
+func checkEvents(field string, events map[string][]string) []string {
+	var warnings []string
+	for ev, actions := range events {
+		known, ok := knownActions[ev]
+		if !ok {
+			if _, ok := format.DefaultTemplates[ev]; !ok {
+				warnings = append(warnings, field+": unknown event type "+ev)
+			}
+			continue
+		}
+		for _, a := range actions {
+			if a != "*" && a != "none" && !Contains(known, a) {
+				warnings = append(warnings, field+": "+ev+" has no "+a+" action, try one of "+strings.Join(known, ", "))
+			}
+		}
+	}
+	return warnings
+}
+
+// Verbs are by action or type.action, so check those exist.
+func checkVerbs(field string, verbs map[string]string) []string {
+	var warnings []string
+	for key := range verbs {
+		ev, action := "", key
+		if i := strings.Index(key, "."); i >= 0 {
+			ev, action = key[:i], key[i+1:]
+		}
+		if ev != "" {
+			known, ok := knownActions[ev]
+			if !ok {
+				warnings = append(warnings, field+": unknown event type "+ev)
+			} else if !Contains(known, action) {
+				warnings = append(warnings, field+": "+ev+" has no "+action+" action, try one of "+strings.Join(known, ", "))
+			}
+			continue
+		}
+		found := false
+		for _, known := range knownActions {
+			found = found || Contains(known, action)
+		}
+		if !found {
+			warnings = append(warnings, field+": no event has a "+action+" action")
+		}
+	}
+	return warnings
+}
@@ -0,0 +1,88 @@
+package config
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/format"
+)
+
+func TestWantsActionDefaults(t *testing.T) {
+	c := &Config{}
+	for _, tc := range []struct {
+		e    format.Event
+		want bool
+	}{
+		{format.Event{Type: "pull_request", Action: "opened"}, true},
+		{format.Event{Type: "pull_request", Action: "closed", Merged: true}, true},
+		{format.Event{Type: "pull_request", Action: "labeled"}, false},
+		{format.Event{Type: "pull_request", Action: "synchronize"}, false},
+		{format.Event{Type: "issues", Action: "reopened"}, true},
+		{format.Event{Type: "issues", Action: "edited"}, false},
+		{format.Event{Type: "repository", Action: "created"}, true},
+		{format.Event{Type: "repository", Action: "deleted"}, false},
+		{format.Event{Type: "push", Action: "pushed"}, true},
+		{format.Event{Type: "build", Action: "FAILURE"}, true},
+	} {
+		if got := c.WantsAction(&tc.e); got != tc.want {
+			t.Errorf("%s %s: expected %v, got %v", tc.e.Type, tc.e.Action, tc.want, got)
+		}
+	}
+}
+
+func TestWantsActionConfigured(t *testing.T) {
+	c := &Config{Events: map[string][]string{
+		"pull_request": {"opened", "merged"},
+		"issues":       {"*"},
+	}}
+	for _, tc := range []struct {
+		e    format.Event
+		want bool
+	}{
+		{format.Event{Type: "pull_request", Action: "opened"}, true},
+		{format.Event{Type: "pull_request", Action: "closed", Merged: true}, true},
+		{format.Event{Type: "pull_request", Action: "closed"}, false},
+		{format.Event{Type: "issues", Action: "labeled"}, true},
+		{format.Event{Type: "repository", Action: "created"}, true},
+	} {
+		if got := c.WantsAction(&tc.e); got != tc.want {
+			t.Errorf("%s %s merged=%v: expected %v, got %v", tc.e.Type, tc.e.Action, tc.e.Merged, tc.want, got)
+		}
+	}
+}
+
+func TestCheckEvents(t *testing.T) {
+	c := &Config{Events: map[string][]string{
+		"pull_request": {"opened", "merged", "mereged"},
+		"issues":       {"*"},
+		"issue":        {"opened"},
+		"build":        {"FAILURE"},
+	}}
+	warnings := c.CheckEvents()
+	if len(warnings) != 2 {
+		t.Fatalf("expected 2 warnings, got %q", warnings)
+	}
+	all := strings.Join(warnings, "\n")
+	if !strings.Contains(all, "unknown event type issue") || !strings.Contains(all, "no mereged action") {
+		t.Errorf("unexpected warnings %q", warnings)
+	}
+}
+
+func TestCheckVerbs(t *testing.T) {
+	c := &Config{Verbs: map[string]string{
+		"synchronize":         "updated",
+		"pull_request.merged": "Landed",
+		"issues.synchronize":  "updated",
+		"isues.opened":        "Opened",
+		"frobnicated":         "frobbed",
+	}}
+	all := strings.Join(c.CheckEvents(), "\n")
+	for _, want := range []string{"issues has no synchronize", "unknown event type isues", "no event has a frobnicated"} {
+		if !strings.Contains(all, want) {
+			t.Errorf("expected a warning about %q, got %q", want, all)
+		}
+	}
+	if strings.Count(all, "\n") != 2 {
+		t.Errorf("expected 3 warnings, got %q", all)
+	}
+}
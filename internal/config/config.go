@@ -0,0 +1,460 @@
+// Package config loads and checks the config, and keeps track of the one in
+// use.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"text/template"
+	"time"
+
+	"github.com/koding/multiconfig"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/format"
+	"github.com/UniversityRadioYork/CaptainHook/internal/github"
+)
+
+// CaptainHook's config struct
+type Config struct {
+	Channels string `required:"true"`
+	Server   string `default:"chat.freenode.net:6667"`
+	Nick     string `default:"CaptHook"`
+	Ident    string `default:"capthook"`
+	Name     string `default:"The Captain"`
+	HostPort string `default:":4665"` // HTTP listen host and port, or unix:/path/to.sock
+	Join     bool   `default:"true"`
+	GHSecret string `required:"true"` // The Github webhook secret
+
+	GHSecrets []string // Further secrets to accept, to allow rotating GHSecret
+
+	GitLabToken string // Accept GitLab webhooks at /gitlab with this token
+
+	JenkinsToken  string // Accept Jenkins build notifications at /jenkins with this token
+	JenkinsNotify string `default:"all"` // all, or failures (and fixes) only
+
+	AlertmanagerUser     string `default:"alertmanager"` // Basic auth for alerts at /alertmanager
+	AlertmanagerPassword string // Alerts are accepted when this is set
+
+	GrafanaToken       string // Accept Grafana alerts at /grafana with this bearer token
+	GrafanaChannels    string // Where to announce them, if not Channels
+	GrafanaShortenURLs bool   // Shorten dashboard/panel links
+
+	SentrySecret   string   // Accept Sentry webhooks at /sentry, signed with this client secret
+	SentryChannels string   // Where to announce them, if not Channels
+	SentryEvents   []string // Which resource.actions to announce, default issue.created and error.created
+
+	MaxBodyBytes int64 `default:"5242880"` // Largest webhook body we'll read
+
+	MetricsListen string // Serve /metrics here instead of alongside the webhooks
+
+	Hooks []HookConfig // Extra named webhook endpoints, see hooks.go
+
+	RepoAllow []string // Only announce repos (owner/name) matching these globs, if any
+	RepoDeny  []string // Never announce repos matching these
+
+	IgnoreSenders        []string            // Drop anything triggered by these logins, as globs
+	IgnoreSendersByEvent map[string][]string // Replaces IgnoreSenders for an event or event.action
+
+	Events map[string][]string // Actions to announce by event type, see actions.go
+
+	ChannelSettings map[string]ChannelConfig `toml:"channel_settings" json:"channel_settings" yaml:"channel_settings"` // By channel, see schedule.go
+	Timezone        string                   // For channel schedules, e.g. Europe/London; default the system's
+	PriorityEvents  []string                 // Event types, or type.action, that ignore channel schedules and digests
+
+	DigestThreshold int           // Past this many announcements for a repo within DigestWindow, sum up the rest in one line; 0 for never, see ircbot/digest.go
+	DigestWindow    time.Duration `default:"10m"`
+
+	ShowOwner string `default:"never"` // Call repos owner/name: never, always, or ambiguous when names clash
+
+	Branches []string              // Branches to announce pushes etc. for, as globs, default all
+	Repos    map[string]RepoConfig `toml:"repos" json:"repos" yaml:"repos"` // Per-repo settings, by owner/name, or owner for all theirs
+
+	GenericHooks []GenericHookConfig `toml:"generic_hooks" json:"generic_hooks" yaml:"generic_hooks"` // Templated endpoints for anything else, see generic.go
+
+	ForwardURLs []ForwardTarget // Pass the GitHub deliveries we take on to these
+
+	RestrictToGitHubIPs bool     // Only accept deliveries from GitHub's hook addresses
+	AllowIPs            []string // Extra addresses/CIDRs to accept, e.g. for GHES
+	TrustedProxies      []string // Proxies whose X-Forwarded-For we believe
+
+	RateLimit       float64  `default:"10"`  // Webhook requests per second per address, 0 for no limit
+	RateLimitBurst  int      `default:"100"` // How many can come at once
+	RateLimitExempt []string // Addresses/CIDRs that are never limited
+
+	SocketMode  string `default:"0660"` // Permissions for a unix: HostPort
+	SocketGroup string // Group to give a unix: HostPort to, e.g. nginx's
+
+	TLSCert string // Serve webhooks over HTTPS when both of these are set
+	TLSKey  string
+
+	LogFormat string `default:"text"`   // text or json
+	LogLevel  string `default:"info"`   // debug (which has everything said on IRC), info, warn or error
+	LogOutput string `default:"stdout"` // stdout, stderr or syslog
+	LogFile   string // Log here instead of stdout or stderr, reopened on SIGUSR1 for logrotate
+
+	SyslogFacility string `default:"daemon"`   // For LogOutput syslog
+	SyslogTag      string `default:"capthook"` // What the lines say they're from
+	SyslogAddress  string // The local syslog socket, if not /dev/log or the like
+
+	LogUnknownEvents bool   // Log GitHub event types and actions we don't handle, each at most hourly
+	DebugDumpDir     string // Save the first payload of each of those here, for working on support for them
+
+	Templates map[string]string `toml:"templates" json:"templates" yaml:"templates"` // Announcement formats by event type, see format/templates.go
+
+	Verbs        map[string]string `toml:"verbs" json:"verbs" yaml:"verbs"`                         // How actions read, by action or type.action, see format/templates.go
+	ActionColors map[string]string `toml:"action_colors" json:"action_colors" yaml:"action_colors"` // Colour names by action, over act2color; "none" for none
+	StateColors  map[string]string `toml:"state_colors" json:"state_colors" yaml:"state_colors"`    // Colour names by build result or alert state, over format.DefaultStateColors; "none" for none
+
+	MaxCommitLines   int  `default:"3"` // Commits listed under a push, a line each, before "…and N more"
+	ShowMergeCommits bool // List "Merge pull request" commits under pushes too
+	SHALength        int  `default:"7"` // How much of commit hashes to show, 4 to 40
+
+	CommentSnippetLength int `default:"100"` // Characters of a comment to quote when announcing it, 0 for none
+
+	NameStyle    string        `default:"both"` // How GitHub senders read when we know their name: login, name or both, "Joe Bloggs (x1tot)"
+	GitHubToken  string        // For GitHub's API: names the payload doesn't have, conflicts, and the IRC commands
+	GitHubAPIURL string        `default:"https://api.github.com"` // Or https://your-ghes/api/v3
+	GitHubURL    string        `default:"https://github.com"`     // Or https://your-ghes, for knowing its links
+	NameCacheTTL time.Duration `default:"24h"`                    // How long a looked up name is kept for
+
+	ManageHooks       bool     // With an admin GitHubToken, make sure HookOrgs' repos have our webhook, see github/hooksync/hooksync.go
+	ManageHooksDryRun bool     // Only log what ManageHooks would change
+	HookOrgs          []string // Orgs (or users) whose repos get the webhook
+	HookEvents        []string // Events it sends, default all we handle
+	PublicURL         string   // Where GitHub can reach our webhook, e.g. https://hooks.example.org/
+
+	ExpandRefsMin int `default:"10"` // Issue numbers below this aren't expanded in ExpandRefs channels
+
+	WarnConflicts        bool          `default:"true"` // With a GitHubToken, say when a PR stops being mergeable
+	ConflictCheckTimeout time.Duration `default:"30s"`  // For checking each delivery's PRs, retries and all
+
+	ShortenURLs      bool     `default:"true"` // Shorten links, except for private repos
+	ShortenMinLength int      // Leave links shorter than this alone
+	Shortener        string   `default:"none"` // none, isgd, custom, yourls or shlink, see format/shortener.go
+	ShortenerURL     string   // For custom, with {url} where the long URL goes, or YOURLS/Shlink's base URL
+	ShortenerKey     string   // YOURLS signature token or Shlink API key
+	ShortenSkipHosts []string // Hosts (globs) whose URLs never go to the shortener, e.g. our GHES
+
+	ShortenTimeout    time.Duration `default:"3s"`   // For each request to the shortener
+	ShortenTripAfter  int           `default:"5"`    // Failures in a row before we stop asking the shortener for a while
+	ShortenCooldown   time.Duration `default:"5m"`   // How long to stop asking for
+	ShortenCacheSize  int           `default:"1024"` // Short URLs to remember, 0 for none
+	ShortenFailureTTL time.Duration `default:"1m"`   // How long to leave a URL alone after failing to shorten it
+
+	Workers       int `default:"4"`   // Goroutines formatting deliveries
+	WorkQueueSize int `default:"100"` // Deliveries waiting for a worker before we 503
+
+	QueueSize    int           `default:"10"`                 // Announcements waiting for IRC
+	QueuePolicy  string        `default:"block-with-timeout"` // drop-oldest, drop-newest or block-with-timeout
+	QueueTimeout time.Duration `default:"5s"`                 // How long block-with-timeout waits
+
+	DuplicateWindow time.Duration `default:"60s"` // Don't send the same message to the same place twice within this, 0 to allow it
+
+	ShutdownTimeout        time.Duration `default:"10s"` // How long to let in-flight deliveries finish
+	ShutdownWorkersTimeout time.Duration `default:"10s"` // How long to let workers finish what they've been given
+	ShutdownDrainTimeout   time.Duration `default:"10s"` // How long to spend sending what's queued to IRC and the other outputs
+	ShutdownQuitTimeout    time.Duration `default:"5s"`  // How long to wait for IRC to close the connection after we QUIT
+
+	RestartTimeout time.Duration `default:"1m"` // How long a new process started by SIGUSR2 gets to be ready to take over
+
+	StateFile         string        // Where to keep state across restarts, if anywhere
+	DeliveryRetention time.Duration `default:"168h"` // How long to remember delivery IDs for
+	PersistStats      bool          // Keep the numbers for /stats and !stats across restarts, saving them daily
+	UnsentMaxAge      time.Duration `default:"1h"` // Announcements left unsent at shutdown go out on the next start if they're no older than this; 0 to drop them
+
+	PayloadArchiveDir string        // Keep a copy of each verified delivery here
+	ArchiveMaxBytes   int64         `default:"104857600"` // Prune the oldest when the archive gets bigger than this
+	ArchiveMaxAge     time.Duration `default:"720h"`      // and anything older than this
+
+	FeedSize    int    `default:"200"` // Announcements to serve at /feed.atom and /feed.json, 0 to turn them off
+	FeedFile    string // Keep the feed here across restarts, if anywhere
+	FeedPrivate bool   // Put private repos' announcements in the feed too, which anyone can read
+
+	EventLogPath     string // Append a JSON line here for every announcement, for statistics
+	EventLogMaxBytes int64  `default:"10485760"` // Rotate it when it gets bigger than this
+	EventLogKeep     int    `default:"5"`        // and keep this many old ones
+
+	AdminToken   string   // Enables POST /test, with this as the bearer token
+	IRCAdmins    []string // nick!user@host globs allowed to !subscribe, !unsubscribe, !mute and !unmute
+	MuteMode     string   `default:"drop"` // What !mute does with a repo's announcements: drop, or defer until it's over
+	IRCReporters []string // nick!user@host globs allowed to !newissue, as well as IRCAdmins
+
+	MatrixHomeserver string // Also announce to Matrix, e.g. https://matrix.org, for matrix:!room targets
+	MatrixToken      string // The bot account's access token
+	MatrixRooms      string // Comma separated room IDs that get every announcement, as well as IRC
+
+	Slack   []SlackTarget   `toml:"slack" json:"slack" yaml:"slack"`       // Incoming webhooks, for slack:name targets, see outputs/slack.go
+	Discord []DiscordTarget `toml:"discord" json:"discord" yaml:"discord"` // Webhooks, for discord:name targets, see outputs/discord.go
+
+	TelegramToken string // A bot's token from @BotFather, for telegram:chat targets
+	TelegramChats string // Comma separated chat IDs that get every announcement
+
+	MQTTBroker   string // Also publish every event as JSON here, e.g. tcp://localhost:1883, see outputs/mqtt.go
+	MQTTUsername string
+	MQTTPassword string
+	MQTTClientID string `default:"capthook"`
+	MQTTTopic    string `default:"capthook/{owner}/{repo}/{event}"` // Also {action}
+	MQTTQoS      int    // 0, 1 or 2
+	MQTTRetain   bool
+
+	DryRun     bool   // Write announcements out instead of connecting to IRC, also -dry-run
+	DryRunFile string // Where to, default stdout
+
+	DevMode      bool   // Serve the unauthenticated /replay alongside the webhooks
+	ReplayListen string // Or serve it here, e.g. on localhost only
+
+	HealthGracePeriod time.Duration `default:"2m"` // IRC downtime before /healthz fails
+
+	templates map[string]*template.Template // Parsed from Templates by Validate
+	Warnings  []string                      `toml:"-" json:"-" yaml:"-"` // Things Validate didn't like, but could live with
+
+	repoTemplates map[string]map[string]*template.Template // Each [repos] entry's overrides, parsed
+	schedules     map[string][]Window                      // Parsed from ChannelSettings, by lower case channel
+	Location      *time.Location                           `toml:"-" json:"-" yaml:"-"` // Timezone, loaded
+	actionColors  map[string]format.MIRCColor              // act2color with ActionColors over it
+	stateColors   map[string]format.MIRCColor              // format.DefaultStateColors with StateColors over it
+	LinkShortener format.Shortener                         `toml:"-" json:"-" yaml:"-"` // Set up from Shortener
+	names         *github.NameCache                        // Set up by Share from GitHubToken, nil without one
+	SlogLevel     slog.Level                               `toml:"-" json:"-" yaml:"-"` // Parsed from LogLevel
+
+	// Made once by main and handed from each config to the next with Share.
+	api           github.GitHubAPI
+	Subscriptions *Subscriptions `toml:"-" json:"-" yaml:"-"`
+	SeenRepos     *RepoRegistry  `toml:"-" json:"-" yaml:"-"`
+}
+
+// The config in use. It's swapped wholesale on SIGHUP, so fetch it with
+// CurrentConfig each time rather than holding on to it.
+var confValue atomic.Value
+
+func CurrentConfig() *Config {
+	c, _ := confValue.Load().(*Config)
+	return c
+}
+
+// Hand c what outlives any one config: the GitHub API, shared so everything
+// stays within the one rate limit, and the subscriptions and repos we've
+// seen. People's names get looked up through api from then on, given a
+// GitHubToken.
+func (c *Config) Share(api github.GitHubAPI, subs *Subscriptions, seen *RepoRegistry) {
+	c.api, c.Subscriptions, c.SeenRepos = api, subs, seen
+	c.names = nil
+	if api != nil && c.GitHubToken != "" {
+		c.names = github.NewNameCache(api, c.GitHubAPIURL, c.GitHubToken, c.NameCacheTTL)
+	}
+}
+
+func SetConfig(c *Config) {
+	confValue.Store(c)
+	format.SetStyle(format.Style{ActionColors: c.actionColorMap(), StateColors: c.stateColorMap(), Shortener: c.urlShortener()})
+}
+
+// Where the config comes from: a TOML, YAML or JSON file, then the
+// environment, then flags.
+type ConfigSource struct {
+	Path     string   // Empty to look for one of configFiles
+	Required bool     // Whether Path was asked for, rather than found
+	Args     []string // Flags for multiconfig, minus our own
+}
+
+// Where to look for a config when we're not told, in order.
+var configFiles = []string{"config.toml", "config.yaml", "config.yml", "config.json"}
+
+// Set from the command line by main, and used again on reload.
+var Source ConfigSource
+
+// What the command line asked for, besides config settings.
+type Options struct {
+	Config    ConfigSource
+	Check     bool // Validate the config and exit
+	DryRun    bool // Write announcements out instead of connecting to IRC
+	Version   bool // Say which version we are and exit
+	SyncHooks bool // Make sure HookOrgs' repos have our webhook and exit, only saying what we'd do with DryRun
+}
+
+// Pick our own flags out of args, leaving the rest for multiconfig: -config
+// (or $CAPTHOOK_CONFIG) names the config file, -check means validate it and
+// exit, -dry-run means stay off IRC, and -sync-hooks means sync webhooks as
+// ManageHooks would, then exit.
+func ParseArgs(args []string, getenv func(string) string) (opts Options, err error) {
+	src := &opts.Config
+	src.Args = []string{} // Never nil, or multiconfig reads os.Args itself
+	if p := getenv("CAPTHOOK_CONFIG"); p != "" {
+		src.Path, src.Required = p, true
+	}
+	for i := 0; i < len(args); i++ {
+		name := strings.TrimLeft(args[i], "-")
+		if !strings.HasPrefix(args[i], "-") || args[i] == "--" {
+			src.Args = append(src.Args, args[i:]...)
+			break
+		}
+		switch {
+		case name == "check":
+			opts.Check = true
+		case name == "dry-run":
+			opts.DryRun = true
+		case name == "version":
+			opts.Version = true
+		case name == "sync-hooks":
+			opts.SyncHooks = true
+		case name == "config":
+			if i+1 == len(args) {
+				return opts, fmt.Errorf("-config needs a path")
+			}
+			i++
+			src.Path, src.Required = args[i], true
+		case strings.HasPrefix(name, "config="):
+			src.Path, src.Required = strings.TrimPrefix(name, "config="), true
+			if src.Path == "" {
+				return opts, fmt.Errorf("-config needs a path")
+			}
+		default:
+			src.Args = append(src.Args, args[i])
+		}
+	}
+	return opts, nil
+}
+
+// The config file to read, or "" if there isn't one and that's fine.
+func (s ConfigSource) File() (string, error) {
+	if s.Path != "" {
+		if _, err := os.Stat(s.Path); err != nil && (s.Required || !os.IsNotExist(err)) {
+			return "", fmt.Errorf("config file: %v", err)
+		}
+		return s.Path, nil
+	}
+	for _, f := range configFiles {
+		if _, err := os.Stat(f); err == nil {
+			return f, nil
+		}
+	}
+	return "", nil
+}
+
+// A multiconfig loader for path, going by its extension.
+func fileLoader(path string) (multiconfig.Loader, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".toml":
+		return &multiconfig.TOMLLoader{Path: path}, nil
+	case ".yaml", ".yml":
+		return &multiconfig.YAMLLoader{Path: path}, nil
+	case ".json":
+		return &multiconfig.JSONLoader{Path: path}, nil
+	}
+	return nil, fmt.Errorf("config file %s: expected .toml, .yaml or .json", path)
+}
+
+// Load the config file if there is one, plus the environment and flags, and
+// make sure it all makes sense. A file that was asked for has to exist.
+func (s ConfigSource) Load() (*Config, error) {
+	loaders := []multiconfig.Loader{&multiconfig.TagLoader{}}
+	path, err := s.File()
+	if err != nil {
+		return nil, err
+	}
+	if path != "" {
+		l, err := fileLoader(path)
+		if err != nil {
+			return nil, err
+		}
+		loaders = append(loaders, l)
+	}
+	loaders = append(loaders, &multiconfig.EnvironmentLoader{}, &multiconfig.FlagLoader{Args: s.Args})
+	c := new(Config)
+	if err := multiconfig.MultiLoader(loaders...).Load(c); err != nil {
+		return nil, err
+	}
+	return c, errors.Join(c.Validate()...)
+}
+
+// Load the config from wherever the command line said.
+func LoadConfig() (*Config, error) {
+	return Source.Load()
+}
+
+// Load the config again and switch over to it, keeping the old one if
+// there's anything wrong with the new. rebuild gets to set up anything made
+// from the config first, and can veto it by returning an error.
+func ReloadConfig(rebuild func(*Config) error, logger *slog.Logger) (old, new *Config, err error) {
+	old = CurrentConfig()
+	new, err = LoadConfig()
+	if err != nil {
+		return old, nil, err
+	}
+	if old != nil {
+		new.Share(old.api, old.Subscriptions, old.SeenRepos)
+	}
+	if err := rebuild(new); err != nil {
+		return old, nil, err
+	}
+	SetConfig(new)
+	changed, restart := diffConfig(old, new)
+	if len(changed) == 0 && len(restart) == 0 {
+		logger.Info("Config reloaded, nothing changed")
+	}
+	if len(changed) > 0 {
+		logger.Info("Config reloaded", "changed", changed)
+	}
+	if len(restart) > 0 {
+		logger.Warn("Restart required for changes", "fields", restart)
+	}
+	for _, w := range new.Warnings {
+		logger.Warn(w)
+	}
+	return old, new, nil
+}
+
+// Settings that are only looked at on startup.
+var restartRequired = map[string]bool{
+	"Server": true, "Nick": true, "Ident": true, "Name": true, "Join": false,
+	"HostPort": true, "SocketMode": true, "SocketGroup": true, "TLSCert": true, "TLSKey": true,
+	"MetricsListen": true, "ReplayListen": true, "DevMode": true, "AdminToken": true,
+	"Workers": true, "WorkQueueSize": true, "QueueSize": true, "QueuePolicy": true, "QueueTimeout": true,
+	"StateFile": true, "DeliveryRetention": true, "UnsentMaxAge": true, "PersistStats": true, "PayloadArchiveDir": true, "ArchiveMaxBytes": true,
+	"ArchiveMaxAge": true, "FeedSize": true, "FeedFile": true, "EventLogPath": true, "EventLogMaxBytes": true, "EventLogKeep": true, "ForwardURLs": true, "DryRun": true, "DryRunFile": true, "MatrixHomeserver": true, "MatrixToken": true, "Slack": true, "Discord": true, "TelegramToken": true, "MQTTBroker": true, "MQTTUsername": true, "MQTTPassword": true, "MQTTClientID": true, "MQTTTopic": true, "MQTTQoS": true, "MQTTRetain": true, "RestrictToGitHubIPs": true, "AllowIPs": true,
+	"TrustedProxies": true, "LogFormat": true, "LogOutput": true, "LogFile": true, "SyslogFacility": true, "SyslogTag": true, "SyslogAddress": true, "ShutdownTimeout": true, "ShutdownWorkersTimeout": true, "ShutdownDrainTimeout": true, "ShutdownQuitTimeout": true, "HealthGracePeriod": true,
+}
+
+// Which settings differ between two configs, split by whether the change
+// takes effect straight away. Only names, since values might be secrets.
+func diffConfig(old, new *Config) (changed, restart []string) {
+	ov, nv := reflect.ValueOf(old).Elem(), reflect.ValueOf(new).Elem()
+	t := ov.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" || f.Tag.Get("toml") == "-" {
+			continue // Not from the file, but worked out from the rest or shared
+		}
+		if reflect.DeepEqual(ov.Field(i).Interface(), nv.Field(i).Interface()) {
+			continue
+		}
+		if restartRequired[f.Name] {
+			restart = append(restart, f.Name)
+		} else {
+			changed = append(changed, f.Name)
+		}
+	}
+	sort.Strings(changed)
+	sort.Strings(restart)
+	return changed, restart
+}
+
+// What to do with an announcement when the broadcast queue is full.
+type OverflowPolicy string
+
+const (
+	DropOldest       OverflowPolicy = "drop-oldest"
+	DropNewest       OverflowPolicy = "drop-newest"
+	BlockWithTimeout OverflowPolicy = "block-with-timeout"
+)
+
+func (p OverflowPolicy) Valid() bool {
+	return p == DropOldest || p == DropNewest || p == BlockWithTimeout
+}
@@ -0,0 +1,110 @@
+package config
+
+import (
+	"log/slog"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/format"
+)
+
+func TestDiffConfig(t *testing.T) {
+	old := &Config{Channels: "#a", Nick: "CaptHook", GHSecret: "s", RateLimit: 10}
+	new := &Config{Channels: "#a,#b", Nick: "CaptHook2", GHSecret: "s", RateLimit: 5}
+	changed, restart := diffConfig(old, new)
+	if got := strings.Join(changed, ","); got != "Channels,RateLimit" {
+		t.Errorf("expected Channels,RateLimit to change, got %s", got)
+	}
+	if got := strings.Join(restart, ","); got != "Nick" {
+		t.Errorf("expected Nick to need a restart, got %s", got)
+	}
+
+	// What Validate and Share fill in isn't a change to the file
+	new = &Config{Channels: "#a", Nick: "CaptHook", GHSecret: "s", RateLimit: 10}
+	new.Warnings, new.Location, new.LinkShortener, new.SlogLevel = []string{"hmm"}, time.UTC, format.NoShortener{}, slog.LevelDebug
+	new.Share(nil, NewSubscriptions(""), NewRepoRegistry())
+	if changed, restart := diffConfig(old, new); len(changed) > 0 || len(restart) > 0 {
+		t.Errorf("expected no changes, got %v and %v", changed, restart)
+	}
+}
+
+func TestParseArgs(t *testing.T) {
+	env := func(vars map[string]string) func(string) string {
+		return func(k string) string { return vars[k] }
+	}
+	opts, err := ParseArgs([]string{"-Nick", "Hook"}, env(nil))
+	src := opts.Config
+	if err != nil || opts.Check || opts.DryRun || src.Path != "" || src.Required || strings.Join(src.Args, " ") != "-Nick Hook" {
+		t.Errorf("defaults: got %+v %v", opts, err)
+	}
+	opts, _ = ParseArgs(nil, env(map[string]string{"CAPTHOOK_CONFIG": "/etc/capthook.toml"}))
+	if opts.Config.Path != "/etc/capthook.toml" || !opts.Config.Required {
+		t.Errorf("env: got %+v", opts)
+	}
+	opts, err = ParseArgs([]string{"-check", "--config", "/a.toml", "-dry-run", "-Nick=Hook"}, env(map[string]string{"CAPTHOOK_CONFIG": "/b.toml"}))
+	if err != nil || !opts.Check || !opts.DryRun || opts.Config.Path != "/a.toml" || strings.Join(opts.Config.Args, " ") != "-Nick=Hook" {
+		t.Errorf("flags: got %+v %v", opts, err)
+	}
+	if opts, _ = ParseArgs([]string{"--version"}, env(nil)); !opts.Version {
+		t.Errorf("-version: got %+v", opts)
+	}
+	if opts, _ = ParseArgs([]string{"-config=/c.toml"}, env(nil)); opts.Config.Path != "/c.toml" {
+		t.Errorf("-config=: got %+v", opts)
+	}
+	if _, err = ParseArgs([]string{"-config"}, env(nil)); err == nil {
+		t.Errorf("expected an error for -config without a path")
+	}
+}
+
+// With only our own flags, multiconfig mustn't go looking at os.Args itself,
+// where it would trip over -config and friends.
+func TestLoadOnlyOurFlags(t *testing.T) {
+	for _, args := range [][]string{
+		{"-config", filepath.Join("testdata", "config", "config.toml")},
+		{"-check", "-config=" + filepath.Join("testdata", "config", "config.toml")},
+		{"-sync-hooks", "-dry-run", "-config", filepath.Join("testdata", "config", "config.toml")},
+	} {
+		opts, err := ParseArgs(args, func(string) string { return "" })
+		if err != nil {
+			t.Fatalf("%v: %v", args, err)
+		}
+		if _, err := opts.Config.Load(); err != nil {
+			t.Errorf("%v: expected it to load, got %v", args, err)
+		}
+	}
+}
+
+func TestLoadMissingConfig(t *testing.T) {
+	src := ConfigSource{Path: filepath.Join(t.TempDir(), "nope.toml"), Required: true}
+	if _, err := src.Load(); err == nil {
+		t.Errorf("expected an error for a missing -config file")
+	}
+}
+
+func TestLoadFormats(t *testing.T) {
+	var want *Config
+	for _, f := range []string{"config.toml", "config.yaml", "config.json"} {
+		src := ConfigSource{Path: filepath.Join("testdata", "config", f), Required: true, Args: []string{}}
+		c, err := src.Load()
+		if err != nil {
+			t.Fatalf("%s: %v", f, err)
+		}
+		c.templates = nil // Parsed separately each time, so never equal
+		if want == nil {
+			want = c
+			if len(c.Hooks) != 1 || len(c.GenericHooks) != 1 || c.Repos["ury/website"].Branches[0] != "main" || c.QueueTimeout != 2*time.Second {
+				t.Fatalf("%s: didn't load everything: %+v", f, c)
+			}
+			continue
+		}
+		if !reflect.DeepEqual(c, want) {
+			t.Errorf("%s: expected the same as config.toml\ngot  %+v\nwant %+v", f, c, want)
+		}
+	}
+	if _, err := (ConfigSource{Path: "config.ini", Args: []string{}}).Load(); err == nil {
+		t.Errorf("expected an error for an unknown extension")
+	}
+}
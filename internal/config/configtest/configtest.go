@@ -0,0 +1,15 @@
+// Package configtest makes configs for other packages' tests.
+package configtest
+
+import (
+	"github.com/koding/multiconfig"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/config"
+)
+
+// A config with the defaults filled in, that Validate should be happy with.
+func Valid() *config.Config {
+	c := &config.Config{Channels: "#a", GHSecret: "s"}
+	(&multiconfig.TagLoader{}).Load(c)
+	return c
+}
@@ -0,0 +1,139 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/github"
+)
+
+// Whether repo ("owner/name") gets announced, given glob patterns to allow
+// and deny. Deny wins, and an empty allow list allows everything.
+func MatchRepo(repo string, allow, deny []string) bool {
+	repo = strings.ToLower(repo)
+	if matchAny(repo, deny) {
+		return false
+	}
+	return len(allow) == 0 || matchAny(repo, allow)
+}
+
+// Whether s is or matches any of the glob patterns, ignoring case.
+func matchAny(s string, patterns []string) bool {
+	for _, p := range patterns {
+		if strings.EqualFold(p, s) {
+			return true
+		}
+		if ok, _ := path.Match(strings.ToLower(p), s); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Whether pushes and the like to ref get announced, given glob patterns like
+// "main", "live-*" or "!wip/*" for branches to leave out. A branch has to
+// match one of the patterns, and none of the negated ones; only negated
+// patterns means everything else. Tags always pass, they're dealt with
+// elsewhere.
+func MatchBranch(ref string, patterns []string) bool {
+	if strings.HasPrefix(ref, "refs/tags/") {
+		return true
+	}
+	branch := strings.TrimPrefix(ref, "refs/heads/")
+	wanted, positive := false, false
+	for _, p := range patterns {
+		if strings.HasPrefix(p, "!") {
+			if ok, _ := path.Match(p[1:], branch); ok {
+				return false
+			}
+			continue
+		}
+		positive = true
+		if ok, _ := path.Match(p, branch); ok {
+			wanted = true
+		}
+	}
+	return wanted || !positive
+}
+
+// Check glob patterns from the config, so typos show up at startup rather
+// than silently never matching.
+func checkPatterns(setting string, patterns []string) error {
+	for _, p := range patterns {
+		if _, err := path.Match(strings.TrimPrefix(p, "!"), ""); err != nil {
+			return fmt.Errorf("%s: bad pattern %q", setting, p)
+		}
+	}
+	return nil
+}
+
+// Have a guess at which repository (owner/name) a payload is about, for
+// filtering and the archive index. Empty if it isn't about one.
+func PayloadRepo(payload []byte) string {
+	var p struct {
+		Repository struct {
+			FullName string `json:"full_name"`
+		}
+		Project struct {
+			PathWithNamespace string `json:"path_with_namespace"`
+		}
+	}
+	json.Unmarshal(payload, &p)
+	if p.Repository.FullName != "" {
+		return p.Repository.FullName
+	}
+	return p.Project.PathWithNamespace
+}
+
+// The org, or failing that the enterprise, a GitHub payload without a
+// repository is about, as org hooks send. Empty if neither.
+func PayloadOrg(payload []byte) string {
+	var s github.Scope
+	json.Unmarshal(payload, &s)
+	if s.Repository.FullName != "" {
+		return ""
+	}
+	return s.FullName()
+}
+
+// Who triggered a payload, and what they did, as far as we can tell.
+func PayloadSender(payload []byte) (login, action string) {
+	var p struct {
+		Action string
+		Sender struct {
+			Login string
+		}
+		UserUsername string `json:"user_username"` // GitLab pushes
+		User         struct {
+			Username string // Other GitLab events
+		}
+	}
+	json.Unmarshal(payload, &p)
+	switch {
+	case p.Sender.Login != "":
+		return p.Sender.Login, p.Action
+	case p.UserUsername != "":
+		return p.UserUsername, p.Action
+	}
+	return p.User.Username, p.Action
+}
+
+// Whether deliveries of event (and action, if any) from sender should be
+// dropped. IgnoreSendersByEvent can replace IgnoreSenders for an event type
+// or "type.action", the more specific the better. This comes before any
+// other judgement about the sender.
+func (c *Config) IgnoresSender(sender, event, action string) bool {
+	if sender == "" {
+		return false
+	}
+	patterns := c.IgnoreSenders
+	if p, ok := c.IgnoreSendersByEvent[event]; ok {
+		patterns = p
+	}
+	if p, ok := c.IgnoreSendersByEvent[event+"."+action]; ok && action != "" {
+		patterns = p
+	}
+	return matchAny(strings.ToLower(sender), patterns)
+}
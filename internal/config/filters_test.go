@@ -0,0 +1,90 @@
+package config
+
+import (
+	"testing"
+)
+
+func TestMatchRepo(t *testing.T) {
+	allow := []string{"UniversityRadioYork/*", "x1tot/capthook"}
+	deny := []string{"UniversityRadioYork/*-archive", "*/sandbox"}
+	for repo, want := range map[string]bool{
+		"UniversityRadioYork/website":         true,
+		"universityradioyork/Website":         true,
+		"UniversityRadioYork/website-archive": false,
+		"UniversityRadioYork/sandbox":         false,
+		"x1tot/capthook":                      true,
+		"x1tot/dotfiles":                      false,
+		"UniversityRadioYork/website/extra":   false,
+	} {
+		if got := MatchRepo(repo, allow, deny); got != want {
+			t.Errorf("%s: expected %v, got %v", repo, want, got)
+		}
+	}
+	if !MatchRepo("anyone/anything", nil, deny) {
+		t.Errorf("expected an empty allow list to allow everything")
+	}
+	if MatchRepo("anyone/sandbox", nil, deny) {
+		t.Errorf("expected deny to apply without an allow list")
+	}
+}
+
+func TestCheckPatterns(t *testing.T) {
+	if err := checkPatterns("RepoAllow", []string{"ury/*", "ury/web[sx]ite"}); err != nil {
+		t.Errorf("expected good patterns to pass, got %v", err)
+	}
+	if err := checkPatterns("RepoAllow", []string{"ury/[web"}); err == nil {
+		t.Errorf("expected an unclosed [ to fail")
+	}
+}
+
+func TestMatchBranch(t *testing.T) {
+	patterns := []string{"main", "live-*", "!live-test", "!wip/*"}
+	for ref, want := range map[string]bool{
+		"main":              true,
+		"refs/heads/main":   true,
+		"live-2023":         true,
+		"live-test":         false,
+		"feature/fader":     false,
+		"wip/fader":         false,
+		"refs/tags/v1.0":    true,
+		"refs/heads/master": false,
+	} {
+		if got := MatchBranch(ref, patterns); got != want {
+			t.Errorf("%s: expected %v, got %v", ref, want, got)
+		}
+	}
+	if !MatchBranch("anything", nil) {
+		t.Errorf("expected no patterns to allow everything")
+	}
+	if MatchBranch("wip/x", []string{"!wip/*"}) || !MatchBranch("main", []string{"!wip/*"}) {
+		t.Errorf("expected only negated patterns to allow everything else")
+	}
+}
+
+func TestIgnoresSender(t *testing.T) {
+	c := &Config{
+		IgnoreSenders: []string{"ury-ci", "dependabot[bot]", "*-mirror"},
+		IgnoreSendersByEvent: map[string][]string{
+			"pull_request.closed": {},
+			"push":                {"ury-ci"},
+		},
+	}
+	for _, tc := range []struct {
+		sender, event, action string
+		want                  bool
+	}{
+		{"ury-ci", "issues", "opened", true},
+		{"URY-CI", "issues", "opened", true},
+		{"dependabot[bot]", "pull_request", "opened", true},
+		{"dependabot[bot]", "pull_request", "closed", false},
+		{"svn-mirror", "issues", "opened", true},
+		{"svn-mirror", "push", "", false},
+		{"ury-ci", "push", "", true},
+		{"x1tot", "issues", "opened", false},
+		{"", "issues", "opened", false},
+	} {
+		if got := c.IgnoresSender(tc.sender, tc.event, tc.action); got != tc.want {
+			t.Errorf("%s %s.%s: expected %v, got %v", tc.sender, tc.event, tc.action, tc.want, got)
+		}
+	}
+}
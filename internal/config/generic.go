@@ -0,0 +1,100 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/format"
+)
+
+// An endpoint for anything that can POST JSON, announced with a template.
+type GenericHookConfig struct {
+	Name     string
+	Path     string
+	Token    string
+	Channels string // Comma separated, like Config.Channels
+	Template string // text/template, given the decoded JSON
+}
+
+// A generic endpoint ready to use, with its template parsed.
+type GenericHook struct {
+	Hook
+	Template *template.Template
+}
+
+// Check and parse the generic hooks, refusing any whose path is in taken.
+func (c *Config) AllGenericHooks(taken map[string]bool) ([]*GenericHook, error) {
+	var hooks []*GenericHook
+	for _, gc := range c.GenericHooks {
+		if gc.Name == "" || gc.Token == "" {
+			return nil, fmt.Errorf("generic hook %q needs both a name and a token", gc.Path)
+		}
+		path := gc.Path
+		if path == "" {
+			path = "/hooks/" + gc.Name
+		}
+		if !strings.HasPrefix(path, "/") {
+			return nil, fmt.Errorf("generic hook %s: path %q must start with /", gc.Name, path)
+		}
+		if taken[path] {
+			return nil, fmt.Errorf("generic hook %s: path %q is already in use", gc.Name, path)
+		}
+		taken[path] = true
+		tmpl, err := template.New(gc.Name).Funcs(format.TemplateFuncs).Parse(gc.Template)
+		if err != nil {
+			return nil, fmt.Errorf("generic hook %s: %v", gc.Name, err)
+		}
+		h := &GenericHook{
+			Hook: Hook{
+				Name:     gc.Name,
+				Path:     path,
+				Secrets:  []string{gc.Token},
+				Channels: SplitChannels(gc.Channels),
+			},
+			Template: tmpl,
+		}
+		if len(h.Channels) == 0 {
+			h.Channels = SplitChannels(c.Channels)
+		}
+		hooks = append(hooks, h)
+	}
+	return hooks, nil
+}
+
+// Render a payload through a hook's template into a single IRC line, giving
+// up on any shortening once ctx is done.
+func (h *GenericHook) Render(ctx context.Context, body []byte) (string, error) {
+	var data map[string]interface{}
+	if err := json.Unmarshal(body, &data); err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := format.Execute(ctx, h.Template, &buf, stripJSONFormatting(data)); err != nil {
+		return "", err
+	}
+	// Missing keys render as "<no value>", which is never what anyone wants
+	text := strings.Replace(buf.String(), "<no value>", "", -1)
+	return strings.Join(strings.Fields(text), " "), nil
+}
+
+// Every string in a decoded JSON value without any formatting, so only the
+// template gets to add colours.
+func stripJSONFormatting(v interface{}) interface{} {
+	switch v := v.(type) {
+	case string:
+		return format.StripFormatting(v)
+	case map[string]interface{}:
+		for k, x := range v {
+			v[k] = stripJSONFormatting(x)
+		}
+	case []interface{}:
+		for i, x := range v {
+			v[i] = stripJSONFormatting(x)
+		}
+	}
+	return v
+}
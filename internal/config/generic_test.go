@@ -0,0 +1,25 @@
+package config
+
+import (
+	"testing"
+)
+
+func TestGenericHooksValidated(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		hook GenericHookConfig
+		ok   bool
+	}{
+		{"valid", GenericHookConfig{Name: "up", Token: "t", Template: "{{ .msg }}"}, true},
+		{"no token", GenericHookConfig{Name: "up", Template: "{{ .msg }}"}, false},
+		{"broken template", GenericHookConfig{Name: "up", Token: "t", Template: "{{ .msg "}, false},
+		{"unknown function", GenericHookConfig{Name: "up", Token: "t", Template: "{{ frobnicate .msg }}"}, false},
+		{"taken path", GenericHookConfig{Name: "up", Path: "/gitlab", Token: "t", Template: "x"}, false},
+	} {
+		c2 := &Config{GenericHooks: []GenericHookConfig{c.hook}}
+		_, err := c2.AllGenericHooks(map[string]bool{"/gitlab": true})
+		if (err == nil) != c.ok {
+			t.Errorf("%s: expected ok=%v, got %v", c.name, c.ok, err)
+		}
+	}
+}
@@ -0,0 +1,24 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/koding/multiconfig"
+)
+
+// Every \x03 should have a colour after it; clients show a bare one as junk.
+func checkColorCodes(t *testing.T, what, s string) {
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\x03' && (i+2 >= len(s) || s[i+1] < '0' || s[i+1] > '9' || s[i+2] < '0' || s[i+2] > '9') {
+			t.Errorf("%s: bare \\x03 in %q", what, s)
+			return
+		}
+	}
+}
+
+// A config with the defaults filled in, that Validate should be happy with.
+func validConfig() *Config {
+	c := &Config{Channels: "#a", GHSecret: "s"}
+	(&multiconfig.TagLoader{}).Load(c)
+	return c
+}
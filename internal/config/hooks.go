@@ -0,0 +1,240 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/github"
+)
+
+// A [[hooks]] entry in the config, describing an extra webhook endpoint with
+// its own secret and destination channels.
+type HookConfig struct {
+	Name     string
+	Path     string
+	Secret   string
+	Secrets  []string // More secrets to accept, for rotation
+	Channels string   // Comma separated, like Config.Channels
+	Events   []string // GitHub event types to announce, empty for all
+}
+
+// A resolved webhook endpoint.
+type Hook struct {
+	Name     string
+	Path     string
+	Secrets  []string // Tried in order, any of them will do
+	Channels []string
+	Events   map[string]bool
+}
+
+// Whether this hook announces the given GitHub event type.
+func (h *Hook) Wants(event string) bool {
+	return len(h.Events) == 0 || h.Events[event]
+}
+
+// Split a comma separated channel list, skipping any blanks.
+func SplitChannels(list string) []string {
+	var channels []string
+	for _, c := range strings.Split(list, ",") {
+		if c = strings.TrimSpace(c); c != "" {
+			channels = append(channels, c)
+		}
+	}
+	return channels
+}
+
+// Gather up a single secret and a list of them, skipping blanks.
+func joinSecrets(secret string, secrets []string) []string {
+	var all []string
+	for _, s := range append([]string{secret}, secrets...) {
+		if s != "" {
+			all = append(all, s)
+		}
+	}
+	return all
+}
+
+// The hook served at / using the top level secret and channels, kept for
+// configs from before named hooks existed.
+func (c *Config) DefaultHook() *Hook {
+	return &Hook{
+		Name:     "default",
+		Path:     "/",
+		Secrets:  joinSecrets(c.GHSecret, c.GHSecrets),
+		Channels: SplitChannels(c.Channels),
+	}
+}
+
+// Paths of the endpoints for other services, which hooks can't have.
+var builtinPaths = []string{"/", "/gitlab", "/jenkins", "/alertmanager", "/grafana", "/sentry", "/test", "/replay", "/feed.atom", "/feed.json", "/stats", "/coverage"}
+
+// Every hook we should be serving, the default one first.
+func (c *Config) AllHooks() ([]*Hook, error) {
+	hooks := []*Hook{c.DefaultHook()}
+	seen := make(map[string]bool)
+	for _, p := range builtinPaths {
+		seen[p] = true
+	}
+	for _, hc := range c.Hooks {
+		secrets := joinSecrets(hc.Secret, hc.Secrets)
+		if hc.Name == "" || len(secrets) == 0 {
+			return nil, fmt.Errorf("hook %q needs both a name and a secret", hc.Path)
+		}
+		path := hc.Path
+		if path == "" {
+			path = "/hooks/" + hc.Name
+		}
+		if !strings.HasPrefix(path, "/") {
+			return nil, fmt.Errorf("hook %s: path %q must start with /", hc.Name, path)
+		}
+		if seen[path] {
+			return nil, fmt.Errorf("hook %s: path %q is already in use", hc.Name, path)
+		}
+		seen[path] = true
+		hook := &Hook{
+			Name:     hc.Name,
+			Path:     path,
+			Secrets:  secrets,
+			Channels: SplitChannels(hc.Channels),
+		}
+		if len(hook.Channels) == 0 {
+			hook.Channels = SplitChannels(c.Channels)
+		}
+		if len(hc.Events) > 0 {
+			hook.Events = make(map[string]bool)
+			for _, ev := range hc.Events {
+				hook.Events[ev] = true
+			}
+		}
+		hooks = append(hooks, hook)
+	}
+	return hooks, nil
+}
+
+// The GitHub hooks to serve, the default one first, and the generic ones,
+// or why they can't be.
+func (c *Config) ServedHooks() ([]*Hook, []*GenericHook, error) {
+	hooks, err := c.AllHooks()
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(hooks[0].Secrets) == 0 {
+		return nil, nil, errors.New("GHSecret or GHSecrets must be set")
+	}
+	taken := make(map[string]bool)
+	for _, p := range builtinPaths {
+		taken[p] = true
+	}
+	for _, h := range hooks {
+		taken[h.Path] = true
+	}
+	generic, err := c.AllGenericHooks(taken)
+	if err != nil {
+		return nil, nil, err
+	}
+	return hooks, generic, nil
+}
+
+// Every channel any hook announces to, without duplicates.
+func (c *Config) AllChannels() []string {
+	var channels []string
+	seen := make(map[string]bool)
+	hooks, _ := c.AllHooks()
+	if hooks == nil {
+		hooks = []*Hook{c.DefaultHook()}
+	}
+	for _, extra := range []string{c.GrafanaChannels, c.SentryChannels} {
+		hooks = append(hooks, &Hook{Channels: SplitChannels(extra)})
+	}
+	for _, g := range c.GenericHooks {
+		hooks = append(hooks, &Hook{Channels: SplitChannels(g.Channels)})
+	}
+	for _, repo := range c.RepoNames() {
+		hooks = append(hooks, &Hook{Channels: SplitChannels(c.Repos[repo].Channels)})
+	}
+	hooks = append(hooks, &Hook{Channels: c.Subscriptions.Channels()})
+	for _, h := range hooks {
+		for _, ch := range h.Channels {
+			if !seen[ch] {
+				seen[ch] = true
+				channels = append(channels, ch)
+			}
+		}
+	}
+	return channels
+}
+
+// The IRC channels to be in: AllChannels, minus any Matrix rooms etc.
+func (c *Config) IRCChannels() []string {
+	var channels []string
+	for _, ch := range c.AllChannels() {
+		if TargetKind(ch) == "" {
+			channels = append(channels, ch)
+		}
+	}
+	return channels
+}
+
+// Somewhere to pass verified GitHub deliveries on to, re-signed with its own
+// secret.
+type ForwardTarget struct {
+	URL    string
+	Secret string
+}
+
+// Events repos' hooks can't send: pings always come, and the rest only
+// come from org hooks.
+var orgOnlyEvents = map[string]bool{"ping": true, "organization": true}
+
+// The events our hooks should send: HookEvents, or everything we handle.
+func (c *Config) EventsToSend() []string {
+	events := append([]string(nil), c.HookEvents...)
+	if len(events) == 0 {
+		for ev := range github.Events {
+			if !orgOnlyEvents[ev] {
+				events = append(events, ev)
+			}
+		}
+	}
+	sort.Strings(events)
+	return events
+}
+
+// Parse a list of CIDRs, allowing bare addresses as shorthand for a single
+// host.
+func ParseCIDRs(list []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, s := range list {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid address %q", s)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// Which builds to announce, per Config.JenkinsNotify.
+const (
+	JenkinsNotifyAll      = "all"
+	JenkinsNotifyFailures = "failures"
+)
@@ -0,0 +1,16 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAllChannels(t *testing.T) {
+	c := &Config{
+		Channels: "#a,#b",
+		Hooks:    []HookConfig{{Name: "x", Secret: "s", Channels: "#b,#c"}},
+	}
+	if got := strings.Join(c.AllChannels(), ","); got != "#a,#b,#c" {
+		t.Errorf("expected #a,#b,#c, got %s", got)
+	}
+}
@@ -0,0 +1,65 @@
+package config
+
+import (
+	"log/slog"
+	"os"
+	"sync"
+)
+
+// A log file that can be closed and opened again by name, so logrotate can
+// move it out of the way and tell us to start a new one. Writes wait while
+// that happens, so no line is lost or split between the two.
+type ReopenWriter struct {
+	mu   sync.Mutex
+	path string
+	f    *os.File
+}
+
+func OpenReopenWriter(path string) (*ReopenWriter, error) {
+	f, err := openLogFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return &ReopenWriter{path: path, f: f}, nil
+}
+
+func openLogFile(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+}
+
+func (w *ReopenWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.f.Write(p)
+}
+
+// Start writing to whatever's at the path now. If that can't be opened, we
+// carry on with the old file.
+func (w *ReopenWriter) Reopen() error {
+	f, err := openLogFile(w.path)
+	if err != nil {
+		return err
+	}
+	w.mu.Lock()
+	old := w.f
+	w.f = f
+	w.mu.Unlock()
+	return old.Close()
+}
+
+// Reopen the file whenever a signal arrives, until the channel's closed.
+func (w *ReopenWriter) Watch(reopen <-chan os.Signal, logger *slog.Logger) {
+	for range reopen {
+		if err := w.Reopen(); err != nil {
+			logger.Error("Error reopening log file", "path", w.path, "err", err)
+			continue
+		}
+		logger.Info("Reopened log file", "path", w.path)
+	}
+}
+
+func (w *ReopenWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.f.Close()
+}
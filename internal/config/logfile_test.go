@@ -0,0 +1,76 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// What logrotate does: move the file, then SIGUSR1.
+func TestLogFileReopen(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "capthook.log")
+	w, err := OpenReopenWriter(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	logger := NewLogger(w, "text")
+	reopens := make(chan os.Signal, 1)
+	signal.Notify(reopens, syscall.SIGUSR1)
+	defer signal.Stop(reopens)
+	go w.Watch(reopens, logger)
+
+	logger.Info("before")
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	logger.Info("during") // Still goes to the old one, now .1
+	syscall.Kill(os.Getpid(), syscall.SIGUSR1)
+	for i := 0; ; i++ {
+		if _, err := os.Stat(path); err == nil {
+			break
+		} else if i == 100 {
+			t.Fatal("expected a new log file after SIGUSR1")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	logger.Info("after")
+
+	old, _ := ioutil.ReadFile(path + ".1")
+	if !strings.Contains(string(old), "msg=before") || !strings.Contains(string(old), "msg=during") || strings.Contains(string(old), "msg=after") {
+		t.Errorf("unexpected rotated file %q", old)
+	}
+	fresh, _ := ioutil.ReadFile(path)
+	if !strings.Contains(string(fresh), "msg=after") || strings.Contains(string(fresh), "msg=before") {
+		t.Errorf("unexpected new file %q", fresh)
+	}
+}
+
+// If the new file can't be made, we keep going with the old one.
+func TestLogFileReopenFails(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "logs", "capthook.log")
+	os.Mkdir(filepath.Dir(path), 0755)
+	w, err := OpenReopenWriter(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	os.Rename(filepath.Dir(path), filepath.Join(dir, "moved"))
+	if err := w.Reopen(); err == nil {
+		t.Fatal("expected an error reopening in a directory that's gone")
+	}
+	if _, err := w.Write([]byte("still here\n")); err != nil {
+		t.Fatal(err)
+	}
+	got, _ := ioutil.ReadFile(filepath.Join(dir, "moved", "capthook.log"))
+	if string(got) != "still here\n" {
+		t.Errorf("expected the old file to carry on, got %q", got)
+	}
+}
@@ -0,0 +1,258 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// The level we log at, from Config.LogLevel, changeable on reload.
+var Level = new(slog.LevelVar)
+
+// For when there's nobody to tell, like checking a config.
+var DiscardLogger = slog.New(slog.NewTextHandler(ioutil.Discard, nil))
+
+// A logger writing to w as text or JSON lines (Config.LogFormat), at
+// whatever Level is, with where each line came from.
+func NewLogger(w io.Writer, format string) *slog.Logger {
+	return slog.New(newLogHandler(w, format, true))
+}
+
+func newLogHandler(w io.Writer, format string, withTime bool) slog.Handler {
+	opts := &slog.HandlerOptions{
+		AddSource: true,
+		Level:     Level,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			// Whatever we're writing to already says when.
+			if !withTime && a.Key == slog.TimeKey && len(groups) == 0 {
+				return slog.Attr{}
+			}
+			// file.go:123 is plenty, like log.Lshortfile.
+			if src, ok := a.Value.Any().(*slog.Source); ok && a.Key == slog.SourceKey {
+				a.Value = slog.StringValue(filepath.Base(src.File) + ":" + strconv.Itoa(src.Line))
+			}
+			return a
+		},
+	}
+	if format == "json" {
+		return slog.NewJSONHandler(w, opts)
+	}
+	return slog.NewTextHandler(w, opts)
+}
+
+// Set up logging as the config says (LogOutput, LogFile, LogFormat and the
+// syslog settings). If syslog or the file can't be opened, logs go to stdout
+// instead, along with the error saying why. A LogFile comes back too, for
+// reopening on SIGUSR1.
+func OpenLogger(c *Config) (*slog.Logger, *ReopenWriter, error) {
+	var out *os.File
+	switch {
+	case c.LogOutput == "syslog":
+		w, err := dialSyslog(c.SyslogAddress, syslogFacilities[c.SyslogFacility], c.SyslogTag)
+		if err == nil {
+			return newSeverityLogger(c.LogFormat, w.writeLine), nil, nil
+		}
+		return NewLogger(os.Stdout, c.LogFormat), nil, fmt.Errorf("can't log to syslog, using stdout: %v", err)
+	case c.LogFile != "":
+		w, err := OpenReopenWriter(c.LogFile)
+		if err != nil {
+			return NewLogger(os.Stdout, c.LogFormat), nil, fmt.Errorf("can't log to LogFile, using stdout: %v", err)
+		}
+		return NewLogger(w, c.LogFormat), w, nil
+	case c.LogOutput == "stderr":
+		out = os.Stderr
+	default:
+		out = os.Stdout
+	}
+	if onJournal(out, os.Getenv("JOURNAL_STREAM")) {
+		// The journal takes each line's severity from a <N> in front.
+		return newSeverityLogger(c.LogFormat, func(severity int, line []byte) error {
+			_, err := fmt.Fprintf(out, "<%d>%s\n", severity, line)
+			return err
+		}), nil, nil
+	}
+	return NewLogger(out, c.LogFormat), nil, nil
+}
+
+// Whether f is the journal, which systemd tells us by putting its device and
+// inode in JOURNAL_STREAM.
+func onJournal(f *os.File, stream string) bool {
+	if stream == "" {
+		return false
+	}
+	fi, err := f.Stat()
+	if err != nil || fi.Sys() == nil {
+		return false
+	}
+	// syscall.Stat_t isn't everywhere, so go by name.
+	st := reflect.Indirect(reflect.ValueOf(fi.Sys()))
+	if st.Kind() != reflect.Struct {
+		return false
+	}
+	dev, ino := st.FieldByName("Dev"), st.FieldByName("Ino")
+	if !dev.IsValid() || !ino.IsValid() {
+		return false
+	}
+	return stream == fmt.Sprintf("%v:%v", dev, ino)
+}
+
+// Formats each record with the wrapped handler, then hands the line over with
+// its level as a syslog severity.
+type severityHandler struct {
+	inner slog.Handler // Writing into out.buf
+	out   *logLines
+}
+
+// Where a severityHandler's lines go, one at a time.
+type logLines struct {
+	mu    sync.Mutex
+	buf   bytes.Buffer
+	write func(severity int, line []byte) error
+}
+
+func newSeverityLogger(format string, write func(severity int, line []byte) error) *slog.Logger {
+	out := &logLines{write: write}
+	return slog.New(&severityHandler{newLogHandler(&out.buf, format, false), out})
+}
+
+func (h *severityHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.inner.Enabled(ctx, level)
+}
+
+func (h *severityHandler) Handle(ctx context.Context, r slog.Record) error {
+	h.out.mu.Lock()
+	defer h.out.mu.Unlock()
+	h.out.buf.Reset()
+	if err := h.inner.Handle(ctx, r); err != nil {
+		return err
+	}
+	return h.out.write(syslogSeverity(r.Level), bytes.TrimSuffix(h.out.buf.Bytes(), []byte("\n")))
+}
+
+func (h *severityHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &severityHandler{h.inner.WithAttrs(attrs), h.out}
+}
+
+func (h *severityHandler) WithGroup(name string) slog.Handler {
+	return &severityHandler{h.inner.WithGroup(name), h.out}
+}
+
+// Syslog severities for our levels. Anything in between goes down to the
+// one below.
+func syslogSeverity(level slog.Level) int {
+	switch {
+	case level >= slog.LevelError:
+		return 3 // err
+	case level >= slog.LevelWarn:
+		return 4 // warning
+	case level >= slog.LevelInfo:
+		return 6 // info
+	default:
+		return 7 // debug
+	}
+}
+
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5, "lpr": 6, "news": 7,
+	"uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19, "local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// Where the local syslog daemon usually listens.
+var syslogSockets = []string{"/dev/log", "/var/run/syslog", "/var/run/log"}
+
+// Sends lines to the local syslog daemon as RFC 5424 messages.
+type syslogWriter struct {
+	addr     string
+	facility int
+	tag      string
+	hostname string
+	conn     net.Conn
+	stream   bool // Not a datagram socket, so messages need a newline after
+}
+
+// Connect to syslog at addr (a unix socket), or wherever it usually is if
+// that's empty.
+func dialSyslog(addr string, facility int, tag string) (*syslogWriter, error) {
+	hostname, _ := os.Hostname()
+	if hostname == "" {
+		hostname = "-"
+	}
+	w := &syslogWriter{addr: addr, facility: facility, tag: tag, hostname: hostname}
+	if err := w.connect(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *syslogWriter) connect() error {
+	addrs := syslogSockets
+	if w.addr != "" {
+		addrs = []string{w.addr}
+	}
+	var err error
+	for _, addr := range addrs {
+		for _, network := range []string{"unixgram", "unix"} {
+			var conn net.Conn
+			if conn, err = net.Dial(network, addr); err == nil {
+				w.conn, w.stream = conn, network == "unix"
+				return nil
+			}
+		}
+	}
+	if err == nil {
+		err = errors.New("no syslog socket")
+	}
+	return err
+}
+
+// Send one line, reconnecting once if that fails, in case syslog restarted.
+func (w *syslogWriter) writeLine(severity int, line []byte) error {
+	msg := fmt.Sprintf("<%d>1 %s %s %s %d - - %s", w.facility*8+severity,
+		time.Now().Format("2006-01-02T15:04:05.000000Z07:00"), w.hostname, w.tag, os.Getpid(), line)
+	if w.stream {
+		msg += "\n"
+	}
+	if w.conn != nil {
+		if _, err := io.WriteString(w.conn, msg); err == nil {
+			return nil
+		}
+		w.conn.Close()
+		w.conn = nil
+	}
+	if err := w.connect(); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w.conn, msg)
+	return err
+}
+
+// Parse a LogLevel: debug, info, warn or error.
+func parseLogLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	err := level.UnmarshalText([]byte(s))
+	return level, err
+}
+
+// Log at error level and exit, like log.Fatal, with the caller as the
+// source.
+func Fatal(logger *slog.Logger, msg string, args ...interface{}) {
+	var pcs [1]uintptr
+	runtime.Callers(2, pcs[:])
+	r := slog.NewRecord(time.Now(), slog.LevelError, msg, pcs[0])
+	r.Add(args...)
+	logger.Handler().Handle(context.Background(), r)
+	os.Exit(1)
+}
@@ -0,0 +1,114 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"net"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestNewLogger(t *testing.T) {
+	defer Level.Set(slog.LevelInfo)
+	var buf bytes.Buffer
+	logger := NewLogger(&buf, "json")
+	Level.Set(slog.LevelWarn)
+	logger.Info("quiet")
+	logger.Warn("loud", "delivery", "abc")
+	var line map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("expected one JSON line, got %q", buf.String())
+	}
+	if line["msg"] != "loud" || line["level"] != "WARN" || line["delivery"] != "abc" || !strings.HasPrefix(line["source"].(string), "logging_test.go:") {
+		t.Errorf("unexpected line %v", line)
+	}
+
+	buf.Reset()
+	Level.Set(slog.LevelDebug)
+	NewLogger(&buf, "text").Debug("chatty")
+	if !strings.Contains(buf.String(), "level=DEBUG") {
+		t.Errorf("expected debug lines at debug level, got %q", buf.String())
+	}
+
+	for s, want := range map[string]slog.Level{"debug": slog.LevelDebug, "WARN": slog.LevelWarn, "error": slog.LevelError} {
+		if got, err := parseLogLevel(s); err != nil || got != want {
+			t.Errorf("%s: expected %v, got %v %v", s, want, got, err)
+		}
+	}
+	if _, err := parseLogLevel("loud"); err == nil {
+		t.Error("expected an error for a made up level")
+	}
+}
+
+func TestSyslog(t *testing.T) {
+	defer Level.Set(slog.LevelInfo)
+	Level.Set(slog.LevelDebug)
+	addr := filepath.Join(t.TempDir(), "log")
+	l, err := net.ListenPacket("unixgram", addr)
+	if err != nil {
+		t.Skip("no unix sockets here:", err)
+	}
+	defer l.Close()
+	conf := &Config{LogOutput: "syslog", LogFormat: "text", SyslogFacility: "local3", SyslogTag: "capthook", SyslogAddress: addr}
+	logger, _, err := OpenLogger(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.With("delivery", "abc").Warn("careful")
+	logger.Debug("chatty")
+
+	buf := make([]byte, 1024)
+	for _, want := range []string{"<156>1 ", "<159>1 "} { // local3 is 19, warning 4 and debug 7
+		l.SetReadDeadline(time.Now().Add(time.Second))
+		n, _, err := l.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := string(buf[:n])
+		if !strings.HasPrefix(got, want) || !strings.Contains(got, " capthook ") || strings.Contains(got, "time=") || strings.HasSuffix(got, "\n") {
+			t.Errorf("expected %s..., got %q", want, got)
+		}
+		if want == "<156>1 " && !strings.Contains(got, "msg=careful delivery=abc") {
+			t.Errorf("expected the message and its fields, got %q", got)
+		}
+	}
+
+	conf.SyslogAddress = filepath.Join(t.TempDir(), "nothing")
+	if logger, _, err := OpenLogger(conf); err == nil || logger == nil {
+		t.Errorf("expected a logger to stdout and an error, got %v %v", logger, err)
+	}
+}
+
+func TestOnJournal(t *testing.T) {
+	f, err := ioutil.TempFile(t.TempDir(), "stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	fi, _ := f.Stat()
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		t.Skip("no inodes here")
+	}
+	if !onJournal(f, fmt.Sprintf("%d:%d", st.Dev, st.Ino)) {
+		t.Error("expected our own file to be the journal")
+	}
+	if onJournal(f, "") || onJournal(f, fmt.Sprintf("%d:%d", st.Dev, st.Ino+1)) {
+		t.Error("expected someone else's stream not to be")
+	}
+
+	var buf bytes.Buffer
+	newSeverityLogger("text", func(severity int, line []byte) error {
+		fmt.Fprintf(&buf, "<%d>%s\n", severity, line)
+		return nil
+	}).Error("broken")
+	if got := buf.String(); !strings.HasPrefix(got, "<3>level=ERROR ") {
+		t.Errorf("expected a severity and no time, got %q", got)
+	}
+}
@@ -0,0 +1,50 @@
+package config
+
+import (
+	"path"
+	"regexp"
+	"strings"
+)
+
+// owner/name, and nothing that'd take us elsewhere in the API.
+var FullRepoName = regexp.MustCompile(`^[\w.-]+/[\w.-]+$`)
+
+// The owner/name that repo (as given to !issue or !pr, maybe "") means when
+// asked in channel, or "" if we can't tell.
+func (c *Config) LookupRepo(channel, repo string) string {
+	if strings.Contains(repo, "/") {
+		if !FullRepoName.MatchString(repo) || strings.Contains(repo, "..") {
+			return ""
+		}
+		return repo
+	}
+	routed := c.ChannelRepo(channel)
+	if repo == "" || strings.EqualFold(path.Base(routed), repo) {
+		return routed
+	}
+	if owner := c.SeenRepos.Owner(repo); owner != "" {
+		return owner + "/" + repo
+	}
+	return ""
+}
+
+// The repo whose [repos] entry is the only one sending to channel, or "" if
+// there isn't exactly one. Entries for more than one repo, like "owner/*",
+// don't count.
+func (c *Config) ChannelRepo(channel string) string {
+	var repo string
+	for _, name := range c.RepoNames() {
+		if !strings.Contains(name, "/") || strings.ContainsAny(name, "*?[") {
+			continue
+		}
+		for _, ch := range SplitChannels(c.Repos[name].Channels) {
+			if strings.EqualFold(ch, channel) {
+				if repo != "" {
+					return ""
+				}
+				repo = name
+			}
+		}
+	}
+	return repo
+}
@@ -0,0 +1,82 @@
+package config
+
+import (
+	"path"
+	"strings"
+	"sync"
+)
+
+// Config.ShowOwner settings.
+const (
+	ShowOwnerNever     = "never"
+	ShowOwnerAlways    = "always"
+	ShowOwnerAmbiguous = "ambiguous"
+)
+
+// Every owner/name we've had a delivery for, so we can tell when a repo's
+// name alone doesn't say which one it is. A nil RepoRegistry hasn't seen any.
+type RepoRegistry struct {
+	mu     sync.Mutex
+	owners map[string]map[string]bool // Owners by lower case repo name
+}
+
+func NewRepoRegistry() *RepoRegistry {
+	return &RepoRegistry{owners: make(map[string]map[string]bool)}
+}
+
+// Note a repo, given as owner/name.
+func (r *RepoRegistry) Add(full string) {
+	owner, name := path.Split(strings.ToLower(full))
+	if r == nil || owner == "" || name == "" {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.owners[name] == nil {
+		r.owners[name] = make(map[string]bool)
+	}
+	r.owners[name][owner] = true
+}
+
+// Whether we've seen repos called name under more than one owner.
+func (r *RepoRegistry) Ambiguous(name string) bool {
+	if r == nil {
+		return false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.owners[strings.ToLower(name)]) > 1
+}
+
+// The one owner we've seen a repo called name under, or "" for none or more
+// than one.
+func (r *RepoRegistry) Owner(name string) string {
+	if r == nil {
+		return ""
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for owner := range r.owners[strings.ToLower(name)] {
+		if len(r.owners[strings.ToLower(name)]) == 1 {
+			return owner
+		}
+	}
+	return ""
+}
+
+// What to call a repo in announcements, per ShowOwner: its name, or owner/name
+// where full has that.
+func (c *Config) RepoLabel(name, full string) string {
+	if full == "" || !strings.EqualFold(path.Base(full), name) {
+		return name // Not from GitHub or GitLab, or nothing to go on
+	}
+	switch c.ShowOwner {
+	case ShowOwnerAlways:
+		return full
+	case ShowOwnerAmbiguous:
+		if c.SeenRepos.Ambiguous(name) {
+			return full
+		}
+	}
+	return name
+}
@@ -0,0 +1,33 @@
+package config
+
+import (
+	"testing"
+)
+
+func TestRepoLabel(t *testing.T) {
+	c := &Config{ShowOwner: ShowOwnerAmbiguous, SeenRepos: NewRepoRegistry()}
+	c.SeenRepos.Add("UniversityRadioYork/website")
+	if got := c.RepoLabel("website", "UniversityRadioYork/website"); got != "website" {
+		t.Errorf("one owner: expected website, got %s", got)
+	}
+	c.SeenRepos.Add("ury-bots/website")
+	c.SeenRepos.Add("ury-bots/website") // Still only two owners
+	if got := c.RepoLabel("website", "UniversityRadioYork/website"); got != "UniversityRadioYork/website" {
+		t.Errorf("two owners: expected the owner shown, got %s", got)
+	}
+	if got := c.RepoLabel("myradio", "ury-bots/myradio"); got != "myradio" {
+		t.Errorf("unrelated repo: expected myradio, got %s", got)
+	}
+
+	c.ShowOwner = ShowOwnerAlways
+	if got := c.RepoLabel("myradio", "ury-bots/myradio"); got != "ury-bots/myradio" {
+		t.Errorf("always: expected the owner shown, got %s", got)
+	}
+	if got := c.RepoLabel("playout", ""); got != "playout" {
+		t.Errorf("no full name: expected playout, got %s", got)
+	}
+	c.ShowOwner = ShowOwnerNever
+	if got := c.RepoLabel("website", "ury-bots/website"); got != "website" {
+		t.Errorf("never: expected website, got %s", got)
+	}
+}
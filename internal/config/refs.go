@@ -0,0 +1,28 @@
+package config
+
+import (
+	"net/url"
+	"strings"
+)
+
+// Whether channel has ExpandRefs on.
+func (c *Config) ExpandRefs(channel string) bool {
+	for name, cc := range c.ChannelSettings {
+		if strings.EqualFold(name, channel) {
+			return cc.ExpandRefs
+		}
+	}
+	return false
+}
+
+// Hosts whose links are GitHub's: github.com, and for GitHub Enterprise
+// Server GitHubURL's, and GitHubAPIURL's if that's somewhere else.
+func (c *Config) GitHubHosts() []string {
+	hosts := []string{"github.com", "www.github.com"}
+	for _, s := range []string{c.GitHubURL, c.GitHubAPIURL} {
+		if u, err := url.Parse(s); err == nil && u.Host != "" && !strings.EqualFold(u.Host, "api.github.com") && !Contains(hosts, strings.ToLower(u.Host)) {
+			hosts = append(hosts, strings.ToLower(u.Host))
+		}
+	}
+	return hosts
+}
@@ -0,0 +1,401 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"path"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/format"
+	"github.com/UniversityRadioYork/CaptainHook/internal/github"
+)
+
+// Settings for one repository, from [repos."owner/name"], taking precedence
+// over the global ones. Anything left out comes from those; see ForRepo for
+// how they combine.
+type RepoConfig struct {
+	Channels    string              // Instead of the hook's, comma separated
+	Events      map[string][]string // Actions by event type, as Config.Events. ["none"] turns a type off
+	Branches    []string            // Instead of Config.Branches
+	Templates   map[string]string   `toml:"templates" json:"templates" yaml:"templates"` // Over Config.Templates, by event type
+	Colors      *bool               // Set false for plain text
+	ShortenURLs *bool               // Instead of Config.ShortenURLs
+	Verbs       map[string]string   `toml:"verbs" json:"verbs" yaml:"verbs"` // Over Config.Verbs, by action
+}
+
+// How to handle deliveries for one repo, with its [repos] entry merged over
+// the global settings:
+//   - Channels: the repo's replace the hook's; nil means use the hook's.
+//   - Events: by event type. A type the repo lists replaces the global list
+//     for that type, and the others come from Config.Events as usual.
+//   - Branches: the repo's replace Config.Branches entirely, if set.
+//   - Templates: by event type, the repo's over Config.Templates over the
+//     built in ones.
+//   - Colors: the repo's if set, otherwise on.
+//   - Shorten: the repo's ShortenURLs if set, otherwise Config.ShortenURLs.
+//   - Verbs: by action, the repo's over Config.Verbs.
+type RepoSettings struct {
+	Repo          string
+	Channels      []string
+	Events        map[string][]string
+	Branches      []string
+	Colors        bool
+	Shorten       bool
+	MinLength     int // Shortest URL worth shortening
+	Verbs         map[string]string
+	ActionColors  map[string]format.MIRCColor
+	Shortener     format.Shortener
+	Templates     map[string]*template.Template
+	CommitLines   int    // Commits to list under a push
+	MergeCommits  bool   // Whether merge commits get listed
+	SHALength     int    // How much of commit hashes to show
+	SnippetLength int    // How much of a comment to quote, 0 for none
+	NameStyle     string // How senders read, see senderName
+	Names         *github.NameCache
+	overrides     []string // Names of the templates the repo changes, for String
+}
+
+// The [repos] entries that apply to repo, least specific first: globs like
+// "owner/*" (or just "owner") by length, then the repo's own. Case doesn't
+// matter, as with GitHub. repo can be just an org, for org hooks' events
+// that aren't about a repo, and then only its owner entry applies.
+func (c *Config) repoConfigs(repo string) []string {
+	var globs []string
+	exact := ""
+	for _, name := range c.RepoNames() {
+		pattern := repoPattern(name)
+		if !strings.Contains(repo, "/") {
+			if repo != "" && strings.EqualFold(pattern, repo+"/*") {
+				globs = append(globs, name)
+			}
+		} else if strings.EqualFold(pattern, repo) {
+			exact = name
+		} else if ok, _ := path.Match(strings.ToLower(pattern), strings.ToLower(repo)); ok {
+			globs = append(globs, name)
+		}
+	}
+	sort.SliceStable(globs, func(i, j int) bool { return len(globs[i]) < len(globs[j]) })
+	if exact != "" {
+		globs = append(globs, exact)
+	}
+	return globs
+}
+
+// A [repos] key as a pattern: just an owner means all their repos.
+func repoPattern(name string) string {
+	if !strings.Contains(name, "/") {
+		return name + "/*"
+	}
+	return name
+}
+
+// The [repos] entries in order, for anything that needs to be predictable.
+func (c *Config) RepoNames() []string {
+	var names []string
+	for name := range c.Repos {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Work out the settings for repo (owner/name), which may be empty for the
+// global ones. Where more than one [repos] entry applies, e.g. "owner/*" and
+// "owner/name", each goes over the last as above, most specific last.
+func (c *Config) ForRepo(repo string) *RepoSettings {
+	s := &RepoSettings{Repo: repo, Colors: true, Shorten: true, Templates: format.EventTemplates, ActionColors: c.actionColorMap(), Shortener: c.urlShortener()}
+	if c == nil {
+		return s
+	}
+	s.Events = c.Events
+	s.Branches = c.Branches
+	s.Shorten = c.ShortenURLs
+	s.MinLength = c.ShortenMinLength
+	s.Verbs = c.Verbs
+	s.CommitLines = c.MaxCommitLines
+	s.MergeCommits = c.ShowMergeCommits
+	s.SHALength = c.SHALength
+	s.SnippetLength = c.CommentSnippetLength
+	s.NameStyle = c.NameStyle
+	s.Names = c.names
+	if c.templates != nil {
+		s.Templates = c.templates
+	}
+	for _, name := range c.repoConfigs(repo) {
+		s.apply(c.Repos[name], c.repoTemplates[name])
+	}
+	sort.Strings(s.overrides)
+	return s
+}
+
+// Put one [repos] entry over the settings so far.
+func (s *RepoSettings) apply(rc RepoConfig, templates map[string]*template.Template) {
+	if channels := SplitChannels(rc.Channels); channels != nil {
+		s.Channels = channels
+	}
+	if len(rc.Events) > 0 {
+		events := make(map[string][]string)
+		for ev, actions := range s.Events {
+			events[ev] = actions
+		}
+		for ev, actions := range rc.Events {
+			events[ev] = actions
+		}
+		s.Events = events
+	}
+	if rc.Branches != nil {
+		s.Branches = rc.Branches
+	}
+	if rc.Colors != nil {
+		s.Colors = *rc.Colors
+	}
+	if rc.ShortenURLs != nil {
+		s.Shorten = *rc.ShortenURLs
+	}
+	if len(rc.Verbs) > 0 {
+		verbs := make(map[string]string)
+		for a, v := range s.Verbs {
+			verbs[a] = v
+		}
+		for a, v := range rc.Verbs {
+			verbs[a] = v
+		}
+		s.Verbs = verbs
+	}
+	if len(templates) > 0 {
+		merged := make(map[string]*template.Template)
+		for name, t := range s.Templates {
+			merged[name] = t
+		}
+		for name, t := range templates {
+			merged[name] = t
+			if !Contains(s.overrides, name) {
+				s.overrides = append(s.overrides, name)
+			}
+		}
+		s.Templates = merged
+	}
+}
+
+// Parse the templates each [repos] entry overrides. Returns a problem per bad
+// template, or bad pattern.
+func (c *Config) loadRepoTemplates() []error {
+	var errs []error
+	c.repoTemplates = make(map[string]map[string]*template.Template)
+	for _, repo := range c.RepoNames() {
+		if _, err := path.Match(repoPattern(repo), ""); err != nil {
+			errs = append(errs, fmt.Errorf("repos.%s: bad pattern: %v", repo, err))
+		}
+		rc := c.Repos[repo]
+		parsed := make(map[string]*template.Template)
+		for name, text := range rc.Templates {
+			t, err := format.LoadTemplates(map[string]string{name: text})
+			if err != nil {
+				errs = append(errs, fmt.Errorf("repos.%s.templates.%s: %v", repo, name, err))
+				continue
+			}
+			parsed[name] = t[name]
+		}
+		c.repoTemplates[repo] = parsed
+	}
+	return errs
+}
+
+// Whether an Event's action should be announced for this repo.
+func (s *RepoSettings) WantsAction(e *format.Event) bool {
+	return wantsAction(s.Events, e)
+}
+
+// Turn an Event into an IRC announcement.
+func (s *RepoSettings) Format(ctx context.Context, e *format.Event, logger *slog.Logger) string {
+	url := e.URL
+	if !e.KeepURL && s.Shorten && len(url) >= s.MinLength {
+		var err error
+		url, err = format.Shorten(ctx, s.Shortener, e.URL, e.Private)
+		if err != nil {
+			logger.Error("Error shortening URL", "url", e.URL, "err", err)
+		}
+	}
+	plain := plainEvent(e)
+	text := e.Message // Generic hooks render with their own template
+	if e.Type != "generic" {
+		data := format.TemplateData{
+			Event:   *plain,
+			URL:     format.StripFormatting(url),
+			LongURL: plain.URL,
+			Verb:    format.EventVerb(plain, s.Verbs, s.ActionColors),
+		}
+		data.Sender = s.senderName(plain, logger)
+		if plain.Via != "" {
+			data.Sender += " " + format.IrcColorize("via "+plain.Via, format.ColorGrey)
+		}
+		data.Comment = format.Truncate(plain.Comment, s.SnippetLength) // Nothing for 0
+		var err error
+		text, err = renderEvent(ctx, s.Templates[e.Type], data)
+		if err != nil {
+			logger.Error("Error formatting event", "event", e.Type, "repo", e.Repo, "err", err)
+		}
+	}
+	if text != "" {
+		text += format.AgeSuffix(e)
+	}
+	if e.Type == "push" && text != "" {
+		for _, line := range s.commitLines(plain) {
+			text += "\n" + line
+		}
+	}
+	if !s.Colors {
+		return format.StripFormatting(text)
+	}
+	return endWithReset(text)
+}
+
+// A line for each of a push's first few commits, then one for how many more
+// there are. They go out as messages of their own after the summary.
+func (s *RepoSettings) commitLines(e *format.Event) []string {
+	if s.CommitLines <= 0 {
+		return nil
+	}
+	var lines []string
+	merges := 0
+	for _, c := range e.Log {
+		if !s.MergeCommits && strings.HasPrefix(c.Subject, "Merge pull request ") {
+			merges++ // Left out, so not worth a mention in "and more" either
+			continue
+		}
+		if len(lines) < s.CommitLines {
+			lines = append(lines, s.commitLine(c))
+		}
+	}
+	total := e.Commits
+	if total < len(e.Log) {
+		total = len(e.Log)
+	}
+	if more := total - merges - len(lines); more > 0 && len(lines) > 0 {
+		lines = append(lines, fmt.Sprintf("…and %d more", more))
+	}
+	return lines
+}
+
+// Who made c, its hash and its subject, leaving out whichever we haven't got.
+func (s *RepoSettings) commitLine(c format.Commit) string {
+	var parts []string
+	for _, p := range []string{c.Author, s.formatSHA(c.ID), format.Truncate(c.Subject, 100)} {
+		if p != "" {
+			parts = append(parts, p)
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// A commit hash cut down to SHALength, in grey. Missing ones, including the
+// all zeros GitHub and GitLab give for the commit before a new branch, are
+// nothing at all.
+func (s *RepoSettings) formatSHA(sha string) string {
+	if strings.Trim(sha, "0") == "" {
+		return ""
+	}
+	if len(sha) > s.SHALength {
+		sha = sha[:s.SHALength]
+	}
+	return format.IrcColorize(sha, format.ColorGrey)
+}
+
+// Render an Event through a template. No template means nothing to say.
+func renderEvent(ctx context.Context, t *template.Template, data format.TemplateData) (string, error) {
+	if t == nil {
+		return "", nil
+	}
+	var buf bytes.Buffer
+	if err := format.Execute(ctx, t, &buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// e with any formatting taken out of what came from the payload, before we
+// put our own in. Otherwise anyone can colour in their issue titles, and
+// leave a colour running on into the rest of the announcement.
+func plainEvent(e *format.Event) *format.Event {
+	p := *e
+	for _, f := range []*string{&p.Action, &p.Repo, &p.Title, &p.Sender, &p.SenderName, &p.Via, &p.URL, &p.Ref, &p.Message, &p.Comment} {
+		*f = format.StripFormatting(*f)
+	}
+	p.Log = make([]format.Commit, len(e.Log))
+	for i, c := range e.Log {
+		p.Log[i] = format.Commit{ID: format.StripFormatting(c.ID), Author: format.StripFormatting(c.Author), Subject: format.StripFormatting(c.Subject)}
+	}
+	return &p
+}
+
+// End every line of text with a reset, so that whatever formatting's still
+// going at the end of one can't carry on anywhere.
+func endWithReset(text string) string {
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		if line != "" && !strings.HasSuffix(line, "\x0F") {
+			lines[i] += "\x0F"
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Sum up the settings, for !status.
+func (s *RepoSettings) String() string {
+	var parts []string
+	if s.Channels != nil {
+		parts = append(parts, "channels "+strings.Join(s.Channels, ","))
+	} else {
+		parts = append(parts, "channels from the hook")
+	}
+	if len(s.Events) > 0 {
+		var events []string
+		for ev, actions := range s.Events {
+			events = append(events, ev+"="+strings.Join(actions, ","))
+		}
+		sort.Strings(events)
+		parts = append(parts, "events "+strings.Join(events, " "))
+	} else {
+		parts = append(parts, "default events")
+	}
+	if len(s.Branches) > 0 {
+		parts = append(parts, "branches "+strings.Join(s.Branches, ","))
+	} else {
+		parts = append(parts, "all branches")
+	}
+	parts = append(parts, "colours "+onOff(s.Colors), "shortening "+onOff(s.Shorten))
+	if len(s.overrides) > 0 {
+		parts = append(parts, "own templates for "+strings.Join(s.overrides, ","))
+	}
+	return strings.Join(parts, "; ")
+}
+
+func onOff(b bool) string {
+	if b {
+		return "on"
+	}
+	return "off"
+}
+
+// Who sent e, as the settings want it shown. Only GitHub logins get names,
+// from the payload if it had one, otherwise from Names.
+func (s *RepoSettings) senderName(e *format.Event, logger *slog.Logger) string {
+	if s.NameStyle == github.NameStyleLogin || s.NameStyle == "" || e.Source != format.SourceGitHub {
+		return e.Sender
+	}
+	name := github.CleanName(e.SenderName)
+	if name == "" {
+		name = s.Names.Name(e.Sender, logger)
+	}
+	switch {
+	case name == "" || name == e.Sender:
+		return e.Sender
+	case s.NameStyle == github.NameStyleName:
+		return name
+	}
+	return name + " (" + e.Sender + ")"
+}
@@ -0,0 +1,292 @@
+package config
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/format"
+	"github.com/UniversityRadioYork/CaptainHook/internal/github"
+	"github.com/UniversityRadioYork/CaptainHook/internal/testutil"
+)
+
+func TestForRepo(t *testing.T) {
+	off := false
+	c := validConfig()
+	c.Events = map[string][]string{"issues": {"opened"}, "pull_request": {"merged"}}
+	c.Branches = []string{"main"}
+	c.Templates = map[string]string{"issues": "global {{ .Title }}"}
+	c.Repos = map[string]RepoConfig{
+		"ury/website": {
+			Channels:  "#web",
+			Events:    map[string][]string{"issues": {"closed"}, "push": {"none"}},
+			Branches:  []string{"live"},
+			Templates: map[string]string{"push": "website {{ .Ref }}"},
+			Colors:    &off,
+		},
+		"ury/quiet": {ShortenURLs: &off},
+	}
+	if errs := c.Validate(); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+
+	s := c.ForRepo("URY/Website") // GitHub doesn't care about case, so neither do we
+	if strings.Join(s.Channels, ",") != "#web" || s.Colors || !s.Shorten {
+		t.Errorf("website: wrong channels or toggles: %+v", s)
+	}
+	// The repo's issues list replaces the global one, pull_request is inherited.
+	for _, c := range []struct {
+		e    format.Event
+		want bool
+	}{
+		{format.Event{Type: "issues", Action: "closed"}, true},
+		{format.Event{Type: "issues", Action: "opened"}, false},
+		{format.Event{Type: "pull_request", Action: "closed", Merged: true}, true},
+		{format.Event{Type: "push", Action: "pushed"}, false},
+	} {
+		if got := s.WantsAction(&c.e); got != c.want {
+			t.Errorf("website %s %s: expected %v, got %v", c.e.Type, c.e.Action, c.want, got)
+		}
+	}
+	if strings.Join(s.Branches, ",") != "live" {
+		t.Errorf("website: expected branches to be replaced, got %v", s.Branches)
+	}
+	logger := DiscardLogger
+	if got := s.Format(context.Background(), &format.Event{Type: "issues", Title: "Hi", KeepURL: true}, logger); got != "global Hi" {
+		t.Errorf("website: expected the global issues template, got %q", got)
+	}
+	if got := s.Format(context.Background(), &format.Event{Type: "push", Ref: "live", KeepURL: true}, logger); got != "website live" {
+		t.Errorf("website: expected its own push template, got %q", got)
+	}
+
+	q := c.ForRepo("ury/quiet")
+	if q.Channels != nil || !q.Colors || q.Shorten || strings.Join(q.Branches, ",") != "main" {
+		t.Errorf("quiet: expected everything but Shorten inherited, got %+v", q)
+	}
+	if !q.WantsAction(&format.Event{Type: "push", Action: "pushed"}) {
+		t.Errorf("quiet: expected pushes announced")
+	}
+
+	if got := c.ForRepo("ury/other").String(); got != "channels from the hook; events issues=opened pull_request=merged; branches main; colours on; shortening on" {
+		t.Errorf("other: unexpected summary %q", got)
+	}
+	if got := s.String(); !strings.Contains(got, "push=none") || !strings.Contains(got, "own templates for push") {
+		t.Errorf("website: unexpected summary %q", got)
+	}
+	if all := strings.Join(c.AllChannels(), ","); all != "#a,#web" {
+		t.Errorf("expected repo channels to be joined, got %s", all)
+	}
+}
+
+func TestRepoFormatting(t *testing.T) {
+	off := false
+	c := validConfig()
+	c.Repos = map[string]RepoConfig{"ury/website": {Colors: &off, ShortenURLs: &off}}
+	if errs := c.Validate(); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	c.LinkShortener = testutil.FakeShortener("https://short/")
+	e := &format.Event{Type: "issues", Action: "opened", Repo: "website", Number: 1, Title: "Hi", Sender: "x", URL: "https://long/"}
+	logger := DiscardLogger
+	if got, want := c.ForRepo("ury/website").Format(context.Background(), e, logger), "[website] Issue #1 opened by x: Hi. https://long/"; got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+	if got := c.ForRepo("ury/other").Format(context.Background(), e, logger); !strings.Contains(got, "\x03") || !strings.HasSuffix(got, "https://short/\x0f") {
+		t.Errorf("expected colours and a short URL elsewhere, got %q", got)
+	}
+}
+
+func TestCommitLines(t *testing.T) {
+	log := []format.Commit{
+		{ID: "1111111aaaa", Author: "alice", Subject: "Fix the relay"},
+		{ID: "2222222bbbb", Author: "bob", Subject: "Merge pull request #4 from ury/relay"},
+		{ID: "3333333cccc", Author: "bob", Subject: "Tidy up"},
+		{ID: "4444444dddd", Author: "carol", Subject: "Add a test"},
+	}
+	e := &format.Event{Type: "push", Action: "pushed", Repo: "website", Sender: "alice", Ref: "main", Commits: 15, Log: log, URL: "https://long/"}
+	s := &RepoSettings{Templates: format.EventTemplates, CommitLines: 3, SHALength: 7}
+	want := "[website] alice pushed 15 commits to main. https://long/\n" +
+		"alice 1111111 Fix the relay\nbob 3333333 Tidy up\ncarol 4444444 Add a test\n…and 11 more"
+	if got := s.Format(context.Background(), e, DiscardLogger); got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+	s.MergeCommits, s.CommitLines = true, 2
+	if got := s.commitLines(e); len(got) != 3 || !strings.Contains(got[1], "Merge pull request") || got[2] != "…and 13 more" {
+		t.Errorf("unexpected lines with merges %q", got)
+	}
+	s.CommitLines = 0
+	if got := s.Format(context.Background(), e, DiscardLogger); strings.Contains(got, "\n") {
+		t.Errorf("expected just the summary, got %q", got)
+	}
+	e.Commits, e.Log, s.CommitLines = 1, log[:1], 3
+	if got := s.commitLines(e); len(got) != 1 {
+		t.Errorf("expected no more line for everything shown, got %q", got)
+	}
+	s.MergeCommits = false
+	e.Commits, e.Log = 2, log[:2]
+	if got := s.commitLines(e); len(got) != 1 {
+		t.Errorf("expected no more line for just a merge left out, got %q", got)
+	}
+	e.Commits, e.Log = 1, []format.Commit{{ID: "0000000000", Author: "alice", Subject: "Fix the relay"}}
+	if got := s.commitLines(e); len(got) != 1 || got[0] != "alice Fix the relay" {
+		t.Errorf("expected no gap for a missing hash, got %q", got)
+	}
+}
+
+func TestFormatSHA(t *testing.T) {
+	s := &RepoSettings{SHALength: 7}
+	for sha, want := range map[string]string{
+		"1111111aaaabbbbccccdddd": "\x03141111111\x0f",
+		"abc":                     "\x0314abc\x0f",
+		"":                        "",
+		"0000000000000000000000000000000000000000": "",
+	} {
+		if got := s.formatSHA(sha); got != want {
+			t.Errorf("%q: expected %q, got %q", sha, want, got)
+		}
+	}
+	s.SHALength = 10
+	if got := format.StripFormatting(s.formatSHA("1111111aaaabbbb")); got != "1111111aaa" {
+		t.Errorf("expected 10 characters, got %q", got)
+	}
+}
+
+func TestSnippetLength(t *testing.T) {
+	e := &format.Event{Type: "issue_comment", Action: "created", Repo: "website", Sender: "alice", Number: 4, Title: "Relay", URL: "https://long/",
+		Comment: "Only the main one, see the logs"}
+	s := &RepoSettings{Templates: format.EventTemplates, SnippetLength: 12}
+	want := `[website] alice commented on #4 (Relay): "Only the..." https://long/`
+	if got := s.Format(context.Background(), e, DiscardLogger); got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+	s.SnippetLength = 0
+	want = "[website] alice commented on #4 (Relay) https://long/"
+	if got := s.Format(context.Background(), e, DiscardLogger); got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
+
+func TestRepoValidation(t *testing.T) {
+	c := validConfig()
+	c.Repos = map[string]RepoConfig{"ury/website": {
+		Channels:  "web",
+		Templates: map[string]string{"push": "{{ .Nope"},
+		Events:    map[string][]string{"issues": {"opend"}},
+	}}
+	errs := c.Validate()
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "repos.ury/website.templates.push") {
+		t.Errorf("expected a problem with the push template, got %v", errs)
+	}
+	if c.Repos["ury/website"].Channels != "#web" {
+		t.Errorf("expected the channel fixed up, got %q", c.Repos["ury/website"].Channels)
+	}
+	if w := strings.Join(c.Warnings, "\n"); !strings.Contains(w, "repos.ury/website.Events: issues has no opend action") {
+		t.Errorf("expected a warning about the typo, got %q", w)
+	}
+}
+
+func TestForRepoOwners(t *testing.T) {
+	off := false
+	c := validConfig()
+	c.Repos = map[string]RepoConfig{
+		"UniversityRadioYork":         {Channels: "#ury", Colors: &off},
+		"UniversityRadioYork/web*":    {Branches: []string{"live"}},
+		"UniversityRadioYork/website": {Channels: "#web"},
+		"ury-bots/*":                  {Channels: "#bots"},
+	}
+	if errs := c.Validate(); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	for _, c := range []struct {
+		repo     string
+		settings *RepoSettings
+		channels string
+		branches string
+		colors   bool
+	}{
+		// Owner, then glob, then the repo itself, each over the last.
+		{"UniversityRadioYork/website", c.ForRepo("UniversityRadioYork/website"), "#web", "live", false},
+		{"UniversityRadioYork/webcam", c.ForRepo("universityradioyork/webcam"), "#ury", "live", false},
+		{"UniversityRadioYork/myradio", c.ForRepo("UniversityRadioYork/myradio"), "#ury", "", false},
+		{"ury-bots/website", c.ForRepo("ury-bots/website"), "#bots", "", true},
+		{"someone/website", c.ForRepo("someone/website"), "", "", true},
+	} {
+		s := c.settings
+		if got := strings.Join(s.Channels, ","); got != c.channels {
+			t.Errorf("%s: expected channels %q, got %q", c.repo, c.channels, got)
+		}
+		if got := strings.Join(s.Branches, ","); got != c.branches {
+			t.Errorf("%s: expected branches %q, got %q", c.repo, c.branches, got)
+		}
+		if s.Colors != c.colors {
+			t.Errorf("%s: expected colours %v", c.repo, c.colors)
+		}
+	}
+}
+
+func TestShortening(t *testing.T) {
+	logger := DiscardLogger
+	body := func(private bool) []byte {
+		return []byte(`{"action":"opened","issue":{"number":1,"title":"Hi","html_url":"https://github.com/ury/website/issues/1"},` +
+			`"repository":{"name":"website","private":` + strconv.FormatBool(private) + `}}`)
+	}
+	for _, c := range []struct {
+		name    string
+		change  func(*Config)
+		private bool
+		short   bool
+	}{
+		{"default", func(c *Config) {}, false, true},
+		{"private repo", func(c *Config) {}, true, false},
+		{"turned off", func(c *Config) { c.ShortenURLs = false }, false, false},
+		{"already short", func(c *Config) { c.ShortenMinLength = 60 }, false, false},
+		{"long enough", func(c *Config) { c.ShortenMinLength = 30 }, false, true},
+	} {
+		conf := validConfig()
+		conf.LinkShortener = testutil.FakeShortener("https://short/")
+		c.change(conf)
+		e, err := github.ParseGitHubEvent("issues", body(c.private))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := conf.ForRepo("ury/website").Format(context.Background(), e, logger)
+		if short := strings.Contains(got, "https://short/"); short != c.short {
+			t.Errorf("%s: expected shortened %v, got %q", c.name, c.short, got)
+		}
+	}
+}
+
+func TestSenderName(t *testing.T) {
+	var asked int32
+	api := testutil.FakeUsersAPI(t, &asked)
+	defer api.Close()
+	names := github.NewNameCache(github.NewGitHubClient(), api.URL, "tok", time.Hour)
+	names.Name("x1tot", DiscardLogger)
+	names.Fetching.Wait()
+
+	for _, c := range []struct {
+		style string
+		e     format.Event
+		want  string
+	}{
+		{github.NameStyleBoth, format.Event{Source: format.SourceGitHub, Sender: "x1tot"}, "Joe Bloggs (x1tot)"},
+		{github.NameStyleName, format.Event{Source: format.SourceGitHub, Sender: "x1tot"}, "Joe Bloggs"},
+		{github.NameStyleLogin, format.Event{Source: format.SourceGitHub, Sender: "x1tot"}, "x1tot"},
+		{github.NameStyleBoth, format.Event{Source: format.SourceGitHub, Sender: "mstratford", SenderName: "Matt Stratford"}, "Matt Stratford (mstratford)"},
+		{github.NameStyleBoth, format.Event{Source: format.SourceGitHub, Sender: "lordalex", SenderName: "lordalex"}, "lordalex"},
+		{github.NameStyleBoth, format.Event{Source: format.SourceGitLab, Sender: "x1tot"}, "x1tot"},
+	} {
+		s := &RepoSettings{NameStyle: c.style, Names: names}
+		if got := s.senderName(&c.e, DiscardLogger); got != c.want {
+			t.Errorf("%s %+v: expected %q, got %q", c.style, c.e, c.want, got)
+		}
+	}
+
+	// Without a token, names only come from payloads.
+	s := &RepoSettings{NameStyle: github.NameStyleBoth}
+	if got := s.senderName(&format.Event{Source: format.SourceGitHub, Sender: "x1tot"}, DiscardLogger); got != "x1tot" {
+		t.Errorf("expected just the login, got %q", got)
+	}
+}
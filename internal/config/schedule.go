@@ -0,0 +1,248 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/format"
+)
+
+// Whether an Event is in PriorityEvents, by type or type.action. A hook
+// being deleted always is, as nobody hears about it otherwise.
+func (c *Config) IsPriority(e *format.Event) bool {
+	if e.Type == "meta" && e.Action == "deleted" {
+		return true
+	}
+	return c != nil && (Contains(c.PriorityEvents, e.Type) || Contains(c.PriorityEvents, e.Type+"."+e.Action))
+}
+
+// Settings for one channel, from [channel_settings."#name"].
+type ChannelConfig struct {
+	Schedule      []string // When to announce here, e.g. "Wed 18:00-21:30" or "Mon-Fri 09:00-17:00"; empty for always
+	OutsideWindow string   // What to do with announcements outside the schedule: drop (the default) or defer
+
+	DigestThreshold int // Instead of Config.DigestThreshold, -1 for never
+
+	Brief bool // Leave out extras, like how long an issue or PR was open
+
+	PrefixStyle string // How the repo at the start reads, see outputs/prefix.go
+
+	ExpandRefs bool // Follow up "see #123", or a pasted GitHub link, with a line about it, see ircbot/refs.go
+}
+
+// What ChannelConfig.OutsideWindow can be.
+const (
+	OutsideWindowDrop  = "drop"
+	OutsideWindowDefer = "defer"
+)
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// A range of times on some days of the week. A range that ends before it
+// starts runs past midnight into the next day.
+type Window struct {
+	Days       [7]bool // By time.Weekday
+	Start, End time.Duration
+}
+
+// Parse something like "Wed 18:00-21:30", "Mon-Fri 09:00-17:00" or
+// "Sat,Sun 22:00-02:00".
+func ParseWindow(spec string) (Window, error) {
+	var w Window
+	fields := strings.Fields(spec)
+	if len(fields) != 2 {
+		return w, fmt.Errorf("%q: expected days then times, e.g. Mon-Fri 09:00-17:00", spec)
+	}
+	for _, part := range strings.Split(strings.ToLower(fields[0]), ",") {
+		from, to := part, part
+		if i := strings.Index(part, "-"); i >= 0 {
+			from, to = part[:i], part[i+1:]
+		}
+		first, ok1 := weekdays[from]
+		last, ok2 := weekdays[to]
+		if !ok1 || !ok2 {
+			return w, fmt.Errorf("%q: unknown day in %q", spec, part)
+		}
+		for d := first; ; d = (d + 1) % 7 {
+			w.Days[d] = true
+			if d == last {
+				break
+			}
+		}
+	}
+	times := strings.Split(fields[1], "-")
+	if len(times) != 2 {
+		return w, fmt.Errorf("%q: expected a time range like 18:00-21:30", spec)
+	}
+	var err error
+	if w.Start, err = parseClock(times[0]); err != nil {
+		return w, fmt.Errorf("%q: %v", spec, err)
+	}
+	if w.End, err = parseClock(times[1]); err != nil {
+		return w, fmt.Errorf("%q: %v", spec, err)
+	}
+	return w, nil
+}
+
+func parseClock(s string) (time.Duration, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 2 {
+		return 0, fmt.Errorf("bad time %q", s)
+	}
+	h, err1 := strconv.Atoi(parts[0])
+	m, err2 := strconv.Atoi(parts[1])
+	if err1 != nil || err2 != nil || h < 0 || h > 24 || m < 0 || m > 59 || (h == 24 && m > 0) {
+		return 0, fmt.Errorf("bad time %q", s)
+	}
+	return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute, nil
+}
+
+// Whether t, in the timezone it's in, falls inside the window.
+func (w Window) Contains(t time.Time) bool {
+	since := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+	if w.Start <= w.End {
+		return w.Days[t.Weekday()] && since >= w.Start && since < w.End
+	}
+	// Past midnight: the evening of a listed day, or the morning after one.
+	return (w.Days[t.Weekday()] && since >= w.Start) || (w.Days[(t.Weekday()+6)%7] && since < w.End)
+}
+
+// Whether announcements can go to channel at t. Channels without a schedule
+// are always open.
+func (c *Config) ChannelOpen(channel string, t time.Time) bool {
+	windows, ok := c.schedules[strings.ToLower(channel)]
+	if !ok {
+		return true
+	}
+	t = t.In(c.Location)
+	for _, w := range windows {
+		if w.Contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// How channel deals with announcements outside its schedule.
+func (c *Config) OutsideWindow(channel string) string {
+	for name, cc := range c.ChannelSettings {
+		if strings.EqualFold(name, channel) && cc.OutsideWindow != "" {
+			return cc.OutsideWindow
+		}
+	}
+	return OutsideWindowDrop
+}
+
+// Whether channel wants announcements without the extras.
+func (c *Config) Brief(channel string) bool {
+	for name, cc := range c.ChannelSettings {
+		if strings.EqualFold(name, channel) {
+			return cc.Brief
+		}
+	}
+	return false
+}
+
+// Parse the channel schedules and timezone for ChannelOpen.
+func (c *Config) loadSchedules() []error {
+	var errs []error
+	c.Location = time.Local
+	if c.Timezone != "" {
+		loc, err := time.LoadLocation(c.Timezone)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("Timezone: %v", err))
+		} else {
+			c.Location = loc
+		}
+	}
+	if m := c.MuteMode; m != "" && m != OutsideWindowDrop && m != OutsideWindowDefer {
+		errs = append(errs, fmt.Errorf("MuteMode: expected drop or defer, not %q", c.MuteMode))
+	}
+	c.schedules = make(map[string][]Window)
+	for name, cc := range c.ChannelSettings {
+		field := "channel_settings." + name
+		if o := cc.OutsideWindow; o != "" && o != OutsideWindowDrop && o != OutsideWindowDefer {
+			errs = append(errs, fmt.Errorf("%s.OutsideWindow: expected drop or defer, not %q", field, o))
+		}
+		if err := checkPrefixStyle(cc.PrefixStyle); err != nil {
+			errs = append(errs, fmt.Errorf("%s.PrefixStyle: %v", field, err))
+		}
+		if len(cc.Schedule) == 0 {
+			continue
+		}
+		var windows []Window
+		for _, spec := range cc.Schedule {
+			w, err := ParseWindow(spec)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s.Schedule: %v", field, err))
+				continue
+			}
+			windows = append(windows, w)
+		}
+		c.schedules[strings.ToLower(name)] = windows
+	}
+	return errs
+}
+
+// The DigestThreshold for a channel: its own if it has one, otherwise the
+// global one. 0 means never digest.
+func (c *Config) ChannelDigestThreshold(channel string) int {
+	for name, cc := range c.ChannelSettings {
+		if strings.EqualFold(name, channel) && cc.DigestThreshold != 0 {
+			if cc.DigestThreshold < 0 {
+				return 0
+			}
+			return cc.DigestThreshold
+		}
+	}
+	return c.DigestThreshold
+}
+
+// How channels can have the repo at the start of announcements shown, by
+// ChannelConfig.PrefixStyle. Anything else is a template with {owner} and
+// {repo} in it, like "{repo}:".
+const (
+	PrefixRepo      = "repo"       // [website], as the default templates have it
+	PrefixOwnerRepo = "owner/repo" // [UniversityRadioYork/website]
+	PrefixNone      = "none"
+)
+
+var prefixPlaceholder = regexp.MustCompile(`\{[^{}]*\}`)
+
+// How channel wants repos named, "" for however the template did it.
+func (c *Config) PrefixStyle(channel string) string {
+	if c == nil {
+		return ""
+	}
+	for name, cc := range c.ChannelSettings {
+		if strings.EqualFold(name, channel) {
+			return cc.PrefixStyle
+		}
+	}
+	return ""
+}
+
+// Check a PrefixStyle is one we know, or a template with only {owner} and
+// {repo} in it.
+func checkPrefixStyle(style string) error {
+	switch style {
+	case "", PrefixRepo, PrefixOwnerRepo, PrefixNone:
+		return nil
+	}
+	placeholders := prefixPlaceholder.FindAllString(style, -1)
+	if len(placeholders) == 0 {
+		return fmt.Errorf("expected repo, owner/repo, none or a template with {owner} or {repo} in it, not %q", style)
+	}
+	for _, p := range placeholders {
+		if p != "{owner}" && p != "{repo}" {
+			return fmt.Errorf("unknown %s in %q, expected {owner} or {repo}", p, style)
+		}
+	}
+	return nil
+}
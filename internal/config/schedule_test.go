@@ -0,0 +1,92 @@
+package config
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWindow(t *testing.T) {
+	london, err := time.LoadLocation("Europe/London")
+	if err != nil {
+		t.Skip("no timezone data")
+	}
+	at := func(s string) time.Time {
+		tm, err := time.ParseInLocation("Mon 2006-01-02 15:04", s, london)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tm
+	}
+	for _, c := range []struct {
+		spec string
+		at   string
+		want bool
+	}{
+		{"Wed 18:00-21:30", "Wed 2026-10-14 18:00", true},
+		{"Wed 18:00-21:30", "Wed 2026-10-14 21:30", false},
+		{"Wed 18:00-21:30", "Thu 2026-10-15 19:00", false},
+		{"Mon-Fri 09:00-17:00", "Fri 2026-10-16 12:00", true},
+		{"Mon-Fri 09:00-17:00", "Sat 2026-10-17 12:00", false},
+		{"Fri-Mon 00:00-24:00", "Sun 2026-10-18 23:59", true},
+		{"Sat,Sun 22:00-02:00", "Sun 2026-10-18 01:00", true},  // Saturday night
+		{"Sat,Sun 22:00-02:00", "Mon 2026-10-19 01:59", true},  // Sunday night
+		{"Sat,Sun 22:00-02:00", "Sat 2026-10-17 01:00", false}, // Friday night
+	} {
+		w, err := ParseWindow(c.spec)
+		if err != nil {
+			t.Fatalf("%s: %v", c.spec, err)
+		}
+		if got := w.Contains(at(c.at)); got != c.want {
+			t.Errorf("%s at %s: expected %v, got %v", c.spec, c.at, c.want, got)
+		}
+	}
+	for _, bad := range []string{"Wed", "Wed 18:00", "Wod 18:00-19:00", "Wed 25:00-26:00", "Wed 18:00-19:60"} {
+		if _, err := ParseWindow(bad); err == nil {
+			t.Errorf("%q: expected an error", bad)
+		}
+	}
+}
+
+func TestScheduleValidation(t *testing.T) {
+	c := validConfig()
+	c.Timezone = "Mars/Olympus_Mons"
+	c.ChannelSettings = map[string]ChannelConfig{
+		"#a": {Schedule: []string{"Someday 18:00-21:00"}, OutsideWindow: "hold"},
+		"#b": {},
+	}
+	errs := c.Validate()
+	var got []string
+	for _, err := range errs {
+		got = append(got, err.Error())
+	}
+	all := strings.Join(got, "\n")
+	for _, want := range []string{"Timezone:", "channel_settings.#a.Schedule:", "channel_settings.#a.OutsideWindow:"} {
+		if !strings.Contains(all, want) {
+			t.Errorf("expected a problem with %s, got:\n%s", want, all)
+		}
+	}
+	if w := strings.Join(c.Warnings, "\n"); !strings.Contains(w, "channel_settings.#b") {
+		t.Errorf("expected a warning about #b not being used, got %q", w)
+	}
+}
+
+func TestCheckPrefixStyle(t *testing.T) {
+	for style, want := range map[string]string{
+		"":              "",
+		PrefixOwnerRepo: "",
+		"<{repo}>":      "",
+		"owner-repo":    "expected repo, owner/repo, none",
+		"{name}/{repo}": "unknown {name}",
+	} {
+		err := checkPrefixStyle(style)
+		if (want == "") != (err == nil) || (err != nil && !strings.Contains(err.Error(), want)) {
+			t.Errorf("%q: expected %q, got %v", style, want, err)
+		}
+	}
+	c := validConfig()
+	c.ChannelSettings = map[string]ChannelConfig{"#a": {PrefixStyle: "{nope}"}}
+	if errs := c.Validate(); len(errs) != 1 || !strings.Contains(errs[0].Error(), "channel_settings.#a.PrefixStyle") {
+		t.Errorf("expected the bad style to be caught, got %v", errs)
+	}
+}
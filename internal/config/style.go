@@ -0,0 +1,37 @@
+package config
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/format"
+)
+
+// The state colours to use, with the config's over the defaults.
+func (c *Config) stateColorMap() map[string]format.MIRCColor {
+	if c == nil || c.stateColors == nil {
+		return format.DefaultStateColors
+	}
+	return c.stateColors
+}
+
+// The action colours to use, with the config's over the defaults.
+func (c *Config) actionColorMap() map[string]format.MIRCColor {
+	if c == nil || c.actionColors == nil {
+		return format.DefaultActionColors
+	}
+	return c.actionColors
+}
+
+// Turn an Event into an IRC announcement, with the global settings.
+func FormatEvent(e *format.Event, logger *slog.Logger) string {
+	return CurrentConfig().ForRepo("").Format(context.Background(), e, logger)
+}
+
+// The shortener to use, or none before the config is loaded.
+func (c *Config) urlShortener() format.Shortener {
+	if c == nil || c.LinkShortener == nil {
+		return format.NoShortener{}
+	}
+	return c.LinkShortener
+}
@@ -0,0 +1,180 @@
+package config
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/format"
+	"github.com/UniversityRadioYork/CaptainHook/internal/metrics"
+	"github.com/UniversityRadioYork/CaptainHook/internal/testutil"
+)
+
+func TestShortenSkips(t *testing.T) {
+	c := validConfig()
+	c.ShortenSkipHosts = []string{"github.ury.org.uk", "*.internal"}
+	if errs := c.Validate(); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	s := &format.SkipHostsShortener{Shortener: testutil.FakeShortener("https://s/"), Hosts: c.ShortenSkipHosts}
+	for _, tc := range []struct {
+		url     string
+		private bool
+		want    string
+	}{
+		{"https://github.com/ury/website/pull/1", false, "https://s/"},
+		{"https://github.com/ury/secret/pull/1", true, "https://github.com/ury/secret/pull/1"},
+		{"https://GitHub.ury.org.uk/ury/website/pull/1", false, "https://GitHub.ury.org.uk/ury/website/pull/1"},
+		{"https://ci.internal:8080/job/1", false, "https://ci.internal:8080/job/1"},
+		{"https://internal.example.org/", false, "https://s/"},
+	} {
+		if got, _ := format.Shorten(context.Background(), s, tc.url, tc.private); got != tc.want {
+			t.Errorf("%s private=%v: expected %q, got %q", tc.url, tc.private, tc.want, got)
+		}
+	}
+
+	c.ShortenSkipHosts = []string{"[bad"}
+	if errs := c.Validate(); len(errs) != 1 || !strings.Contains(errs[0].Error(), "ShortenSkipHosts") {
+		t.Errorf("expected a bad pattern, got %v", errs)
+	}
+}
+
+func TestLoadTemplatesOverride(t *testing.T) {
+	f, err := ioutil.TempFile("", "issues.tmpl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("{{ bold .Repo }}#{{ .Number }} {{ .Title | truncate 10 }}\n")
+	f.Close()
+
+	templates, err := format.LoadTemplates(map[string]string{
+		"push":   `[{{ .Repo }}/{{ .Ref }}] {{ .Commits }} new`,
+		"issues": "@" + f.Name(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { format.EventTemplates = format.MustParseTemplates(format.DefaultTemplates) }()
+	format.EventTemplates = templates
+
+	logger := DiscardLogger
+	for _, c := range []struct {
+		e    format.Event
+		want string
+	}{
+		{format.Event{Type: "push", Repo: "website", Ref: "main", Commits: 3}, "[website/main] 3 new\x0f"},
+		{format.Event{Type: "issues", Repo: "website", Number: 42, Title: "Stream relay drops out"}, "\x02website\x02#42 Stream...\x0f"},
+		{format.Event{Type: "repository", Action: "created", Repo: "x", Sender: "y", URL: "u"}, "y \x0303created\x0f \x0306x\x0f: u\x0f"},
+	} {
+		if got := FormatEvent(&c.e, logger); got != c.want {
+			t.Errorf("%s: expected %q, got %q", c.e.Type, c.want, got)
+		}
+	}
+}
+
+func TestVerbs(t *testing.T) {
+	s := (&Config{Verbs: map[string]string{
+		"synchronize":         "updated with new commits",
+		"closed":              "Closed",
+		"pull_request.merged": "Landed",
+		"issues.opened":       "Reported",
+	}}).ForRepo("")
+	logger := DiscardLogger
+	for _, c := range []struct {
+		e    format.Event
+		want string
+	}{
+		{format.Event{Type: "pull_request", Action: "synchronize", Repo: "r", Number: 1, Sender: "s", Title: "t", URL: "u"}, "PRQ #1 \x0310updated with new commits\x0f by"},
+		{format.Event{Type: "pull_request", Action: "closed", Repo: "r", Number: 1, Sender: "s", Title: "t", URL: "u"}, "PRQ #1 \x0304Closed\x0f by"},
+		{format.Event{Type: "pull_request", Action: "closed", Merged: true, Repo: "r", Number: 1, Sender: "s", Title: "t", URL: "u"}, "PRQ #1 \x0302Landed\x0f by"},
+		{format.Event{Type: "pull_request", Action: "opened", Repo: "r", Number: 1, Sender: "s", Title: "t", URL: "u"}, "PRQ #1 \x0303opened\x0f by"},
+		{format.Event{Type: "issues", Action: "opened", Repo: "r", Number: 2, Sender: "s", Title: "t", URL: "u"}, "Issue #2 \x0303Reported\x0f by"},
+		{format.Event{Type: "issues", Action: "closed", Repo: "r", Number: 2, Sender: "s", Title: "t", URL: "u"}, "Issue #2 \x0304Closed\x0f by"},
+	} {
+		if got := s.Format(context.Background(), &c.e, logger); !strings.Contains(got, c.want) {
+			t.Errorf("%s %s: expected %q in %q", c.e.Type, c.e.Action, c.want, got)
+		}
+	}
+	e := format.Event{Type: "pull_request", Action: "closed", Merged: true}
+	if got := format.EventVerb(&e, nil, format.DefaultActionColors); got != "\x0302Merged\x0f" {
+		t.Errorf("expected merged PRs to say Merged by default, got %q", got)
+	}
+}
+
+func TestActionColors(t *testing.T) {
+	logger := DiscardLogger
+	s := (&Config{}).ForRepo("")
+	for typ, actions := range knownActions {
+		for _, a := range append(actions, "frobnicated") {
+			e := format.Event{Type: typ, Action: a, Merged: a == "merged", Repo: "r", Number: 1, Sender: "s", Title: "t", URL: "u", Ref: "main", Commits: 1}
+			text := s.Format(context.Background(), &e, logger)
+			checkColorCodes(t, typ+" "+a, text)
+			if a != "frobnicated" && typ != "push" && !strings.Contains(text, "\x03"+string(format.DefaultActionColors[a])) {
+				t.Errorf("%s %s: expected it coloured, got %q", typ, a, text)
+			}
+		}
+	}
+
+	c := validConfig()
+	c.ActionColors = map[string]string{"closed": "orange", "opened": "none"}
+	if errs := c.Validate(); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	s = c.ForRepo("")
+	e := format.Event{Type: "issues", Action: "closed", Repo: "r", Number: 1, Sender: "s", Title: "t", URL: "u"}
+	if got := s.Format(context.Background(), &e, logger); !strings.Contains(got, "\x0307closed\x0f") {
+		t.Errorf("expected closed in orange, got %q", got)
+	}
+	e.Action = "opened"
+	if got := s.Format(context.Background(), &e, logger); !strings.Contains(got, " opened by") {
+		t.Errorf("expected opened uncoloured, got %q", got)
+	}
+	e.Action = "reopened"
+	if got := s.Format(context.Background(), &e, logger); !strings.Contains(got, "\x0303reopened\x0f") {
+		t.Errorf("expected the default colour for reopened, got %q", got)
+	}
+
+	c.ActionColors = map[string]string{"closed": "mauve"}
+	if errs := c.Validate(); len(errs) != 1 || !strings.Contains(errs[0].Error(), "mauve") {
+		t.Errorf("expected an error about mauve, got %v", errs)
+	}
+}
+
+func TestStateColors(t *testing.T) {
+	for state, want := range map[string]format.MIRCColor{"SUCCESS": format.ColorGreen, "failure": format.ColorRed, "Timed_Out": format.ColorRed, "neutral": format.ColorLightGrey} {
+		if got := format.ConclusionColor(state); got != want {
+			t.Errorf("%s: expected %q, got %q", state, want, got)
+		}
+	}
+	before := promtestutil.ToFloat64(metrics.UnknownConclusions.WithLabelValues("frobnicated"))
+	if got := format.ConclusionColor("FROBNICATED"); got != "" {
+		t.Errorf("expected nothing for an unknown state, got %q", got)
+	}
+	if n := promtestutil.ToFloat64(metrics.UnknownConclusions.WithLabelValues("frobnicated")) - before; n != 1 {
+		t.Errorf("expected the unknown state counted once, got %v", n)
+	}
+
+	c := validConfig()
+	c.StateColors = map[string]string{"Unstable": "orange", "neutral": "none"}
+	if errs := c.Validate(); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	SetConfig(c)
+	defer SetConfig(validConfig())
+	state := format.TemplateFuncs["state"].(func(string) string)
+	for s, want := range map[string]string{"UNSTABLE": "\x0307UNSTABLE\x0f", "neutral": "NEUTRAL", "timed_out": "\x0304TIMED OUT\x0f"} {
+		if got := state(s); got != want {
+			t.Errorf("%s: expected %q, got %q", s, want, got)
+		}
+	}
+
+	c.StateColors = map[string]string{"success": "mauve"}
+	if errs := c.Validate(); len(errs) != 1 || !strings.Contains(errs[0].Error(), "state_colors.success") {
+		t.Errorf("expected an error about state_colors.success, got %v", errs)
+	}
+}
@@ -0,0 +1,197 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Channels added to or taken off repos from IRC with !subscribe and
+// !unsubscribe. These go over the config's routes: a channel subscribed to a
+// repo gets it whatever the config says, and one unsubscribed doesn't.
+// They're kept next to the StateFile, if there is one, and reloading the
+// config leaves them be. A nil Subscriptions has none, and can't get any.
+type Subscriptions struct {
+	path string // "" to keep them in memory only
+
+	mu   sync.Mutex
+	subs map[string]map[string]bool // Subscribed or not by lower case channel, by lower case owner/name
+}
+
+func NewSubscriptions(path string) *Subscriptions {
+	return &Subscriptions{path: path, subs: make(map[string]map[string]bool)}
+}
+
+// Bump this if subscriptionsFile changes.
+const subscriptionsVersion = 1
+
+type subscriptionsFile struct {
+	Version int                        `json:"version"`
+	Saved   time.Time                  `json:"saved"`
+	Subs    map[string]map[string]bool `json:"subscriptions"`
+}
+
+func SubscriptionsPath(stateFile string) string {
+	return stateFile + ".subscriptions"
+}
+
+// Pick up the subscriptions saved at path, starting with none if there
+// aren't any we can read.
+func LoadSubscriptions(path string, logger *slog.Logger) *Subscriptions {
+	s := NewSubscriptions(path)
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logger.Error("Error reading subscriptions", "path", path, "err", err)
+		}
+		return s
+	}
+	var f subscriptionsFile
+	if err := json.Unmarshal(data, &f); err != nil || f.Version != subscriptionsVersion {
+		logger.Warn("Skipping subscriptions we can't read", "path", path, "version", f.Version, "err", err)
+		return s
+	}
+	for repo, channels := range f.Subs {
+		s.subs[repo] = channels
+	}
+	return s
+}
+
+// Subscribe channel to repo (owner/name), or unsubscribe it, saving the
+// change if we've somewhere to.
+func (s *Subscriptions) Set(repo, channel string, subscribed bool) error {
+	if s == nil {
+		return errors.New("nowhere to keep subscriptions")
+	}
+	repo, channel = strings.ToLower(repo), strings.ToLower(channel)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.subs[repo] == nil {
+		s.subs[repo] = make(map[string]bool)
+	}
+	s.subs[repo][channel] = subscribed
+	if s.path == "" {
+		return nil
+	}
+	data, err := json.Marshal(subscriptionsFile{Version: subscriptionsVersion, Saved: time.Now().UTC(), Subs: s.subs})
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// channels, where the config sends repo (owner/name), with the subscriptions
+// over them.
+func (s *Subscriptions) Apply(repo string, channels []string) []string {
+	if s == nil {
+		return channels
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	subs := s.subs[strings.ToLower(repo)]
+	if len(subs) == 0 {
+		return channels
+	}
+	var out []string
+	have := make(map[string]bool)
+	for _, ch := range channels {
+		if subscribed, ok := subs[strings.ToLower(ch)]; (!ok || subscribed) && !have[strings.ToLower(ch)] {
+			have[strings.ToLower(ch)] = true
+			out = append(out, ch)
+		}
+	}
+	for _, ch := range sortedKeys(subs) {
+		if subs[ch] && !have[ch] {
+			out = append(out, ch)
+		}
+	}
+	return out
+}
+
+// The repos channel has been subscribed to, and unsubscribed from.
+func (s *Subscriptions) For(channel string) (subscribed, unsubscribed []string) {
+	if s == nil {
+		return nil, nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for repo, channels := range s.subs {
+		on, ok := channels[strings.ToLower(channel)]
+		switch {
+		case ok && on:
+			subscribed = append(subscribed, repo)
+		case ok:
+			unsubscribed = append(unsubscribed, repo)
+		}
+	}
+	sort.Strings(subscribed)
+	sort.Strings(unsubscribed)
+	return subscribed, unsubscribed
+}
+
+// Every channel subscribed to something, so we can be in them.
+func (s *Subscriptions) Channels() []string {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	seen := make(map[string]bool)
+	for _, channels := range s.subs {
+		for ch, on := range channels {
+			if on {
+				seen[ch] = true
+			}
+		}
+	}
+	return sortedKeys(seen)
+}
+
+func sortedKeys(m map[string]bool) []string {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Whether the IRC user nick!user@host matches one of IRCAdmins.
+func (c *Config) IsIRCAdmin(mask string) bool {
+	return matchesMask(c.IRCAdmins, mask)
+}
+
+// Whether the IRC user nick!user@host matches one of globs, in any case.
+func matchesMask(globs []string, mask string) bool {
+	for _, g := range globs {
+		if ok, _ := path.Match(strings.ToLower(g), strings.ToLower(mask)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Where the default hook sends repo, subscriptions and all.
+func (c *Config) RepoChannels(repo string) []string {
+	channels := c.DefaultHook().Channels
+	if s := c.ForRepo(repo); s.Channels != nil {
+		channels = s.Channels
+	}
+	return c.Subscriptions.Apply(repo, channels)
+}
+
+// Whether the IRC user nick!user@host can file issues.
+func (c *Config) IsIRCReporter(mask string) bool {
+	return c.IsIRCAdmin(mask) || matchesMask(c.IRCReporters, mask)
+}
@@ -0,0 +1,207 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Announcement targets starting with this go to one of Config.Discord's
+// webhooks, by name, e.g. discord:dev.
+const DiscordPrefix = "discord:"
+
+// A Discord webhook to announce to.
+type DiscordTarget struct {
+	Name   string   // For discord:Name targets
+	URL    string   // The webhook's URL, from the channel's integrations
+	Events []string // Also gets every announcement for these event types, as globs, e.g. alert and grafana for an ops channel
+}
+
+// The Discord webhooks that want event, as targets.
+func (c *Config) discordFor(event string) []string {
+	var targets []string
+	if c == nil {
+		return nil
+	}
+	for _, t := range c.Discord {
+		for _, e := range t.Events {
+			if ok, _ := path.Match(strings.ToLower(e), strings.ToLower(event)); ok {
+				targets = append(targets, DiscordPrefix+t.Name)
+				break
+			}
+		}
+	}
+	return targets
+}
+
+func (c *Config) checkDiscord() []error {
+	var names, urls []string
+	var errs []error
+	for i, t := range c.Discord {
+		names, urls = append(names, t.Name), append(urls, t.URL)
+		for _, e := range t.Events {
+			if _, err := path.Match(e, ""); err != nil {
+				errs = append(errs, fmt.Errorf("discord[%d].Events: bad pattern %q", i, e))
+			}
+		}
+	}
+	return append(errs, c.checkWebhooks("discord", names, urls)...)
+}
+
+// Announcement targets starting with this go to a Matrix room rather than an
+// IRC channel, e.g. matrix:!abcdef:matrix.org.
+const MatrixPrefix = "matrix:"
+
+func isMatrixTarget(channel string) bool {
+	return strings.HasPrefix(channel, MatrixPrefix)
+}
+
+// The Matrix rooms that get everything, as targets.
+func (c *Config) matrixRooms() []string {
+	var rooms []string
+	if c == nil {
+		return nil
+	}
+	for _, room := range SplitChannels(c.MatrixRooms) {
+		rooms = append(rooms, MatrixPrefix+strings.TrimPrefix(room, MatrixPrefix))
+	}
+	return rooms
+}
+
+// Everything goes to MQTT as this one target, so it's queued in order.
+const MQTTTarget = "mqtt:broker"
+
+// Everything goes to MQTT, when it's set up.
+func (c *Config) mqttAll() []string {
+	if c == nil || c.MQTTBroker == "" {
+		return nil
+	}
+	return []string{MQTTTarget}
+}
+
+func (c *Config) checkMQTT() []error {
+	if c.MQTTBroker == "" {
+		return nil
+	}
+	var errs []error
+	u, err := url.Parse(c.MQTTBroker)
+	switch {
+	case err != nil || u.Host == "":
+		errs = append(errs, fmt.Errorf("MQTTBroker: expected a URL like tcp://host:1883, not %q", c.MQTTBroker))
+	case u.Scheme != "tcp" && u.Scheme != "mqtt" && u.Scheme != "ssl" && u.Scheme != "mqtts" && u.Scheme != "tls":
+		errs = append(errs, fmt.Errorf("MQTTBroker: %q should be tcp:// or mqtt://, or ssl:// or mqtts:// for TLS", c.MQTTBroker))
+	}
+	if c.MQTTQoS < 0 || c.MQTTQoS > 2 {
+		errs = append(errs, fmt.Errorf("MQTTQoS: must be 0, 1 or 2"))
+	}
+	if c.MQTTTopic == "" || strings.ContainsAny(c.MQTTTopic, "+#") {
+		errs = append(errs, fmt.Errorf("MQTTTopic: %q needs to be a topic without wildcards", c.MQTTTopic))
+	}
+	if c.MQTTClientID == "" {
+		errs = append(errs, fmt.Errorf("MQTTClientID: is needed"))
+	}
+	if c.MQTTPassword != "" && c.MQTTUsername == "" {
+		errs = append(errs, fmt.Errorf("MQTTPassword: needs MQTTUsername too"))
+	}
+	return errs
+}
+
+// What kind of target channel is, e.g. "matrix" for matrix:!room, or "" for
+// an IRC channel. IRC channel names can't have colons in.
+func TargetKind(channel string) string {
+	if i := strings.Index(channel, ":"); i > 0 {
+		return channel[:i]
+	}
+	return ""
+}
+
+// Targets that get announcements of event wherever they were going, like
+// MatrixRooms.
+func (c *Config) ExtraTargets(event string) []string {
+	targets := append(c.matrixRooms(), c.slackAll()...)
+	targets = append(targets, c.TelegramTargets()...)
+	targets = append(targets, c.mqttAll()...)
+	return append(targets, c.discordFor(event)...)
+}
+
+// Check the webhooks for an output (slack, discord...) each have a name and
+// an https URL, and that targets like slack:name are for one of them.
+func (c *Config) checkWebhooks(kind string, names, urls []string) []error {
+	var errs []error
+	seen := make(map[string]bool)
+	for i, name := range names {
+		field := fmt.Sprintf("%s[%d]", kind, i)
+		switch {
+		case name == "" || strings.ContainsAny(name, " ,:"):
+			errs = append(errs, fmt.Errorf("%s.Name: %q needs to be a name without spaces, commas or colons", field, name))
+		case seen[strings.ToLower(name)]:
+			errs = append(errs, fmt.Errorf("%s.Name: %q is used twice", field, name))
+		}
+		seen[strings.ToLower(name)] = true
+		if u, err := url.Parse(urls[i]); err != nil || u.Scheme != "https" || u.Host == "" {
+			errs = append(errs, fmt.Errorf("%s.URL: expected the webhook's https URL, not %q", field, urls[i]))
+		}
+	}
+	for _, ch := range c.AllChannels() {
+		if name := strings.TrimPrefix(ch, kind+":"); TargetKind(ch) == kind && !seen[strings.ToLower(name)] {
+			errs = append(errs, fmt.Errorf("%s: no %s webhook called %q", ch, kind, name))
+		}
+	}
+	return errs
+}
+
+// Announcement targets starting with this go to one of Config.Slack's
+// webhooks, by name, e.g. slack:committee.
+const SlackPrefix = "slack:"
+
+// A Slack incoming webhook to announce to.
+type SlackTarget struct {
+	Name    string // For slack:Name targets
+	URL     string // The webhook's URL, from Slack
+	Channel string // Post here instead of the webhook's own channel, for legacy webhooks that allow it
+	All     bool   // Gets every announcement, like MatrixRooms
+}
+
+// The Slack webhooks that get everything, as targets.
+func (c *Config) slackAll() []string {
+	var targets []string
+	if c == nil {
+		return nil
+	}
+	for _, t := range c.Slack {
+		if t.All {
+			targets = append(targets, SlackPrefix+t.Name)
+		}
+	}
+	return targets
+}
+
+func (c *Config) checkSlack() []error {
+	var names, urls []string
+	for _, t := range c.Slack {
+		names, urls = append(names, t.Name), append(urls, t.URL)
+	}
+	return c.checkWebhooks("slack", names, urls)
+}
+
+// Announcement targets starting with this go to a Telegram chat, by ID or
+// @username, e.g. telegram:-1001234567890.
+const TelegramPrefix = "telegram:"
+
+// A group or supergroup's ID, which is negative, a user's, or a public
+// channel's @username.
+var telegramChat = regexp.MustCompile(`^(-?[0-9]+|@[A-Za-z][A-Za-z0-9_]{4,})$`)
+
+// The Telegram chats that get everything, as targets.
+func (c *Config) TelegramTargets() []string {
+	var chats []string
+	if c == nil {
+		return nil
+	}
+	for _, chat := range SplitChannels(c.TelegramChats) {
+		chats = append(chats, TelegramPrefix+strings.TrimPrefix(chat, TelegramPrefix))
+	}
+	return chats
+}
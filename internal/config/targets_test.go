@@ -0,0 +1,61 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMatrixConfig(t *testing.T) {
+	c := validConfig()
+	c.Repos = map[string]RepoConfig{"ury/website": {Channels: "#web,matrix:!web:example.org"}}
+	if errs := c.Validate(); len(errs) != 1 || !strings.Contains(errs[0].Error(), "MatrixToken") {
+		t.Errorf("expected Matrix settings to be needed, got %v", errs)
+	}
+	c.MatrixHomeserver, c.MatrixToken, c.MatrixRooms = "https://matrix.example.org", "tok", "!all:example.org"
+	if errs := c.Validate(); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	if got := strings.Join(c.IRCChannels(), ","); got != "#a,#web" {
+		t.Errorf("expected only IRC channels to join, got %s", got)
+	}
+	c.Repos["ury/website"] = RepoConfig{Channels: "matrix:#alias:example.org"}
+	if errs := c.Validate(); len(errs) != 1 || !strings.Contains(errs[0].Error(), "room ID") {
+		t.Errorf("expected an alias to be refused, got %v", errs)
+	}
+}
+
+func TestMQTTConfig(t *testing.T) {
+	c := validConfig()
+	c.MQTTBroker = "http://localhost"
+	c.MQTTQoS = 3
+	c.MQTTTopic = "capthook/#"
+	c.MQTTClientID = "capthook"
+	if errs := c.checkMQTT(); len(errs) != 3 {
+		t.Errorf("expected 3 errors, got %v", errs)
+	}
+	c.MQTTBroker, c.MQTTQoS, c.MQTTTopic = "mqtts://broker.ury.org.uk", 2, "capthook/{repo}"
+	if errs := c.checkMQTT(); len(errs) != 0 {
+		t.Errorf("expected no errors, got %v", errs)
+	}
+	if targets := c.ExtraTargets(""); !Contains(targets, MQTTTarget) {
+		t.Errorf("expected MQTT to get everything, got %v", targets)
+	}
+}
+
+func TestSlackConfig(t *testing.T) {
+	c := validConfig()
+	c.Slack = []SlackTarget{{Name: "committee", URL: "https://hooks.slack.com/services/x"}}
+	c.Channels = "#a,slack:Committee"
+	if errs := c.Validate(); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	if got := strings.Join(c.IRCChannels(), ","); got != "#a" {
+		t.Errorf("expected only IRC channels to join, got %s", got)
+	}
+	c.Channels = "#a,slack:elsewhere,xmpp:x"
+	c.Slack = append(c.Slack, SlackTarget{Name: "committee", URL: "http://example.org"})
+	errs := c.Validate()
+	if len(errs) != 4 {
+		t.Errorf("expected a duplicate, a bad URL, an unknown webhook and an unknown kind, got %v", errs)
+	}
+}
@@ -0,0 +1,417 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/format"
+	"github.com/UniversityRadioYork/CaptainHook/internal/github"
+)
+
+// Check everything we can about a config before using it, returning every
+// problem rather than stopping at the first. Channel names missing their #
+// are fixed up with a warning, since that's the usual typo, and Warnings
+// gets the rest of those. What's worked out from the settings is filled in
+// on the way: the templates, colours, schedules, log level and the
+// LinkShortener. The NameCache is left to Share.
+func (c *Config) Validate() []error {
+	var errs []error
+	bad := func(field, format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(field+": "+format, args...))
+	}
+	c.Warnings = nil
+	warn := func(field, format string, args ...interface{}) {
+		c.Warnings = append(c.Warnings, field+": "+fmt.Sprintf(format, args...))
+	}
+
+	if strings.TrimSpace(c.Channels) == "" {
+		bad("Channels", "required")
+	}
+	c.Channels = fixChannels("Channels", c.Channels, bad, warn)
+	c.GrafanaChannels = fixChannels("GrafanaChannels", c.GrafanaChannels, bad, warn)
+	c.SentryChannels = fixChannels("SentryChannels", c.SentryChannels, bad, warn)
+	for i := range c.Hooks {
+		c.Hooks[i].Channels = fixChannels(fmt.Sprintf("hooks[%d].Channels", i), c.Hooks[i].Channels, bad, warn)
+	}
+	for i := range c.GenericHooks {
+		c.GenericHooks[i].Channels = fixChannels(fmt.Sprintf("generic_hooks[%d].Channels", i), c.GenericHooks[i].Channels, bad, warn)
+	}
+	for _, repo := range c.RepoNames() {
+		rc := c.Repos[repo]
+		rc.Channels = fixChannels("repos."+repo+".Channels", rc.Channels, bad, warn)
+		c.Repos[repo] = rc
+	}
+	if !c.Join {
+		warn("Join", "off, so announcements only work in channels something else has put us in")
+	}
+	if len(c.DefaultHook().Secrets) == 0 {
+		bad("GHSecret", "required, unless GHSecrets is set")
+	}
+
+	if !OverflowPolicy(c.QueuePolicy).Valid() {
+		bad("QueuePolicy", "unknown policy %q", c.QueuePolicy)
+	}
+	if c.JenkinsNotify != JenkinsNotifyAll && c.JenkinsNotify != JenkinsNotifyFailures {
+		bad("JenkinsNotify", "expected %s or %s, not %q", JenkinsNotifyAll, JenkinsNotifyFailures, c.JenkinsNotify)
+	}
+	if c.ShowOwner != ShowOwnerNever && c.ShowOwner != ShowOwnerAlways && c.ShowOwner != ShowOwnerAmbiguous {
+		bad("ShowOwner", "expected %s, %s or %s, not %q", ShowOwnerNever, ShowOwnerAlways, ShowOwnerAmbiguous, c.ShowOwner)
+	}
+	if c.LogFormat != "text" && c.LogFormat != "json" {
+		bad("LogFormat", "expected text or json, not %q", c.LogFormat)
+	}
+	if c.LogOutput != "stdout" && c.LogOutput != "stderr" && c.LogOutput != "syslog" {
+		bad("LogOutput", "expected stdout, stderr or syslog, not %q", c.LogOutput)
+	}
+	if c.LogFile != "" && c.LogOutput == "syslog" {
+		bad("LogFile", "can't go with LogOutput syslog")
+	}
+	if _, ok := syslogFacilities[c.SyslogFacility]; c.LogOutput == "syslog" && !ok {
+		bad("SyslogFacility", "unknown facility %q", c.SyslogFacility)
+	}
+	if level, err := parseLogLevel(c.LogLevel); c.LogLevel != "" && err != nil {
+		bad("LogLevel", "expected debug, info, warn or error, not %q", c.LogLevel)
+	} else {
+		c.SlogLevel = level
+	}
+	if _, err := strconv.ParseUint(c.SocketMode, 8, 32); c.SocketMode != "" && err != nil {
+		bad("SocketMode", "expected octal permissions, not %q", c.SocketMode)
+	}
+	if (c.TLSCert == "") != (c.TLSKey == "") {
+		bad("TLSCert", "needs TLSKey too, and vice versa")
+	}
+	for field, n := range map[string]int64{
+		"Workers":      int64(c.Workers),
+		"QueueSize":    int64(c.QueueSize),
+		"MaxBodyBytes": c.MaxBodyBytes,
+	} {
+		if n < 1 {
+			bad(field, "must be at least 1")
+		}
+	}
+	if c.WorkQueueSize < 0 {
+		bad("WorkQueueSize", "can't be negative, use 0 to only take deliveries a worker's free for")
+	}
+	if c.ShortenTimeout <= 0 {
+		bad("ShortenTimeout", "must be more than 0")
+	}
+	for field, d := range map[string]time.Duration{
+		"ShutdownTimeout":        c.ShutdownTimeout,
+		"ShutdownWorkersTimeout": c.ShutdownWorkersTimeout,
+		"ShutdownDrainTimeout":   c.ShutdownDrainTimeout,
+		"ShutdownQuitTimeout":    c.ShutdownQuitTimeout,
+	} {
+		if d < 0 {
+			bad(field, "can't be negative, use 0 for no limit")
+		}
+	}
+	if c.NameCacheTTL <= 0 {
+		bad("NameCacheTTL", "must be more than 0")
+	}
+	if c.ConflictCheckTimeout <= 0 {
+		bad("ConflictCheckTimeout", "must be more than 0")
+	}
+	if c.RestartTimeout <= 0 {
+		bad("RestartTimeout", "must be more than 0")
+	}
+	if c.ShortenCacheSize < 0 {
+		bad("ShortenCacheSize", "can't be negative, use 0 for no cache")
+	}
+	if c.MaxCommitLines < 0 {
+		bad("MaxCommitLines", "can't be negative, use 0 for none")
+	}
+	if c.SHALength < 4 || c.SHALength > 40 {
+		bad("SHALength", "must be from 4 to 40")
+	}
+	if c.CommentSnippetLength < 0 {
+		bad("CommentSnippetLength", "can't be negative, use 0 for none")
+	}
+	if c.FeedSize < 0 {
+		bad("FeedSize", "can't be negative, use 0 for no feed")
+	}
+	if c.EventLogPath != "" && c.EventLogMaxBytes < 1 {
+		bad("EventLogMaxBytes", "must be at least 1")
+	}
+	if c.PersistStats && c.StateFile == "" {
+		bad("PersistStats", "needs a StateFile")
+	}
+	if c.DigestThreshold < 0 {
+		bad("DigestThreshold", "can't be negative, use 0 for no digests")
+	}
+	digests := c.DigestThreshold > 0
+	for _, cc := range c.ChannelSettings {
+		digests = digests || cc.DigestThreshold > 0
+	}
+	if digests && c.DigestWindow <= 0 {
+		bad("DigestWindow", "must be more than 0")
+	}
+	if c.DuplicateWindow < 0 {
+		bad("DuplicateWindow", "can't be negative, use 0 to send duplicates")
+	}
+	if c.UnsentMaxAge < 0 {
+		bad("UnsentMaxAge", "can't be negative, use 0 to drop unsent announcements")
+	}
+	if c.EventLogKeep < 0 {
+		bad("EventLogKeep", "can't be negative")
+	}
+	if c.RateLimit < 0 {
+		bad("RateLimit", "can't be negative, use 0 for no limit")
+	} else if c.RateLimit > 0 && c.RateLimitBurst < 1 {
+		bad("RateLimitBurst", "must be at least 1 when rate limiting")
+	}
+	for field, list := range map[string][]string{
+		"AllowIPs":        c.AllowIPs,
+		"TrustedProxies":  c.TrustedProxies,
+		"RateLimitExempt": c.RateLimitExempt,
+	} {
+		if _, err := ParseCIDRs(list); err != nil {
+			bad(field, "%v", err)
+		}
+	}
+	for i, t := range c.ForwardURLs {
+		if u, err := url.Parse(t.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			bad(fmt.Sprintf("ForwardURLs[%d].URL", i), "expected an http(s) URL, not %q", t.URL)
+		}
+	}
+
+	patterns := map[string][]string{
+		"RepoAllow":     c.RepoAllow,
+		"RepoDeny":      c.RepoDeny,
+		"Branches":      c.Branches,
+		"IgnoreSenders": c.IgnoreSenders,
+
+		"ShortenSkipHosts": c.ShortenSkipHosts,
+	}
+	for event, p := range c.IgnoreSendersByEvent {
+		patterns["IgnoreSendersByEvent."+event] = p
+	}
+	for repo, rc := range c.Repos {
+		patterns["repos."+repo+".Branches"] = rc.Branches
+	}
+	for field, p := range patterns {
+		if err := checkPatterns(field, p); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	// One at a time, so every bad template gets mentioned.
+	ok := true
+	for name, src := range c.Templates {
+		if _, err := format.LoadTemplates(map[string]string{name: src}); err != nil {
+			bad("templates."+name, "%v", err)
+			ok = false
+		}
+	}
+	if ok {
+		c.templates, _ = format.LoadTemplates(c.Templates)
+	}
+	errs = append(errs, c.loadRepoTemplates()...)
+	if s, err := format.NewShortener(format.ShortenerConfig{
+		Kind:      c.Shortener,
+		URL:       c.ShortenerURL,
+		Key:       c.ShortenerKey,
+		Timeout:   c.ShortenTimeout,
+		TripAfter: c.ShortenTripAfter,
+		Cooldown:  c.ShortenCooldown,
+	}); err != nil {
+		bad("Shortener", "%v", err)
+	} else if _, none := s.(format.NoShortener); none {
+		c.LinkShortener = s
+	} else {
+		if c.ShortenCacheSize > 0 {
+			s = format.NewCachedShortener(s, c.ShortenCacheSize, c.ShortenFailureTTL)
+		}
+		if len(c.ShortenSkipHosts) > 0 {
+			s = &format.SkipHostsShortener{Shortener: s, Hosts: c.ShortenSkipHosts}
+		}
+		c.LinkShortener = s
+	}
+	switch c.NameStyle {
+	case github.NameStyleLogin, github.NameStyleName, github.NameStyleBoth:
+	default:
+		bad("NameStyle", "expected login, name or both, not %q", c.NameStyle)
+	}
+	if u, err := url.Parse(c.GitHubAPIURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		bad("GitHubAPIURL", "expected an http(s) URL, not %q", c.GitHubAPIURL)
+	}
+	if u, err := url.Parse(c.GitHubURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		bad("GitHubURL", "expected an http(s) URL, not %q", c.GitHubURL)
+	}
+	if c.PublicURL != "" {
+		if u, err := url.Parse(c.PublicURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			bad("PublicURL", "expected an http(s) URL, not %q", c.PublicURL)
+		}
+	}
+	if c.ManageHooks {
+		switch {
+		case c.GitHubToken == "":
+			bad("ManageHooks", "needs a GitHubToken that can admin repo hooks")
+		case c.PublicURL == "":
+			bad("ManageHooks", "needs PublicURL, for the hooks to point at")
+		case len(c.HookOrgs) == 0:
+			bad("ManageHooks", "needs HookOrgs, to know which repos")
+		}
+	}
+	for _, ev := range c.HookEvents {
+		if _, ok := github.Events[ev]; !ok && ev != "*" {
+			warn("HookEvents", "%q isn't an event we handle", ev)
+		} else if orgOnlyEvents[ev] {
+			warn("HookEvents", "repos' hooks can't send %q, only org hooks", ev)
+		}
+	}
+	overrideColors := func(field string, defaults map[string]format.MIRCColor, names map[string]string) map[string]format.MIRCColor {
+		if len(names) == 0 {
+			return nil
+		}
+		colors := make(map[string]format.MIRCColor)
+		for k, color := range defaults {
+			colors[k] = color
+		}
+		for k, name := range names {
+			color, ok := format.TemplateColors[strings.ToLower(name)]
+			switch {
+			case strings.EqualFold(name, "none"):
+				color = ""
+			case !ok:
+				bad(field+"."+k, "unknown colour %q", name)
+			}
+			colors[strings.ToLower(k)] = color
+		}
+		return colors
+	}
+	c.actionColors = overrideColors("action_colors", format.DefaultActionColors, c.ActionColors)
+	c.stateColors = overrideColors("state_colors", format.DefaultStateColors, c.StateColors)
+	for _, room := range c.matrixRooms() {
+		if !strings.HasPrefix(strings.TrimPrefix(room, MatrixPrefix), "!") {
+			bad("MatrixRooms", "%q isn't a room ID, like !abc:matrix.org", room)
+		}
+	}
+	usesMatrix := len(c.matrixRooms()) > 0
+	for _, ch := range c.AllChannels() {
+		usesMatrix = usesMatrix || isMatrixTarget(ch)
+	}
+	if u, err := url.Parse(c.MatrixHomeserver); c.MatrixHomeserver != "" && (err != nil || (u.Scheme != "http" && u.Scheme != "https")) {
+		bad("MatrixHomeserver", "expected an http(s) URL, not %q", c.MatrixHomeserver)
+	} else if usesMatrix && (c.MatrixHomeserver == "" || c.MatrixToken == "") {
+		bad("MatrixHomeserver", "and MatrixToken are needed to announce to Matrix rooms")
+	}
+	fixChannels("TelegramChats", strings.Join(c.TelegramTargets(), ","), bad, warn) // Only to check them
+	usesTelegram := c.TelegramChats != ""
+	for _, ch := range c.AllChannels() {
+		usesTelegram = usesTelegram || TargetKind(ch) == "telegram"
+	}
+	if usesTelegram && c.TelegramToken == "" {
+		bad("TelegramToken", "is needed to announce to Telegram chats")
+	}
+	errs = append(errs, c.checkSlack()...)
+	errs = append(errs, c.checkDiscord()...)
+	errs = append(errs, c.checkMQTT()...)
+	errs = append(errs, c.loadSchedules()...)
+	for name := range c.ChannelSettings {
+		if !ContainsFold(c.AllChannels(), name) {
+			warn("channel_settings."+name, "not a channel anything is announced to")
+		}
+	}
+
+	errs = append(errs, c.checkRoutes()...)
+	if len(errs) == 0 {
+		// Work the routes out as NewHookMux will, in case there's anything
+		// we've missed.
+		if _, _, err := c.ServedHooks(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	c.Warnings = append(c.Warnings, c.CheckEvents()...)
+	return errs
+}
+
+// Check a comma separated channel list, putting a # on anything that looks
+// like it's missing one.
+func fixChannels(field, list string, bad, warn func(field, format string, args ...interface{})) string {
+	channels := SplitChannels(list)
+	for i, ch := range channels {
+		switch TargetKind(ch) {
+		case "":
+		case "matrix":
+			if !strings.HasPrefix(strings.TrimPrefix(ch, MatrixPrefix), "!") || strings.ContainsAny(ch, " ") {
+				bad(field, "%q should be matrix: then a room ID, like matrix:!abc:matrix.org", ch)
+			}
+			continue
+		case "telegram":
+			if !telegramChat.MatchString(strings.TrimPrefix(ch, TelegramPrefix)) {
+				bad(field, "%q should be telegram: then a chat ID or @channel, like telegram:-1001234567890", ch)
+			}
+			continue
+		case "slack", "discord":
+			continue // Checked against their webhooks by checkSlack etc.
+		default:
+			bad(field, "%q isn't a channel name, or a matrix:, slack:, discord: or telegram: target", ch)
+			continue
+		}
+		if strings.ContainsAny(ch, " \x07") {
+			bad(field, "%q isn't a channel name", ch)
+			continue
+		}
+		if !strings.ContainsAny(ch[:1], "#&+!") {
+			warn(field, "%q doesn't look like a channel, using #%s", ch, ch)
+			channels[i] = "#" + ch
+		}
+	}
+	return strings.Join(channels, ",")
+}
+
+// Check every hook has what it needs, and that no two want the same path.
+func (c *Config) checkRoutes() []error {
+	var errs []error
+	taken := make(map[string]string)
+	for _, p := range builtinPaths {
+		taken[p] = "built in"
+	}
+	claim := func(field, name, path string) {
+		if path == "" {
+			path = "/hooks/" + name
+		}
+		if !strings.HasPrefix(path, "/") {
+			errs = append(errs, fmt.Errorf("%s.Path: %q must start with /", field, path))
+			return
+		}
+		if by, ok := taken[path]; ok {
+			errs = append(errs, fmt.Errorf("%s.Path: %q is already in use (%s)", field, path, by))
+			return
+		}
+		taken[path] = field
+	}
+	for i, hc := range c.Hooks {
+		field := fmt.Sprintf("hooks[%d]", i)
+		if hc.Name == "" {
+			errs = append(errs, fmt.Errorf("%s.Name: required", field))
+		}
+		if len(joinSecrets(hc.Secret, hc.Secrets)) == 0 {
+			errs = append(errs, fmt.Errorf("%s.Secret: required", field))
+		}
+		claim(field, hc.Name, hc.Path)
+	}
+	for i, gc := range c.GenericHooks {
+		field := fmt.Sprintf("generic_hooks[%d]", i)
+		if gc.Name == "" {
+			errs = append(errs, fmt.Errorf("%s.Name: required", field))
+		}
+		if gc.Token == "" {
+			errs = append(errs, fmt.Errorf("%s.Token: required", field))
+		}
+		claim(field, gc.Name, gc.Path)
+	}
+	return errs
+}
+
+func ContainsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
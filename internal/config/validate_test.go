@@ -0,0 +1,75 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	for _, c := range []struct {
+		name   string
+		change func(*Config)
+		want   []string // Fields we expect complaints about
+	}{
+		{"fine", func(c *Config) {}, nil},
+		{"no secret", func(c *Config) { c.GHSecret = "" }, []string{"GHSecret"}},
+		{"no channels", func(c *Config) { c.Channels = "" }, []string{"Channels"}},
+		{"bad channel", func(c *Config) { c.SentryChannels = "#a b" }, []string{"SentryChannels"}},
+		{"bad policy", func(c *Config) { c.QueuePolicy = "yolo" }, []string{"QueuePolicy"}},
+		{"negative work queue", func(c *Config) { c.WorkQueueSize = -1 }, []string{"WorkQueueSize"}},
+		{"no work queue", func(c *Config) { c.WorkQueueSize = 0 }, nil},
+		{"bad pattern", func(c *Config) { c.RepoDeny = []string{"[ury"} }, []string{"RepoDeny"}},
+		{"bad templates", func(c *Config) {
+			c.Templates = map[string]string{"push": "{{ .Nope", "issues": `{{ color "mauve" .Repo }}`}
+		}, []string{"templates.push", "templates.issues"}},
+		{"bad hooks", func(c *Config) {
+			c.Hooks = []HookConfig{{Name: "x"}, {Name: "y", Secret: "s", Path: "/jenkins"}}
+			c.GenericHooks = []GenericHookConfig{{Name: "x", Token: "t"}}
+		}, []string{"hooks[0].Secret", "hooks[1].Path", "generic_hooks[0].Path"}},
+		{"everything else", func(c *Config) {
+			c.JenkinsNotify = "sometimes"
+			c.LogFormat = "xml"
+			c.SocketMode = "rw-rw----"
+			c.TLSCert = "cert.pem"
+			c.Workers = 0
+			c.RateLimit = -1
+			c.ShutdownQuitTimeout = -1
+			c.SHALength = 41
+			c.NameStyle = "nick"
+			c.TrustedProxies = []string{"localhost"}
+			c.ForwardURLs = []ForwardTarget{{URL: "example.com/hook"}}
+		}, []string{"JenkinsNotify", "LogFormat", "SocketMode", "TLSCert", "Workers", "RateLimit", "ShutdownQuitTimeout", "SHALength", "NameStyle", "TrustedProxies", "ForwardURLs[0].URL"}},
+	} {
+		conf := validConfig()
+		c.change(conf)
+		errs := conf.Validate()
+		var got []string
+		for _, err := range errs {
+			got = append(got, err.Error())
+		}
+		all := strings.Join(got, "\n")
+		if len(errs) != len(c.want) {
+			t.Errorf("%s: expected %d problems, got %d:\n%s", c.name, len(c.want), len(errs), all)
+		}
+		for _, field := range c.want {
+			if !strings.Contains(all, field+": ") && !strings.Contains(all, field+" ") {
+				t.Errorf("%s: expected a problem with %s, got:\n%s", c.name, field, all)
+			}
+		}
+	}
+}
+
+func TestValidateFixesChannels(t *testing.T) {
+	c := validConfig()
+	c.Channels = "#a, b,&c"
+	c.Hooks = []HookConfig{{Name: "x", Secret: "s", Channels: "web"}}
+	if errs := c.Validate(); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	if c.Channels != "#a,#b,&c" || c.Hooks[0].Channels != "#web" {
+		t.Errorf("expected missing #s added, got %q and %q", c.Channels, c.Hooks[0].Channels)
+	}
+	if w := strings.Join(c.Warnings, "\n"); !strings.Contains(w, "Channels:") || !strings.Contains(w, "hooks[0].Channels:") {
+		t.Errorf("expected warnings about both, got %q", w)
+	}
+}
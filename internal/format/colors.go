@@ -0,0 +1,134 @@
+package format
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/metrics"
+)
+
+// These are in string format as not having a leading zero can mess
+// up some clients when the string to colorize starts with a number.
+type MIRCColor string
+
+const (
+	ColorWhite      MIRCColor = "00"
+	ColorBlack                = "01"
+	ColorBlue                 = "02"
+	ColorGreen                = "03"
+	ColorRed                  = "04"
+	ColorBrown                = "05"
+	ColorPurple               = "06"
+	ColorOrange               = "07"
+	ColorYellow               = "08"
+	ColorLightGreen           = "09"
+	ColorCyan                 = "10"
+	ColorLightCyan            = "11"
+	ColorLightBlue            = "12"
+	ColorPink                 = "13"
+	ColorGrey                 = "14"
+	ColorLightGrey            = "15"
+)
+
+// Take a string and insert irc formatting codes around it. No colour means
+// leave it be, rather than a \x03 on its own.
+func IrcColorize(in string, fg MIRCColor) string {
+	if fg == "" {
+		return in
+	}
+	return string('\x03') + string(fg) + in + string('\x0F')
+}
+
+// Maps GitHub event strings (e.g. for PRQs, issues) to colors, for make
+// benefit beautiful IRC channel times/optical assault. Anything not here
+// goes uncoloured. [action_colors] in the config goes over these.
+var DefaultActionColors = map[string]MIRCColor{
+	"opened":   ColorGreen,
+	"reopened": ColorGreen,
+	"closed":   ColorRed,
+	"created":  ColorGreen,
+	"merged":   ColorBlue,
+
+	"ready_for_review":   ColorGreen,
+	"converted_to_draft": ColorGrey,
+	"synchronize":        ColorCyan,
+	"edited":             ColorCyan,
+	"update":             ColorCyan,
+	"pushed":             ColorCyan,
+
+	"labeled":                ColorOrange,
+	"unlabeled":              ColorOrange,
+	"assigned":               ColorLightBlue,
+	"unassigned":             ColorLightBlue,
+	"review_requested":       ColorLightBlue,
+	"review_request_removed": ColorLightBlue,
+	"milestoned":             ColorPink,
+	"demilestoned":           ColorPink,
+
+	"auto_merge_enabled":  ColorLightCyan,
+	"auto_merge_disabled": ColorLightCyan,
+	"enqueued":            ColorLightCyan,
+	"dequeued":            ColorLightCyan,
+
+	"locked":   ColorGrey,
+	"unlocked": ColorGrey,
+	"pinned":   ColorYellow,
+	"unpinned": ColorYellow,
+
+	"deleted":     ColorRed,
+	"archived":    ColorGrey,
+	"unarchived":  ColorGreen,
+	"privatized":  ColorGrey,
+	"publicized":  ColorGreen,
+	"renamed":     ColorCyan,
+	"transferred": ColorCyan,
+}
+
+// Colours for how builds, workflow runs and the like went, and alert states,
+// from Jenkins, GitHub Actions, Alertmanager and Grafana. Everything CI-ish
+// goes by these, through ConclusionColor. [state_colors] in the config goes
+// over them.
+var DefaultStateColors = map[string]MIRCColor{
+	"success":         ColorGreen,
+	"failure":         ColorRed,
+	"error":           ColorRed,
+	"timed_out":       ColorRed,
+	"unstable":        ColorYellow,
+	"pending":         ColorYellow,
+	"queued":          ColorYellow,
+	"requested":       ColorYellow,
+	"in_progress":     ColorYellow,
+	"action_required": ColorYellow,
+	"aborted":         ColorGrey,
+	"cancelled":       ColorGrey,
+	"skipped":         ColorGrey,
+	"stale":           ColorGrey,
+	"not_built":       ColorGrey,
+	"neutral":         ColorLightGrey,
+	"firing":          ColorRed,
+	"resolved":        ColorGreen,
+	"alerting":        ColorRed,
+	"ok":              ColorGreen,
+	"no_data":         ColorYellow,
+	"paused":          ColorGrey,
+}
+
+// The colour for a build result, conclusion or alert state, in any case.
+// States we've never heard of are counted, so new ones get noticed, and go
+// uncoloured.
+func ConclusionColor(state string) MIRCColor {
+	state = strings.ToLower(state)
+	c, ok := currentStyle().StateColors[state]
+	if !ok {
+		metrics.UnknownConclusions.WithLabelValues(Truncate(state, 32)).Inc()
+	}
+	return c
+}
+
+// Colours, with their optional background, and bold, italic, underline and
+// reset codes.
+var Formatting = regexp.MustCompile("\x03([0-9]{1,2}(,[0-9]{1,2})?)?|[\x02\x0F\x16\x1D\x1F]")
+
+func StripFormatting(s string) string {
+	return Formatting.ReplaceAllString(s, "")
+}
@@ -0,0 +1,67 @@
+package format
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// Markdown and HTML that comments are full of, for CommentSnippet.
+var (
+	htmlComment  = regexp.MustCompile(`(?s)<!--.*?-->`)
+	replyHeader  = regexp.MustCompile(`^On .+ wrote:$`) // Above the quote in replies by email
+	mdImage      = regexp.MustCompile(`!\[[^\]]*\]\([^)]*\)`)
+	mdLink       = regexp.MustCompile(`\[([^\]]*)\]\([^)]*\)`)
+	htmlTag      = regexp.MustCompile(`</?[a-zA-Z][^>]*>`)
+	mdLinePrefix = regexp.MustCompile(`^(#{1,6}\s+|[-*+]\s+(\[[ xX]\]\s+)?|\d+[.)]\s+)`)
+	mdEmphasis   = []*regexp.Regexp{
+		regexp.MustCompile(`\*\*(.+?)\*\*`),
+		regexp.MustCompile(`__(.+?)__`),
+		regexp.MustCompile(`~~(.+?)~~`),
+		regexp.MustCompile("`([^`]+)`"),
+		regexp.MustCompile(`\*([^*\s][^*]*)\*`),
+		regexp.MustCompile(`\b_([^_]+)_\b`),
+	}
+)
+
+// The first line of a comment worth showing, as plain text: quoted replies,
+// code blocks, HTML comments (like the ones in templates) and images are
+// skipped over, and markdown and HTML are taken out of whatever's left. ""
+// if nothing is, say for a comment that's all quotes.
+func CommentSnippet(body string) string {
+	body = htmlComment.ReplaceAllString(body, "")
+	inCode := false
+	for _, line := range strings.Split(body, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "```") || strings.HasPrefix(line, "~~~") {
+			inCode = !inCode
+			continue
+		}
+		if inCode || strings.HasPrefix(line, ">") || replyHeader.MatchString(line) {
+			continue
+		}
+		if text := markdownToText(line); text != "" {
+			return text
+		}
+	}
+	return ""
+}
+
+// Roughly what a line of markdown reads as, without formatting of any kind
+// or anything that isn't printable.
+func markdownToText(line string) string {
+	line = mdImage.ReplaceAllString(line, "")
+	line = mdLink.ReplaceAllString(line, "$1")
+	line = htmlTag.ReplaceAllString(line, "")
+	line = mdLinePrefix.ReplaceAllString(line, "")
+	for _, re := range mdEmphasis {
+		line = re.ReplaceAllString(line, "$1")
+	}
+	line = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return ' '
+		}
+		return r
+	}, StripFormatting(line))
+	return strings.Join(strings.Fields(line), " ")
+}
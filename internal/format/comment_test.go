@@ -0,0 +1,35 @@
+package format
+
+import (
+	"testing"
+)
+
+func TestCommentSnippet(t *testing.T) {
+	for _, c := range []struct {
+		body, want string
+	}{
+		{"Looks good to me", "Looks good to me"},
+		{"\n\n  Looks good\n\nto me", "Looks good"},
+		{"> Can you rebase?\n> Please\n\nDone, should be fine now", "Done, should be fine now"},
+		{"On Tue, 3 Mar 2026 at 09:12, x1tot <x1tot@example.org> wrote:\n> Any news?\nNot yet", "Not yet"},
+		{"<!-- Describe the bug -->\nIt's broken", "It's broken"},
+		{"<!--\nmore than\none line\n-->It's broken", "It's broken"},
+		{"![screenshot](https://example.org/a.png)\nLike this", "Like this"},
+		{"See [the docs](https://example.org/docs) for **why**", "See the docs for why"},
+		{"## Steps\n- [x] open the _schedule_\n", "Steps"},
+		{"- [ ] `make test`", "make test"},
+		{"1. ~~first~~ second", "first second"},
+		{"<b>Bold</b> move", "Bold move"},
+		{"```\ncode\n```\nAfter the code", "After the code"},
+		{"```go\n> not a quote\n```\n", ""},
+		{"snake_case_name stays", "snake_case_name stays"},
+		{"Bell\x07 and\ttab \x02bold\x02", "Bell and tab bold"},
+		{"> All quote\n> nothing else", ""},
+		{"![only](https://example.org/a.png)", ""},
+		{"", ""},
+	} {
+		if got := CommentSnippet(c.body); got != c.want {
+			t.Errorf("%q: expected %q, got %q", c.body, c.want, got)
+		}
+	}
+}
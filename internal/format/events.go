@@ -0,0 +1,102 @@
+package format
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Where a delivery came from, which decides how its payload gets parsed.
+const (
+	SourceGitHub = "github"
+	SourceGitLab = "gitlab"
+)
+
+// Something worth announcing, whichever forge it came from. Parsers turn
+// payloads into these, and FormatEvent turns these into IRC lines, so every
+// source gets formatted the same way.
+type Event struct {
+	Source     string // SourceGitHub, SourceGitLab, ...
+	Type       string // pull_request, issues, issue_comment, pull_request_review_comment, workflow_run, conflict, repository, push, organization, ping, meta, build, alert, grafana, sentry or generic
+	Action     string // opened, closed, reopened, created, pushed, or a build status
+	Merged     bool   // Set on closed pull requests that got merged
+	Repo       string
+	Number     int
+	Title      string
+	Sender     string
+	SenderName string `json:",omitempty"` // Their name, if the payload had it
+	Via        string `json:",omitempty"` // The bot that did it for Sender, like github-actions
+	URL        string
+	Ref        string        // Branch name, for pushes
+	Commits    int           // How many commits, for pushes
+	Log        []Commit      `json:",omitempty"` // The commits themselves, oldest first, as many as the forge sent
+	Message    string        // Any longer description, already truncated
+	KeepURL    bool          // Don't shorten URL
+	Private    bool          // From a private repo, so URLs mustn't go to a shortener
+	Age        time.Duration `json:",omitempty"` // How long it was open, for issues and PRs that have just closed
+	Comment    string        `json:",omitempty"` // The first line of a comment, as plain text, not yet cut down
+}
+
+// One commit in a push.
+type Commit struct {
+	ID      string
+	Author  string
+	Subject string // The first line of the message
+}
+
+// Roughly how long d is, in at most two units, e.g. "2d4h" or "23m", or
+// "moments" for under a minute. Negative durations, from clocks not agreeing,
+// come out as "".
+func HumanDuration(d time.Duration) string {
+	if d < 0 {
+		return ""
+	}
+	if d < time.Minute {
+		return "moments"
+	}
+	units := []struct {
+		name string
+		size time.Duration
+	}{{"d", 24 * time.Hour}, {"h", time.Hour}, {"m", time.Minute}}
+	var s string
+	shown := 0
+	for _, u := range units {
+		if n := d / u.size; n > 0 {
+			s += fmt.Sprintf("%d%s", n, u.name)
+			d -= n * u.size
+			shown++
+		} else if shown > 0 {
+			shown++ // Don't skip to a smaller unit, e.g. 2d0h5m is just 2d
+		}
+		if shown == 2 {
+			break
+		}
+	}
+	return s
+}
+
+// What goes on the end of an announcement about how long e was open, e.g.
+// " (after 2d4h)", or "" if that's not known.
+func AgeSuffix(e *Event) string {
+	if e.Age == 0 {
+		return ""
+	}
+	if d := HumanDuration(e.Age); d != "" {
+		return " (after " + d + ")"
+	}
+	return ""
+}
+
+// Squash whitespace (IRC lines can't contain newlines) and cut s down to at
+// most n characters, marking where it was cut.
+func Truncate(s string, n int) string {
+	s = strings.Join(strings.Fields(s), " ")
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	if n <= 3 {
+		return string(r[:n])
+	}
+	return strings.TrimSpace(string(r[:n-3])) + "..."
+}
@@ -0,0 +1,96 @@
+package format
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/metrics"
+)
+
+// Remembers what a Shortener said about the last so many URLs, since the same
+// PR's URL comes round again and again as it's opened, pushed to, reviewed
+// and merged. Failures are remembered too, for a little while, so a
+// shortener that's struggling doesn't get asked about every announcement.
+// It starts empty again when the config's reloaded.
+type CachedShortener struct {
+	Shortener
+	size       int
+	failureTTL time.Duration
+	now        func() time.Time
+
+	mu    sync.Mutex
+	order *list.List // Most recently used at the front
+	urls  map[string]*list.Element
+}
+
+type cachedURL struct {
+	long, short string
+	failed      time.Time // When shortening it failed, zero if it didn't
+}
+
+func NewCachedShortener(s Shortener, size int, failureTTL time.Duration) *CachedShortener {
+	return &CachedShortener{
+		Shortener:  s,
+		size:       size,
+		failureTTL: failureTTL,
+		now:        time.Now,
+		order:      list.New(),
+		urls:       make(map[string]*list.Element),
+	}
+}
+
+// Shorten long, if we haven't already. URLs that recently failed come back
+// as they are, without an error, since it's already been logged.
+func (c *CachedShortener) Shorten(ctx context.Context, long string) (string, error) {
+	c.mu.Lock()
+	if el, ok := c.urls[long]; ok {
+		u := el.Value.(*cachedURL)
+		switch {
+		case u.failed.IsZero():
+			c.order.MoveToFront(el)
+			c.mu.Unlock()
+			metrics.ShortenerCache.WithLabelValues("hit").Inc()
+			return u.short, nil
+		case c.now().Sub(u.failed) < c.failureTTL:
+			c.mu.Unlock()
+			metrics.ShortenerCache.WithLabelValues("failed").Inc()
+			return long, nil
+		}
+		c.order.Remove(el)
+		delete(c.urls, long)
+	}
+	c.mu.Unlock()
+	metrics.ShortenerCache.WithLabelValues("miss").Inc()
+
+	// Not holding the lock while we wait on the network. Two workers might
+	// both ask about the same URL, which is fine.
+	short, err := timedShorten(ctx, c.Shortener, long)
+	if err != nil && ctx.Err() != nil {
+		return short, err // Nothing to remember about the shortener
+	}
+	u := &cachedURL{long: long, short: short}
+	if err != nil {
+		u.failed = c.now()
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.urls[long]; ok {
+		c.order.Remove(el)
+	}
+	c.urls[long] = c.order.PushFront(u)
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.urls, oldest.Value.(*cachedURL).long)
+	}
+	return short, err
+}
+
+// How many URLs are remembered.
+func (c *CachedShortener) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
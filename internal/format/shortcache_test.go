@@ -0,0 +1,87 @@
+package format
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Counts calls, and fails while failing is set.
+type countingShortener struct {
+	mu      sync.Mutex
+	calls   map[string]int
+	failing bool
+}
+
+func (s *countingShortener) Shorten(ctx context.Context, long string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls[long]++
+	if s.failing {
+		return long, errors.New("down")
+	}
+	return "https://s/" + long, nil
+}
+
+func TestCachedShortener(t *testing.T) {
+	inner := &countingShortener{calls: make(map[string]int)}
+	c := NewCachedShortener(inner, 2, time.Minute)
+	now := time.Unix(0, 0)
+	c.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		if short, err := c.Shorten(context.Background(), "a"); short != "https://s/a" || err != nil {
+			t.Fatalf("expected a shortened, got %q %v", short, err)
+		}
+	}
+	if inner.calls["a"] != 1 {
+		t.Errorf("expected one call for a, got %d", inner.calls["a"])
+	}
+
+	// b and c push out a, the least recently used.
+	c.Shorten(context.Background(), "b")
+	c.Shorten(context.Background(), "a")
+	c.Shorten(context.Background(), "c")
+	c.Shorten(context.Background(), "a")
+	c.Shorten(context.Background(), "b")
+	if inner.calls["a"] != 1 || inner.calls["b"] != 2 || c.Len() != 2 {
+		t.Errorf("expected b evicted rather than a, got calls %v, %d cached", inner.calls, c.Len())
+	}
+
+	inner.failing = true
+	if short, err := c.Shorten(context.Background(), "d"); short != "d" || err == nil {
+		t.Errorf("expected the long URL and an error, got %q %v", short, err)
+	}
+	if short, err := c.Shorten(context.Background(), "d"); short != "d" || err != nil || inner.calls["d"] != 1 {
+		t.Errorf("expected the failure remembered, got %q %v after %d calls", short, err, inner.calls["d"])
+	}
+	inner.failing = false
+	now = now.Add(2 * time.Minute)
+	if short, _ := c.Shorten(context.Background(), "d"); short != "https://s/d" || inner.calls["d"] != 2 {
+		t.Errorf("expected another go once the failure expired, got %q after %d calls", short, inner.calls["d"])
+	}
+}
+
+func TestCachedShortenerConcurrent(t *testing.T) {
+	c := NewCachedShortener(&countingShortener{calls: make(map[string]int)}, 10, time.Minute)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				u := strconv.Itoa((i + j) % 20)
+				if short, _ := c.Shorten(context.Background(), u); short != "https://s/"+u {
+					t.Errorf("expected %s shortened, got %q", u, short)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	if c.Len() != 10 {
+		t.Errorf("expected the cache full at 10, got %d", c.Len())
+	}
+}
@@ -0,0 +1,110 @@
+package format
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/metrics"
+)
+
+// How shorteners make requests: with a timeout, and one retry for anything
+// that looks temporary.
+type shortenerClient struct {
+	client  *http.Client
+	backoff time.Duration // Roughly how long to wait before retrying
+}
+
+func newShortenerClient(timeout time.Duration) *shortenerClient {
+	return &shortenerClient{
+		client:  &http.Client{Timeout: timeout},
+		backoff: 200 * time.Millisecond,
+	}
+}
+
+// Send req, trying again once after a jittered wait if the connection failed
+// or the server's having trouble, unless ctx is done first. Responses are the
+// caller's to close.
+func (c *shortenerClient) Do(ctx context.Context, req *http.Request) (*http.Response, error) {
+	req = req.WithContext(ctx)
+	resp, err := c.client.Do(req)
+	if !transient(resp, err) || ctx.Err() != nil {
+		return resp, err
+	}
+	if resp != nil {
+		resp.Body.Close()
+	}
+	retry := req.Clone(ctx)
+	if req.GetBody != nil {
+		if retry.Body, err = req.GetBody(); err != nil {
+			return nil, err
+		}
+	}
+	wait := c.backoff/2 + time.Duration(rand.Int63n(int64(c.backoff)+1))
+	select {
+	case <-time.After(wait):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	metrics.ShortenerRetries.Inc()
+	return c.client.Do(retry)
+}
+
+// Whether a request might work if we tried again.
+func transient(resp *http.Response, err error) bool {
+	if err != nil {
+		return !errors.Is(err, context.Canceled)
+	}
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+}
+
+// Stops asking a shortener after it's failed so many times in a row, passing
+// URLs straight through for a while instead, so a dead shortener doesn't slow
+// every announcement down.
+type BreakerShortener struct {
+	Shortener
+	tripAfter int
+	cooldown  time.Duration
+	now       func() time.Time
+
+	mu        sync.Mutex
+	failures  int       // In a row
+	OpenUntil time.Time // Don't bother until then
+}
+
+func NewBreakerShortener(s Shortener, tripAfter int, cooldown time.Duration) *BreakerShortener {
+	return &BreakerShortener{Shortener: s, tripAfter: tripAfter, cooldown: cooldown, now: time.Now}
+}
+
+// Shorten long, unless the breaker's open, in which case it comes back as it
+// is without an error; the one that tripped it has already been seen. Being
+// cancelled isn't the shortener's fault, so doesn't count against it.
+func (b *BreakerShortener) Shorten(ctx context.Context, long string) (string, error) {
+	b.mu.Lock()
+	open := b.now().Before(b.OpenUntil)
+	b.mu.Unlock()
+	if open {
+		return long, nil
+	}
+	short, err := b.Shortener.Shorten(ctx, long)
+	if err != nil && ctx.Err() != nil {
+		return short, err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err == nil {
+		b.failures = 0
+		return short, nil
+	}
+	b.failures++
+	if b.tripAfter > 0 && b.failures >= b.tripAfter {
+		b.failures = 0
+		b.OpenUntil = b.now().Add(b.cooldown)
+		metrics.ShortenerTrips.Inc()
+		return short, errors.New(err.Error() + ", giving the shortener a rest for " + b.cooldown.String())
+	}
+	return short, err
+}
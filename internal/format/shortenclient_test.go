@@ -0,0 +1,104 @@
+package format
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestShortenerClientRetries(t *testing.T) {
+	var calls int32
+	var failures int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&calls, 1)
+		if body, _ := ioutil.ReadAll(r.Body); !strings.Contains(string(body), "longUrl") {
+			t.Errorf("call %d: expected the body sent again, got %q", n, body)
+		}
+		if n <= atomic.LoadInt32(&failures) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"shortUrl":"https://s.example/abc"}`))
+	}))
+	defer srv.Close()
+	s := &ShlinkShortener{Base: srv.URL, APIKey: "key", client: testShortenerClient(srv)}
+
+	for _, c := range []struct {
+		failures, calls int32
+		ok              bool
+	}{
+		{0, 1, true},
+		{1, 2, true},  // Retried
+		{2, 2, false}, // But only the once
+	} {
+		atomic.StoreInt32(&calls, 0)
+		atomic.StoreInt32(&failures, c.failures)
+		short, err := s.Shorten(context.Background(), "https://long.example/")
+		if (err == nil) != c.ok || (c.ok && short != "https://s.example/abc") {
+			t.Errorf("%d failures: expected ok %v, got %q %v", c.failures, c.ok, short, err)
+		}
+		if got := atomic.LoadInt32(&calls); got != c.calls {
+			t.Errorf("%d failures: expected %d calls, got %d", c.failures, c.calls, got)
+		}
+	}
+}
+
+func TestShortenerClientGivesUp(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	// Timing out, and then again on the retry.
+	client := testShortenerClient(srv)
+	timeout := *client.client
+	timeout.Timeout = 20 * time.Millisecond
+	client.client = &timeout
+	start := time.Now()
+	if _, err := (&IsGdShortener{Endpoint: srv.URL, client: client}).Shorten(context.Background(), "https://long.example/"); err == nil {
+		t.Errorf("expected a timeout")
+	}
+	if took := time.Since(start); took > time.Second {
+		t.Errorf("expected to give up quickly, took %v", took)
+	}
+
+	// Shutting down, without a retry.
+	ctx, cancel := context.WithCancel(context.Background())
+	client = testShortenerClient(srv)
+	time.AfterFunc(20*time.Millisecond, cancel)
+	if _, err := (&IsGdShortener{Endpoint: srv.URL, client: client}).Shorten(ctx, "https://long.example/"); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected to be cancelled, got %v", err)
+	}
+}
+
+func TestBreakerShortener(t *testing.T) {
+	inner := &countingShortener{calls: make(map[string]int), failing: true}
+	b := NewBreakerShortener(inner, 3, time.Minute)
+	now := time.Unix(0, 0)
+	b.now = func() time.Time { return now }
+
+	for i := 1; i <= 3; i++ {
+		if short, err := b.Shorten(context.Background(), "a"); short != "a" || err == nil {
+			t.Errorf("failure %d: expected the long URL and an error, got %q %v", i, short, err)
+		}
+	}
+	if short, err := b.Shorten(context.Background(), "a"); short != "a" || err != nil || inner.calls["a"] != 3 {
+		t.Errorf("expected to pass straight through once tripped, got %q %v after %d calls", short, err, inner.calls["a"])
+	}
+	inner.failing = false
+	now = now.Add(2 * time.Minute)
+	if short, err := b.Shorten(context.Background(), "a"); short != "https://s/a" || err != nil {
+		t.Errorf("expected to try again after the cooldown, got %q %v", short, err)
+	}
+}
@@ -0,0 +1,297 @@
+package format
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/metrics"
+)
+
+// Something that turns long URLs into short ones. git.io used to do this for
+// us, until it stopped taking new links.
+type Shortener interface {
+	Shorten(ctx context.Context, long string) (string, error)
+}
+
+// Shorteners for Config.Shortener.
+const (
+	ShortenerNone   = "none"
+	ShortenerIsGd   = "isgd"
+	ShortenerCustom = "custom"
+	ShortenerYOURLS = "yourls"
+	ShortenerShlink = "shlink"
+)
+
+// Leaves URLs as they are.
+type NoShortener struct{}
+
+func (NoShortener) Shorten(ctx context.Context, long string) (string, error) {
+	return long, nil
+}
+
+// Shortens with is.gd's API.
+type IsGdShortener struct {
+	Endpoint string // For tests, default is.gd
+	client   *shortenerClient
+}
+
+func (s *IsGdShortener) Shorten(ctx context.Context, long string) (string, error) {
+	endpoint := s.Endpoint
+	if endpoint == "" {
+		endpoint = "https://is.gd/create.php"
+	}
+	return fetchShortURL(ctx, s.client, "is.gd", endpoint+"?format=simple&url="+url.QueryEscape(long), long)
+}
+
+// Shortens with any service that takes a GET with the long URL in it and
+// replies with the short one as plain text. Endpoint has {url} in it where
+// the long URL goes, e.g. https://s.example.org/api?url={url}.
+type CustomShortener struct {
+	Endpoint string
+	client   *shortenerClient
+}
+
+func (s *CustomShortener) Shorten(ctx context.Context, long string) (string, error) {
+	u := strings.Replace(s.Endpoint, "{url}", url.QueryEscape(long), -1)
+	return fetchShortURL(ctx, s.client, "shortener", u, long)
+}
+
+// Shortens with a YOURLS install, e.g. https://ury.org.uk/s, using its
+// signature token.
+type YOURLSShortener struct {
+	Base      string
+	Signature string
+	client    *shortenerClient
+}
+
+func (s *YOURLSShortener) Shorten(ctx context.Context, long string) (string, error) {
+	form := url.Values{
+		"action":    {"shorturl"},
+		"format":    {"json"},
+		"signature": {s.Signature},
+		"url":       {long},
+	}
+	req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(s.Base, "/")+"/yourls-api.php", strings.NewReader(form.Encode()))
+	if err != nil {
+		return long, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := s.client.Do(ctx, req)
+	if err != nil {
+		return long, err
+	}
+	body, err := readReply(resp)
+	if err != nil {
+		return long, err
+	}
+	var reply struct {
+		Status   string
+		Code     string
+		Message  string
+		ShortURL string `json:"shorturl"`
+	}
+	if err := json.Unmarshal(body, &reply); err != nil {
+		return long, fmt.Errorf("yourls returned %s, not JSON: %q", resp.Status, Truncate(string(body), 100))
+	}
+	// A URL it's seen before is an error, but comes with the short URL.
+	if reply.ShortURL != "" && (reply.Status == "success" || reply.Code == "error:url") {
+		return checkShortURL("yourls", reply.ShortURL, long)
+	}
+	return long, fmt.Errorf("yourls returned %s: %s", resp.Status, Truncate(reply.Message, 100))
+}
+
+// Shortens with a Shlink server's REST API, using an API key.
+type ShlinkShortener struct {
+	Base   string
+	APIKey string
+	client *shortenerClient
+}
+
+func (s *ShlinkShortener) Shorten(ctx context.Context, long string) (string, error) {
+	// findIfExists gets us the existing short URL for anything it's seen.
+	payload, _ := json.Marshal(map[string]interface{}{"longUrl": long, "findIfExists": true})
+	req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(s.Base, "/")+"/rest/v3/short-urls", bytes.NewReader(payload))
+	if err != nil {
+		return long, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Api-Key", s.APIKey)
+	resp, err := s.client.Do(ctx, req)
+	if err != nil {
+		return long, err
+	}
+	body, err := readReply(resp)
+	if err != nil {
+		return long, err
+	}
+	var reply struct {
+		ShortURL string `json:"shortUrl"`
+		Detail   string // Problem details, on errors
+	}
+	jsonErr := json.Unmarshal(body, &reply)
+	if resp.StatusCode/100 != 2 {
+		return long, fmt.Errorf("shlink returned %s: %s", resp.Status, Truncate(reply.Detail, 100))
+	}
+	if jsonErr != nil {
+		return long, fmt.Errorf("shlink returned %s, not JSON: %q", resp.Status, Truncate(string(body), 100))
+	}
+	return checkShortURL("shlink", reply.ShortURL, long)
+}
+
+// Ask a shortener for a short URL. On any error the long URL comes back, so
+// callers can carry on with that.
+func fetchShortURL(ctx context.Context, client *shortenerClient, name, u, long string) (string, error) {
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return long, err
+	}
+	resp, err := client.Do(ctx, req)
+	if err != nil {
+		return long, err
+	}
+	body, err := readReply(resp)
+	if err != nil {
+		return long, err
+	}
+	reply := strings.TrimSpace(string(body))
+	if resp.StatusCode != http.StatusOK {
+		return long, fmt.Errorf("%s returned %s: %s", name, resp.Status, Truncate(reply, 100))
+	}
+	return checkShortURL(name, reply, long)
+}
+
+// Read a shortener's reply, which should be small.
+func readReply(resp *http.Response) ([]byte, error) {
+	defer resp.Body.Close()
+	return ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+}
+
+// Make sure what came back is a URL before we use it.
+func checkShortURL(name, short, long string) (string, error) {
+	if u, err := url.Parse(short); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return long, fmt.Errorf("%s didn't return a URL: %q", name, Truncate(short, 100))
+	}
+	return short, nil
+}
+
+// Which shortener to use and how, from Config's Shortener, ShortenerURL,
+// ShortenerKey, ShortenTimeout, ShortenTripAfter and ShortenCooldown.
+type ShortenerConfig struct {
+	Kind      string
+	URL       string
+	Key       string
+	Timeout   time.Duration
+	TripAfter int
+	Cooldown  time.Duration
+}
+
+// Set up the shortener c.Kind names, with URL and Key for the ones that need
+// them, behind a circuit breaker.
+func NewShortener(c ShortenerConfig) (Shortener, error) {
+	s, err := newShortener(c, newShortenerClient(c.Timeout))
+	if _, none := s.(NoShortener); err != nil || none {
+		return s, err
+	}
+	return NewBreakerShortener(s, c.TripAfter, c.Cooldown), nil
+}
+
+func newShortener(c ShortenerConfig, client *shortenerClient) (Shortener, error) {
+	kind, endpoint := c.Kind, c.URL
+	switch kind {
+	case ShortenerNone, "":
+		return NoShortener{}, nil
+	case ShortenerIsGd:
+		return &IsGdShortener{client: client}, nil
+	case ShortenerCustom, ShortenerYOURLS, ShortenerShlink:
+	default:
+		return nil, fmt.Errorf("unknown shortener %q, try none, isgd, custom, yourls or shlink", kind)
+	}
+	if u, err := url.Parse(endpoint); endpoint == "" || err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("%s needs ShortenerURL set to an http(s) URL", kind)
+	}
+	if kind == ShortenerCustom {
+		if !strings.Contains(endpoint, "{url}") {
+			return nil, fmt.Errorf("ShortenerURL needs {url} in it, where the long URL goes")
+		}
+		return &CustomShortener{Endpoint: endpoint, client: client}, nil
+	}
+	if c.Key == "" {
+		return nil, fmt.Errorf("%s needs ShortenerKey", kind)
+	}
+	if kind == ShortenerYOURLS {
+		return &YOURLSShortener{Base: endpoint, Signature: c.Key, client: client}, nil
+	}
+	return &ShlinkShortener{Base: endpoint, APIKey: c.Key, client: client}, nil
+}
+
+// Keeps URLs on some hosts away from the shortener, like our own GitHub
+// Enterprise, whose URLs give away more than we'd like.
+type SkipHostsShortener struct {
+	Shortener
+	Hosts []string // Globs, e.g. "*.ury.org.uk"
+}
+
+// Whether u is on one of the hosts.
+func (s *SkipHostsShortener) Skips(u string) bool {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return true // Not that it'd shorten anyway
+	}
+	host := strings.ToLower(parsed.Hostname())
+	for _, h := range s.Hosts {
+		if ok, _ := path.Match(strings.ToLower(h), host); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *SkipHostsShortener) Shorten(ctx context.Context, long string) (string, error) {
+	if s.Skips(long) {
+		return long, nil
+	}
+	return s.Shortener.Shorten(ctx, long)
+}
+
+// Shorten a URL, keeping track of how it went. The cache keeps its own
+// track, so hits don't count as calls. URLs from private repos, or hosts in
+// Config.ShortenSkipHosts, never get as far as the shortener.
+func Shorten(ctx context.Context, s Shortener, u string, private bool) (string, error) {
+	if _, none := s.(NoShortener); none {
+		return u, nil
+	}
+	if private {
+		metrics.ShortenerSkipped.WithLabelValues("private").Inc()
+		return u, nil
+	}
+	if sk, ok := s.(*SkipHostsShortener); ok {
+		if sk.Skips(u) {
+			metrics.ShortenerSkipped.WithLabelValues("host").Inc()
+			return u, nil
+		}
+		s = sk.Shortener
+	}
+	if c, ok := s.(*CachedShortener); ok {
+		return c.Shorten(ctx, u)
+	}
+	return timedShorten(ctx, s, u)
+}
+
+func timedShorten(ctx context.Context, s Shortener, u string) (string, error) {
+	start := time.Now()
+	short, err := s.Shorten(ctx, u)
+	metrics.ShortenerDuration.Observe(time.Since(start).Seconds())
+	if err != nil {
+		metrics.ShortenerFailures.Inc()
+	}
+	return short, err
+}
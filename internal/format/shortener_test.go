@@ -0,0 +1,162 @@
+package format
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testShortenerClient(srv *httptest.Server) *shortenerClient {
+	return &shortenerClient{client: srv.Client(), backoff: time.Millisecond}
+}
+
+func TestShorteners(t *testing.T) {
+	const long = "https://github.com/UniversityRadioYork/CaptainHook/pull/1?a=b&c=d"
+	var reply string
+	var status int
+	var got string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.URL.Query().Get("url")
+		w.WriteHeader(status)
+		w.Write([]byte(reply))
+	}))
+	defer srv.Close()
+
+	shorteners := map[string]Shortener{
+		"isgd":   &IsGdShortener{Endpoint: srv.URL + "/create.php", client: testShortenerClient(srv)},
+		"custom": &CustomShortener{Endpoint: srv.URL + "/api?url={url}&key=k", client: testShortenerClient(srv)},
+	}
+	for name, s := range shorteners {
+		for _, c := range []struct {
+			what   string
+			status int
+			reply  string
+			want   string
+			err    string
+		}{
+			{"ok", http.StatusOK, "https://s.example/abc\n", "https://s.example/abc", ""},
+			{"non-200", http.StatusBadRequest, "Error: Please enter a valid URL to shorten", long, "400"},
+			{"garbage", http.StatusOK, "<html>oops</html>", long, "didn't return a URL"},
+			{"empty", http.StatusOK, "", long, "didn't return a URL"},
+		} {
+			status, reply, got = c.status, c.reply, ""
+			short, err := s.Shorten(context.Background(), long)
+			if short != c.want {
+				t.Errorf("%s %s: expected %q, got %q", name, c.what, c.want, short)
+			}
+			if (err == nil) != (c.err == "") || (err != nil && !strings.Contains(err.Error(), c.err)) {
+				t.Errorf("%s %s: expected error %q, got %v", name, c.what, c.err, err)
+			}
+			if got != long {
+				t.Errorf("%s %s: expected the long URL to be passed on, got %q", name, c.what, got)
+			}
+		}
+	}
+
+	if short, err := (NoShortener{}).Shorten(context.Background(), long); short != long || err != nil {
+		t.Errorf("none: expected the URL unchanged, got %q %v", short, err)
+	}
+	srv.Close()
+	if short, err := shorteners["isgd"].Shorten(context.Background(), long); short != long || err == nil {
+		t.Errorf("expected the long URL and an error when the shortener is down, got %q %v", short, err)
+	}
+}
+
+func TestNewShortener(t *testing.T) {
+	for _, c := range []struct {
+		kind, endpoint, key string
+		ok                  bool
+	}{
+		{"", "", "", true},
+		{"none", "", "", true},
+		{"isgd", "", "", true},
+		{"custom", "https://s.example/api?url={url}", "", true},
+		{"custom", "", "", false},
+		{"custom", "https://s.example/api", "", false},
+		{"custom", "s.example/api?url={url}", "", false},
+		{"yourls", "https://ury.org.uk/s", "abc", true},
+		{"yourls", "https://ury.org.uk/s", "", false},
+		{"shlink", "https://s.example", "abc", true},
+		{"shlink", "", "abc", false},
+		{"gitio", "", "", false},
+	} {
+		if _, err := NewShortener(ShortenerConfig{Kind: c.kind, URL: c.endpoint, Key: c.key}); (err == nil) != c.ok {
+			t.Errorf("%s %q: expected ok %v, got %v", c.kind, c.endpoint, c.ok, err)
+		}
+	}
+}
+
+func TestYOURLSShortener(t *testing.T) {
+	const long = "https://github.com/UniversityRadioYork/CaptainHook/pull/1"
+	var status int
+	var reply string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.URL.Path != "/s/yourls-api.php" || r.Form.Get("action") != "shorturl" || r.Form.Get("signature") != "sig" || r.Form.Get("url") != long {
+			t.Errorf("unexpected request %s %v", r.URL.Path, r.Form)
+		}
+		w.WriteHeader(status)
+		w.Write([]byte(reply))
+	}))
+	defer srv.Close()
+	s := &YOURLSShortener{Base: srv.URL + "/s/", Signature: "sig", client: testShortenerClient(srv)}
+	for _, c := range []struct {
+		what   string
+		status int
+		reply  string
+		want   string
+	}{
+		{"new", http.StatusOK, `{"status":"success","shorturl":"https://ury.org.uk/s/1","statusCode":200}`, "https://ury.org.uk/s/1"},
+		{"duplicate", http.StatusBadRequest, `{"status":"fail","code":"error:url","message":"already exists","shorturl":"https://ury.org.uk/s/1"}`, "https://ury.org.uk/s/1"},
+		{"bad signature", http.StatusForbidden, `{"errorCode":403,"message":"Please log in"}`, long},
+		{"garbage", http.StatusOK, `<html>`, long},
+		{"not a URL", http.StatusOK, `{"status":"success","shorturl":"nope"}`, long},
+	} {
+		status, reply = c.status, c.reply
+		short, err := s.Shorten(context.Background(), long)
+		if short != c.want || (err == nil) != (c.want != long) {
+			t.Errorf("%s: expected %q, got %q %v", c.what, c.want, short, err)
+		}
+	}
+}
+
+func TestShlinkShortener(t *testing.T) {
+	const long = "https://github.com/UniversityRadioYork/CaptainHook/pull/1"
+	var status int
+	var reply string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			LongURL      string `json:"longUrl"`
+			FindIfExists bool
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		if r.Method != http.MethodPost || r.URL.Path != "/rest/v3/short-urls" || r.Header.Get("X-Api-Key") != "key" || body.LongURL != long || !body.FindIfExists {
+			t.Errorf("unexpected request %s %s %+v", r.Method, r.URL.Path, body)
+		}
+		w.WriteHeader(status)
+		w.Write([]byte(reply))
+	}))
+	defer srv.Close()
+	s := &ShlinkShortener{Base: srv.URL, APIKey: "key", client: testShortenerClient(srv)}
+	for _, c := range []struct {
+		what   string
+		status int
+		reply  string
+		want   string
+	}{
+		{"new", http.StatusOK, `{"shortUrl":"https://s.example/abc","longUrl":"` + long + `"}`, "https://s.example/abc"},
+		{"bad key", http.StatusUnauthorized, `{"type":"INVALID_API_KEY","detail":"Provided API key does not exist"}`, long},
+		{"garbage", http.StatusOK, `oops`, long},
+		{"no short URL", http.StatusOK, `{}`, long},
+	} {
+		status, reply = c.status, c.reply
+		short, err := s.Shorten(context.Background(), long)
+		if short != c.want || (err == nil) != (c.want != long) {
+			t.Errorf("%s: expected %q, got %q %v", c.what, c.want, short, err)
+		}
+	}
+}
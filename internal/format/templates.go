@@ -0,0 +1,277 @@
+// Package format turns events into IRC announcements: templates, colours and
+// shortened links.
+package format
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"text/template"
+)
+
+// The announcement for each Event.Type. Templates are given a TemplateData.
+// Override them in the [templates] section of the config, either inline or
+// as "@/path/to/file".
+var DefaultTemplates = map[string]string{
+	"pull_request":                `[{{ color "purple" .Repo }}] PRQ #{{ .Number }} {{ .Verb }} by {{ .Sender }}: {{ .Title }}. {{ .URL }}`,
+	"issues":                      `[{{ color "purple" .Repo }}] Issue #{{ .Number }} {{ .Verb }} by {{ .Sender }}: {{ .Title }}. {{ .URL }}`,
+	"repository":                  `{{ .Sender }} {{ .Verb }} {{ color "purple" .Repo }}: {{ .URL }}`,
+	"issue_comment":               `[{{ color "purple" .Repo }}] {{ .Sender }} {{ .Verb }} #{{ .Number }} ({{ .Title }}){{ with .Comment }}: "{{ . }}"{{ end }} {{ .URL }}`,
+	"pull_request_review_comment": `[{{ color "purple" .Repo }}] {{ .Sender }} {{ .Verb }} PRQ #{{ .Number }} ({{ .Title }}){{ with .Comment }}: "{{ . }}"{{ end }} {{ .URL }}`,
+	"conflict":                    `[{{ color "purple" .Repo }}] PRQ #{{ .Number }} now has {{ color "red" "conflicts" }} with {{ .Ref }}: {{ .Title }}. {{ .URL }}`,
+	"workflow_run":                `[{{ color "purple" .Repo }}] {{ .Title }} #{{ .Number }} on {{ .Ref }}: {{ state .Action }} ({{ .Message }} by {{ .Sender }}) {{ .URL }}`,
+	"push":                        `[{{ color "purple" .Repo }}] {{ .Sender }} pushed {{ .Commits }} {{ if eq .Commits 1 }}commit{{ else }}commits{{ end }} to {{ .Ref }}. {{ .URL }}`,
+	"organization":                `[{{ color "purple" .Repo }}] {{ .Sender }} {{ .Verb }}{{ with .Title }} {{ . }}{{ end }}{{ with .URL }} {{ . }}{{ end }}`,
+	"ping":                        `[{{ color "purple" .Repo }}] Webhook set up by {{ .Sender }}{{ with .Message }} for {{ . }}{{ end }}: {{ .Title }}`,
+	"meta":                        `{{ color "red" (printf "⚠ webhook removed from [%s] by %s — CaptainHook will no longer receive its events" .Repo .Sender) }}`,
+	"build":                       `[{{ color "purple" .Source }}] {{ .Repo }} #{{ .Number }}: {{ state .Action }} {{ .URL }}`,
+	"alert":                       `[{{ color "purple" "alerts" }}] {{ state .Action }} ({{ .Number }}): {{ .Title }} {{ .URL }}`,
+	"grafana":                     `[{{ color "purple" .Source }}] {{ state .Action }}{{ if .Number }} ({{ .Number }}){{ end }}: {{ .Title }}{{ with .Message }}: {{ . }}{{ end }} {{ .URL }}`,
+	"sentry":                      `[{{ color "purple" .Repo }}] New {{ color "red" .Action }} {{ .Title }}{{ with .Message }} in {{ . }}{{ end }}. {{ .URL }}`,
+}
+
+// What announcement templates get: everything in the Event, with URL
+// shortened where possible.
+type TemplateData struct {
+	Event
+	URL     string // Shortened, unless the Event says not to
+	LongURL string
+	Verb    string // The action in words, coloured, see EventVerb
+}
+
+// How actions read where it isn't just the action. [verbs] in the config
+// goes over these.
+var defaultVerbs = map[string]string{
+	"merged":                              "Merged",
+	"issue_comment.created":               "commented on",
+	"issue_comment.edited":                "edited a comment on",
+	"issue_comment.deleted":               "deleted a comment on",
+	"pull_request_review_comment.created": "commented on",
+	"pull_request_review_comment.edited":  "edited a comment on",
+	"pull_request_review_comment.deleted": "deleted a comment on",
+	"organization.member_added":           "added member",
+	"organization.member_removed":         "removed member",
+	"organization.member_invited":         "invited",
+	"organization.renamed":                "renamed the org",
+	"organization.deleted":                "deleted the org",
+}
+
+// The Event's action as it should read, e.g. "updated" for synchronize.
+// verbs is tried by type.action then action, then defaultVerbs the same
+// way; merged pull requests count as "merged". It's coloured by the action,
+// not the words, so rewording doesn't change colours.
+func EventVerb(e *Event, verbs map[string]string, colors map[string]MIRCColor) string {
+	action := EventAction(e)
+	verb, ok := verbs[e.Type+"."+action]
+	if !ok {
+		verb, ok = verbs[action]
+	}
+	if !ok {
+		verb, ok = defaultVerbs[e.Type+"."+action]
+	}
+	if !ok {
+		verb, ok = defaultVerbs[action]
+	}
+	if !ok {
+		verb = action
+	}
+	return IrcColorize(verb, colors[action])
+}
+
+// The action as far as verbs and colours go, which is "merged" for merged
+// pull requests.
+func EventAction(e *Event) string {
+	if e.Merged {
+		return "merged"
+	}
+	return e.Action
+}
+
+// The parsed default announcement templates, by Event.Type. The config's
+// own, from LoadTemplates, take over once it's loaded.
+var EventTemplates = MustParseTemplates(DefaultTemplates)
+
+// Colour names usable from templates and [action_colors].
+var TemplateColors = map[string]MIRCColor{
+	"white":      ColorWhite,
+	"black":      ColorBlack,
+	"blue":       ColorBlue,
+	"green":      ColorGreen,
+	"red":        ColorRed,
+	"brown":      ColorBrown,
+	"purple":     ColorPurple,
+	"orange":     ColorOrange,
+	"yellow":     ColorYellow,
+	"lightgreen": ColorLightGreen,
+	"cyan":       ColorCyan,
+	"lightcyan":  ColorLightCyan,
+	"lightblue":  ColorLightBlue,
+	"pink":       ColorPink,
+	"grey":       ColorGrey,
+	"lightgrey":  ColorLightGrey,
+}
+
+// The colours and shortener templates go by, which config.SetConfig keeps in step
+// with the config in use.
+type Style struct {
+	ActionColors map[string]MIRCColor
+	StateColors  map[string]MIRCColor
+	Shortener    Shortener
+}
+
+var style atomic.Value
+
+func SetStyle(s Style) {
+	style.Store(s)
+}
+
+// The Style in use, with the defaults for anything not set.
+func currentStyle() Style {
+	s, _ := style.Load().(Style)
+	if s.ActionColors == nil {
+		s.ActionColors = DefaultActionColors
+	}
+	if s.StateColors == nil {
+		s.StateColors = DefaultStateColors
+	}
+	if s.Shortener == nil {
+		s.Shortener = NoShortener{}
+	}
+	return s
+}
+
+// Helpers for templates, e.g.
+// {{ color "red" .status }} {{ bold .Title }} {{ .msg | truncate 80 }} {{ shorten .url }}
+var TemplateFuncs = template.FuncMap{
+	"truncate": func(n int, v interface{}) string {
+		return Truncate(str(v), n)
+	},
+	"color": func(name string, v interface{}) (string, error) {
+		c, ok := TemplateColors[strings.ToLower(name)]
+		if !ok {
+			return "", fmt.Errorf("unknown colour %q", name)
+		}
+		return IrcColorize(str(v), c), nil
+	},
+	"bold": func(v interface{}) string {
+		return "\x02" + str(v) + "\x02"
+	},
+	"shorten": shortenFunc(context.Background()), // Swapped for the caller's by Execute
+	// Colour a GitHub style action, e.g. opened.
+	"action": func(a string) string {
+		return IrcColorize(a, currentStyle().ActionColors[a])
+	},
+	// Shout a build result or alert state, coloured.
+	"state": func(s string) string {
+		shouted := strings.ToUpper(strings.Replace(s, "_", " ", -1))
+		return IrcColorize(shouted, ConclusionColor(s))
+	},
+}
+
+// {{ shorten }}, giving up on the shortener once ctx is done.
+func shortenFunc(ctx context.Context) func(v interface{}) string {
+	return func(v interface{}) string {
+		if str(v) == "" {
+			return ""
+		}
+		u, _ := Shorten(ctx, currentStyle().Shortener, str(v), false) // Falls back to the long URL
+		return u
+	}
+}
+
+// Run t with data into w. Templates can't be handed a context, so this is
+// how {{ shorten }} knows when to stop waiting.
+func Execute(ctx context.Context, t *template.Template, w io.Writer, data interface{}) error {
+	t, err := t.Clone()
+	if err != nil {
+		return err
+	}
+	return t.Funcs(template.FuncMap{"shorten": shortenFunc(ctx)}).Execute(w, data)
+}
+
+// Like fmt.Sprint, but missing values are empty rather than "<nil>".
+func str(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	return fmt.Sprint(v)
+}
+
+// Something to try templates out on, so mistakes show up at startup rather
+// than on the first delivery.
+var sampleTemplateData = TemplateData{
+	Event: Event{
+		Source:  SourceGitHub,
+		Action:  "success", // Something state knows, so trying it isn't counted
+		Repo:    "website",
+		Number:  1,
+		Title:   "Example",
+		Sender:  "someone",
+		Ref:     "main",
+		Commits: 2,
+		Message: "Example",
+	},
+	URL:     "https://example.org/",
+	LongURL: "https://example.org/",
+	Verb:    "opened",
+}
+
+// Parse the default templates with any overrides in place.
+func LoadTemplates(overrides map[string]string) (map[string]*template.Template, error) {
+	sources := make(map[string]string)
+	for name, text := range DefaultTemplates {
+		sources[name] = text
+	}
+	for name, text := range overrides {
+		if _, ok := DefaultTemplates[name]; !ok {
+			return nil, fmt.Errorf("template %s: no such event type, try one of %s", name, templateNames())
+		}
+		if strings.HasPrefix(text, "@") {
+			b, err := ioutil.ReadFile(strings.TrimPrefix(text, "@"))
+			if err != nil {
+				return nil, fmt.Errorf("template %s: %v", name, err)
+			}
+			text = strings.TrimRight(string(b), "\n")
+		}
+		sources[name] = text
+	}
+	return parseTemplates(sources)
+}
+
+func parseTemplates(sources map[string]string) (map[string]*template.Template, error) {
+	parsed := make(map[string]*template.Template)
+	for name, text := range sources {
+		t, err := template.New(name).Funcs(TemplateFuncs).Parse(text)
+		if err != nil {
+			return nil, err // These already say which template and where
+		}
+		sample := sampleTemplateData
+		sample.Type = name
+		if err := t.Execute(ioutil.Discard, sample); err != nil {
+			return nil, err
+		}
+		parsed[name] = t
+	}
+	return parsed, nil
+}
+
+func MustParseTemplates(sources map[string]string) map[string]*template.Template {
+	t, err := parseTemplates(sources)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func templateNames() string {
+	var names []string
+	for name := range DefaultTemplates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,60 @@
+package format
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadTemplatesErrors(t *testing.T) {
+	for _, c := range []struct {
+		name, text, want string
+	}{
+		{"push", "{{ .Repo ", "push:1"},
+		{"push", "{{ frobnicate .Repo }}", "frobnicate"},
+		{"push", "{{ .NoSuchField }}", "NoSuchField"},
+		{"push", `{{ color "mauve" .Repo }}`, "mauve"},
+		{"pushes", "{{ .Repo }}", "no such event type"},
+		{"push", "@/nonexistent/push.tmpl", "nonexistent"},
+	} {
+		_, err := LoadTemplates(map[string]string{c.name: c.text})
+		if err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("%s %q: expected an error mentioning %q, got %v", c.name, c.text, c.want, err)
+		}
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	for _, c := range []struct {
+		in   string
+		n    int
+		want string
+	}{
+		{"short", 10, "short"},
+		{"line one\n\nline two", 20, "line one line two"},
+		{"a much longer message", 10, "a much..."},
+		{"ünïcödé strings", 8, "ünïcö..."},
+	} {
+		if got := Truncate(c.in, c.n); got != c.want {
+			t.Errorf("truncate(%q, %d): expected %q, got %q", c.in, c.n, c.want, got)
+		}
+	}
+}
+
+func TestHumanDuration(t *testing.T) {
+	for d, want := range map[time.Duration]string{
+		-2 * time.Second:                "",
+		0:                               "moments",
+		59 * time.Second:                "moments",
+		23*time.Minute + 11*time.Second: "23m",
+		time.Hour:                       "1h",
+		3*time.Hour + 5*time.Minute:     "3h5m",
+		52*time.Hour + 17*time.Minute:   "2d4h",
+		48*time.Hour + 5*time.Minute:    "2d",
+		400*24*time.Hour + time.Hour + time.Minute: "400d1h",
+	} {
+		if got := HumanDuration(d); got != want {
+			t.Errorf("%s: expected %q, got %q", d, want, got)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+// Package conflicts notices pull requests that a push has left unmergeable.
+package conflicts
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/config"
+	"github.com/UniversityRadioYork/CaptainHook/internal/format"
+	"github.com/UniversityRadioYork/CaptainHook/internal/github"
+)
+
+// Pull requests can start conflicting with their base branch when they're
+// pushed to, or when the base is. Either way we ask GitHub whether they can
+// still be merged, and say so when one that could now can't. New ones are
+// asked about too, so we know where they started. Only the last
+// answer for each is kept, and only in memory, so after a restart a PR needs
+// to be seen mergeable again before it'll be warned about.
+type Tracker struct {
+	api   github.GitHubAPI
+	retry time.Duration // Between asks while GitHub works it out
+
+	mu        sync.Mutex
+	mergeable map[string]bool // By "owner/name#number"
+}
+
+func NewTracker(api github.GitHubAPI) *Tracker {
+	return &Tracker{
+		api:       api,
+		retry:     2 * time.Second,
+		mergeable: make(map[string]bool),
+	}
+}
+
+// The most PRs we'll check after a push to their base branch, and times
+// we'll ask about each before giving up on GitHub working it out.
+const (
+	maxConflictChecks = 30
+	mergeableTries    = 5
+)
+
+// Check the PRs a GitHub delivery of event could have changed the
+// mergeability of, returning events for the ones that have started
+// conflicting, and the repo they're in. Nothing's checked without a
+// GitHubToken, or with WarnConflicts off.
+func (t *Tracker) Check(ctx context.Context, conf *config.Config, event string, payload []byte, logger *slog.Logger) (string, []*format.Event) {
+	if t == nil || conf == nil || conf.GitHubToken == "" || !conf.WarnConflicts || ctx.Err() != nil {
+		return "", nil
+	}
+	var p struct {
+		Action     string
+		Ref        string
+		PRQ        github.PRQ `json:"pull_request"`
+		Repository github.Repo
+	}
+	if json.Unmarshal(payload, &p) != nil || p.Repository.FullName == "" {
+		return "", nil
+	}
+	repo := p.Repository.FullName
+	var numbers []int
+	switch {
+	case event == "pull_request" && (p.Action == "synchronize" || p.Action == "opened" || p.Action == "reopened"):
+		numbers = []int{p.PRQ.Number}
+	case event == "pull_request" && p.Action == "closed":
+		t.forget(repo, p.PRQ.Number)
+		return "", nil
+	case event == "push" && strings.HasPrefix(p.Ref, "refs/heads/"):
+		numbers = t.openPRs(ctx, conf, repo, strings.TrimPrefix(p.Ref, "refs/heads/"), logger)
+	default:
+		return "", nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, conf.ConflictCheckTimeout)
+	defer cancel()
+	var events []*format.Event
+	for _, n := range numbers {
+		pr, err := t.pullRequest(ctx, conf, repo, n)
+		if github.Unavailable(err) {
+			logger.Info("Not checking for conflicts while GitHub's rate limiting us", "repo", repo)
+			break
+		}
+		if err != nil {
+			logger.Warn("Couldn't check whether a pull request can be merged", "repo", repo, "number", n, "err", err)
+			continue
+		}
+		if pr.Mergeable == nil || !t.conflicted(repo, n, *pr.Mergeable) {
+			continue
+		}
+		e := &format.Event{
+			Source:  format.SourceGitHub,
+			Type:    "conflict",
+			Action:  "conflicted",
+			Repo:    p.Repository.Name,
+			Number:  pr.Number,
+			Title:   pr.Title,
+			URL:     pr.HTMLURL,
+			Ref:     pr.Base.Ref,
+			Private: p.Repository.Private,
+		}
+		events = append(events, e)
+	}
+	return repo, events
+}
+
+// Note whether repo's PR number can be merged, and whether that means it's
+// just started conflicting.
+func (t *Tracker) conflicted(repo string, number int, mergeable bool) bool {
+	key := fmt.Sprintf("%s#%d", strings.ToLower(repo), number)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	was, known := t.mergeable[key]
+	t.mergeable[key] = mergeable
+	return known && was && !mergeable
+}
+
+func (t *Tracker) forget(repo string, number int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.mergeable, fmt.Sprintf("%s#%d", strings.ToLower(repo), number))
+}
+
+// The numbers of repo's open PRs into base.
+func (t *Tracker) openPRs(ctx context.Context, conf *config.Config, repo, base string, logger *slog.Logger) []int {
+	ctx, cancel := context.WithTimeout(ctx, conf.ConflictCheckTimeout)
+	defer cancel()
+	var prs []github.PRQ
+	path := fmt.Sprintf("/repos/%s/pulls?state=open&base=%s&per_page=%d", repo, url.QueryEscape(base), maxConflictChecks)
+	if err := t.api.Get(ctx, conf.GitHubAPIURL, conf.GitHubToken, path, &prs); err != nil {
+		logger.Warn("Couldn't list pull requests to check for conflicts", "repo", repo, "base", base, "err", err)
+		return nil
+	}
+	var numbers []int
+	for _, pr := range prs {
+		numbers = append(numbers, pr.Number)
+	}
+	return numbers
+}
+
+// Ask GitHub about repo's PR number. Mergeable is null while GitHub works it
+// out, which it starts doing when asked, so we ask again a few times.
+func (t *Tracker) pullRequest(ctx context.Context, conf *config.Config, repo string, number int) (*github.PRQ, error) {
+	var pr github.PRQ
+	for try := 1; ; try++ {
+		if err := t.api.Get(ctx, conf.GitHubAPIURL, conf.GitHubToken, fmt.Sprintf("/repos/%s/pulls/%d", repo, number), &pr); err != nil {
+			return nil, err
+		}
+		if pr.Mergeable != nil || pr.State != "open" || try == mergeableTries {
+			return &pr, nil
+		}
+		select {
+		case <-time.After(t.retry):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package conflicts
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/config"
+	"github.com/UniversityRadioYork/CaptainHook/internal/config/configtest"
+	"github.com/UniversityRadioYork/CaptainHook/internal/github"
+	"github.com/UniversityRadioYork/CaptainHook/internal/outputs"
+)
+
+// A GitHub API with one open pull request, #14 into main, whose mergeable
+// comes from the front of answers each time it's asked about.
+type fakePullsAPI struct {
+	mu      sync.Mutex
+	answers []string // JSON for mergeable: true, false or null
+	asked   int
+}
+
+func (f *fakePullsAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch r.URL.Path {
+	case "/repos/ury/playout/pulls":
+		if r.URL.Query().Get("base") != "main" {
+			w.Write([]byte(`[]`))
+			return
+		}
+		w.Write([]byte(`[{"number": 14}]`))
+	case "/repos/ury/playout/pulls/14":
+		f.asked++
+		mergeable := "null"
+		if len(f.answers) > 0 {
+			mergeable, f.answers = f.answers[0], f.answers[1:]
+		}
+		fmt.Fprintf(w, `{"number": 14, "title": "Fix the silence detector", "state": "open",
+			"html_url": "https://github.com/ury/playout/pull/14", "base": {"ref": "main"}, "mergeable": %s}`, mergeable)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestConflictCheck(t *testing.T) {
+	api := &fakePullsAPI{}
+	srv := httptest.NewServer(api)
+	defer srv.Close()
+	c := configtest.Valid()
+	c.GitHubToken, c.GitHubAPIURL = "tok", srv.URL
+	if errs := c.Validate(); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	config.SetConfig(c)
+	defer config.SetConfig(configtest.Valid())
+	tracker := NewTracker(github.NewGitHubClient())
+	tracker.retry = time.Millisecond
+	repo := `"repository": {"name": "playout", "full_name": "ury/playout"}`
+	type delivery struct {
+		event   string
+		payload []byte
+	}
+	synced := delivery{"pull_request", []byte(`{"action": "synchronize", "pull_request": {"number": 14}, ` + repo + `}`)}
+	push := delivery{"push", []byte(`{"ref": "refs/heads/main", ` + repo + `}`)}
+	check := func(d delivery) []string {
+		var texts []string
+		full, events := tracker.Check(context.Background(), c, d.event, d.payload, config.DiscardLogger)
+		for _, e := range events {
+			texts = append(texts, outputs.ShowFormatting(c.ForRepo(full).Format(context.Background(), e, config.DiscardLogger)))
+		}
+		return texts
+	}
+
+	api.answers = []string{"null", "null", "true"}
+	if got := check(synced); len(got) > 0 || api.asked != 3 {
+		t.Errorf("expected it asked about until GitHub knew, and nothing said, got %q after %d", got, api.asked)
+	}
+	api.answers = []string{"false"}
+	want := "[%C06playout%O] PRQ #14 now has %C04conflicts%O with main: Fix the silence detector. https://github.com/ury/playout/pull/14%O"
+	if got := check(push); len(got) != 1 || got[0] != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+	api.answers = []string{"false"}
+	if got := check(synced); len(got) > 0 {
+		t.Errorf("expected nothing for one that was already conflicting, got %q", got)
+	}
+	api.answers = []string{"true", "false"}
+	check(synced)
+	if got := check(push); len(got) != 1 {
+		t.Errorf("expected another warning once it had been fixed, got %q", got)
+	}
+	api.answers = nil
+	if got := check(push); len(got) > 0 {
+		t.Errorf("expected nothing when GitHub never says, got %q", got)
+	}
+
+	asked := api.asked
+	c.GitHubToken = ""
+	if got := check(push); len(got) > 0 || api.asked != asked {
+		t.Errorf("expected no checks without a token, got %q", got)
+	}
+}
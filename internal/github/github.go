@@ -0,0 +1,484 @@
+// Package github parses GitHub's webhook payloads, and asks its API about
+// repos and users.
+package github
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/format"
+)
+
+// Various (partial!) Github object structs, JSON is parsed into these
+
+type User struct {
+	Login   string
+	Name    string // Hardly ever there
+	Type    string // User, Bot or Organization
+	HTMLURL string `json:"html_url"`
+}
+
+type Repo struct {
+	Name     string
+	FullName string `json:"full_name"`
+	HTMLURL  string `json:"html_url"`
+	Private  bool   `json:"private"`
+}
+
+type Issue struct {
+	Number    int
+	Title     string
+	HTMLURL   string    `json:"html_url"`
+	CreatedAt time.Time `json:"created_at"`
+	ClosedAt  time.Time `json:"closed_at"`
+}
+
+type PRQ struct {
+	Number    int
+	Title     string
+	HTMLURL   string `json:"html_url"`
+	State     string
+	Merged    bool
+	Mergeable *bool // Null while GitHub's still working it out
+	MergedBy  User  `json:"merged_by"`
+	Base      struct {
+		Ref string
+	}
+	CreatedAt time.Time `json:"created_at"`
+	ClosedAt  time.Time `json:"closed_at"`
+	MergedAt  time.Time `json:"merged_at"`
+}
+
+type IssueEvent struct {
+	Action     string
+	Issue      Issue
+	Sender     User
+	Repository Repo
+}
+
+type PRQEvent struct {
+	Action     string
+	Sender     User
+	PRQ        PRQ `json:"pull_request"`
+	Repository Repo
+}
+
+type Comment struct {
+	Body    string
+	HTMLURL string `json:"html_url"`
+}
+
+type IssueCommentEvent struct {
+	Action     string
+	Issue      Issue
+	Comment    Comment
+	Sender     User
+	Repository Repo
+}
+
+type ReviewCommentEvent struct {
+	Action     string
+	PRQ        PRQ `json:"pull_request"`
+	Comment    Comment
+	Sender     User
+	Repository Repo
+}
+
+type WorkflowRun struct {
+	Name            string
+	RunNumber       int    `json:"run_number"`
+	HeadBranch      string `json:"head_branch"`
+	HTMLURL         string `json:"html_url"`
+	Event           string // What set it off: push, schedule, workflow_dispatch...
+	Conclusion      string // Once it's completed
+	Actor           User
+	TriggeringActor User `json:"triggering_actor"` // Differs from Actor for re-runs
+}
+
+type WorkflowRunEvent struct {
+	Action      string
+	WorkflowRun WorkflowRun `json:"workflow_run"`
+	Sender      User
+	Repository  Repo
+}
+
+// Pushes have no action. Their repository's dates are numbers rather than
+// strings, unlike everywhere else, so Repo had better not grow any.
+type PushEvent struct {
+	Ref     string
+	Deleted bool
+	Compare string
+	Commits []struct {
+		ID      string
+		Message string
+		Author  struct {
+			Name string
+		}
+	}
+	Sender     User
+	Repository Repo
+}
+
+// Where an event happened. Org hooks send some, like organization and
+// their pings, without a repository, and some only say which enterprise.
+type Scope struct {
+	Repository   Repo
+	Organization User
+	Enterprise   struct {
+		Slug string
+	}
+}
+
+// What goes in the [] at the start of announcements: the repo's name, or
+// failing that the org's, or the enterprise's.
+func (s Scope) Prefix() string {
+	switch {
+	case s.Repository.Name != "":
+		return s.Repository.Name
+	case s.Organization.Login != "":
+		return s.Organization.Login
+	}
+	return s.Enterprise.Slug
+}
+
+// What [repos] settings go by: owner/name, or just the org or enterprise.
+func (s Scope) FullName() string {
+	if s.Repository.FullName != "" {
+		return s.Repository.FullName
+	}
+	return s.Prefix()
+}
+
+// People joining and leaving an org, and the org itself changing.
+type OrganizationEvent struct {
+	Action     string
+	Membership struct {
+		User User
+	}
+	Invitation struct {
+		Login string // Null for invitations by email
+	}
+	Sender User
+	Scope
+}
+
+// Sent when a hook's set up, and with "Redeliver" or "Test" on GitHub.
+type PingEvent struct {
+	Zen  string
+	Hook struct {
+		Type   string // Repository or Organization
+		Events []string
+	}
+	Sender User
+	Scope
+}
+
+// Sent by a hook as it's deleted, the last we'll hear from it.
+type MetaEvent struct {
+	Action string
+	HookID int64 `json:"hook_id"`
+	Sender User
+	Scope
+}
+
+type RepositoryEvent struct {
+	Action     string
+	Sender     User
+	Repository Repo
+}
+
+// The GitHub event types we handle: how to parse each, the actions GitHub
+// sends with it, and any more the parser turns them into (or GitLab's do)
+// that Events can ask for. Anything not in here, type or action, is unknown
+// to us (see ircbot/unknown.go), and knownActions is made from it too.
+var Events = map[string]struct {
+	parse   func(body []byte) (*format.Event, error)
+	Actions []string
+	Also    []string
+}{
+	"pull_request": {parsePullRequest, []string{"assigned", "auto_merge_disabled", "auto_merge_enabled", "closed",
+		"converted_to_draft", "demilestoned", "dequeued", "edited", "enqueued", "labeled", "locked", "milestoned",
+		"opened", "ready_for_review", "reopened", "review_request_removed", "review_requested", "synchronize",
+		"unassigned", "unlabeled", "unlocked"}, []string{"merged", "update"}},
+	"issues": {parseIssues, []string{"assigned", "closed", "deleted", "demilestoned", "edited", "labeled", "locked",
+		"milestoned", "opened", "pinned", "reopened", "transferred", "unassigned", "unlabeled", "unlocked", "unpinned"},
+		[]string{"update"}},
+	"repository": {parseRepository, []string{"archived", "created", "deleted", "edited", "privatized", "publicized",
+		"renamed", "transferred", "unarchived"}, nil},
+	"issue_comment":               {parseIssueComment, []string{"created", "deleted", "edited"}, nil},
+	"pull_request_review_comment": {parseReviewComment, []string{"created", "deleted", "edited"}, nil},
+	"workflow_run": {parseWorkflowRun, []string{"completed", "in_progress", "requested"}, []string{"action_required",
+		"cancelled", "failure", "neutral", "skipped", "stale", "success", "timed_out"}},
+	"push": {parsePush, nil, []string{"pushed"}},
+	"organization": {parseOrganization, []string{"deleted", "member_added", "member_invited", "member_removed",
+		"renamed"}, nil},
+	"ping": {parsePing, nil, []string{"pinged"}},
+	"meta": {parseMeta, []string{"deleted"}, nil},
+}
+
+// Turn a GitHub event payload into an Event. Events we don't handle produce
+// nil and no error.
+func ParseGitHubEvent(ev string, body []byte) (*format.Event, error) {
+	h, ok := Events[ev]
+	if !ok {
+		return nil, nil
+	}
+	return h.parse(body)
+}
+
+func parsePullRequest(body []byte) (*format.Event, error) {
+	var event PRQEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		return nil, err
+	}
+	closed := event.PRQ.ClosedAt
+	if event.PRQ.Merged && !event.PRQ.MergedAt.IsZero() {
+		closed = event.PRQ.MergedAt
+	}
+	sender, via := behindBot(event.Sender, event.PRQ.MergedBy)
+	return &format.Event{
+		Source: format.SourceGitHub,
+		Type:   "pull_request",
+		Action: event.Action,
+		// PRQs are a bit special -_-
+		// The PRQ has a 'merged' key instead of a merged
+		// event, so we explicitly check for that.
+		Merged:     event.Action == "closed" && event.PRQ.Merged,
+		Repo:       event.Repository.Name,
+		Number:     event.PRQ.Number,
+		Title:      event.PRQ.Title,
+		Sender:     sender.Login,
+		SenderName: sender.Name,
+		Via:        via,
+		URL:        event.PRQ.HTMLURL,
+		Private:    event.Repository.Private,
+		Age:        openFor(event.Action, event.PRQ.CreatedAt, closed),
+	}, nil
+}
+
+func parseIssues(body []byte) (*format.Event, error) {
+	var event IssueEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		return nil, err
+	}
+	return &format.Event{
+		Source:     format.SourceGitHub,
+		Type:       "issues",
+		Action:     event.Action,
+		Repo:       event.Repository.Name,
+		Number:     event.Issue.Number,
+		Title:      event.Issue.Title,
+		Sender:     event.Sender.Login,
+		SenderName: event.Sender.Name,
+		URL:        event.Issue.HTMLURL,
+		Private:    event.Repository.Private,
+		Age:        openFor(event.Action, event.Issue.CreatedAt, event.Issue.ClosedAt),
+	}, nil
+}
+
+// Runs of GitHub Actions workflows. Completed runs go by how they went,
+// success, failure and so on, so those are what [Events] lists for them.
+func parseWorkflowRun(body []byte) (*format.Event, error) {
+	var event WorkflowRunEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		return nil, err
+	}
+	run := event.WorkflowRun
+	action := event.Action
+	if action == "completed" && run.Conclusion != "" {
+		action = run.Conclusion
+	}
+	sender, via := behindBot(event.Sender, run.TriggeringActor, run.Actor)
+	return &format.Event{
+		Source:     format.SourceGitHub,
+		Type:       "workflow_run",
+		Action:     action,
+		Repo:       event.Repository.Name,
+		Number:     run.RunNumber,
+		Title:      run.Name,
+		Sender:     sender.Login,
+		SenderName: sender.Name,
+		Via:        via,
+		URL:        run.HTMLURL,
+		Ref:        run.HeadBranch,
+		Message:    run.Event,
+		Private:    event.Repository.Private,
+	}, nil
+}
+
+// Pushes to branches, like GitLab's. Deleting a branch, or pushing one that
+// only has commits we've seen, isn't announced.
+func parsePush(body []byte) (*format.Event, error) {
+	var event PushEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(event.Ref, "refs/heads/") || event.Deleted || len(event.Commits) == 0 {
+		return nil, nil
+	}
+	var log []format.Commit
+	for _, c := range event.Commits {
+		log = append(log, format.Commit{ID: c.ID, Author: c.Author.Name, Subject: strings.SplitN(c.Message, "\n", 2)[0]})
+	}
+	return &format.Event{
+		Source:     format.SourceGitHub,
+		Type:       "push",
+		Action:     "pushed",
+		Repo:       event.Repository.Name,
+		Sender:     event.Sender.Login,
+		SenderName: event.Sender.Name,
+		URL:        event.Compare,
+		Ref:        strings.TrimPrefix(event.Ref, "refs/heads/"),
+		Commits:    len(event.Commits),
+		Log:        log,
+		Private:    event.Repository.Private,
+	}, nil
+}
+
+// Whether u is an app rather than a person, like github-actions[bot].
+func isBot(u User) bool {
+	return u.Type == "Bot" || strings.HasSuffix(u.Login, "[bot]")
+}
+
+// Who to say did something: sender, unless it's a bot, in which case the
+// first of people who isn't, along with the bot's name to say it went via.
+// If it's bots all the way down the bot gets the credit after all.
+func behindBot(sender User, people ...User) (User, string) {
+	if !isBot(sender) {
+		return sender, ""
+	}
+	for _, u := range people {
+		if u.Login != "" && !isBot(u) {
+			return u, strings.TrimSuffix(sender.Login, "[bot]")
+		}
+	}
+	return sender, ""
+}
+
+// How long an issue or PR was open, if action closed it and GitHub told us
+// both ends.
+func openFor(action string, created, closed time.Time) time.Duration {
+	if action != "closed" || created.IsZero() || closed.IsZero() {
+		return 0
+	}
+	return closed.Sub(created)
+}
+
+// Comments on issues, and on pull requests as a whole.
+func parseIssueComment(body []byte) (*format.Event, error) {
+	var event IssueCommentEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		return nil, err
+	}
+	return &format.Event{
+		Source:     format.SourceGitHub,
+		Type:       "issue_comment",
+		Action:     event.Action,
+		Repo:       event.Repository.Name,
+		Number:     event.Issue.Number,
+		Title:      event.Issue.Title,
+		Sender:     event.Sender.Login,
+		SenderName: event.Sender.Name,
+		URL:        event.Comment.HTMLURL,
+		Private:    event.Repository.Private,
+		Comment:    format.CommentSnippet(event.Comment.Body),
+	}, nil
+}
+
+// Comments on a line of a pull request's changes.
+func parseReviewComment(body []byte) (*format.Event, error) {
+	var event ReviewCommentEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		return nil, err
+	}
+	return &format.Event{
+		Source:     format.SourceGitHub,
+		Type:       "pull_request_review_comment",
+		Action:     event.Action,
+		Repo:       event.Repository.Name,
+		Number:     event.PRQ.Number,
+		Title:      event.PRQ.Title,
+		Sender:     event.Sender.Login,
+		SenderName: event.Sender.Name,
+		URL:        event.Comment.HTMLURL,
+		Private:    event.Repository.Private,
+		Comment:    format.CommentSnippet(event.Comment.Body),
+	}, nil
+}
+
+func parseRepository(body []byte) (*format.Event, error) {
+	var event RepositoryEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		return nil, err
+	}
+	return &format.Event{
+		Source:     format.SourceGitHub,
+		Type:       "repository",
+		Action:     event.Action,
+		Repo:       event.Repository.Name,
+		Sender:     event.Sender.Login,
+		SenderName: event.Sender.Name,
+		URL:        event.Repository.HTMLURL,
+		Private:    event.Repository.Private,
+	}, nil
+}
+
+// From org hooks, so there's no repository: Repo is the org's name.
+func parseOrganization(body []byte) (*format.Event, error) {
+	var event OrganizationEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		return nil, err
+	}
+	member := event.Membership.User
+	if member.Login == "" {
+		member.Login = event.Invitation.Login
+	}
+	return &format.Event{
+		Source:     format.SourceGitHub,
+		Type:       "organization",
+		Action:     event.Action,
+		Repo:       event.Prefix(),
+		Title:      member.Login,
+		Sender:     event.Sender.Login,
+		SenderName: event.Sender.Name,
+		URL:        member.HTMLURL,
+	}, nil
+}
+
+// A hook saying hello, from a repo or an org. Pings have no action, so
+// they're "pinged", and Message is the events the hook sends.
+func parsePing(body []byte) (*format.Event, error) {
+	var event PingEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		return nil, err
+	}
+	return &format.Event{
+		Source:     format.SourceGitHub,
+		Type:       "ping",
+		Action:     "pinged",
+		Repo:       event.Prefix(),
+		Title:      event.Zen,
+		Sender:     event.Sender.Login,
+		SenderName: event.Sender.Name,
+		Message:    strings.Join(event.Hook.Events, ", "),
+		Private:    event.Repository.Private,
+	}, nil
+}
+
+// A hook, ours since it's telling us, being deleted from a repo or org.
+func parseMeta(body []byte) (*format.Event, error) {
+	var event MetaEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		return nil, err
+	}
+	return &format.Event{
+		Source:     format.SourceGitHub,
+		Type:       "meta",
+		Action:     event.Action,
+		Repo:       event.Prefix(),
+		Sender:     event.Sender.Login,
+		SenderName: event.Sender.Name,
+		Private:    event.Repository.Private,
+	}, nil
+}
@@ -0,0 +1,72 @@
+package github
+
+import (
+	"testing"
+	"time"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/format"
+)
+
+func TestBehindBot(t *testing.T) {
+	person := User{Login: "mstratford", Type: "User"}
+	actions := User{Login: "github-actions[bot]", Type: "Bot"}
+	app := User{Login: "ury-deployer", Type: "Bot"}
+	for _, c := range []struct {
+		name     string
+		sender   User
+		people   []User
+		who, via string
+	}{
+		{"person", person, []User{{Login: "x1tot"}}, "mstratford", ""},
+		{"bot for a person", actions, []User{{}, actions, person}, "mstratford", "github-actions"},
+		{"app without [bot]", app, []User{person}, "mstratford", "ury-deployer"},
+		{"nobody behind it", actions, []User{actions}, "github-actions[bot]", ""},
+	} {
+		who, via := behindBot(c.sender, c.people...)
+		if who.Login != c.who || via != c.via {
+			t.Errorf("%s: expected %s via %q, got %s via %q", c.name, c.who, c.via, who.Login, via)
+		}
+	}
+}
+
+func TestScopePrefix(t *testing.T) {
+	repo := Repo{Name: "website", FullName: "UniversityRadioYork/website"}
+	org := User{Login: "ury-bots"}
+	for _, c := range []struct {
+		name           string
+		scope          Scope
+		prefix, routed string
+	}{
+		{"repo", Scope{Repository: repo, Organization: org}, "website", "UniversityRadioYork/website"},
+		{"org", Scope{Organization: org}, "ury-bots", "ury-bots"},
+		{"enterprise", func() Scope { var s Scope; s.Enterprise.Slug = "compsoc"; return s }(), "compsoc", "compsoc"},
+		{"nothing", Scope{}, "", ""},
+	} {
+		if got := c.scope.Prefix(); got != c.prefix {
+			t.Errorf("%s: expected prefix %q, got %q", c.name, c.prefix, got)
+		}
+		if got := c.scope.FullName(); got != c.routed {
+			t.Errorf("%s: expected to go by %q, got %q", c.name, c.routed, got)
+		}
+	}
+}
+
+func TestAgeSuffix(t *testing.T) {
+	created := time.Date(2024, 2, 9, 14, 12, 40, 0, time.UTC)
+	for _, c := range []struct {
+		action          string
+		created, closed time.Time
+		want            string
+	}{
+		{"closed", created, created.Add(26 * time.Hour), " (after 1d2h)"},
+		{"closed", created, created.Add(-2 * time.Second), ""}, // Clock skew
+		{"closed", time.Time{}, created, ""},
+		{"closed", created, time.Time{}, ""},
+		{"opened", created, created.Add(time.Hour), ""},
+	} {
+		e := &format.Event{Action: c.action, Age: openFor(c.action, c.created, c.closed)}
+		if got := format.AgeSuffix(e); got != c.want {
+			t.Errorf("%s %s-%s: expected %q, got %q", c.action, c.created, c.closed, c.want, got)
+		}
+	}
+}
@@ -0,0 +1,234 @@
+package github
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/metrics"
+)
+
+// How everything that wants something from GitHub's API asks for it, so
+// tests can fake it. apiURL is Config.GitHubAPIURL, GitHub Enterprise's or
+// the real one, and path is like "/users/x1tot".
+type GitHubAPI interface {
+	Get(ctx context.Context, apiURL, token, path string, v interface{}) error
+	Post(ctx context.Context, apiURL, token, path string, body, v interface{}) error
+	Patch(ctx context.Context, apiURL, token, path string, body, v interface{}) error
+}
+
+// Talks to GitHub's API. GETs are made conditional on the ETag we got last
+// time, and GitHub doesn't count those against the rate limit when nothing's
+// changed. When we've run out of requests, or GitHub asks us to slow down, we
+// stop asking until it says we can again: anything wanting an answer
+// meanwhile gets ErrBackingOff straight away rather than waiting.
+type GitHubClient struct {
+	client *http.Client
+	now    func() time.Time
+
+	mu      sync.Mutex
+	cached  map[string]etagged   // GET responses by token and URL
+	order   []string             // cached's keys, oldest first
+	blocked map[string]time.Time // Until when we're not asking, by API URL
+}
+
+type etagged struct {
+	etag string
+	body []byte
+}
+
+func NewGitHubClient() *GitHubClient {
+	return &GitHubClient{
+		client:  &http.Client{Timeout: 30 * time.Second},
+		now:     time.Now,
+		cached:  make(map[string]etagged),
+		blocked: make(map[string]time.Time),
+	}
+}
+
+// The most GET responses we keep for conditional requests, how long we back
+// off when GitHub wants us to without saying for how long, and the longest
+// we'll believe it if it does.
+const (
+	maxGitHubCached  = 500
+	githubBackoff    = time.Minute
+	maxGitHubBackoff = time.Hour
+)
+
+// What we say instead of asking GitHub while we're waiting out its rate limit.
+var ErrBackingOff = errors.New("backing off from GitHub's rate limit")
+
+// Whether err means GitHub won't answer us for a while, so whatever wanted
+// it is unavailable for now.
+func Unavailable(err error) bool {
+	var status *StatusError
+	return errors.Is(err, ErrBackingOff) || (errors.As(err, &status) && status.rateLimited)
+}
+
+// GET path, decoding the JSON into v.
+func (c *GitHubClient) Get(ctx context.Context, apiURL, token, path string, v interface{}) error {
+	return c.do(ctx, http.MethodGet, apiURL, token, path, nil, v)
+}
+
+// POST body as JSON to path, decoding the JSON we get back into v.
+func (c *GitHubClient) Post(ctx context.Context, apiURL, token, path string, body, v interface{}) error {
+	return c.do(ctx, http.MethodPost, apiURL, token, path, body, v)
+}
+
+// PATCH path with body as JSON, decoding the JSON we get back into v.
+func (c *GitHubClient) Patch(ctx context.Context, apiURL, token, path string, body, v interface{}) error {
+	return c.do(ctx, http.MethodPatch, apiURL, token, path, body, v)
+}
+
+func (c *GitHubClient) do(ctx context.Context, method, apiURL, token, path string, body, v interface{}) error {
+	apiURL = strings.TrimSuffix(apiURL, "/")
+	c.mu.Lock()
+	until := c.blocked[apiURL]
+	c.mu.Unlock()
+	if c.now().Before(until) {
+		metrics.GitHubRequests.WithLabelValues("backing_off").Inc()
+		return ErrBackingOff
+	}
+
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, apiURL+path, r)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	key := token + " " + apiURL + path
+	var last etagged
+	if method == http.MethodGet {
+		c.mu.Lock()
+		last = c.cached[key]
+		c.mu.Unlock()
+		if last.etag != "" {
+			req.Header.Set("If-None-Match", last.etag)
+		}
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		metrics.GitHubRequests.WithLabelValues("error").Inc()
+		return err
+	}
+	defer resp.Body.Close()
+	c.noteLimits(apiURL, resp)
+	if resp.StatusCode == http.StatusNotModified && last.etag != "" {
+		metrics.GitHubRequests.WithLabelValues("not_modified").Inc()
+		return json.Unmarshal(last.body, v)
+	}
+	if resp.StatusCode/100 != 2 {
+		e := &StatusError{
+			Code:   resp.StatusCode,
+			status: resp.Status,
+			rateLimited: resp.StatusCode == http.StatusTooManyRequests || resp.Header.Get("X-RateLimit-Remaining") == "0" ||
+				(resp.StatusCode == http.StatusForbidden && resp.Header.Get("Retry-After") != ""),
+		}
+		var explained struct{ Message string }
+		if b, err := ioutil.ReadAll(io.LimitReader(resp.Body, 64*1024)); err == nil && json.Unmarshal(b, &explained) == nil {
+			e.message = explained.Message
+		}
+		if e.rateLimited {
+			metrics.GitHubRequests.WithLabelValues("rate_limited").Inc()
+		} else {
+			metrics.GitHubRequests.WithLabelValues("error").Inc()
+		}
+		return e
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		metrics.GitHubRequests.WithLabelValues("error").Inc()
+		return err
+	}
+	metrics.GitHubRequests.WithLabelValues("ok").Inc()
+	if etag := resp.Header.Get("ETag"); method == http.MethodGet && etag != "" {
+		c.remember(key, etagged{etag, data})
+	}
+	return json.Unmarshal(data, v)
+}
+
+// Keep track of how many requests GitHub says we've got left, and stop asking
+// apiURL for a while if that's none, or it's told us to wait.
+func (c *GitHubClient) noteLimits(apiURL string, resp *http.Response) {
+	now := c.now()
+	var until time.Time
+	if remaining, err := strconv.Atoi(resp.Header.Get("X-RateLimit-Remaining")); err == nil {
+		metrics.GitHubRateRemaining.Set(float64(remaining))
+		if remaining == 0 {
+			until = now.Add(githubBackoff)
+			if reset, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64); err == nil && time.Unix(reset, 0).After(now) {
+				until = time.Unix(reset, 0) // Otherwise one of our clocks is off
+			}
+		}
+	}
+	if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+		until = now.Add(time.Duration(secs) * time.Second)
+	} else if resp.StatusCode == http.StatusTooManyRequests && until.IsZero() {
+		until = now.Add(githubBackoff)
+	}
+	if !until.After(now) {
+		return
+	}
+	if until.Sub(now) > maxGitHubBackoff {
+		until = now.Add(maxGitHubBackoff)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if until.After(c.blocked[apiURL]) {
+		c.blocked[apiURL] = until
+	}
+}
+
+// Keep a GET response to ask about next time, forgetting the oldest if we've
+// too many.
+func (c *GitHubClient) remember(key string, e etagged) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.cached[key]; !ok {
+		c.order = append(c.order, key)
+	}
+	c.cached[key] = e
+	for len(c.order) > maxGitHubCached {
+		delete(c.cached, c.order[0])
+		c.order = c.order[1:]
+	}
+}
+
+// GitHub answered, but not with what we asked for.
+type StatusError struct {
+	Code        int
+	status      string
+	message     string // What GitHub said was wrong, if it did
+	rateLimited bool   // Out of requests for now, rather than not allowed
+}
+
+func (e *StatusError) Error() string {
+	if e.message != "" {
+		return "GitHub said " + e.status + ": " + e.message
+	}
+	return "GitHub said " + e.status
+}
+
+// How long we'll wait on GitHub for an answer.
+const LookupTimeout = 10 * time.Second
@@ -0,0 +1,101 @@
+package github
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/metrics"
+)
+
+func TestGitHubClient(t *testing.T) {
+	var asked, conditional int
+	var remaining, retryAfter string
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		asked++
+		if r.Header.Get("Authorization") != "Bearer tok" {
+			t.Errorf("expected the token, got %q", r.Header.Get("Authorization"))
+		}
+		w.Header().Set("X-RateLimit-Remaining", remaining)
+		w.Header().Set("X-RateLimit-Reset", "0")
+		if retryAfter != "" {
+			w.Header().Set("Retry-After", retryAfter)
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"message": "You have exceeded a secondary rate limit"}`))
+			return
+		}
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			conditional++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte(`{"login": "x1tot", "name": "Joe Bloggs"}`))
+	}))
+	defer api.Close()
+	c := NewGitHubClient()
+	now := time.Now()
+	c.now = func() time.Time { return now }
+	get := func() (User, error) {
+		var u User
+		err := c.Get(context.Background(), api.URL+"/", "tok", "/users/x1tot", &u)
+		return u, err
+	}
+
+	remaining = "4999"
+	for i := 0; i < 2; i++ {
+		if u, err := get(); err != nil || u.Name != "Joe Bloggs" {
+			t.Fatalf("expected the user, got %+v, %v", u, err)
+		}
+	}
+	if conditional != 1 {
+		t.Errorf("expected the second ask to be conditional, got %d", conditional)
+	}
+	if got := testutil.ToFloat64(metrics.GitHubRateRemaining); got != 4999 {
+		t.Errorf("expected 4999 requests left noted, got %v", got)
+	}
+
+	// The last request we had, with a reset GitHub's clock puts in the past.
+	remaining = "0"
+	if _, err := get(); err != nil {
+		t.Fatal(err)
+	}
+	before := asked
+	backingOff := testutil.ToFloat64(metrics.GitHubRequests.WithLabelValues("backing_off"))
+	if _, err := get(); err != ErrBackingOff || !Unavailable(err) {
+		t.Errorf("expected to back off when out of requests, got %v", err)
+	}
+	if asked != before || testutil.ToFloat64(metrics.GitHubRequests.WithLabelValues("backing_off")) != backingOff+1 {
+		t.Errorf("expected GitHub not asked while backing off, got %d more asks", asked-before)
+	}
+	now = now.Add(githubBackoff)
+	remaining, retryAfter = "4000", "30"
+	_, err := get()
+	if !Unavailable(err) || err.Error() != "GitHub said 403 Forbidden: You have exceeded a secondary rate limit" {
+		t.Errorf("expected a secondary rate limit, got %v", err)
+	}
+	now = now.Add(29 * time.Second)
+	if _, err := get(); err != ErrBackingOff {
+		t.Errorf("expected to wait as long as GitHub said, got %v", err)
+	}
+	now = now.Add(time.Second)
+	retryAfter = ""
+	if _, err := get(); err != nil {
+		t.Errorf("expected to ask again once we'd waited, got %v", err)
+	}
+}
+
+func TestGitHubClientCache(t *testing.T) {
+	c := NewGitHubClient()
+	for i := 0; i < maxGitHubCached+1; i++ {
+		c.remember(strconv.Itoa(i), etagged{etag: "e"})
+	}
+	if _, ok := c.cached["0"]; ok || len(c.cached) != maxGitHubCached || len(c.order) != maxGitHubCached {
+		t.Errorf("expected the oldest forgotten, keeping %d, got %d", maxGitHubCached, len(c.cached))
+	}
+}
@@ -0,0 +1,53 @@
+// Package githubtest fakes GitHub's API for tests. It's apart from
+// testutil as it needs github's errors, and github's own tests use testutil.
+package githubtest
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/github"
+)
+
+// A github.GitHubAPI that answers from Answers, JSON by path, without going near
+// the network. Anything else is a 404, unless Err's set, when that's what
+// everything gets.
+type FakeAPI struct {
+	Answers map[string]string
+	Err     error
+
+	mu     sync.Mutex
+	Asked  []string      // Like "GET /repos/ury/website"
+	Posted []interface{} // Bodies of the POSTs and PATCHes
+}
+
+func (f *FakeAPI) Get(ctx context.Context, apiURL, token, path string, v interface{}) error {
+	return f.answer(http.MethodGet, path, nil, v)
+}
+
+func (f *FakeAPI) Post(ctx context.Context, apiURL, token, path string, body, v interface{}) error {
+	return f.answer(http.MethodPost, path, body, v)
+}
+
+func (f *FakeAPI) Patch(ctx context.Context, apiURL, token, path string, body, v interface{}) error {
+	return f.answer(http.MethodPatch, path, body, v)
+}
+
+func (f *FakeAPI) answer(method, path string, body, v interface{}) error {
+	f.mu.Lock()
+	f.Asked = append(f.Asked, method+" "+path)
+	if body != nil {
+		f.Posted = append(f.Posted, body)
+	}
+	f.mu.Unlock()
+	if f.Err != nil {
+		return f.Err
+	}
+	answer, ok := f.Answers[path]
+	if !ok {
+		return &github.StatusError{Code: http.StatusNotFound}
+	}
+	return json.Unmarshal([]byte(answer), v)
+}
@@ -0,0 +1,8 @@
+package github
+
+import (
+	"io"
+	"log/slog"
+)
+
+var discardLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
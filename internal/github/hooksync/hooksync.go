@@ -0,0 +1,187 @@
+// Package hooksync makes sure each repo has our webhook set up on GitHub.
+package hooksync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/config"
+	"github.com/UniversityRadioYork/CaptainHook/internal/github"
+)
+
+// With ManageHooks, every repo of HookOrgs' that RepoAllow and RepoDeny let
+// through gets a webhook sending HookEvents to PublicURL, signed with
+// GHSecret, as setting it up by hand is the step that gets forgotten. It's
+// done at startup and daily after, or once with -sync-hooks. Hooks pointing
+// at PublicURL are ours, and are put right if their events have drifted;
+// anyone else's are left alone, and we never delete any. GitHub doesn't
+// show us secrets, so a hook with an old GHSecret isn't noticed.
+type hookSync struct {
+	Created, Updated, Unchanged, Failed int
+}
+
+// How often hooks are synced after startup, how long each go gets, and the
+// most pages of an org's repos we'll go through.
+const (
+	hookSyncInterval = 24 * time.Hour
+	HookSyncTimeout  = 10 * time.Minute
+	maxRepoPages     = 20
+)
+
+// A repo's webhook, as much of it as we care about.
+type repoHook struct {
+	ID     int64    `json:"id"`
+	Active bool     `json:"active"`
+	Events []string `json:"events"`
+	Config struct {
+		URL         string `json:"url"`
+		ContentType string `json:"content_type"`
+	} `json:"config"`
+}
+
+// Sync the hooks now, and every hookSyncInterval after, with whatever the
+// config is by then, until ctx is done.
+func RunHookSync(ctx context.Context, api github.GitHubAPI, logger *slog.Logger) {
+	for {
+		if conf := config.CurrentConfig(); conf.ManageHooks {
+			syncCtx, cancel := context.WithTimeout(ctx, HookSyncTimeout)
+			if _, err := SyncHooks(syncCtx, api, conf, conf.ManageHooksDryRun, logger); err != nil {
+				logger.Error("Couldn't sync webhooks", "err", err)
+			}
+			cancel()
+		}
+		select {
+		case <-time.After(hookSyncInterval):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Make sure each of HookOrgs' repos that we announce has our hook, or with
+// dryRun just say what we'd change. Repos we couldn't do count as Failed;
+// an error means we didn't get as far as all of them.
+func SyncHooks(ctx context.Context, api github.GitHubAPI, conf *config.Config, dryRun bool, logger *slog.Logger) (hookSync, error) {
+	var sum hookSync
+	if conf.GitHubToken == "" || conf.PublicURL == "" || len(conf.HookOrgs) == 0 {
+		return sum, errors.New("syncing webhooks needs GitHubToken, PublicURL and HookOrgs")
+	}
+	var err error
+	for _, org := range conf.HookOrgs {
+		var repos []string
+		if repos, err = orgRepos(ctx, api, conf, org); err != nil {
+			err = fmt.Errorf("listing %s's repos: %v", org, err)
+			break
+		}
+		for _, repo := range repos {
+			if !config.MatchRepo(repo, conf.RepoAllow, conf.RepoDeny) {
+				continue
+			}
+			var changed string
+			if changed, err = syncRepoHook(ctx, api, conf, repo, dryRun); err != nil {
+				sum.Failed++
+				if github.Unavailable(err) || ctx.Err() != nil {
+					break
+				}
+				logger.Warn("Couldn't sync a repo's webhook", "repo", repo, "err", err)
+				err = nil
+				continue
+			}
+			switch changed {
+			case "created":
+				sum.Created++
+			case "updated":
+				sum.Updated++
+			default:
+				sum.Unchanged++
+				continue
+			}
+			logger.Info("Webhook "+changed, "repo", repo, "url", conf.PublicURL, "dry_run", dryRun)
+		}
+		if err != nil {
+			break
+		}
+	}
+	logger.Info("Synced webhooks", "created", sum.Created, "updated", sum.Updated, "unchanged", sum.Unchanged, "failed", sum.Failed, "dry_run", dryRun)
+	return sum, err
+}
+
+// The owner/names of org's repos that aren't archived. org can be a user.
+func orgRepos(ctx context.Context, api github.GitHubAPI, conf *config.Config, org string) ([]string, error) {
+	kind := "orgs"
+	var names []string
+	for page := 1; page <= maxRepoPages; page++ {
+		var repos []struct {
+			FullName string `json:"full_name"`
+			Archived bool   `json:"archived"`
+		}
+		err := api.Get(ctx, conf.GitHubAPIURL, conf.GitHubToken, fmt.Sprintf("/%s/%s/repos?per_page=100&page=%d", kind, url.PathEscape(org), page), &repos)
+		var status *github.StatusError
+		if errors.As(err, &status) && status.Code == http.StatusNotFound && kind == "orgs" && page == 1 {
+			kind, page = "users", 0
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range repos {
+			if !r.Archived {
+				names = append(names, r.FullName)
+			}
+		}
+		if len(repos) < 100 {
+			break
+		}
+	}
+	return names, nil
+}
+
+// Make sure repo has our hook, creating or fixing it unless dryRun, and
+// say which we did: "created", "updated" or "" if it was fine already.
+func syncRepoHook(ctx context.Context, api github.GitHubAPI, conf *config.Config, repo string, dryRun bool) (string, error) {
+	var hooks []repoHook
+	if err := api.Get(ctx, conf.GitHubAPIURL, conf.GitHubToken, "/repos/"+repo+"/hooks?per_page=100", &hooks); err != nil {
+		return "", err
+	}
+	events := conf.EventsToSend()
+	var secret string
+	if secrets := conf.DefaultHook().Secrets; len(secrets) > 0 {
+		secret = secrets[0]
+	}
+	want := map[string]interface{}{
+		"active": true,
+		"events": events,
+		"config": map[string]string{
+			"url":          conf.PublicURL,
+			"content_type": "json",
+			"secret":       secret,
+			"insecure_ssl": "0",
+		},
+	}
+	for _, h := range hooks {
+		if strings.TrimSuffix(h.Config.URL, "/") != strings.TrimSuffix(conf.PublicURL, "/") {
+			continue
+		}
+		have := append([]string(nil), h.Events...)
+		sort.Strings(have)
+		if h.Active && h.Config.ContentType == "json" && strings.Join(have, ",") == strings.Join(events, ",") {
+			return "", nil
+		}
+		if dryRun {
+			return "updated", nil
+		}
+		return "updated", api.Patch(ctx, conf.GitHubAPIURL, conf.GitHubToken, fmt.Sprintf("/repos/%s/hooks/%d", repo, h.ID), want, &repoHook{})
+	}
+	if dryRun {
+		return "created", nil
+	}
+	want["name"] = "web"
+	return "created", api.Post(ctx, conf.GitHubAPIURL, conf.GitHubToken, "/repos/"+repo+"/hooks", want, &repoHook{})
+}
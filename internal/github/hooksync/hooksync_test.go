@@ -0,0 +1,78 @@
+package hooksync
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/config"
+	"github.com/UniversityRadioYork/CaptainHook/internal/config/configtest"
+	"github.com/UniversityRadioYork/CaptainHook/internal/github"
+	"github.com/UniversityRadioYork/CaptainHook/internal/github/githubtest"
+)
+
+func TestSyncHooks(t *testing.T) {
+	c := configtest.Valid()
+	c.GitHubToken, c.PublicURL, c.ManageHooks = "tok", "https://hooks.ury.org.uk/", true
+	c.HookOrgs = []string{"ury", "x1tot"}
+	c.HookEvents = []string{"push", "issues"}
+	c.RepoDeny = []string{"ury/sandbox-*"}
+	if errs := c.Validate(); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	api := &githubtest.FakeAPI{Answers: map[string]string{
+		"/orgs/ury/repos?per_page=100&page=1": `[{"full_name": "ury/website"}, {"full_name": "ury/myradio"}, {"full_name": "ury/jukebox"},
+			{"full_name": "ury/old", "archived": true}, {"full_name": "ury/sandbox-1"}]`,
+		"/users/x1tot/repos?per_page=100&page=1": `[{"full_name": "x1tot/dotfiles"}]`,
+		"/repos/ury/website/hooks?per_page=100": `[{"id": 1, "active": true, "events": ["*"], "config": {"url": "https://ci.ury.org.uk/hook", "content_type": "form"}},
+			{"id": 2, "active": true, "events": ["push", "issues"], "config": {"url": "https://hooks.ury.org.uk", "content_type": "json"}}]`,
+		"/repos/ury/myradio/hooks?per_page=100":    `[{"id": 3, "active": false, "events": ["push"], "config": {"url": "https://hooks.ury.org.uk/", "content_type": "json"}}]`,
+		"/repos/ury/jukebox/hooks?per_page=100":    `[]`,
+		"/repos/x1tot/dotfiles/hooks?per_page=100": `[]`,
+		"/repos/ury/myradio/hooks/3":               `{}`,
+		"/repos/ury/jukebox/hooks":                 `{}`,
+		"/repos/x1tot/dotfiles/hooks":              `{}`,
+	}}
+	sync := func(dryRun bool) hookSync {
+		api.Asked, api.Posted = nil, nil
+		sum, err := SyncHooks(context.Background(), api, c, dryRun, config.DiscardLogger)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sum
+	}
+	changes := func() (changed []string) {
+		for _, a := range api.Asked {
+			if !strings.HasPrefix(a, "GET ") {
+				changed = append(changed, a)
+			}
+		}
+		return changed
+	}
+
+	want := hookSync{Created: 2, Updated: 1, Unchanged: 1}
+	if got := sync(true); got != want || len(changes()) > 0 {
+		t.Errorf("expected a dry run to change nothing and say %+v, got %+v and %v", want, got, changes())
+	}
+	if got := sync(false); got != want {
+		t.Errorf("expected %+v, got %+v", want, got)
+	}
+	if got := strings.Join(changes(), ", "); got != "PATCH /repos/ury/myradio/hooks/3, POST /repos/ury/jukebox/hooks, POST /repos/x1tot/dotfiles/hooks" {
+		t.Errorf("expected only our hooks fixed or made, got %s", got)
+	}
+	created := api.Posted[1].(map[string]interface{})
+	hookConf := created["config"].(map[string]string)
+	if created["name"] != "web" || strings.Join(created["events"].([]string), ",") != "issues,push" ||
+		hookConf["url"] != c.PublicURL || hookConf["secret"] != c.GHSecret || hookConf["content_type"] != "json" {
+		t.Errorf("expected a hook sending our events to PublicURL, got %v", created)
+	}
+
+	api.Err = github.ErrBackingOff
+	if _, err := SyncHooks(context.Background(), api, c, false, config.DiscardLogger); err == nil {
+		t.Error("expected an error while GitHub's rate limiting us")
+	}
+	c.PublicURL = ""
+	if _, err := SyncHooks(context.Background(), api, c, false, config.DiscardLogger); err == nil {
+		t.Error("expected to need a PublicURL")
+	}
+}
@@ -0,0 +1,103 @@
+package github
+
+import (
+	"context"
+	"log/slog"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How senders read in announcements, by NameStyle.
+const (
+	NameStyleLogin = "login" // Just x1tot, as GitHub gives us
+	NameStyleName  = "name"  // Joe Bloggs
+	NameStyleBoth  = "both"
+)
+
+// Remembers people's names by GitHub login, looking them up with the API in
+// the background. Announcing never waits on it: someone we don't know yet
+// is announced by login, and named from then on. People who haven't set a
+// name, and lookups that failed, are remembered too, so we don't keep asking.
+// Like the shortener cache it starts empty again when the config's reloaded.
+type NameCache struct {
+	APIURL string
+	Token  string
+	ttl    time.Duration
+	now    func() time.Time
+	api    GitHubAPI
+
+	mu       sync.Mutex
+	names    map[string]cachedName
+	Fetching sync.WaitGroup // Lookups in progress, for tests
+}
+
+type cachedName struct {
+	name    string // "" for no name
+	fetched time.Time
+	pending bool // Being looked up right now
+}
+
+func NewNameCache(api GitHubAPI, apiURL, token string, ttl time.Duration) *NameCache {
+	return &NameCache{
+		APIURL: strings.TrimSuffix(apiURL, "/"),
+		Token:  token,
+		ttl:    ttl,
+		now:    time.Now,
+		api:    api,
+		names:  make(map[string]cachedName),
+	}
+}
+
+// login's name if we know it, otherwise "" and we go and find out.
+func (c *NameCache) Name(login string, logger *slog.Logger) string {
+	if c == nil || login == "" {
+		return ""
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n, ok := c.names[login]
+	if ok && (n.pending || c.now().Sub(n.fetched) < c.ttl) {
+		return n.name
+	}
+	c.names[login] = cachedName{name: n.name, fetched: n.fetched, pending: true} // An old name's better than none meanwhile
+	c.Fetching.Add(1)
+	go func() {
+		defer c.Fetching.Done()
+		name, err := c.lookup(login)
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if Unavailable(err) {
+			c.names[login] = cachedName{name: n.name, fetched: n.fetched} // Ask again next time, not in NameCacheTTL
+			return
+		}
+		if err != nil {
+			logger.Warn("Couldn't look up GitHub user's name", "login", login, "err", err)
+		}
+		c.names[login] = cachedName{name: name, fetched: c.now()}
+	}()
+	return n.name
+}
+
+// Ask GitHub for login's name.
+func (c *NameCache) lookup(login string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), LookupTimeout)
+	defer cancel()
+	var user User
+	if err := c.api.Get(ctx, c.APIURL, c.Token, "/users/"+url.PathEscape(login), &user); err != nil {
+		return "", err
+	}
+	return CleanName(user.Name), nil
+}
+
+// A name as we'll show it: one line, without anything that'd upset IRC.
+func CleanName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if r < ' ' || r == 0x7f {
+			return ' '
+		}
+		return r
+	}, name)
+	return strings.Join(strings.Fields(name), " ")
+}
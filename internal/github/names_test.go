@@ -0,0 +1,44 @@
+package github
+
+import (
+	"testing"
+	"time"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/testutil"
+)
+
+func TestNameCache(t *testing.T) {
+	var asked int32
+	api := testutil.FakeUsersAPI(t, &asked)
+	defer api.Close()
+	c := NewNameCache(NewGitHubClient(), api.URL+"/", "tok", time.Hour)
+	now := time.Now()
+	c.now = func() time.Time { return now }
+
+	if got := c.Name("x1tot", discardLogger); got != "" {
+		t.Errorf("expected nothing before it's looked up, got %q", got)
+	}
+	c.Fetching.Wait()
+	for _, login := range []string{"x1tot", "noname", "ghost"} {
+		c.Name(login, discardLogger)
+	}
+	c.Fetching.Wait()
+	for login, want := range map[string]string{"x1tot": "Joe Bloggs", "noname": "", "ghost": ""} {
+		if got := c.Name(login, discardLogger); got != want {
+			t.Errorf("%s: expected %q, got %q", login, want, got)
+		}
+	}
+	c.Fetching.Wait()
+	if asked != 3 {
+		t.Errorf("expected each login looked up once, got %d lookups", asked)
+	}
+
+	now = now.Add(2 * time.Hour)
+	if got := c.Name("x1tot", discardLogger); got != "Joe Bloggs" {
+		t.Errorf("expected the old name while it's looked up again, got %q", got)
+	}
+	c.Fetching.Wait()
+	if asked != 4 {
+		t.Errorf("expected an expired name looked up again, got %d lookups", asked)
+	}
+}
@@ -0,0 +1,83 @@
+package hookserver
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+)
+
+type contextKey int
+
+const clientIPKey contextKey = iota
+
+// The client address worked out by AccessLog, or the socket peer for requests
+// that didn't come through it.
+func requestIP(r *http.Request) net.IP {
+	if ip, ok := r.Context().Value(clientIPKey).(net.IP); ok {
+		return ip
+	}
+	return ClientIP(r, nil)
+}
+
+// Records what we sent back, for the access log.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	s.status = code
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	return s.ResponseWriter.Write(b)
+}
+
+// Counts how much of the request body the handler read.
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (c *countingReader) Read(b []byte) (int, error) {
+	n, err := c.ReadCloser.Read(b)
+	c.n += int64(n)
+	return n, err
+}
+
+// Log a line per request saying where it came from, what it was and what we
+// did with it. Also works out the real client address for everything
+// further in, so the log and the handlers agree on who sent it.
+func AccessLog(h http.Handler, trusted []*net.IPNet, logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ip := ClientIP(r, trusted)
+		r = r.WithContext(context.WithValue(r.Context(), clientIPKey, ip))
+		body := &countingReader{ReadCloser: r.Body}
+		r.Body = body
+		rec := &statusRecorder{ResponseWriter: w}
+
+		h.ServeHTTP(rec, r)
+
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		logger.Info("request",
+			"remote", ip.String(),
+			"method", r.Method,
+			"path", r.URL.Path,
+			"delivery", r.Header.Get("X-GitHub-Delivery"),
+			"event", r.Header.Get("X-GitHub-Event"),
+			"status", rec.status,
+			"bytes", body.n,
+			"duration_ms", float64(time.Since(start).Microseconds())/1000,
+		)
+	})
+}
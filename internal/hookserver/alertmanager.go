@@ -0,0 +1,127 @@
+package hookserver
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/config"
+	"github.com/UniversityRadioYork/CaptainHook/internal/format"
+	"github.com/UniversityRadioYork/CaptainHook/internal/metrics"
+)
+
+const SourceAlertmanager = "alertmanager"
+
+// The most labels we'll show for an alert group before giving up.
+const maxAlertLabels = 4
+
+// What Alertmanager's webhook receiver sends us (version 4, partially).
+type AlertmanagerEvent struct {
+	Version      string
+	Status       string
+	GroupLabels  map[string]string
+	CommonLabels map[string]string
+	Alerts       []struct {
+		Status       string
+		Labels       map[string]string
+		GeneratorURL string
+	}
+}
+
+// Turn an Alertmanager notification into one Event for the whole group.
+func ParseAlertmanagerEvent(body []byte) (*format.Event, error) {
+	var event AlertmanagerEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		return nil, err
+	}
+	if len(event.Alerts) == 0 {
+		return nil, nil
+	}
+	labels := event.CommonLabels
+	if len(labels) == 0 {
+		labels = event.GroupLabels
+	}
+	title := labels["alertname"]
+	if title == "" {
+		title = "Alert"
+	}
+	if host := alertHost(labels); host != "" {
+		title += " on " + host
+	}
+	if pairs := alertLabels(labels); pairs != "" {
+		title += " " + pairs
+	}
+	return &format.Event{
+		Source: SourceAlertmanager,
+		Type:   "alert",
+		Action: event.Status,
+		Number: len(event.Alerts),
+		Title:  title,
+		URL:    event.Alerts[0].GeneratorURL,
+	}, nil
+}
+
+// Where an alert is from: the instance without its port, or failing that the job.
+func alertHost(labels map[string]string) string {
+	instance := labels["instance"]
+	if instance == "" {
+		return labels["job"]
+	}
+	if host, _, err := net.SplitHostPort(instance); err == nil {
+		return host
+	}
+	return instance
+}
+
+// Render labels as "{a=b, c=d}", sorted, leaving out the alert name and
+// stopping after maxAlertLabels.
+func alertLabels(labels map[string]string) string {
+	var keys []string
+	for k := range labels {
+		if k != "alertname" {
+			keys = append(keys, k)
+		}
+	}
+	if len(keys) == 0 {
+		return ""
+	}
+	sort.Strings(keys)
+	var pairs []string
+	for i, k := range keys {
+		if i == maxAlertLabels {
+			pairs = append(pairs, "...")
+			break
+		}
+		pairs = append(pairs, k+"="+labels[k])
+	}
+	return "{" + strings.Join(pairs, ", ") + "}"
+}
+
+// Handles notifications from Alertmanager, which authenticates with HTTP
+// basic auth since that's what it supports out of the box.
+func AlertmanagerHandler(hook *config.Hook, in *Intake, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, _, ok := readBody(w, r, logger)
+		if !ok {
+			return
+		}
+		user, pass, ok := r.BasicAuth()
+		if !ok || !matchToken(user+":"+pass, hook.Secrets) {
+			logger.Warn("Invalid credentials", "hook", hook.Name, "ip", requestIP(r))
+			metrics.DeliveriesTotal.WithLabelValues(metrics.UnverifiedEvent, metrics.OutcomeBadSignature).Inc()
+			w.Header().Set("WWW-Authenticate", `Basic realm="CaptainHook"`)
+			respond(w, http.StatusUnauthorized, "Invalid credentials")
+			return
+		}
+		enqueue(w, r, Delivery{
+			Source:  SourceAlertmanager,
+			Hook:    hook,
+			Event:   "alert",
+			Header:  r.Header.Clone(),
+			Payload: body,
+		}, in, logger)
+	}
+}
@@ -0,0 +1,59 @@
+package hookserver
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/config"
+)
+
+func TestParseAlertmanagerEvent(t *testing.T) {
+
+	body, err := ioutil.ReadFile("testdata/alertmanager/firing.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := ParseAlertmanagerEvent(body)
+	if err != nil || e == nil {
+		t.Fatalf("expected an event, got %v", err)
+	}
+	want := "[\x0306alerts\x0f] \x0304FIRING\x0f (3): HighDiskUsage on studio-pc {instance=studio-pc:9100, job=node, severity=warning} http://prometheus.example.org:9090/graph?g0.expr=disk_used\x0f"
+	if got := config.FormatEvent(e, config.DiscardLogger); got != want {
+		t.Errorf("\nexpected %q\n     got %q", want, got)
+	}
+}
+
+func TestAlertLabelsCapped(t *testing.T) {
+	got := alertLabels(map[string]string{"alertname": "x", "a": "1", "b": "2", "c": "3", "d": "4", "e": "5"})
+	if want := "{a=1, b=2, c=3, d=4, ...}"; got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
+
+func TestAlertmanagerHandlerAuth(t *testing.T) {
+	config.SetConfig(&config.Config{MaxBodyBytes: 1 << 20})
+	hook := &config.Hook{Name: "alertmanager", Secrets: []string{"alertmanager:hunter2"}}
+	for _, c := range []struct {
+		user, pass string
+		want       int
+	}{
+		{"", "", http.StatusUnauthorized},
+		{"alertmanager", "wrong", http.StatusUnauthorized},
+		{"alertmanager", "hunter2", http.StatusAccepted},
+	} {
+		work := make(chan Delivery, 1)
+		req := httptest.NewRequest("POST", "/alertmanager", strings.NewReader(`{"version":"4","alerts":[]}`))
+		req.Header.Set("Content-Type", "application/json")
+		if c.user != "" {
+			req.SetBasicAuth(c.user, c.pass)
+		}
+		rec := httptest.NewRecorder()
+		AlertmanagerHandler(hook, &Intake{Work: work}, config.DiscardLogger)(rec, req)
+		if rec.Code != c.want {
+			t.Errorf("%s:%s: expected %d, got %d", c.user, c.pass, c.want, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,242 @@
+package hookserver
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/config"
+)
+
+// What we keep alongside each archived payload, in <name>.headers.json.
+type ArchiveEntry struct {
+	Name     string      `json:"-"` // The payload's file name, without .json
+	Source   string      `json:"source"`
+	Hook     string      `json:"hook"`
+	Event    string      `json:"event"`
+	ID       string      `json:"id"`
+	Repo     string      `json:"repo,omitempty"`
+	Received time.Time   `json:"received"`
+	Header   http.Header `json:"header"`
+}
+
+type archivedDelivery struct {
+	Entry   ArchiveEntry
+	Payload []byte
+}
+
+// Keeps a copy of each verified delivery in a directory, so we've still got
+// the payload when we notice a formatting bug. Writes happen in the
+// background, and the oldest files are pruned to keep within the limits.
+type Archive struct {
+	dir     string
+	maxSize int64
+	maxAge  time.Duration
+	logger  *slog.Logger
+
+	mu     sync.Mutex // Held sending on writes, so it's never sent on once closed
+	closed bool
+	writes chan archivedDelivery
+	done   chan struct{}
+}
+
+var ErrNotArchived = errors.New("delivery not found in archive")
+
+// Set up an archive in dir, creating it if need be.
+func NewArchive(dir string, maxSize int64, maxAge time.Duration, logger *slog.Logger) (*Archive, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	a := &Archive{
+		dir:     dir,
+		maxSize: maxSize,
+		maxAge:  maxAge,
+		logger:  logger,
+		writes:  make(chan archivedDelivery, 64),
+		done:    make(chan struct{}),
+	}
+	go a.run()
+	return a, nil
+}
+
+// Queue a delivery for archiving, never blocking. Safe to call on a nil
+// Archive, and dropped once it's been closed.
+func (a *Archive) Save(d Delivery) {
+	if a == nil {
+		return
+	}
+	hook := ""
+	if d.Hook != nil {
+		hook = d.Hook.Name
+	}
+	entry := ArchiveEntry{
+		Source:   d.Source,
+		Hook:     hook,
+		Event:    d.Event,
+		ID:       d.ID,
+		Repo:     config.PayloadRepo(d.Payload),
+		Received: time.Now().UTC(),
+		Header:   d.Header,
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.closed {
+		a.logger.Debug("Archive closed, not archiving", "event", d.Event, "delivery", d.ID)
+		return
+	}
+	select {
+	case a.writes <- archivedDelivery{Entry: entry, Payload: d.Payload}:
+	default:
+		a.logger.Warn("Archive writer backed up, not archiving", "event", d.Event, "delivery", d.ID)
+	}
+}
+
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9_.-]+`)
+
+func (a *Archive) run() {
+	defer close(a.done)
+	a.prune()
+	prune := time.NewTicker(time.Hour)
+	defer prune.Stop()
+	for {
+		select {
+		case d, ok := <-a.writes:
+			if !ok {
+				return
+			}
+			if err := a.write(d); err != nil {
+				a.logger.Error("Error archiving delivery", "delivery", d.Entry.ID, "err", err)
+			}
+		case <-prune.C:
+			a.prune()
+		}
+	}
+}
+
+func (a *Archive) write(d archivedDelivery) error {
+	id := d.Entry.ID
+	if id == "" {
+		id = "none"
+	}
+	name := strings.Join([]string{
+		d.Entry.Received.Format("20060102T150405.000000000Z"),
+		unsafeFileChars.ReplaceAllString(d.Entry.Event, "_"),
+		unsafeFileChars.ReplaceAllString(id, "_"),
+	}, "-")
+	sidecar, err := json.MarshalIndent(d.Entry, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(a.dir, name+".json"), d.Payload, 0600); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(a.dir, name+".headers.json"), sidecar, 0600)
+}
+
+// Everything in the archive matching repo and event (either can be empty to
+// match anything), oldest first.
+func (a *Archive) List(repo, event string) ([]ArchiveEntry, error) {
+	names, err := filepath.Glob(filepath.Join(a.dir, "*.headers.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	var entries []ArchiveEntry
+	for _, n := range names {
+		b, err := ioutil.ReadFile(n)
+		if err != nil {
+			continue // Pruned under our feet
+		}
+		var e ArchiveEntry
+		if err := json.Unmarshal(b, &e); err != nil {
+			continue
+		}
+		if (repo != "" && e.Repo != repo) || (event != "" && e.Event != event) {
+			continue
+		}
+		e.Name = strings.TrimSuffix(filepath.Base(n), ".headers.json")
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// Fetch an archived delivery back by its ID.
+func (a *Archive) Find(id string) (ArchiveEntry, []byte, error) {
+	entries, err := a.List("", "")
+	if err != nil {
+		return ArchiveEntry{}, nil, err
+	}
+	for i := len(entries) - 1; i >= 0; i-- {
+		if entries[i].ID == id {
+			payload, err := ioutil.ReadFile(filepath.Join(a.dir, entries[i].Name+".json"))
+			return entries[i], payload, err
+		}
+	}
+	return ArchiveEntry{}, nil, ErrNotArchived
+}
+
+// Delete payloads past maxAge, then the oldest until we're within maxSize.
+// Either limit can be zero to turn it off.
+func (a *Archive) prune() {
+	names, err := filepath.Glob(filepath.Join(a.dir, "*.headers.json"))
+	if err != nil {
+		a.logger.Error("Error pruning archive", "err", err)
+		return
+	}
+	sort.Strings(names) // Timestamps first, so oldest first
+	type file struct {
+		base string
+		size int64
+		mod  time.Time
+	}
+	var files []file
+	var total int64
+	for _, n := range names {
+		base := strings.TrimSuffix(n, ".headers.json")
+		f := file{base: base}
+		for _, p := range []string{base + ".json", n} {
+			if fi, err := os.Stat(p); err == nil {
+				f.size += fi.Size()
+				f.mod = fi.ModTime()
+			}
+		}
+		files = append(files, f)
+		total += f.size
+	}
+	cutoff := time.Now().Add(-a.maxAge)
+	for _, f := range files {
+		if (a.maxAge <= 0 || f.mod.After(cutoff)) && (a.maxSize <= 0 || total <= a.maxSize) {
+			break
+		}
+		os.Remove(f.base + ".json")
+		os.Remove(f.base + ".headers.json")
+		total -= f.size
+	}
+}
+
+// Finish writing anything queued. Anything Saved after this is dropped.
+func (a *Archive) Close() {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	if a.closed {
+		a.mu.Unlock()
+		<-a.done
+		return
+	}
+	a.closed = true
+	close(a.writes)
+	a.mu.Unlock()
+	<-a.done
+	a.prune()
+}
@@ -0,0 +1,96 @@
+package hookserver
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/config"
+	"github.com/UniversityRadioYork/CaptainHook/internal/format"
+)
+
+func TestArchiveSaveAndFind(t *testing.T) {
+	dir, err := ioutil.TempDir("", "archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	a, err := NewArchive(dir, 0, time.Hour, config.DiscardLogger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload := []byte(`{"repository":{"full_name":"UniversityRadioYork/website"}}`)
+	a.Save(Delivery{Source: format.SourceGitHub, Event: "issues", ID: "guid/1", Header: http.Header{"X-Github-Event": {"issues"}}, Payload: payload})
+	a.Save(Delivery{Source: format.SourceGitHub, Event: "push", ID: "guid-2", Payload: []byte(`{}`)})
+	a.Close()
+
+	entries, err := a.List("UniversityRadioYork/website", "")
+	if err != nil || len(entries) != 1 || entries[0].Event != "issues" {
+		t.Fatalf("expected the issues delivery, got %v %v", entries, err)
+	}
+	entry, got, err := a.Find("guid/1")
+	if err != nil || string(got) != string(payload) || entry.Header.Get("X-Github-Event") != "issues" {
+		t.Errorf("expected the archived payload back, got %q %v", got, err)
+	}
+	if _, _, err := a.Find("guid-3"); err != ErrNotArchived {
+		t.Errorf("expected ErrNotArchived, got %v", err)
+	}
+}
+
+// Deliveries still being taken as we shut down are dropped rather than
+// panicking, and closing twice is fine.
+func TestArchiveSaveAfterClose(t *testing.T) {
+	dir, err := ioutil.TempDir("", "archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	a, err := NewArchive(dir, 0, time.Hour, config.DiscardLogger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.Close()
+	a.Save(Delivery{Source: format.SourceGitHub, Event: "push", ID: "guid-1", Payload: []byte(`{}`)})
+	a.Close()
+	if entries, err := a.List("", ""); err != nil || len(entries) != 0 {
+		t.Errorf("expected nothing archived, got %v %v", entries, err)
+	}
+}
+
+func TestArchivePrune(t *testing.T) {
+	dir, err := ioutil.TempDir("", "archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	a := &Archive{dir: dir, maxSize: 1 << 20, maxAge: time.Hour, logger: config.DiscardLogger}
+	old := time.Now().Add(-2 * time.Hour)
+	for _, d := range []archivedDelivery{
+		{Entry: ArchiveEntry{Event: "push", ID: "old", Received: old}, Payload: []byte(`{}`)},
+		{Entry: ArchiveEntry{Event: "push", ID: "new", Received: time.Now()}, Payload: []byte(`{}`)},
+	} {
+		if err := a.write(d); err != nil {
+			t.Fatal(err)
+		}
+	}
+	oldFiles, _ := filepath.Glob(filepath.Join(dir, "*-old.*"))
+	for _, f := range oldFiles {
+		os.Chtimes(f, old, old)
+	}
+	a.prune()
+	if _, _, err := a.Find("old"); err != ErrNotArchived {
+		t.Errorf("expected the old delivery to be pruned")
+	}
+	if _, _, err := a.Find("new"); err != nil {
+		t.Errorf("expected the new delivery to survive, got %v", err)
+	}
+
+	a.maxSize = 1
+	a.prune()
+	if entries, _ := a.List("", ""); len(entries) != 0 {
+		t.Errorf("expected everything pruned to get under maxSize, got %v", entries)
+	}
+}
@@ -0,0 +1,22 @@
+package hookserver
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/ircbot"
+)
+
+// Serves GatherCoverage as JSON.
+func CoverageHandler(u *ircbot.UnknownEvents) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			respond(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		json.NewEncoder(w).Encode(ircbot.GatherCoverage(u))
+	}
+}
@@ -0,0 +1,60 @@
+package hookserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/config"
+	"github.com/UniversityRadioYork/CaptainHook/internal/github"
+	"github.com/UniversityRadioYork/CaptainHook/internal/ircbot"
+)
+
+func TestCoverage(t *testing.T) {
+	u := ircbot.NewUnknownEvents()
+	now := time.Date(2024, 3, 4, 12, 0, 0, 0, time.UTC)
+	u.Now = func() time.Time { return now }
+	conf := &config.Config{}
+	for _, d := range []Delivery{
+		{Event: "discussion", Payload: []byte(`{"action":"created"}`)},
+		{Event: "issues", Payload: []byte(`{"action":"typed"}`)},
+		{Event: "discussion", Payload: []byte(`{"action":"created"}`)},
+		{Event: "star", Payload: []byte(`{}`)},
+	} {
+		e, _ := github.ParseGitHubEvent(d.Event, d.Payload)
+		if action, unknown := ircbot.UnknownGitHubEvent(d.Event, e, d.Payload); unknown {
+			u.Seen(conf, d.Event, action, d.ID, d.Payload, config.DiscardLogger)
+		}
+		now = now.Add(time.Hour)
+	}
+
+	rec := httptest.NewRecorder()
+	CoverageHandler(u)(rec, httptest.NewRequest(http.MethodGet, "/coverage", nil))
+	var got ircbot.Coverage
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("expected coverage, got %d %v", rec.Code, err)
+	}
+	if len(got.Unknown) != 3 || got.Unknown[0].Event != "discussion" || got.Unknown[0].Count != 2 ||
+		!got.Unknown[0].LastSeen.Equal(time.Date(2024, 3, 4, 14, 0, 0, 0, time.UTC)) {
+		t.Errorf("expected discussion.created twice first, got %+v", got.Unknown)
+	}
+	if actions, ok := got.Handled["issues"]; !ok || !config.Contains(actions, "opened") || config.Contains(actions, "update") {
+		t.Errorf("expected the actions GitHub sends for issues, got %v", actions)
+	}
+
+	admin := "marky!mark@ury.org.uk"
+	conf.IRCAdmins = []string{"marky!*@ury.org.uk"}
+	for _, c := range []struct{ mask, want string }{
+		{"someone!x@example.org", "Sorry, only admins can do that"},
+		{admin, "Not handled since I started: discussion.created 2 (last 2h ago), issues.typed 1 (last 3h ago), star 1 (last 1h ago)"},
+	} {
+		if got := ircbot.CoverageCommand(conf, u, c.mask, now); got != c.want {
+			t.Errorf("%s: expected %q, got %q", c.mask, c.want, got)
+		}
+	}
+	if got := ircbot.CoverageCommand(conf, ircbot.NewUnknownEvents(), admin, now); got != "Nothing I don't handle since I started, all covered!" {
+		t.Errorf("expected all covered, got %q", got)
+	}
+}
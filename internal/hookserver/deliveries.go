@@ -0,0 +1,208 @@
+package hookserver
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// One line of the state file.
+type deliveryRecord struct {
+	ID   string    `json:"id"`
+	Seen time.Time `json:"seen"`
+}
+
+// Remembers the X-GitHub-Delivery GUIDs we've already handled, so a captured
+// request can't be replayed at us. Optionally backed by a JSON-lines file so
+// this survives restarts; disk writes happen in the background.
+type DeliveryLog struct {
+	retention time.Duration
+	path      string
+	logger    *slog.Logger
+
+	mu   sync.Mutex
+	seen map[string]time.Time
+
+	writes chan deliveryRecord
+	done   chan struct{}
+}
+
+// Set up a delivery log, loading anything recorded at path. An unreadable
+// file just means we start from scratch in memory; an empty path means we
+// never touch the disk at all.
+func NewDeliveryLog(path string, retention time.Duration, logger *slog.Logger) *DeliveryLog {
+	d := &DeliveryLog{
+		retention: retention,
+		path:      path,
+		logger:    logger,
+		seen:      make(map[string]time.Time),
+	}
+	if path == "" {
+		return d
+	}
+	if err := d.load(); err != nil && !os.IsNotExist(err) {
+		logger.Error("Error reading state file, continuing with in-memory replay protection only", "err", err)
+		d.path = ""
+		return d
+	}
+	d.writes = make(chan deliveryRecord, 256)
+	d.done = make(chan struct{})
+	go d.run()
+	return d
+}
+
+func (d *DeliveryLog) load() error {
+	f, err := os.Open(d.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	cutoff := time.Now().Add(-d.retention)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var rec deliveryRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			continue // Most likely a line cut short by a crash
+		}
+		if rec.ID != "" && rec.Seen.After(cutoff) {
+			d.seen[rec.ID] = rec.Seen
+		}
+	}
+	return scanner.Err()
+}
+
+// Record id as handled, returning false if we'd already seen it.
+func (d *DeliveryLog) CheckAndRecord(id string) bool {
+	now := time.Now()
+	d.mu.Lock()
+	if seen, ok := d.seen[id]; ok && now.Sub(seen) < d.retention {
+		d.mu.Unlock()
+		return false
+	}
+	d.seen[id] = now
+	d.mu.Unlock()
+	if d.writes != nil {
+		select {
+		case d.writes <- deliveryRecord{ID: id, Seen: now}:
+		default:
+			d.logger.Warn("State file writer backed up, delivery only recorded in memory", "delivery", id)
+		}
+	}
+	return true
+}
+
+// Undo CheckAndRecord, for when we couldn't actually handle the delivery and
+// want GitHub's retry to get through. The record on disk is left to expire.
+func (d *DeliveryLog) Forget(id string) {
+	d.mu.Lock()
+	delete(d.seen, id)
+	d.mu.Unlock()
+}
+
+// Drop anything older than the retention period from memory.
+func (d *DeliveryLog) prune() {
+	cutoff := time.Now().Add(-d.retention)
+	d.mu.Lock()
+	for id, seen := range d.seen {
+		if seen.Before(cutoff) {
+			delete(d.seen, id)
+		}
+	}
+	d.mu.Unlock()
+}
+
+// Append queued records to the state file in batches, and every so often
+// prune and rewrite it so it doesn't grow forever.
+func (d *DeliveryLog) run() {
+	defer close(d.done)
+	flush := time.NewTicker(time.Second)
+	defer flush.Stop()
+	compact := time.NewTicker(time.Hour)
+	defer compact.Stop()
+	var batch []deliveryRecord
+	for {
+		select {
+		case rec, ok := <-d.writes:
+			if !ok {
+				d.append(batch)
+				return
+			}
+			batch = append(batch, rec)
+		case <-flush.C:
+			d.append(batch)
+			batch = nil
+		case <-compact.C:
+			d.append(batch)
+			batch = nil
+			d.prune()
+			d.compact()
+		}
+	}
+}
+
+func (d *DeliveryLog) append(batch []deliveryRecord) {
+	if len(batch) == 0 {
+		return
+	}
+	f, err := os.OpenFile(d.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		d.logger.Error("Error opening state file", "err", err)
+		return
+	}
+	defer f.Close()
+	if err := writeRecords(f, batch); err != nil {
+		d.logger.Error("Error writing state file", "err", err)
+	}
+}
+
+// Rewrite the state file with just what's still in memory.
+func (d *DeliveryLog) compact() {
+	d.mu.Lock()
+	records := make([]deliveryRecord, 0, len(d.seen))
+	for id, seen := range d.seen {
+		records = append(records, deliveryRecord{ID: id, Seen: seen})
+	}
+	d.mu.Unlock()
+	tmp := d.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		d.logger.Error("Error compacting state file", "err", err)
+		return
+	}
+	err = writeRecords(f, records)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, d.path)
+	}
+	if err != nil {
+		d.logger.Error("Error compacting state file", "err", err)
+		os.Remove(tmp)
+	}
+}
+
+func writeRecords(w io.Writer, records []deliveryRecord) error {
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	for _, rec := range records {
+		if err := enc.Encode(rec); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// Flush anything still waiting to be written. Don't record anything after
+// calling this.
+func (d *DeliveryLog) Close() {
+	if d.writes == nil {
+		return
+	}
+	close(d.writes)
+	<-d.done
+}
@@ -0,0 +1,44 @@
+package hookserver
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/config"
+)
+
+func TestDeliveryLogSurvivesRestart(t *testing.T) {
+	logger := config.DiscardLogger
+	path := filepath.Join(t.TempDir(), "state.jsonl")
+
+	d := NewDeliveryLog(path, time.Hour, logger)
+	if !d.CheckAndRecord("72d3162e-cc78-11e3-81ab-4c9367dc0958") {
+		t.Fatal("first sighting of a delivery reported as a replay")
+	}
+	if d.CheckAndRecord("72d3162e-cc78-11e3-81ab-4c9367dc0958") {
+		t.Error("replayed delivery not caught")
+	}
+	d.Close()
+
+	d = NewDeliveryLog(path, time.Hour, logger)
+	defer d.Close()
+	if d.CheckAndRecord("72d3162e-cc78-11e3-81ab-4c9367dc0958") {
+		t.Error("replayed delivery not caught after a restart")
+	}
+	if !d.CheckAndRecord("another-delivery") {
+		t.Error("new delivery reported as a replay after a restart")
+	}
+}
+
+func TestDeliveryLogUnreadableState(t *testing.T) {
+	// A directory can't be read as a state file.
+	d := NewDeliveryLog(t.TempDir(), time.Hour, config.DiscardLogger)
+	defer d.Close()
+	if !d.CheckAndRecord("abc") || d.CheckAndRecord("abc") {
+		t.Error("in-memory replay protection not working without a state file")
+	}
+	if d.path != "" {
+		t.Error("still trying to use an unreadable state file")
+	}
+}
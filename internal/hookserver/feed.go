@@ -0,0 +1,72 @@
+package hookserver
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/config"
+	"github.com/UniversityRadioYork/CaptainHook/internal/outputs"
+)
+
+// Serves the feed as Atom.
+func FeedAtomHandler(f *outputs.Feed) http.HandlerFunc {
+	return feedHandler(func(w http.ResponseWriter, entries []outputs.FeedEntry) {
+		out := outputs.AtomFeed{
+			ID:      "urn:capthook:feed",
+			Title:   "CaptainHook announcements",
+			Updated: time.Now().UTC().Format(time.RFC3339),
+			Author:  config.CurrentConfig().Nick,
+		}
+		if len(entries) > 0 {
+			out.Updated = entries[0].Time.Format(time.RFC3339)
+		}
+		for _, e := range entries {
+			entry := outputs.AtomEntry{
+				ID:         "urn:capthook:delivery:" + url.PathEscape(e.ID),
+				Title:      e.Title,
+				Updated:    e.Time.Format(time.RFC3339),
+				Categories: []outputs.AtomCategory{{Term: e.Event, Label: "event"}},
+				Content:    outputs.AtomContent{Type: "text", Text: e.Text},
+			}
+			if entry.Title == "" {
+				entry.Title = e.Text
+			}
+			if e.URL != "" {
+				entry.Link = &outputs.AtomLink{Href: e.URL}
+			}
+			if e.Repo != "" {
+				entry.Categories = append(entry.Categories, outputs.AtomCategory{Term: e.Repo, Label: "repo"})
+			}
+			out.Entries = append(out.Entries, entry)
+		}
+		w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+		w.Write([]byte(xml.Header))
+		xml.NewEncoder(w).Encode(out)
+	}, f)
+}
+
+// Serves the feed as a JSON array of entries, newest first.
+func FeedJSONHandler(f *outputs.Feed) http.HandlerFunc {
+	return feedHandler(func(w http.ResponseWriter, entries []outputs.FeedEntry) {
+		w.Header().Set("Content-Type", contentTypeJSON)
+		json.NewEncoder(w).Encode(entries)
+	}, f)
+}
+
+func feedHandler(write func(http.ResponseWriter, []outputs.FeedEntry), f *outputs.Feed) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			respond(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+		entries := f.Entries()
+		if len(entries) > 0 {
+			w.Header().Set("Last-Modified", entries[0].Time.Format(http.TimeFormat))
+		}
+		write(w, entries)
+	}
+}
@@ -0,0 +1,54 @@
+package hookserver
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/config"
+	"github.com/UniversityRadioYork/CaptainHook/internal/config/configtest"
+	"github.com/UniversityRadioYork/CaptainHook/internal/outputs"
+)
+
+func TestFeedHandlers(t *testing.T) {
+	config.SetConfig(configtest.Valid())
+	f := outputs.NewFeed(10, "", config.DiscardLogger)
+	f.Add(outputs.Announcement{ID: "abc-123", Event: "issues", Repo: "website", Title: "Fix <it>", Text: "[website] Fix <it> & more", URL: "https://github.com/UniversityRadioYork/website/issues/1"})
+
+	w := httptest.NewRecorder()
+	FeedAtomHandler(f).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/feed.atom", nil))
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "application/atom+xml") {
+		t.Fatalf("unexpected response %d %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	var atom outputs.AtomFeed
+	if err := xml.Unmarshal(w.Body.Bytes(), &atom); err != nil {
+		t.Fatal(err)
+	}
+	if len(atom.Entries) != 1 {
+		t.Fatalf("expected one entry, got %+v", atom)
+	}
+	e := atom.Entries[0]
+	if e.ID != "urn:capthook:delivery:abc-123" || e.Title != "Fix <it>" || e.Content.Text != "[website] Fix <it> & more" ||
+		e.Link == nil || e.Link.Href != "https://github.com/UniversityRadioYork/website/issues/1" || e.Categories[0].Term != "issues" {
+		t.Errorf("unexpected entry %+v", e)
+	}
+
+	w = httptest.NewRecorder()
+	FeedJSONHandler(f).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/feed.json", nil))
+	var entries []outputs.FeedEntry
+	if err := json.Unmarshal(w.Body.Bytes(), &entries); err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].ID != "abc-123" || entries[0].Event != "issues" {
+		t.Errorf("unexpected entries %+v", entries)
+	}
+
+	w = httptest.NewRecorder()
+	FeedJSONHandler(f).ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/feed.json", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected POST to be refused, got %d", w.Code)
+	}
+}
@@ -0,0 +1,72 @@
+package hookserver
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/config"
+	"github.com/UniversityRadioYork/CaptainHook/internal/format"
+	"github.com/UniversityRadioYork/CaptainHook/internal/testutil"
+)
+
+func TestFilteredRepoIgnored(t *testing.T) {
+	conf := &config.Config{GHSecret: "hunter2", MaxBodyBytes: 1 << 20, RepoDeny: []string{"ury/junk"}}
+	config.SetConfig(conf)
+	hook := conf.DefaultHook()
+	for repo, want := range map[string]int{"ury/junk": http.StatusNoContent, "ury/website": http.StatusAccepted} {
+		body := `{"action":"opened","repository":{"full_name":"` + repo + `"}}`
+		req := httptest.NewRequest("POST", "/", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Github-Event", "issues")
+		req.Header.Set("X-Hub-Signature", testutil.Sign(body, "hunter2"))
+		rec := httptest.NewRecorder()
+		WebhookHandler(hook, &Intake{Work: make(chan Delivery, 1)}, config.DiscardLogger)(rec, req)
+		if rec.Code != want {
+			t.Errorf("%s: expected %d, got %d", repo, want, rec.Code)
+		}
+	}
+}
+
+func TestBranchesPerRepo(t *testing.T) {
+	payload, err := ioutil.ReadFile("testdata/gitlab/push.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := Delivery{Source: format.SourceGitLab, Hook: &config.Hook{}, Event: "Push Hook", Payload: payload}
+	for _, c := range []struct {
+		name string
+		conf *config.Config
+		want bool
+	}{
+		{"no filter", &config.Config{}, true},
+		{"global filter", &config.Config{Branches: []string{"live-*"}}, false},
+		{"repo override", &config.Config{Branches: []string{"live-*"}, Repos: map[string]config.RepoConfig{"ury/playout": {Branches: []string{"*"}}}}, true},
+	} {
+		config.SetConfig(c.conf)
+		if _, ok, err := new(Processor).ProcessDelivery(context.Background(), d, config.DiscardLogger); ok != c.want || err != nil {
+			t.Errorf("%s: expected announce=%v, got %v %v", c.name, c.want, ok, err)
+		}
+	}
+}
+
+func TestIgnoredSenderHandler(t *testing.T) {
+	conf := &config.Config{GHSecret: "hunter2", MaxBodyBytes: 1 << 20, IgnoreSenders: []string{"dependabot[bot]"}}
+	config.SetConfig(conf)
+	hook := conf.DefaultHook()
+	for sender, want := range map[string]int{"dependabot[bot]": http.StatusNoContent, "x1tot": http.StatusAccepted} {
+		body := `{"action":"opened","sender":{"login":"` + sender + `"}}`
+		req := httptest.NewRequest("POST", "/", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Github-Event", "pull_request")
+		req.Header.Set("X-Hub-Signature", testutil.Sign(body, "hunter2"))
+		rec := httptest.NewRecorder()
+		WebhookHandler(hook, &Intake{Work: make(chan Delivery, 1)}, config.DiscardLogger)(rec, req)
+		if rec.Code != want {
+			t.Errorf("%s: expected %d, got %d", sender, want, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package hookserver
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/config"
+	"github.com/UniversityRadioYork/CaptainHook/internal/metrics"
+	"github.com/UniversityRadioYork/CaptainHook/internal/panics"
+)
+
+// A delivery on its way to a target.
+type forwardedDelivery struct {
+	Event   string
+	ID      string
+	Payload []byte
+}
+
+// Passes deliveries on to each target in the background, retrying with
+// backoff, and giving up on targets that have been down for a while.
+type Forwarder struct {
+	Retries   int           // Attempts per delivery after the first
+	Backoff   time.Duration // Wait before the first retry, doubling each time
+	TripAfter int           // Deliveries failed in a row before we stop trying
+	Cooldown  time.Duration // How long to stop trying for
+
+	client  *http.Client
+	targets []*forwardTarget
+	logger  *slog.Logger
+}
+
+type forwardTarget struct {
+	config.ForwardTarget
+	queue chan forwardedDelivery
+
+	mu        sync.Mutex
+	failures  int       // Deliveries failed in a row
+	openUntil time.Time // Don't bother trying until then
+}
+
+func NewForwarder(targets []config.ForwardTarget, logger *slog.Logger) *Forwarder {
+	f := &Forwarder{
+		Retries:   5,
+		Backoff:   time.Second,
+		TripAfter: 5,
+		Cooldown:  5 * time.Minute,
+		client:    &http.Client{Timeout: 10 * time.Second},
+		logger:    logger,
+	}
+	for _, t := range targets {
+		f.targets = append(f.targets, &forwardTarget{
+			ForwardTarget: t,
+			queue:         make(chan forwardedDelivery, 100),
+		})
+	}
+	return f
+}
+
+// Start delivering to each target in the background, giving up on anything
+// in flight once ctx is done.
+func (f *Forwarder) Run(ctx context.Context) {
+	for _, t := range f.targets {
+		go func(t *forwardTarget) {
+			for d := range t.queue {
+				f.deliver(ctx, t, d)
+			}
+		}(t)
+	}
+}
+
+// Queue a delivery for every target, without ever blocking. Safe to call on
+// a nil Forwarder.
+func (f *Forwarder) Forward(event, id string, payload []byte) {
+	if f == nil {
+		return
+	}
+	d := forwardedDelivery{Event: event, ID: id, Payload: payload}
+	for _, t := range f.targets {
+		select {
+		case t.queue <- d:
+		default:
+			f.logger.Warn("Forwarding queue full, dropped delivery", "url", t.URL, "event", event, "delivery", id)
+			metrics.ForwardsTotal.WithLabelValues(t.URL, "dropped").Inc()
+		}
+	}
+}
+
+// Try to get a delivery to a target, backing off between attempts.
+func (f *Forwarder) deliver(ctx context.Context, t *forwardTarget, d forwardedDelivery) {
+	defer panics.Recover("forward", f.logger.With("url", t.URL))
+	t.mu.Lock()
+	open := time.Now().Before(t.openUntil)
+	t.mu.Unlock()
+	if open {
+		metrics.ForwardsTotal.WithLabelValues(t.URL, "circuit_open").Inc()
+		return
+	}
+	backoff := f.Backoff
+	var err error
+	for attempt := 0; attempt <= f.Retries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+			}
+			backoff *= 2
+		}
+		if ctx.Err() != nil {
+			// Shutting down, which says nothing about the target.
+			metrics.ForwardsTotal.WithLabelValues(t.URL, "cancelled").Inc()
+			return
+		}
+		if err = f.post(ctx, t, d); err == nil {
+			break
+		}
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err == nil {
+		t.failures = 0
+		metrics.ForwardsTotal.WithLabelValues(t.URL, "success").Inc()
+		return
+	}
+	f.logger.Error("Error forwarding delivery", "event", d.Event, "delivery", d.ID, "url", t.URL, "err", err)
+	metrics.ForwardsTotal.WithLabelValues(t.URL, "failure").Inc()
+	t.failures++
+	if t.failures >= f.TripAfter {
+		f.logger.Warn("Forward target failing, leaving it alone for a while", "url", t.URL, "failures", t.failures, "cooldown", f.Cooldown)
+		t.openUntil = time.Now().Add(f.Cooldown)
+		t.failures = 0
+	}
+}
+
+// Send a delivery once, signed like GitHub would with the target's secret.
+func (f *Forwarder) post(ctx context.Context, t *forwardTarget, d forwardedDelivery) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", t.URL, bytes.NewReader(d.Payload))
+	if err != nil {
+		return err
+	}
+	mac, mac256 := hmac.New(sha1.New, []byte(t.Secret)), hmac.New(sha256.New, []byte(t.Secret))
+	mac.Write(d.Payload)
+	mac256.Write(d.Payload)
+	req.Header.Set("Content-Type", contentTypeJSON)
+	req.Header.Set("User-Agent", "CaptainHook")
+	req.Header.Set("X-GitHub-Event", d.Event)
+	req.Header.Set("X-GitHub-Delivery", d.ID)
+	req.Header.Set("X-Hub-Signature", "sha1="+hex.EncodeToString(mac.Sum(nil)))
+	req.Header.Set("X-Hub-Signature-256", "sha256="+hex.EncodeToString(mac256.Sum(nil)))
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("got %s", resp.Status)
+	}
+	return nil
+}
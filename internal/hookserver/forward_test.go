@@ -0,0 +1,84 @@
+package hookserver
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/config"
+	"github.com/UniversityRadioYork/CaptainHook/internal/testutil"
+)
+
+func TestForwarderResigns(t *testing.T) {
+	body := []byte(`{"action":"opened"}`)
+	got := make(chan *http.Request, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got <- r
+	}))
+	defer srv.Close()
+
+	f := NewForwarder([]config.ForwardTarget{{URL: srv.URL, Secret: "downstream"}}, config.DiscardLogger)
+	f.Run(context.Background())
+	f.Forward("issues", "guid-1", body)
+	select {
+	case r := <-got:
+		if r.Header.Get("X-Hub-Signature") != testutil.Sign(string(body), "downstream") || r.Header.Get("X-Hub-Signature-256") != sign256(string(body), "downstream") {
+			t.Errorf("expected signatures made with the target's secret, got %q and %q", r.Header.Get("X-Hub-Signature"), r.Header.Get("X-Hub-Signature-256"))
+		}
+		if r.Header.Get("X-GitHub-Event") != "issues" || r.Header.Get("X-GitHub-Delivery") != "guid-1" {
+			t.Errorf("headers not passed on: %v", r.Header)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("delivery never forwarded")
+	}
+}
+
+func TestForwarderRetriesAndTrips(t *testing.T) {
+	var hits int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer srv.Close()
+
+	f := NewForwarder([]config.ForwardTarget{{URL: srv.URL}}, config.DiscardLogger)
+	f.Retries, f.Backoff, f.TripAfter, f.Cooldown = 2, time.Millisecond, 2, time.Hour
+	target := f.targets[0]
+	for i := 0; i < 4; i++ {
+		f.deliver(context.Background(), target, forwardedDelivery{Event: "push", Payload: []byte(`{}`)})
+	}
+	// Two deliveries of three attempts each, then the breaker is open.
+	if n := atomic.LoadInt32(&hits); n != 6 {
+		t.Errorf("expected 6 attempts, got %d", n)
+	}
+}
+
+// Only deliveries we take are passed on, so a replay isn't forwarded twice.
+func TestReplayNotForwarded(t *testing.T) {
+	conf := &config.Config{GHSecret: "hunter2", MaxBodyBytes: 1 << 20}
+	config.SetConfig(conf)
+	f := NewForwarder([]config.ForwardTarget{{URL: "http://example.org/"}}, config.DiscardLogger)
+	seen := NewDeliveryLog("", time.Hour, config.DiscardLogger)
+	defer seen.Close()
+	h := WebhookHandler(conf.DefaultHook(), &Intake{Work: make(chan Delivery, 2), Seen: seen, Forwarder: f}, config.DiscardLogger)
+	body := `{"action":"opened"}`
+	for _, want := range []int{http.StatusAccepted, http.StatusOK} {
+		req := httptest.NewRequest("POST", "/", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Github-Event", "issues")
+		req.Header.Set("X-GitHub-Delivery", "guid-1")
+		req.Header.Set("X-Hub-Signature", testutil.Sign(body, "hunter2"))
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != want {
+			t.Fatalf("expected %d, got %d", want, rec.Code)
+		}
+	}
+	if n := len(f.targets[0].queue); n != 1 {
+		t.Errorf("expected the delivery forwarded once, got %d", n)
+	}
+}
@@ -0,0 +1,55 @@
+package hookserver
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/config"
+	"github.com/UniversityRadioYork/CaptainHook/internal/metrics"
+)
+
+const SourceGeneric = "generic"
+
+// Handles a generic hook. Unlike the others the template is rendered here,
+// so senders find out about broken templates with a 422.
+func GenericHandler(hook *config.GenericHook, in *Intake, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, _, ok := readBody(w, r, logger)
+		if !ok {
+			return
+		}
+		token := r.Header.Get("X-Hook-Token")
+		if token == "" {
+			token = strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		}
+		if token == "" {
+			token = r.URL.Query().Get("token")
+		}
+		if !matchToken(token, hook.Secrets) {
+			logger.Warn("Invalid token", "hook", hook.Name, "ip", requestIP(r))
+			metrics.DeliveriesTotal.WithLabelValues(metrics.UnverifiedEvent, metrics.OutcomeBadSignature).Inc()
+			respond(w, http.StatusUnauthorized, "Invalid token")
+			return
+		}
+		text, err := hook.Render(r.Context(), body)
+		if err == nil && text == "" {
+			err = fmt.Errorf("template rendered nothing")
+		}
+		if err != nil {
+			logger.Warn("Dropping delivery", "hook", hook.Name, "err", err)
+			metrics.DeliveriesTotal.WithLabelValues(hook.Name, metrics.OutcomeParseError).Inc()
+			respond(w, http.StatusUnprocessableEntity, "Unable to render template")
+			return
+		}
+		enqueue(w, r, Delivery{
+			Source:  SourceGeneric,
+			Hook:    &hook.Hook,
+			Event:   hook.Name,
+			Header:  r.Header.Clone(),
+			Payload: body,
+			Text:    text,
+		}, in, logger)
+	}
+}
@@ -0,0 +1,76 @@
+package hookserver
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/config"
+	"github.com/UniversityRadioYork/CaptainHook/internal/testutil"
+)
+
+func TestGenericRenderEmpty(t *testing.T) {
+	config.SetConfig(&config.Config{MaxBodyBytes: 1 << 20})
+	c := &config.Config{GenericHooks: []config.GenericHookConfig{{Name: "e", Token: "t", Template: "{{ .nothing }}"}}}
+	hooks, err := c.AllGenericHooks(map[string]bool{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest("POST", "/hooks/e", strings.NewReader(`{"something":1}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Hook-Token", "t")
+	rec := httptest.NewRecorder()
+	GenericHandler(hooks[0], &Intake{Work: make(chan Delivery, 1)}, config.DiscardLogger)(rec, req)
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected 422, got %d", rec.Code)
+	}
+}
+
+func TestGenericHandler(t *testing.T) {
+	config.SetConfig(&config.Config{MaxBodyBytes: 1 << 20, LinkShortener: testutil.FakeShortener("https://short.example/x")})
+
+	c := &config.Config{Channels: "#ops", GenericHooks: []config.GenericHookConfig{{
+		Name:     "uptime",
+		Token:    "t0ken",
+		Template: `[uptime] {{ if .up }}{{ color "green" "UP" }}{{ else }}{{ color "red" "DOWN" }}{{ end }} {{ .name }}: {{ .msg | truncate 13 }} {{ shorten .url }}`,
+	}}}
+	hooks, err := c.AllGenericHooks(map[string]bool{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name, token, body string
+		want              int
+		text              string
+	}{
+		{"bad token", "nope", `{}`, http.StatusUnauthorized, ""},
+		{"renders", "t0ken", `{"up":false,"name":"stream","msg":"Connection\nrefused by host","url":"https://status.example/1"}`,
+			http.StatusAccepted, "[uptime] \x0304DOWN\x0f stream: Connection... https://short.example/x\x0f"},
+		{"missing fields", "t0ken", `{"up":true}`, http.StatusAccepted, "[uptime] \x0303UP\x0f :\x0f"},
+		{"formatting", "t0ken", `{"up":true,"name":"\u00034,12str\u0002eam\u0003","msg":"\u001fok"}`, http.StatusAccepted, "[uptime] \x0303UP\x0f stream: ok\x0f"},
+		{"not an object", "t0ken", `[1, 2]`, http.StatusUnprocessableEntity, ""},
+	} {
+		work := make(chan Delivery, 1)
+		req := httptest.NewRequest("POST", "/hooks/uptime", strings.NewReader(tc.body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Hook-Token", tc.token)
+		rec := httptest.NewRecorder()
+		GenericHandler(hooks[0], &Intake{Work: work}, config.DiscardLogger)(rec, req)
+		if rec.Code != tc.want {
+			t.Errorf("%s: expected %d, got %d", tc.name, tc.want, rec.Code)
+			continue
+		}
+		if tc.text == "" {
+			continue
+		}
+		a, ok, err := new(Processor).ProcessDelivery(context.Background(), <-work, config.DiscardLogger)
+		if !ok || err != nil || a.Text != tc.text {
+			t.Errorf("%s:\nexpected %q\n     got %q (%v)", tc.name, tc.text, a.Text, err)
+		}
+		if len(a.Channels) != 1 || a.Channels[0] != "#ops" {
+			t.Errorf("%s: expected #ops, got %v", tc.name, a.Channels)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package hookserver
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/config"
+	"github.com/UniversityRadioYork/CaptainHook/internal/format"
+	"github.com/UniversityRadioYork/CaptainHook/internal/metrics"
+)
+
+// Various (partial!) GitLab webhook structs. These differ a fair bit from
+// GitHub's, so they get turned into Events as soon as they're parsed.
+
+type GitLabUser struct {
+	Name     string
+	Username string
+}
+
+type GitLabProject struct {
+	Name              string
+	PathWithNamespace string `json:"path_with_namespace"`
+	WebURL            string `json:"web_url"`
+}
+
+type GitLabCommit struct {
+	ID     string
+	URL    string
+	Title  string
+	Author struct {
+		Name string
+	}
+}
+
+type GitLabPushEvent struct {
+	Ref               string
+	Before            string
+	After             string
+	UserUsername      string `json:"user_username"`
+	Project           GitLabProject
+	Commits           []GitLabCommit
+	TotalCommitsCount int `json:"total_commits_count"`
+}
+
+// Merge requests and issues look the same as far as we're concerned.
+type GitLabObjectEvent struct {
+	User             GitLabUser
+	Project          GitLabProject
+	ObjectAttributes struct {
+		IID    int
+		Title  string
+		URL    string
+		Action string
+	} `json:"object_attributes"`
+}
+
+// GitLab's object actions, mapped onto the vocabulary GitHub uses.
+var gitLabActions = map[string]string{
+	"open":   "opened",
+	"close":  "closed",
+	"reopen": "reopened",
+	"merge":  "closed",
+}
+
+// A commit ID of all zeroes means the branch didn't exist before the push.
+const gitLabNullSHA = "0000000000000000000000000000000000000000"
+
+// Turn a GitLab event payload into an Event. Events and actions we don't
+// announce produce nil and no error.
+func ParseGitLabEvent(ev string, body []byte) (*format.Event, error) {
+	switch ev {
+	case "Push Hook":
+		var event GitLabPushEvent
+		if err := json.Unmarshal(body, &event); err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(event.Ref, "refs/heads/") || event.TotalCommitsCount == 0 {
+			return nil, nil
+		}
+		url := event.Project.WebURL + "/-/compare/" + event.Before + "..." + event.After
+		if event.Before == gitLabNullSHA && len(event.Commits) > 0 {
+			url = event.Commits[len(event.Commits)-1].URL
+		}
+		var log []format.Commit
+		for _, c := range event.Commits {
+			log = append(log, format.Commit{ID: c.ID, Author: c.Author.Name, Subject: c.Title})
+		}
+		return &format.Event{
+			Source:  format.SourceGitLab,
+			Type:    "push",
+			Action:  "pushed",
+			Repo:    event.Project.Name,
+			Sender:  event.UserUsername,
+			URL:     url,
+			Ref:     strings.TrimPrefix(event.Ref, "refs/heads/"),
+			Commits: event.TotalCommitsCount,
+			Log:     log,
+		}, nil
+	case "Merge Request Hook", "Issue Hook":
+		var event GitLabObjectEvent
+		if err := json.Unmarshal(body, &event); err != nil {
+			return nil, err
+		}
+		attrs := event.ObjectAttributes
+		action, ok := gitLabActions[attrs.Action]
+		if !ok {
+			action = attrs.Action // Left to the action filters
+		}
+		e := &format.Event{
+			Source: format.SourceGitLab,
+			Type:   "issues",
+			Action: action,
+			Repo:   event.Project.Name,
+			Number: attrs.IID,
+			Title:  attrs.Title,
+			Sender: event.User.Username,
+			URL:    attrs.URL,
+		}
+		if ev == "Merge Request Hook" {
+			e.Type = "pull_request"
+			e.Merged = attrs.Action == "merge"
+		}
+		return e, nil
+	}
+	return nil, nil
+}
+
+// Handles incoming GitLab webhooks, which authenticate by sending a shared
+// token in X-Gitlab-Token rather than signing the body.
+func GitLabHandler(hook *config.Hook, in *Intake, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, _, ok := readBody(w, r, logger)
+		if !ok {
+			return
+		}
+		if !matchToken(r.Header.Get("X-Gitlab-Token"), hook.Secrets) {
+			logger.Warn("Invalid token", "hook", hook.Name, "ip", requestIP(r))
+			metrics.DeliveriesTotal.WithLabelValues(metrics.UnverifiedEvent, metrics.OutcomeBadSignature).Inc()
+			respond(w, http.StatusUnauthorized, "Invalid token")
+			return
+		}
+		ev := r.Header.Get("X-Gitlab-Event")
+		if !hook.Wants(ev) {
+			metrics.DeliveriesTotal.WithLabelValues(ev, metrics.OutcomeIgnored).Inc()
+			respond(w, http.StatusNoContent, "")
+			return
+		}
+		enqueue(w, r, Delivery{
+			Source:  format.SourceGitLab,
+			Hook:    hook,
+			Event:   ev,
+			ID:      r.Header.Get("X-Gitlab-Event-UUID"),
+			Header:  r.Header.Clone(),
+			Payload: body,
+		}, in, logger)
+	}
+}
+
+// Check a presented token against each of the accepted ones in constant time.
+func matchToken(presented string, tokens []string) bool {
+	if presented == "" {
+		return false
+	}
+	for _, t := range tokens {
+		if subtle.ConstantTimeCompare([]byte(presented), []byte(t)) == 1 {
+			return true
+		}
+	}
+	return false
+}
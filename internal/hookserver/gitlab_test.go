@@ -0,0 +1,61 @@
+package hookserver
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/config"
+)
+
+func TestParseGitLabEvent(t *testing.T) {
+
+	cases := []struct {
+		fixture string
+		event   string
+		want    string
+	}{
+		{"push.json", "Push Hook",
+			"[\x0306playout\x0f] jsmith pushed 4 commits to main. https://gitlab.example.org/ury/playout/-/compare/95790bf891e76fee5e1747ab589903a6a1f80f22...da1560886d4f094c3e6c9ef40349f7d38b5d27d7\x0f"},
+		{"merge_request_merge.json", "Merge Request Hook",
+			"[\x0306playout\x0f] PRQ #14 \x0302Merged\x0f by root: Fix the silence detector threshold. https://gitlab.example.org/ury/playout/-/merge_requests/14\x0f"},
+		{"issue_open.json", "Issue Hook",
+			"[\x0306playout\x0f] Issue #23 \x0303opened\x0f by root: Studio 2 fader start doesn't work. https://gitlab.example.org/ury/playout/-/issues/23\x0f"},
+	}
+	for _, c := range cases {
+		body, err := ioutil.ReadFile("testdata/gitlab/" + c.fixture)
+		if err != nil {
+			t.Fatal(err)
+		}
+		e, err := ParseGitLabEvent(c.event, body)
+		if err != nil || e == nil {
+			t.Fatalf("%s: expected an event, got %v", c.fixture, err)
+		}
+		if got := config.FormatEvent(e, config.DiscardLogger); got != c.want {
+			t.Errorf("%s:\nexpected %q\n     got %q", c.fixture, c.want, got)
+		}
+	}
+}
+
+func TestGitLabHandlerToken(t *testing.T) {
+	config.SetConfig(&config.Config{MaxBodyBytes: 1 << 20})
+	hook := &config.Hook{Name: "gitlab", Secrets: []string{"glsecret"}}
+	for token, want := range map[string]int{
+		"":         http.StatusUnauthorized,
+		"wrong":    http.StatusUnauthorized,
+		"glsecret": http.StatusAccepted,
+	} {
+		work := make(chan Delivery, 1)
+		req := httptest.NewRequest("POST", "/gitlab", strings.NewReader(`{"object_kind":"issue"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Gitlab-Event", "Issue Hook")
+		req.Header.Set("X-Gitlab-Token", token)
+		rec := httptest.NewRecorder()
+		GitLabHandler(hook, &Intake{Work: work}, config.DiscardLogger)(rec, req)
+		if rec.Code != want {
+			t.Errorf("token %q: expected %d, got %d", token, want, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package hookserver
+
+import (
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/config"
+	"github.com/UniversityRadioYork/CaptainHook/internal/config/configtest"
+	"github.com/UniversityRadioYork/CaptainHook/internal/format"
+	"github.com/UniversityRadioYork/CaptainHook/internal/outputs"
+)
+
+var update = flag.Bool("update", false, "rewrite testdata/**/*.golden from what the fixtures give now")
+
+// Each fixture is parsed and announced with the default templates, and the
+// announcement (formatting spelled out, see ShowFormatting) and the parsed
+// Event are checked against the .golden file next to it. Any change to a
+// format or a parser shows up as a diff there; if it's meant, run
+//
+//	go test -run TestGolden -update
+//
+// and commit the new golden files.
+func TestGolden(t *testing.T) {
+	config.SetConfig(configtest.Valid())
+	for _, c := range []struct {
+		fixture, source, event string
+	}{
+		{"issues_opened.json", format.SourceGitHub, "issues"},
+		{"pull_request_merged.json", format.SourceGitHub, "pull_request"},
+		{"repository_created.json", format.SourceGitHub, "repository"},
+		{"issue_comment.json", format.SourceGitHub, "issue_comment"},
+		{"pull_request_review_comment.json", format.SourceGitHub, "pull_request_review_comment"},
+		{"pull_request_merge_queue.json", format.SourceGitHub, "pull_request"},
+		{"workflow_run_scheduled.json", format.SourceGitHub, "workflow_run"},
+		{"ghes/pull_request_opened.json", format.SourceGitHub, "pull_request"},
+		{"ghes/push.json", format.SourceGitHub, "push"},
+		{"org/organization_member_added.json", format.SourceGitHub, "organization"},
+		{"org/ping.json", format.SourceGitHub, "ping"},
+		{"meta_deleted.json", format.SourceGitHub, "meta"},
+		{"gitlab/push.json", format.SourceGitLab, "Push Hook"},
+		{"gitlab/merge_request_merge.json", format.SourceGitLab, "Merge Request Hook"},
+		{"gitlab/issue_open.json", format.SourceGitLab, "Issue Hook"},
+		{"jenkins/failure.json", SourceJenkins, ""},
+		{"alertmanager/firing.json", SourceAlertmanager, ""},
+		{"grafana/legacy.json", SourceGrafana, ""},
+		{"grafana/unified.json", SourceGrafana, ""},
+		{"sentry/issue_created.json", SourceSentry, "issue.created"},
+	} {
+		payload, err := ioutil.ReadFile("testdata/" + c.fixture)
+		if err != nil {
+			t.Fatal(err)
+		}
+		e, err := ParseDelivery(Delivery{Source: c.source, Event: c.event, Payload: payload})
+		if err != nil || e == nil {
+			t.Errorf("%s: expected an event, got %v", c.fixture, err)
+			continue
+		}
+		parsed, _ := json.MarshalIndent(e, "", "  ")
+		got := outputs.ShowFormatting(config.FormatEvent(e, config.DiscardLogger)) + "\n\n" + string(parsed) + "\n"
+
+		golden := "testdata/" + strings.TrimSuffix(c.fixture, ".json") + ".golden"
+		if *update {
+			if err := ioutil.WriteFile(golden, []byte(got), 0644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		want, err := ioutil.ReadFile(golden)
+		if err != nil {
+			t.Errorf("%s: %v (run with -update to make it)", c.fixture, err)
+			continue
+		}
+		if got != string(want) {
+			t.Errorf("%s doesn't match %s:\n--- want\n%s--- got\n%s", c.fixture, golden, want, got)
+		}
+	}
+}
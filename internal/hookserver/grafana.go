@@ -0,0 +1,107 @@
+package hookserver
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/config"
+	"github.com/UniversityRadioYork/CaptainHook/internal/format"
+	"github.com/UniversityRadioYork/CaptainHook/internal/metrics"
+)
+
+const SourceGrafana = "grafana"
+
+// How much of Grafana's (often enormous) alert text we'll pass on.
+const (
+	maxGrafanaTitle   = 80
+	maxGrafanaMessage = 120
+)
+
+// What Grafana sends us, covering both legacy alerting (evalMatches) and
+// unified alerting (alerts), partially.
+type GrafanaEvent struct {
+	Title       string
+	RuleName    string
+	RuleURL     string
+	State       string
+	Status      string
+	Message     string
+	ExternalURL string
+	EvalMatches []struct {
+		Metric string
+	}
+	CommonLabels map[string]string
+	Alerts       []struct {
+		DashboardURL string
+		PanelURL     string
+		GeneratorURL string
+	}
+}
+
+// Turn a Grafana notification into an Event, keeping the URL long unless
+// shortenURLs is set.
+func ParseGrafanaEvent(body []byte, shortenURLs bool) (*format.Event, error) {
+	var event GrafanaEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		return nil, err
+	}
+	e := &format.Event{
+		Source:  SourceGrafana,
+		Type:    "grafana",
+		Action:  event.State,
+		Title:   event.RuleName,
+		URL:     event.RuleURL,
+		Number:  len(event.EvalMatches),
+		KeepURL: !shortenURLs,
+		Message: format.Truncate(event.Message, maxGrafanaMessage),
+	}
+	if len(event.Alerts) > 0 {
+		// Unified alerting
+		e.Action = event.Status
+		e.Title = event.CommonLabels["alertname"]
+		e.Number = len(event.Alerts)
+		a := event.Alerts[0]
+		e.URL = firstNonEmpty(a.PanelURL, a.DashboardURL, a.GeneratorURL, event.ExternalURL)
+	}
+	if e.Title == "" {
+		e.Title = event.Title
+	}
+	e.Title = format.Truncate(e.Title, maxGrafanaTitle)
+	return e, nil
+}
+
+func firstNonEmpty(s ...string) string {
+	for _, v := range s {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// Handles alerts from Grafana's webhook contact point, which sends a bearer
+// token in the Authorization header.
+func GrafanaHandler(hook *config.Hook, in *Intake, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, _, ok := readBody(w, r, logger)
+		if !ok {
+			return
+		}
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !matchToken(token, hook.Secrets) {
+			logger.Warn("Invalid token", "hook", hook.Name, "ip", requestIP(r))
+			metrics.DeliveriesTotal.WithLabelValues(metrics.UnverifiedEvent, metrics.OutcomeBadSignature).Inc()
+			respond(w, http.StatusUnauthorized, "Invalid token")
+			return
+		}
+		enqueue(w, r, Delivery{
+			Source:  SourceGrafana,
+			Hook:    hook,
+			Event:   "grafana",
+			Header:  r.Header.Clone(),
+			Payload: body,
+		}, in, logger)
+	}
+}
@@ -0,0 +1,64 @@
+package hookserver
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/config"
+	"github.com/UniversityRadioYork/CaptainHook/internal/config/configtest"
+	"github.com/UniversityRadioYork/CaptainHook/internal/testutil"
+)
+
+func TestParseGrafanaEvent(t *testing.T) {
+	c := configtest.Valid()
+	c.Validate()
+	c.LinkShortener = testutil.FakeShortener("https://short.example/x")
+	config.SetConfig(c)
+
+	cases := []struct {
+		fixture string
+		shorten bool
+		want    string
+	}{
+		{"legacy.json", false,
+			"[\x0306grafana\x0f] \x0304ALERTING\x0f (2): Disk usage: Disk usage is above 90%. Check the music store before the breakfast show. https://grafana.example.org/d/abc123/servers?tab=alert&viewPanel=4&orgId=1\x0f"},
+		{"unified.json", true,
+			"[\x0306grafana\x0f] \x0303RESOLVED\x0f (1): Stream down https://short.example/x\x0f"},
+	}
+	for _, c := range cases {
+		body, err := ioutil.ReadFile("testdata/grafana/" + c.fixture)
+		if err != nil {
+			t.Fatal(err)
+		}
+		e, err := ParseGrafanaEvent(body, c.shorten)
+		if err != nil || e == nil {
+			t.Fatalf("%s: expected an event, got %v", c.fixture, err)
+		}
+		if got := config.FormatEvent(e, config.DiscardLogger); got != c.want {
+			t.Errorf("%s:\nexpected %q\n     got %q", c.fixture, c.want, got)
+		}
+	}
+}
+
+func TestGrafanaHandlerToken(t *testing.T) {
+	config.SetConfig(&config.Config{MaxBodyBytes: 1 << 20})
+	hook := &config.Hook{Name: "grafana", Secrets: []string{"gftoken"}}
+	for header, want := range map[string]int{
+		"":               http.StatusUnauthorized,
+		"Bearer wrong":   http.StatusUnauthorized,
+		"Bearer gftoken": http.StatusAccepted,
+	} {
+		work := make(chan Delivery, 1)
+		req := httptest.NewRequest("POST", "/grafana", strings.NewReader(`{"state":"ok"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", header)
+		rec := httptest.NewRecorder()
+		GrafanaHandler(hook, &Intake{Work: work}, config.DiscardLogger)(rec, req)
+		if rec.Code != want {
+			t.Errorf("%q: expected %d, got %d", header, want, rec.Code)
+		}
+	}
+}
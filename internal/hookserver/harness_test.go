@@ -0,0 +1,220 @@
+package hookserver
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/sorcix/irc"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/config"
+	"github.com/UniversityRadioYork/CaptainHook/internal/config/configtest"
+	"github.com/UniversityRadioYork/CaptainHook/internal/format"
+	"github.com/UniversityRadioYork/CaptainHook/internal/ircbot"
+	"github.com/UniversityRadioYork/CaptainHook/internal/outputs"
+	"github.com/UniversityRadioYork/CaptainHook/internal/testutil"
+)
+
+// An ircx.Sender that keeps what it's sent, in place of an IRC server.
+type MemorySender struct {
+	mu   sync.Mutex
+	sent []*irc.Message
+}
+
+func (s *MemorySender) Send(m *irc.Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sent = append(s.sent, m)
+	return nil
+}
+
+// Everything sent so far, as "COMMAND params :trailing" lines.
+func (s *MemorySender) Lines() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var lines []string
+	for _, m := range s.sent {
+		line := m.Command + " " + strings.Join(m.Params, " ")
+		if m.Trailing != "" {
+			line += " :" + m.Trailing
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// The secrets and tokens testConfig gives each source.
+const (
+	testGitHubSecret = "hunter2"
+	testToken        = "tok"
+	testSentrySecret = "sentry-secret"
+)
+
+// A config with every source turned on, announcing to #ury.
+func testConfig() *config.Config {
+	c := configtest.Valid()
+	c.GHSecret = testGitHubSecret
+	c.Channels = "#ury"
+	c.GitLabToken = testToken
+	c.JenkinsToken = testToken
+	c.AlertmanagerUser, c.AlertmanagerPassword = "am", testToken
+	c.GrafanaToken = testToken
+	c.SentrySecret = testSentrySecret
+	return c
+}
+
+// Everything from the webhook mux to IRC as main wires it up, with a
+// MemorySender for the IRC server.
+type testPipeline struct {
+	Sender *MemorySender
+	mux    http.Handler
+	work   chan Delivery
+	msgs   *ircbot.Queue
+}
+
+func newTestPipeline(t *testing.T, c *config.Config) *testPipeline {
+	t.Helper()
+	if errs := c.Validate(); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	config.SetConfig(c)
+	work := make(chan Delivery, 100)
+	mux, err := NewHookMux(c, &Intake{Work: work}, config.DiscardLogger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testPipeline{
+		Sender: &MemorySender{},
+		mux:    mux,
+		work:   work,
+		msgs:   ircbot.NewQueue(100, config.DropNewest, 0, config.DiscardLogger),
+	}
+}
+
+// Post a fixture from testdata as source would send it, signed or with
+// testConfig's token. For GitHub and GitLab, event goes in the event header;
+// for Sentry it's the resource.
+func (p *testPipeline) Post(t *testing.T, source, event, fixture string) *httptest.ResponseRecorder {
+	t.Helper()
+	body, err := ioutil.ReadFile("testdata/" + fixture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := "/" + source
+	if source == format.SourceGitHub {
+		path = "/"
+	}
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(string(body)))
+	req.Header.Set("Content-Type", contentTypeJSON)
+	if strings.HasSuffix(fixture, ".form") {
+		req.Header.Set("Content-Type", contentTypeForm)
+	}
+	switch source {
+	case format.SourceGitHub:
+		req.Header.Set("X-GitHub-Event", event)
+		req.Header.Set("X-Hub-Signature", testutil.Sign(string(body), testGitHubSecret))
+	case format.SourceGitLab:
+		req.Header.Set("X-Gitlab-Event", event)
+		req.Header.Set("X-Gitlab-Token", testToken)
+	case SourceJenkins:
+		req.Header.Set("X-Jenkins-Token", testToken)
+	case SourceAlertmanager:
+		req.SetBasicAuth("am", testToken)
+	case SourceGrafana:
+		req.Header.Set("Authorization", "Bearer "+testToken)
+	case SourceSentry:
+		mac := hmac.New(sha256.New, []byte(testSentrySecret))
+		mac.Write(body)
+		req.Header.Set("Sentry-Hook-Resource", event)
+		req.Header.Set("Sentry-Hook-Signature", hex.EncodeToString(mac.Sum(nil)))
+	}
+	w := httptest.NewRecorder()
+	p.mux.ServeHTTP(w, req)
+	return w
+}
+
+// Let the workers at everything posted, then send what they made as main's
+// loop would, returning everything that's gone to IRC. The pipeline's done
+// with after this.
+func (p *testPipeline) Flush() []string {
+	close(p.work)
+	new(Processor).RunWorkers(context.Background(), 1, p.work, p.msgs, config.DiscardLogger) // One, so they stay in order across repos
+	out := &outputs.Broadcaster{IRC: ircbot.IRCOutput{Sender: p.Sender}}
+	schedule, digest := ircbot.NewScheduler(nil), ircbot.NewDigester()
+	for p.msgs.Stats().Depth > 0 {
+		c := config.CurrentConfig()
+		new(ircbot.Bot).Broadcast(context.Background(), out, digest.Filter(c, schedule.Filter(c, <-p.msgs.C(), config.DiscardLogger)), ircbot.NewIRCState(), config.DiscardLogger)
+	}
+	return p.Sender.Lines()
+}
+
+// Every event and action we announce by default, from its fixture to exactly
+// what goes to IRC. Anything that changes what we say shows up here.
+func TestFixtures(t *testing.T) {
+	issue := "[\x0306website\x0f] Issue #42 %s by %s: Stream relay drops out every hour. https://github.com/UniversityRadioYork/website/issues/42"
+	pr := "[\x0306website\x0f] PRQ #97 %s by LordAlex: Add the new studio to the schedule page. https://github.com/UniversityRadioYork/website/pull/97"
+	mr := "[\x0306playout\x0f] PRQ #14 %s by root: Fix the silence detector threshold. https://gitlab.example.org/ury/playout/-/merge_requests/14"
+	glIssue := "[\x0306playout\x0f] Issue #23 %s by root: Studio 2 fader start doesn't work. https://gitlab.example.org/ury/playout/-/issues/23"
+	alert := "[\x0306alerts\x0f] %s (3): HighDiskUsage on studio-pc {instance=studio-pc:9100, job=node, severity=warning} http://prometheus.example.org:9090/graph?g0.expr=disk_used"
+	sentry := "[\x0306website\x0f] New \x0304%s\x0f TypeError: Cannot read properties of undefined (reading 'show') in schedule.loadNowPlaying(app/js/schedule). https://sentry.io/organizations/ury/issues/1170820242/"
+	for _, c := range []struct {
+		source, event, fixture string
+		want                   []string // NOTICEs to #ury, one for each line, less the reset at the end
+	}{
+		{format.SourceGitHub, "issues", "issues_opened.json", []string{fmt.Sprintf(issue, "\x0303opened\x0f", "x1tot")}},
+		{format.SourceGitHub, "issues", "issues_opened.form", []string{fmt.Sprintf(issue, "\x0303opened\x0f", "x1tot")}},
+		{format.SourceGitHub, "issues", "issues_closed.json", []string{fmt.Sprintf(issue, "\x0304closed\x0f", "mstratford") + " (after moments)"}},
+		{format.SourceGitHub, "issues", "issues_reopened.json", []string{fmt.Sprintf(issue, "\x0303reopened\x0f", "mstratford")}},
+		{format.SourceGitHub, "pull_request", "pull_request_opened.json", []string{fmt.Sprintf(pr, "\x0303opened\x0f")}},
+		{format.SourceGitHub, "pull_request", "pull_request_closed.json", []string{fmt.Sprintf(pr, "\x0304closed\x0f") + " (after 23m)"}},
+		{format.SourceGitHub, "pull_request", "pull_request_reopened.json", []string{fmt.Sprintf(pr, "\x0303reopened\x0f")}},
+		{format.SourceGitHub, "pull_request", "pull_request_merged.json", []string{fmt.Sprintf(pr, "\x0302Merged\x0f") + " (after 2d4h)"}},
+		{format.SourceGitHub, "pull_request", "pull_request_merge_queue.json", []string{strings.Replace(fmt.Sprintf(pr, "\x0302Merged\x0f"), "LordAlex", "mstratford \x0314via github-merge-queue\x0f", 1) + " (after 2d4h)"}},
+		{format.SourceGitHub, "repository", "repository_created.json", []string{"x1tot \x0303created\x0f \x0306playout\x0f: https://github.com/UniversityRadioYork/playout"}},
+		{format.SourceGitHub, "star", "repository_created.json", nil},
+		{format.SourceGitHub, "issue_comment", "issue_comment.json", nil}, // Only if asked for
+		{format.SourceGitHub, "workflow_run", "workflow_run_scheduled.json", nil},
+		{format.SourceGitLab, "Push Hook", "gitlab/push.json", []string{
+			"[\x0306playout\x0f] jsmith pushed 4 commits to main. https://gitlab.example.org/ury/playout/-/compare/95790bf891e76fee5e1747ab589903a6a1f80f22...da1560886d4f094c3e6c9ef40349f7d38b5d27d7",
+			"Jordi Mallach \x0314b6568db\x0f Update Catalan translation to e38cb41.",
+			"GitLab dev user \x0314da15608\x0f fixed readme",
+			"…and 2 more",
+		}},
+		{format.SourceGitLab, "Merge Request Hook", "gitlab/merge_request_open.json", []string{fmt.Sprintf(mr, "\x0303opened\x0f")}},
+		{format.SourceGitLab, "Merge Request Hook", "gitlab/merge_request_close.json", []string{fmt.Sprintf(mr, "\x0304closed\x0f")}},
+		{format.SourceGitLab, "Merge Request Hook", "gitlab/merge_request_reopen.json", []string{fmt.Sprintf(mr, "\x0303reopened\x0f")}},
+		{format.SourceGitLab, "Merge Request Hook", "gitlab/merge_request_merge.json", []string{fmt.Sprintf(mr, "\x0302Merged\x0f")}},
+		{format.SourceGitLab, "Issue Hook", "gitlab/issue_open.json", []string{fmt.Sprintf(glIssue, "\x0303opened\x0f")}},
+		{format.SourceGitLab, "Issue Hook", "gitlab/issue_close.json", []string{fmt.Sprintf(glIssue, "\x0304closed\x0f")}},
+		{format.SourceGitLab, "Issue Hook", "gitlab/issue_reopen.json", []string{fmt.Sprintf(glIssue, "\x0303reopened\x0f")}},
+		{SourceJenkins, "", "jenkins/failure.json", []string{"[\x0306jenkins\x0f] website #142: \x0304FAILURE\x0f https://jenkins.example.org/job/website/142/"}},
+		{SourceJenkins, "", "jenkins/success.json", []string{"[\x0306jenkins\x0f] website #143: \x0303SUCCESS\x0f https://jenkins.example.org/job/website/143/"}},
+		{SourceAlertmanager, "", "alertmanager/firing.json", []string{fmt.Sprintf(alert, "\x0304FIRING\x0f")}},
+		{SourceAlertmanager, "", "alertmanager/resolved.json", []string{fmt.Sprintf(alert, "\x0303RESOLVED\x0f")}},
+		{SourceGrafana, "", "grafana/legacy.json", []string{"[\x0306grafana\x0f] \x0304ALERTING\x0f (2): Disk usage: Disk usage is above 90%. Check the music store before the breakfast show. https://grafana.example.org/d/abc123/servers?tab=alert&viewPanel=4&orgId=1"}},
+		{SourceGrafana, "", "grafana/unified.json", []string{"[\x0306grafana\x0f] \x0303RESOLVED\x0f (1): Stream down https://grafana.example.org/d/def456?viewPanel=2"}},
+		{SourceSentry, "issue", "sentry/issue_created.json", []string{fmt.Sprintf(sentry, "issue")}},
+		{SourceSentry, "error", "sentry/error_created.json", []string{fmt.Sprintf(sentry, "error")}},
+	} {
+		p := newTestPipeline(t, testConfig())
+		if w := p.Post(t, c.source, c.event, c.fixture); w.Code != http.StatusAccepted && w.Code != http.StatusNoContent {
+			t.Errorf("%s: expected it to be taken, got %d %s", c.fixture, w.Code, w.Body)
+			continue
+		}
+		var want []string
+		for _, line := range c.want {
+			want = append(want, "NOTICE #ury :"+line+"\x0f")
+		}
+		if got := p.Flush(); strings.Join(got, "\n") != strings.Join(want, "\n") {
+			t.Errorf("%s:\nexpected %q\ngot      %q", c.fixture, want, got)
+		}
+	}
+}
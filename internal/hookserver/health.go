@@ -0,0 +1,60 @@
+package hookserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/ircbot"
+	"github.com/UniversityRadioYork/CaptainHook/internal/version"
+)
+
+type healthIRC struct {
+	Connected bool   `json:"connected"`
+	Server    string `json:"server,omitempty"`
+	Channels  int    `json:"channels"`
+}
+
+type healthReport struct {
+	Status            string       `json:"status"`
+	Build             version.Info `json:"build"`
+	IRC               healthIRC    `json:"irc"`
+	QueueDepth        int          `json:"queue_depth"`
+	SinceLastDelivery *float64     `json:"seconds_since_last_delivery"`
+}
+
+// Reports whether we're in a fit state to be announcing things. Unhealthy
+// means IRC has been down for longer than grace.
+func HealthHandler(state *ircbot.IRCState, q *ircbot.Queue, grace time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			respond(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+		status := state.Status()
+		report := healthReport{
+			Status: "ok",
+			Build:  version.Current(),
+			IRC: healthIRC{
+				Connected: status.Connected,
+				Server:    status.Server,
+				Channels:  status.Channels,
+			},
+			QueueDepth: q.Stats().Depth,
+		}
+		if !status.LastDelivery.IsZero() {
+			since := time.Since(status.LastDelivery).Seconds()
+			report.SinceLastDelivery = &since
+		}
+		code := http.StatusOK
+		if !status.Connected && time.Since(status.Since) > grace {
+			report.Status = "irc down"
+			code = http.StatusServiceUnavailable
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(report)
+	}
+}
@@ -0,0 +1,32 @@
+package hookserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/config"
+	"github.com/UniversityRadioYork/CaptainHook/internal/ircbot"
+)
+
+func TestHealthHandler(t *testing.T) {
+	state := ircbot.NewIRCState()
+	q := ircbot.NewQueue(1, config.DropNewest, 0, config.DiscardLogger)
+	get := func(grace time.Duration) int {
+		rec := httptest.NewRecorder()
+		HealthHandler(state, q, grace)(rec, httptest.NewRequest("GET", "/healthz", nil))
+		return rec.Code
+	}
+
+	if code := get(time.Hour); code != http.StatusOK {
+		t.Errorf("not yet connected but within grace: expected 200, got %d", code)
+	}
+	if code := get(0); code != http.StatusServiceUnavailable {
+		t.Errorf("disconnected past grace: expected 503, got %d", code)
+	}
+	state.Connected("irc.example.org")
+	if code := get(0); code != http.StatusOK {
+		t.Errorf("connected: expected 200, got %d", code)
+	}
+}
@@ -0,0 +1,19 @@
+package hookserver
+
+import (
+	"sync"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/outputs"
+)
+
+type recordingPusher struct {
+	mu     sync.Mutex
+	pushed []outputs.Announcement
+}
+
+func (p *recordingPusher) Push(a outputs.Announcement) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.pushed = append(p.pushed, a)
+	return true
+}
@@ -0,0 +1,137 @@
+package hookserver
+
+import (
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/config"
+	"github.com/UniversityRadioYork/CaptainHook/internal/version"
+)
+
+// Passes requests on to whichever handler it was last given, so the routes
+// can change without restarting the server.
+type SwappableHandler struct {
+	h atomic.Value
+}
+
+func (s *SwappableHandler) Swap(h http.Handler) {
+	s.h.Store(&h)
+}
+
+func (s *SwappableHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	(*s.h.Load().(*http.Handler)).ServeHTTP(w, r)
+}
+
+// Build a mux serving a webhook handler per configured hook. Anything that
+// isn't a hook path gets a 404.
+func NewHookMux(c *config.Config, in *Intake, logger *slog.Logger) (*http.ServeMux, error) {
+	hooks, generic, err := c.ServedHooks()
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	handle := func(path string, h http.Handler) {
+		mux.Handle(path, PostOnly(h))
+	}
+	for _, g := range generic {
+		handle(g.Path, GenericHandler(g, in, logger))
+	}
+	if c.GitLabToken != "" {
+		gitlab := &config.Hook{
+			Name:     "gitlab",
+			Path:     "/gitlab",
+			Secrets:  []string{c.GitLabToken},
+			Channels: config.SplitChannels(c.Channels),
+		}
+		handle(gitlab.Path, GitLabHandler(gitlab, in, logger))
+	}
+	if c.JenkinsToken != "" {
+		jenkins := &config.Hook{
+			Name:     "jenkins",
+			Path:     "/jenkins",
+			Secrets:  []string{c.JenkinsToken},
+			Channels: config.SplitChannels(c.Channels),
+		}
+		handle(jenkins.Path, JenkinsHandler(jenkins, in, logger))
+	}
+	if c.AlertmanagerPassword != "" {
+		alerts := &config.Hook{
+			Name:     "alertmanager",
+			Path:     "/alertmanager",
+			Secrets:  []string{c.AlertmanagerUser + ":" + c.AlertmanagerPassword},
+			Channels: config.SplitChannels(c.Channels),
+		}
+		handle(alerts.Path, AlertmanagerHandler(alerts, in, logger))
+	}
+	if c.GrafanaToken != "" {
+		channels := c.GrafanaChannels
+		if channels == "" {
+			channels = c.Channels
+		}
+		grafana := &config.Hook{
+			Name:     "grafana",
+			Path:     "/grafana",
+			Secrets:  []string{c.GrafanaToken},
+			Channels: config.SplitChannels(channels),
+		}
+		handle(grafana.Path, GrafanaHandler(grafana, in, logger))
+	}
+	if c.SentrySecret != "" {
+		channels := c.SentryChannels
+		if channels == "" {
+			channels = c.Channels
+		}
+		events := c.SentryEvents
+		if len(events) == 0 {
+			events = defaultSentryEvents
+		}
+		sentry := &config.Hook{
+			Name:     "sentry",
+			Path:     "/sentry",
+			Secrets:  []string{c.SentrySecret},
+			Channels: config.SplitChannels(channels),
+			Events:   make(map[string]bool),
+		}
+		for _, e := range events {
+			sentry.Events[e] = true
+		}
+		handle(sentry.Path, SentryHandler(sentry, in, logger))
+	}
+	for _, h := range hooks {
+		handler := WebhookHandler(h, in, logger)
+		if h.Path == "/" {
+			// The / pattern matches everything, so be explicit.
+			root := PostOnly(handler)
+			mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/" {
+					http.NotFound(w, r)
+					return
+				}
+				root.ServeHTTP(w, r)
+			})
+			continue
+		}
+		handle(h.Path, handler)
+	}
+	return mux, nil
+}
+
+// Put webhook handlers behind a check that they're being POSTed to. Anyone
+// poking at a hook in a browser gets told what it is rather than a signature
+// error, and nothing about how we're configured.
+func PostOnly(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			h.ServeHTTP(w, r)
+		case http.MethodGet, http.MethodHead:
+			w.Header().Set("Cache-Control", "no-store")
+			respond(w, http.StatusOK, "CaptainHook "+version.Current().Version+"\n\n"+
+				"This is a webhook endpoint. POST a signed payload to it to announce it on IRC.")
+		default:
+			w.Header().Set("Allow", http.MethodPost)
+			respond(w, http.StatusMethodNotAllowed, "Method not allowed, webhooks must be POSTed")
+		}
+	})
+}
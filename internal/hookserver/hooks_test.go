@@ -0,0 +1,77 @@
+package hookserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/config"
+	"github.com/UniversityRadioYork/CaptainHook/internal/testutil"
+)
+
+func TestHookMuxRouting(t *testing.T) {
+	conf := &config.Config{
+		Channels:     "#general",
+		GHSecret:     "default-secret",
+		MaxBodyBytes: 1 << 20,
+		Hooks: []config.HookConfig{
+			{Name: "website", Path: "/hooks/website", Secret: "website-secret", Channels: "#web"},
+		},
+	}
+	config.SetConfig(conf)
+	work := make(chan Delivery, 1)
+	mux, err := NewHookMux(conf, &Intake{Work: work}, config.DiscardLogger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := `{"action":"opened","issue":{"number":1,"title":"Broken","html_url":"x"},"repository":{"name":"website"}}`
+
+	post := func(path, secret string) int {
+		req := httptest.NewRequest("POST", path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Github-Event", "issues")
+		req.Header.Set("X-Hub-Signature", testutil.Sign(body, secret))
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if code := post("/hooks/website", "default-secret"); code != http.StatusUnauthorized {
+		t.Errorf("wrong secret for path: expected 401, got %d", code)
+	}
+	if code := post("/hooks/nope", "website-secret"); code != http.StatusNotFound {
+		t.Errorf("unknown path: expected 404, got %d", code)
+	}
+}
+
+func TestHookMuxMethods(t *testing.T) {
+	conf := &config.Config{Channels: "#secret-channel", GHSecret: "default-secret", MaxBodyBytes: 1 << 20}
+	config.SetConfig(conf)
+	mux, err := NewHookMux(conf, &Intake{Work: make(chan Delivery, 1)}, config.DiscardLogger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		method, path string
+		want         int
+	}{
+		{"GET", "/", http.StatusOK},
+		{"HEAD", "/", http.StatusOK},
+		{"PUT", "/", http.StatusMethodNotAllowed},
+		{"DELETE", "/", http.StatusMethodNotAllowed},
+		{"GET", "/favicon.ico", http.StatusNotFound},
+	} {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(c.method, c.path, nil))
+		if rec.Code != c.want {
+			t.Errorf("%s %s: expected %d, got %d", c.method, c.path, c.want, rec.Code)
+		}
+		if c.want == http.StatusMethodNotAllowed && rec.Header().Get("Allow") != "POST" {
+			t.Errorf("%s %s: expected Allow: POST, got %q", c.method, c.path, rec.Header().Get("Allow"))
+		}
+		if strings.Contains(rec.Body.String(), "secret") {
+			t.Errorf("%s %s: info page leaks configuration: %q", c.method, c.path, rec.Body)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package hookserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/config"
+)
+
+// Where GitHub publishes the address ranges its webhooks come from.
+const gitHubMetaURL = "https://api.github.com/meta"
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Work out who actually sent a request. Forwarding headers are only believed
+// when the immediate peer is one of our trusted proxies, otherwise anyone
+// could claim to be GitHub. A peer with no IP at all ("@" for a Unix socket)
+// can only be whatever's allowed to open the socket, so that counts as a
+// trusted proxy too.
+func ClientIP(r *http.Request, trusted []*net.IPNet) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	peer := net.ParseIP(host)
+	if peer != nil && !containsIP(trusted, peer) {
+		return peer
+	}
+	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+		hops := strings.Split(xff, ",")
+		var last net.IP
+		for i := len(hops) - 1; i >= 0; i-- {
+			ip := net.ParseIP(strings.TrimSpace(hops[i]))
+			if ip == nil {
+				last = nil
+				break
+			}
+			if !containsIP(trusted, ip) {
+				return ip
+			}
+			last = ip
+		}
+		if last != nil {
+			return last // Proxies all the way down, so the furthest we can see
+		}
+	}
+	if ip := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); ip != nil {
+		return ip
+	}
+	return peer
+}
+
+// Restricts requests to GitHub's published hook addresses plus a static list.
+type IPAllowlist struct {
+	MetaURL string
+	Static  []*net.IPNet
+	Trusted []*net.IPNet
+
+	mu    sync.RWMutex
+	hooks []*net.IPNet
+}
+
+func NewIPAllowlist(c *config.Config) (*IPAllowlist, error) {
+	static, err := config.ParseCIDRs(c.AllowIPs)
+	if err != nil {
+		return nil, fmt.Errorf("AllowIPs: %v", err)
+	}
+	trusted, err := config.ParseCIDRs(c.TrustedProxies)
+	if err != nil {
+		return nil, fmt.Errorf("TrustedProxies: %v", err)
+	}
+	return &IPAllowlist{MetaURL: gitHubMetaURL, Static: static, Trusted: trusted}, nil
+}
+
+// Fetch the current hook ranges from GitHub's meta API.
+func (a *IPAllowlist) Refresh() error {
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Get(a.MetaURL)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("meta API returned %s", resp.Status)
+	}
+	var meta struct {
+		Hooks []string
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&meta); err != nil {
+		return err
+	}
+	hooks, err := config.ParseCIDRs(meta.Hooks)
+	if err != nil {
+		return err
+	}
+	if len(hooks) == 0 {
+		return fmt.Errorf("meta API returned no hook ranges")
+	}
+	a.mu.Lock()
+	a.hooks = hooks
+	a.mu.Unlock()
+	return nil
+}
+
+// Keep the hook ranges fresh. Failures keep whatever we had last time.
+func (a *IPAllowlist) Run(interval time.Duration, logger *slog.Logger) {
+	for {
+		if err := a.Refresh(); err != nil {
+			logger.Error("Error fetching GitHub hook addresses", "err", err)
+		}
+		time.Sleep(interval)
+	}
+}
+
+func (a *IPAllowlist) Allowed(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	if containsIP(a.Static, ip) {
+		return true
+	}
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return containsIP(a.hooks, ip)
+}
+
+// Reject anything not from an allowed address before we bother reading it.
+func (a *IPAllowlist) Middleware(h http.Handler, logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := ClientIP(r, a.Trusted)
+		if !a.Allowed(ip) {
+			logger.Warn("Rejecting request from disallowed address", "ip", ip)
+			respond(w, http.StatusForbidden, "Forbidden")
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,75 @@
+package hookserver
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/config"
+)
+
+func TestIPAllowlistRefresh(t *testing.T) {
+	meta := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"hooks":["192.30.252.0/22","2620:112:3000::/44"]}`)
+	}))
+	defer meta.Close()
+
+	static, _ := config.ParseCIDRs([]string{"10.0.0.5"})
+	a := &IPAllowlist{MetaURL: meta.URL, Static: static}
+	if a.Allowed(net.ParseIP("192.30.252.1")) {
+		t.Error("allowed a hook address before fetching the ranges")
+	}
+	if err := a.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	for ip, want := range map[string]bool{
+		"192.30.252.1":     true,
+		"2620:112:3000::1": true,
+		"10.0.0.5":         true,
+		"10.0.0.6":         false,
+		"8.8.8.8":          false,
+	} {
+		if got := a.Allowed(net.ParseIP(ip)); got != want {
+			t.Errorf("Allowed(%s) = %v, expected %v", ip, got, want)
+		}
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	trusted, _ := config.ParseCIDRs([]string{"127.0.0.1", "10.0.0.0/8"})
+	cases := []struct {
+		name   string
+		peer   string
+		xff    string
+		realIP string
+		want   string
+	}{
+		{"no proxy", "203.0.113.9:1234", "", "", "203.0.113.9"},
+		{"spoofed from untrusted peer", "203.0.113.9:1234", "192.30.252.1", "192.30.252.2", "203.0.113.9"},
+		{"trusted proxy", "127.0.0.1:1234", "192.30.252.1", "", "192.30.252.1"},
+		{"chained proxies", "127.0.0.1:1234", "192.30.252.1, 10.0.0.2, 10.0.0.3", "", "192.30.252.1"},
+		{"spoofed hop before the real client", "127.0.0.1:1234", "1.2.3.4, 203.0.113.9, 10.0.0.2", "", "203.0.113.9"},
+		{"only proxies", "127.0.0.1:1234", "10.0.0.2, 10.0.0.3", "", "10.0.0.2"},
+		{"garbage hop", "127.0.0.1:1234", "nonsense, 10.0.0.2", "192.30.252.1", "192.30.252.1"},
+		{"x-real-ip", "127.0.0.1:1234", "", "192.30.252.1", "192.30.252.1"},
+		{"trusted proxy, no headers", "127.0.0.1:1234", "", "", "127.0.0.1"},
+		{"unix socket", "@", "192.30.252.1", "", "192.30.252.1"},
+		{"unix socket, x-real-ip", "@", "", "192.30.252.1", "192.30.252.1"},
+		{"unix socket, no headers", "@", "", "", "<nil>"},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest("POST", "/", nil)
+		r.RemoteAddr = c.peer
+		if c.xff != "" {
+			r.Header.Set("X-Forwarded-For", c.xff)
+		}
+		if c.realIP != "" {
+			r.Header.Set("X-Real-IP", c.realIP)
+		}
+		if got := ClientIP(r, trusted); got.String() != c.want {
+			t.Errorf("%s: expected %s, got %v", c.name, c.want, got)
+		}
+	}
+}
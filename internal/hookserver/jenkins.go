@@ -0,0 +1,97 @@
+package hookserver
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sync"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/config"
+	"github.com/UniversityRadioYork/CaptainHook/internal/format"
+	"github.com/UniversityRadioYork/CaptainHook/internal/metrics"
+)
+
+const SourceJenkins = "jenkins"
+
+// What the Jenkins Notification plugin sends us (partially).
+type JenkinsEvent struct {
+	Name  string
+	Build struct {
+		FullURL string `json:"full_url"`
+		Number  int
+		Phase   string
+		Status  string
+	}
+}
+
+// Remembers the last result for each job, so we can tell when a build has
+// been fixed.
+type buildTracker struct {
+	mu   sync.Mutex
+	last map[string]string
+}
+
+var jenkinsBuilds = &buildTracker{last: make(map[string]string)}
+
+// Record a job's latest result, returning the previous one.
+func (b *buildTracker) record(job, status string) string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	prev := b.last[job]
+	b.last[job] = status
+	return prev
+}
+
+// Turn a Jenkins notification into an Event. Only finished builds are
+// announced, and with notify set to "failures" only the ones that failed or
+// fixed a failure.
+func ParseJenkinsEvent(body []byte, notify string) (*format.Event, error) {
+	var event JenkinsEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		return nil, err
+	}
+	if event.Build.Phase != "FINALIZED" {
+		return nil, nil
+	}
+	prev := jenkinsBuilds.record(event.Name, event.Build.Status)
+	if notify == config.JenkinsNotifyFailures && event.Build.Status == "SUCCESS" && (prev == "" || prev == "SUCCESS") {
+		return nil, nil
+	}
+	return &format.Event{
+		Source: SourceJenkins,
+		Type:   "build",
+		Action: event.Build.Status,
+		Repo:   event.Name,
+		Number: event.Build.Number,
+		URL:    event.Build.FullURL,
+	}, nil
+}
+
+// Handles build notifications from Jenkins. The plugin can't sign anything,
+// so a shared token goes in the X-Jenkins-Token header or token query
+// parameter instead.
+func JenkinsHandler(hook *config.Hook, in *Intake, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, _, ok := readBody(w, r, logger)
+		if !ok {
+			return
+		}
+		token := r.Header.Get("X-Jenkins-Token")
+		if token == "" {
+			token = r.URL.Query().Get("token")
+		}
+		if !matchToken(token, hook.Secrets) {
+			logger.Warn("Invalid token", "hook", hook.Name, "ip", requestIP(r))
+			metrics.DeliveriesTotal.WithLabelValues(metrics.UnverifiedEvent, metrics.OutcomeBadSignature).Inc()
+			respond(w, http.StatusUnauthorized, "Invalid token")
+			return
+		}
+		enqueue(w, r, Delivery{
+			Source:  SourceJenkins,
+			Hook:    hook,
+			Event:   "build",
+			Header:  r.Header.Clone(),
+			Payload: body,
+		}, in, logger)
+	}
+}
@@ -0,0 +1,51 @@
+package hookserver
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/config"
+)
+
+func TestParseJenkinsEvent(t *testing.T) {
+
+	body, err := ioutil.ReadFile("testdata/jenkins/failure.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := ParseJenkinsEvent(body, config.JenkinsNotifyAll)
+	if err != nil || e == nil {
+		t.Fatalf("expected an event, got %v", err)
+	}
+	want := "[\x0306jenkins\x0f] website #142: \x0304FAILURE\x0f https://jenkins.example.org/job/website/142/\x0f"
+	if got := config.FormatEvent(e, config.DiscardLogger); got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
+
+func TestJenkinsNotifyFailures(t *testing.T) {
+	build := func(phase, status string) []byte {
+		return []byte(`{"name":"playout","build":{"number":1,"phase":"` + phase + `","status":"` + status + `"}}`)
+	}
+	steps := []struct {
+		phase, status string
+		announce      bool
+	}{
+		{"STARTED", "", false},
+		{"FINALIZED", "SUCCESS", false},
+		{"FINALIZED", "FAILURE", true},
+		{"FINALIZED", "FAILURE", true},
+		{"FINALIZED", "SUCCESS", true},
+		{"FINALIZED", "SUCCESS", false},
+	}
+	for i, s := range steps {
+		e, err := ParseJenkinsEvent(build(s.phase, s.status), config.JenkinsNotifyFailures)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (e != nil) != s.announce {
+			t.Errorf("step %d (%s %s): expected announce=%v", i, s.phase, strings.ToLower(s.status), s.announce)
+		}
+	}
+}
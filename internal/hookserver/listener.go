@@ -0,0 +1,147 @@
+package hookserver
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The first file descriptor systemd passes us under socket activation.
+const listenFDsStart = 3
+
+// Open whatever we've been told to serve webhooks on: a socket passed by
+// systemd, a Unix socket for hostPort "unix:/path", or TCP. Unix sockets are
+// created with mode (octal, e.g. "0660") and group, if set, replacing any
+// stale socket left behind; closing the listener removes it again.
+func Listen(hostPort, mode, group string) (net.Listener, string, error) {
+	if ln, err := systemdListener(); ln != nil || err != nil {
+		return ln, "systemd socket", err
+	}
+	if !strings.HasPrefix(hostPort, "unix:") {
+		ln, err := net.Listen("tcp", hostPort)
+		return ln, hostPort, err
+	}
+	path := strings.TrimPrefix(hostPort, "unix:")
+	if fi, err := os.Lstat(path); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return nil, hostPort, fmt.Errorf("%s exists and isn't a socket", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, hostPort, err
+		}
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, hostPort, err
+	}
+	if err := setSocketPerms(path, mode, group); err != nil {
+		ln.Close()
+		return nil, hostPort, err
+	}
+	return ln, hostPort, nil
+}
+
+func setSocketPerms(path, mode, group string) error {
+	if mode != "" {
+		m, err := strconv.ParseUint(mode, 8, 32)
+		if err != nil {
+			return fmt.Errorf("bad SocketMode %q: %v", mode, err)
+		}
+		if err := os.Chmod(path, os.FileMode(m)); err != nil {
+			return err
+		}
+	}
+	if group != "" {
+		g, err := user.LookupGroup(group)
+		if err != nil {
+			return err
+		}
+		gid, err := strconv.Atoi(g.Gid)
+		if err != nil {
+			return err
+		}
+		if err := os.Chown(path, -1, gid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Pick up a listening socket from systemd socket activation, if there is one.
+func systemdListener() (net.Listener, error) {
+	if os.Getenv("LISTEN_PID") != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n < 1 {
+		return nil, nil
+	}
+	// Don't let anything we start think these are meant for it.
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+	f := os.NewFile(listenFDsStart, "systemd")
+	defer f.Close()
+	ln, err := net.FileListener(f)
+	if err != nil {
+		return nil, fmt.Errorf("using systemd socket: %v", err)
+	}
+	return ln, nil
+}
+
+// For http.Server.BaseContext: requests get ctx, so handlers give up on
+// anything slow when we're shutting down.
+func BaseContext(ctx context.Context) func(net.Listener) context.Context {
+	return func(net.Listener) context.Context { return ctx }
+}
+
+// Keeps track of connections an http.Server has accepted but not read a
+// request from yet, via its ConnState. Shutdown hangs up on those without
+// answering, and that could be a delivery.
+type NewConns struct {
+	mu    sync.Mutex
+	conns map[net.Conn]bool
+}
+
+func (n *NewConns) ConnState(c net.Conn, state http.ConnState) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.conns == nil {
+		n.conns = make(map[net.Conn]bool)
+	}
+	if state == http.StateNew {
+		n.conns[c] = true
+	} else {
+		delete(n.conns, c)
+	}
+}
+
+func (n *NewConns) Len() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return len(n.conns)
+}
+
+// Shut down srv, which is serving on ln and tracking conns, without hanging
+// up on anyone: stop accepting, wait for the requests on connections it's
+// already accepted to come in, then Shutdown as usual. Whoever's serving on
+// ln gets net.ErrClosed rather than http.ErrServerClosed.
+func ShutdownGently(ctx context.Context, srv *http.Server, ln net.Listener, conns *NewConns) error {
+	ln.Close()
+	tick := time.NewTicker(10 * time.Millisecond)
+	defer tick.Stop()
+	for conns.Len() > 0 && ctx.Err() == nil {
+		select {
+		case <-ctx.Done():
+		case <-tick.C:
+		}
+	}
+	return srv.Shutdown(ctx)
+}
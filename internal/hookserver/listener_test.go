@@ -0,0 +1,56 @@
+package hookserver
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestListenUnixSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "listen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "http.sock")
+
+	// Leave a stale socket behind, as after a crash.
+	stale, _, err := Listen("unix:"+path, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l, ok := stale.(interface{ SetUnlinkOnClose(bool) }); ok {
+		l.SetUnlinkOnClose(false)
+	}
+	stale.Close()
+
+	ln, _, err := Listen("unix:"+path, "0600", "")
+	if err != nil {
+		t.Fatalf("expected the stale socket to be replaced, got %v", err)
+	}
+	fi, err := os.Stat(path)
+	if err != nil || fi.Mode().Perm() != 0600 {
+		t.Errorf("expected mode 0600, got %v %v", fi.Mode(), err)
+	}
+	ln.Close()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("expected the socket to be removed on close, got %v", err)
+	}
+}
+
+func TestListenRefusesToReplaceFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "listen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "important.txt")
+	ioutil.WriteFile(path, []byte("don't delete me"), 0600)
+	if _, _, err := Listen("unix:"+path, "", ""); err == nil {
+		t.Errorf("expected an error rather than replacing a regular file")
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("file was removed: %v", err)
+	}
+}
@@ -0,0 +1,27 @@
+package hookserver
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/config"
+	"github.com/UniversityRadioYork/CaptainHook/internal/testutil"
+)
+
+// Bad signatures are warnings, with where they came from.
+func TestBadSignatureLogged(t *testing.T) {
+	conf := &config.Config{GHSecret: "hunter2", MaxBodyBytes: 1 << 20}
+	config.SetConfig(conf)
+	var buf bytes.Buffer
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("{}"))
+	req.RemoteAddr = "192.0.2.7:1234"
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Hub-Signature", testutil.Sign("{}", "wrong"))
+	WebhookHandler(conf.DefaultHook(), &Intake{Work: make(chan Delivery, 1)}, config.NewLogger(&buf, "text"))(httptest.NewRecorder(), req)
+	if got := buf.String(); !strings.Contains(got, "level=WARN") || !strings.Contains(got, "ip=192.0.2.7") {
+		t.Errorf("expected a warning with the IP, got %q", got)
+	}
+}
@@ -0,0 +1,51 @@
+package hookserver
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/config"
+	"github.com/UniversityRadioYork/CaptainHook/internal/ircbot"
+	"github.com/UniversityRadioYork/CaptainHook/internal/metrics"
+	"github.com/UniversityRadioYork/CaptainHook/internal/testutil"
+)
+
+func TestMetricsAfterDelivery(t *testing.T) {
+	conf := &config.Config{Channels: "#test", GHSecret: "hunter2", MaxBodyBytes: 1 << 20}
+	config.SetConfig(conf)
+	logger := config.DiscardLogger
+
+	body, err := ioutil.ReadFile("testdata/issues_opened.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	work := make(chan Delivery, 1)
+	req := httptest.NewRequest("POST", "/", strings.NewReader(string(body)))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Github-Event", "issues")
+	req.Header.Set("X-Hub-Signature", testutil.Sign(string(body), "hunter2"))
+	WebhookHandler(conf.DefaultHook(), &Intake{Work: work}, logger).ServeHTTP(httptest.NewRecorder(), req)
+	close(work)
+	new(Processor).Worker(context.Background(), work, ircbot.NewQueue(1, config.DropNewest, 0, logger), logger)
+
+	families, err := metrics.Registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make(map[string]bool)
+	for _, f := range families {
+		names[f.GetName()] = true
+	}
+	for _, name := range []string{
+		"capthook_webhook_deliveries_total",
+		"capthook_webhook_processed_total",
+		"capthook_shortener_duration_seconds",
+	} {
+		if !names[name] {
+			t.Errorf("metric %s missing after a delivery", name)
+		}
+	}
+}
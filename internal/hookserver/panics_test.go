@@ -0,0 +1,68 @@
+package hookserver
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/config"
+	"github.com/UniversityRadioYork/CaptainHook/internal/config/configtest"
+	"github.com/UniversityRadioYork/CaptainHook/internal/metrics"
+	"github.com/UniversityRadioYork/CaptainHook/internal/testutil"
+)
+
+func TestRecovererPanic(t *testing.T) {
+	before := promtestutil.ToFloat64(metrics.PanicsTotal.WithLabelValues("http"))
+	h := Recoverer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/boom" {
+			var hook *config.Hook
+			hook.Wants("push") // A nil pointer, as a bad payload might give us
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}), config.DiscardLogger)
+	for _, c := range []struct {
+		path string
+		want int
+	}{{"/boom", http.StatusInternalServerError}, {"/", http.StatusNoContent}} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("POST", c.path, nil))
+		if rec.Code != c.want {
+			t.Errorf("%s: expected %d, got %d", c.path, c.want, rec.Code)
+		}
+	}
+	if got := promtestutil.ToFloat64(metrics.PanicsTotal.WithLabelValues("http")) - before; got != 1 {
+		t.Errorf("expected 1 panic counted, got %v", got)
+	}
+}
+
+func TestWorkerPanic(t *testing.T) {
+	payload, err := ioutil.ReadFile("testdata/issues_opened.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := configtest.Valid()
+	if errs := c.Validate(); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	c.LinkShortener = &testutil.PanickyShortener{Shorts: map[string]bool{"https://github.com/UniversityRadioYork/website/issues/42": true}}
+	config.SetConfig(c)
+	before := promtestutil.ToFloat64(metrics.PanicsTotal.WithLabelValues("worker"))
+	hook := &config.Hook{Channels: []string{"#a"}}
+	work := make(chan Delivery, 2)
+	work <- Delivery{Hook: hook, Event: "issues", Payload: payload}
+	work <- Delivery{Hook: hook, Event: "issues", Payload: []byte(strings.Replace(string(payload), "/issues/42", "/issues/43", -1))}
+	close(work)
+	msgs := &recordingPusher{}
+	new(Processor).Worker(context.Background(), work, msgs, config.DiscardLogger)
+	if len(msgs.pushed) != 1 || !strings.HasSuffix(msgs.pushed[0].Text, "/issues/43\x0f") {
+		t.Errorf("expected the worker to carry on after a panic, got %+v", msgs.pushed)
+	}
+	if got := promtestutil.ToFloat64(metrics.PanicsTotal.WithLabelValues("worker")) - before; got != 1 {
+		t.Errorf("expected 1 panic counted, got %v", got)
+	}
+}
@@ -0,0 +1,112 @@
+package hookserver
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/metrics"
+)
+
+// The most addresses we'll keep buckets for. Past this, new addresses share
+// one bucket, so a flood of spoofed sources can't eat our memory.
+const maxRateLimitEntries = 10000
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// A token bucket per client address, so scanners can't make us do a body
+// read and HMAC for every probe.
+type RateLimiter struct {
+	Rate   float64 // Tokens added per second
+	Burst  float64 // Most tokens a bucket holds
+	Exempt []*net.IPNet
+
+	mu       sync.Mutex
+	buckets  map[string]*bucket
+	overflow bucket
+	now      func() time.Time
+}
+
+func NewRateLimiter(rate float64, burst int, exempt []*net.IPNet) *RateLimiter {
+	return &RateLimiter{
+		Rate:     rate,
+		Burst:    float64(burst),
+		Exempt:   exempt,
+		buckets:  make(map[string]*bucket),
+		overflow: bucket{tokens: float64(burst)},
+		now:      time.Now,
+	}
+}
+
+// Change the limits, e.g. on a config reload. A rate of 0 turns limiting
+// off. Buckets carry on from where they were.
+func (l *RateLimiter) SetLimits(rate float64, burst int, exempt []*net.IPNet) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.Rate, l.Burst, l.Exempt = rate, float64(burst), exempt
+	if l.overflow.tokens > l.Burst {
+		l.overflow.tokens = l.Burst
+	}
+}
+
+// Take a token for ip if there is one, otherwise say how long until there
+// will be.
+func (l *RateLimiter) Allow(ip net.IP) (bool, time.Duration) {
+	now := l.now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.Rate <= 0 || containsIP(l.Exempt, ip) {
+		return true, 0
+	}
+	key := ip.String()
+	b, ok := l.buckets[key]
+	if !ok {
+		if len(l.buckets) >= maxRateLimitEntries {
+			l.sweep(now)
+		}
+		if len(l.buckets) >= maxRateLimitEntries {
+			b = &l.overflow
+		} else {
+			b = &bucket{tokens: l.Burst, last: now}
+			l.buckets[key] = b
+		}
+	}
+	b.tokens = math.Min(l.Burst, b.tokens+now.Sub(b.last).Seconds()*l.Rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / l.Rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// Forget buckets that have filled back up, since they're no different to a
+// new one. Call with mu held.
+func (l *RateLimiter) sweep(now time.Time) {
+	full := time.Duration(l.Burst / l.Rate * float64(time.Second))
+	for k, b := range l.buckets {
+		if now.Sub(b.last) >= full {
+			delete(l.buckets, k)
+		}
+	}
+}
+
+// Turn away clients that have run out of tokens with a 429, before we do
+// any work for them.
+func (l *RateLimiter) Middleware(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ok, wait := l.Allow(requestIP(r)); !ok {
+			metrics.RateLimited.Inc()
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			respond(w, http.StatusTooManyRequests, "Too many requests")
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,89 @@
+package hookserver
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/config"
+)
+
+func TestRateLimiter(t *testing.T) {
+	exempt, _ := config.ParseCIDRs([]string{"192.30.252.0/22"})
+	l := NewRateLimiter(1, 2, exempt)
+	now := time.Now()
+	l.now = func() time.Time { return now }
+
+	scanner := net.ParseIP("203.0.113.9")
+	for i := 0; i < 2; i++ {
+		if ok, _ := l.Allow(scanner); !ok {
+			t.Fatalf("request %d: expected the burst to be allowed", i)
+		}
+	}
+	ok, wait := l.Allow(scanner)
+	if ok || wait != time.Second {
+		t.Errorf("expected to be limited for 1s, got %v %v", ok, wait)
+	}
+	if ok, _ := l.Allow(net.ParseIP("198.51.100.1")); !ok {
+		t.Errorf("expected other addresses to have their own bucket")
+	}
+	for i := 0; i < 10; i++ {
+		if ok, _ := l.Allow(net.ParseIP("192.30.252.1")); !ok {
+			t.Fatalf("expected exempt addresses never to be limited")
+		}
+	}
+	now = now.Add(time.Second)
+	if ok, _ := l.Allow(scanner); !ok {
+		t.Errorf("expected a token back after a second")
+	}
+}
+
+func TestRateLimiterBounded(t *testing.T) {
+	l := NewRateLimiter(1, 1, nil)
+	now := time.Now()
+	l.now = func() time.Time { return now }
+	for i := 0; i < maxRateLimitEntries+100; i++ {
+		l.Allow(net.IPv4(10, byte(i>>16), byte(i>>8), byte(i)))
+	}
+	if len(l.buckets) > maxRateLimitEntries {
+		t.Errorf("expected at most %d buckets, got %d", maxRateLimitEntries, len(l.buckets))
+	}
+	// Once they've all refilled there's room again.
+	now = now.Add(time.Minute)
+	l.Allow(net.ParseIP("203.0.113.9"))
+	if _, ok := l.buckets["203.0.113.9"]; !ok {
+		t.Errorf("expected stale buckets to be swept")
+	}
+}
+
+func TestRateLimiterMiddleware(t *testing.T) {
+	h := NewRateLimiter(1, 1, nil).Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	codes := []int{http.StatusOK, http.StatusTooManyRequests}
+	for i, want := range codes {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("POST", "/", nil))
+		if rec.Code != want {
+			t.Errorf("request %d: expected %d, got %d", i, want, rec.Code)
+		}
+		if want == http.StatusTooManyRequests && rec.Header().Get("Retry-After") != "1" {
+			t.Errorf("expected Retry-After: 1, got %q", rec.Header().Get("Retry-After"))
+		}
+	}
+}
+
+func TestRateLimiterSetLimits(t *testing.T) {
+	l := NewRateLimiter(0, 1, nil)
+	ip := net.ParseIP("203.0.113.9")
+	for i := 0; i < 5; i++ {
+		if ok, _ := l.Allow(ip); !ok {
+			t.Fatalf("request %d: expected no limit with a rate of 0", i)
+		}
+	}
+	l.SetLimits(1, 1, nil)
+	l.Allow(ip)
+	if ok, _ := l.Allow(ip); ok {
+		t.Errorf("expected to be limited once turned on")
+	}
+}
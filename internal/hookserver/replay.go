@@ -0,0 +1,87 @@
+package hookserver
+
+import (
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/config"
+	"github.com/UniversityRadioYork/CaptainHook/internal/format"
+)
+
+// Runs a payload through the usual formatting and routing without it having
+// to come from GitHub, for working on formatters. Either POST a payload with
+// an X-Github-Event (or X-Gitlab-Event) header, or ?id= an archived delivery.
+// With ?dry=1 nothing reaches IRC; either way the response has what would be
+// said where. Nothing here is authenticated, so keep it off the internet.
+func ReplayHandler(hooks []*config.Hook, archive *Archive, p *Processor, msgs Pusher, logger *slog.Logger) http.HandlerFunc {
+	byName := make(map[string]*config.Hook)
+	for _, h := range hooks {
+		byName[h.Name] = h
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			respond(w, http.StatusMethodNotAllowed, "POST a payload to replay it")
+			return
+		}
+		var d Delivery
+		if id := r.URL.Query().Get("id"); id != "" {
+			if archive == nil {
+				respond(w, http.StatusNotFound, "No PayloadArchiveDir configured")
+				return
+			}
+			entry, payload, err := archive.Find(id)
+			if err != nil {
+				respond(w, http.StatusNotFound, err.Error())
+				return
+			}
+			d = Delivery{
+				Source:  entry.Source,
+				Hook:    byName[entry.Hook],
+				Event:   entry.Event,
+				ID:      entry.ID,
+				Header:  entry.Header,
+				Payload: payload,
+			}
+		} else {
+			body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, config.CurrentConfig().MaxBodyBytes))
+			if err != nil {
+				respond(w, http.StatusBadRequest, "Error reading body: "+err.Error())
+				return
+			}
+			d = Delivery{Source: format.SourceGitHub, Event: r.Header.Get("X-Github-Event"), Header: r.Header, Payload: body}
+			if ev := r.Header.Get("X-Gitlab-Event"); ev != "" {
+				d.Source, d.Event = format.SourceGitLab, ev
+			}
+			if d.Event == "" {
+				respond(w, http.StatusBadRequest, "Missing X-Github-Event header")
+				return
+			}
+		}
+		if name := r.URL.Query().Get("hook"); name != "" {
+			d.Hook = byName[name]
+		}
+		if d.Hook == nil {
+			d.Hook = hooks[0]
+		}
+		a, ok, err := p.ProcessDelivery(r.Context(), d, logger)
+		if err != nil {
+			respond(w, http.StatusUnprocessableEntity, "Error processing payload: "+err.Error())
+			return
+		}
+		if !ok {
+			respond(w, http.StatusOK, "Nothing to announce for this "+d.Event+" event")
+			return
+		}
+		if r.URL.Query().Get("dry") != "1" {
+			msgs.Push(a)
+		}
+		var lines []string
+		for _, ch := range a.Channels {
+			lines = append(lines, ch+" "+a.Text)
+		}
+		respond(w, http.StatusOK, strings.Join(lines, "\n"))
+	}
+}
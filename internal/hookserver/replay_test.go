@@ -0,0 +1,71 @@
+package hookserver
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/config"
+	"github.com/UniversityRadioYork/CaptainHook/internal/format"
+)
+
+func TestReplay(t *testing.T) {
+	config.SetConfig(&config.Config{MaxBodyBytes: 1 << 20})
+	body, err := ioutil.ReadFile("testdata/issues_opened.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hooks := []*config.Hook{{Name: "default", Channels: []string{"#a"}}, {Name: "web", Channels: []string{"#web"}}}
+
+	for _, c := range []struct {
+		name, query string
+		pushed      int
+		channel     string
+	}{
+		{"live", "", 1, "#a"},
+		{"dry run", "?dry=1", 0, "#a"},
+		{"other hook", "?hook=web", 1, "#web"},
+	} {
+		p := &recordingPusher{}
+		req := httptest.NewRequest("POST", "/replay"+c.query, strings.NewReader(string(body)))
+		req.Header.Set("X-Github-Event", "issues")
+		rec := httptest.NewRecorder()
+		ReplayHandler(hooks, nil, new(Processor), p, config.DiscardLogger)(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: expected 200, got %d: %s", c.name, rec.Code, rec.Body)
+		}
+		if len(p.pushed) != c.pushed {
+			t.Errorf("%s: expected %d announcements, got %d", c.name, c.pushed, len(p.pushed))
+		}
+		if !strings.HasPrefix(rec.Body.String(), c.channel+" [") {
+			t.Errorf("%s: expected the rendered message for %s, got %q", c.name, c.channel, rec.Body)
+		}
+	}
+}
+
+func TestReplayFromArchive(t *testing.T) {
+	dir, err := ioutil.TempDir("", "archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	archive, err := NewArchive(dir, 0, 0, config.DiscardLogger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadFile("testdata/issues_opened.json")
+	archive.Save(Delivery{Source: format.SourceGitHub, Event: "issues", ID: "abc", Payload: body})
+	archive.Close()
+
+	hooks := []*config.Hook{{Name: "default", Channels: []string{"#a"}}}
+	for id, want := range map[string]int{"abc": http.StatusOK, "nope": http.StatusNotFound} {
+		rec := httptest.NewRecorder()
+		ReplayHandler(hooks, archive, new(Processor), &recordingPusher{}, config.DiscardLogger)(rec, httptest.NewRequest("POST", "/replay?dry=1&id="+id, nil))
+		if rec.Code != want {
+			t.Errorf("%s: expected %d, got %d: %s", id, want, rec.Code, rec.Body)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package hookserver
+
+import (
+	"context"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/config"
+	"github.com/UniversityRadioYork/CaptainHook/internal/config/configtest"
+	"github.com/UniversityRadioYork/CaptainHook/internal/format"
+)
+
+func TestProcessDeliveryRepoChannels(t *testing.T) {
+	c := configtest.Valid()
+	c.Repos = map[string]config.RepoConfig{"ury/website": {Channels: "#web"}}
+	if errs := c.Validate(); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	config.SetConfig(c)
+	hook := &config.Hook{Name: "test", Channels: []string{"#a"}}
+	for repo, want := range map[string]string{"ury/website": "#web", "ury/other": "#a"} {
+		body := `{"action":"opened","issue":{"number":1,"title":"Hi"},"repository":{"name":"x","full_name":"` + repo + `"}}`
+		a, ok, err := new(Processor).ProcessDelivery(context.Background(), Delivery{Hook: hook, Event: "issues", Payload: []byte(body)}, config.DiscardLogger)
+		if err != nil || !ok {
+			t.Fatalf("%s: expected an announcement, got %v %v", repo, ok, err)
+		}
+		if got := strings.Join(a.Channels, ","); got != want {
+			t.Errorf("%s: expected %s, got %s", repo, want, got)
+		}
+	}
+}
+
+// Org hooks' events without a repo go by the org's entry, and nothing else.
+func TestProcessDeliveryOrgChannels(t *testing.T) {
+	c := configtest.Valid()
+	c.Repos = map[string]config.RepoConfig{"ury-bots": {Channels: "#bots"}, "ury-bots/website": {Channels: "#web"}, "*/*": {Channels: "#all"}}
+	c.Events = map[string][]string{"ping": {"*"}}
+	if errs := c.Validate(); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	config.SetConfig(c)
+	hook := &config.Hook{Name: "test", Channels: []string{"#a"}}
+	for _, f := range []struct{ fixture, event, want string }{
+		{"org/organization_member_added.json", "organization", "[ury-bots] x1tot added member mchen https://github.com/mchen"},
+		{"org/ping.json", "ping", "[ury-bots] Webhook set up by x1tot for issues, organization, pull_request, push: Keep it logically awesome."},
+	} {
+		payload, err := ioutil.ReadFile("testdata/" + f.fixture)
+		if err != nil {
+			t.Fatal(err)
+		}
+		a, ok, err := new(Processor).ProcessDelivery(context.Background(), Delivery{Hook: hook, Event: f.event, Source: format.SourceGitHub, Payload: payload}, config.DiscardLogger)
+		if err != nil || !ok {
+			t.Fatalf("%s: expected an announcement, got %v %v", f.fixture, ok, err)
+		}
+		if got := strings.Join(a.Channels, ","); got != "#bots" {
+			t.Errorf("%s: expected #bots, got %s", f.fixture, got)
+		}
+		if got := format.StripFormatting(a.Text); got != f.want {
+			t.Errorf("%s: expected %q, got %q", f.fixture, f.want, got)
+		}
+		if a.Prefix == "" || a.FullName != "ury-bots" {
+			t.Errorf("%s: expected the org as the prefix, got %q for %q", f.fixture, a.Prefix, a.FullName)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package hookserver
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/ircbot"
+)
+
+// On SIGUSR2 we start a new copy of ourselves and hand it our listening
+// sockets, so deliveries keep being accepted all the way through a restart.
+// It tells us over a pipe once it's on IRC, then we shut down as usual.
+const (
+	envListeners = "CAPTHOOK_LISTENERS" // Sockets passed to us, e.g. "webhooks:3,metrics:4"
+	envReady     = "CAPTHOOK_READY_FD"
+)
+
+// What the process we're taking over from passed us, if anything.
+type Handoff struct {
+	Listeners map[string]net.Listener // By name: webhooks, metrics or replay
+	ready     *os.File
+}
+
+// Pick up anything passed to us by the process we're taking over from.
+func TakeHandoff() (*Handoff, error) {
+	h := &Handoff{Listeners: make(map[string]net.Listener)}
+	listeners, ready := os.Getenv(envListeners), os.Getenv(envReady)
+	// Don't let anything we start think these are meant for it.
+	os.Unsetenv(envListeners)
+	os.Unsetenv(envReady)
+	if listeners != "" {
+		for _, l := range strings.Split(listeners, ",") {
+			i := strings.LastIndex(l, ":")
+			fd, err := strconv.Atoi(l[i+1:])
+			if i < 0 || err != nil {
+				return h, fmt.Errorf("bad %s %q", envListeners, listeners)
+			}
+			f := os.NewFile(uintptr(fd), l[:i])
+			ln, err := net.FileListener(f)
+			f.Close()
+			if err != nil {
+				return h, fmt.Errorf("using %s socket from the old process: %v", l[:i], err)
+			}
+			h.Listeners[l[:i]] = ln
+		}
+	}
+	if ready != "" {
+		fd, err := strconv.Atoi(ready)
+		if err != nil {
+			return h, fmt.Errorf("bad %s %q", envReady, ready)
+		}
+		h.ready = os.NewFile(uintptr(fd), "ready")
+	}
+	return h, nil
+}
+
+// The listener we were passed as name, or a new one on addr.
+func (h *Handoff) Listen(name, addr string) (net.Listener, error) {
+	if ln, ok := h.Listeners[name]; ok {
+		return ln, nil
+	}
+	return net.Listen("tcp", addr)
+}
+
+// Let the old process know we're ready to take over once IRC is connected,
+// if there's one waiting to hear. If ctx is done first we hang up on it
+// instead, so it knows we never will be.
+func (h *Handoff) Ready(ctx context.Context, state *ircbot.IRCState) {
+	if h.ready == nil {
+		return
+	}
+	defer h.ready.Close()
+	for !state.WaitConnected(time.Second) {
+		if ctx.Err() != nil {
+			return
+		}
+	}
+	h.ready.Write([]byte("ready\n"))
+}
+
+// Start cmd, a new copy of ourselves, handing it listeners, and wait up to
+// timeout for it to say it's ready to take over. If it isn't it's killed,
+// and we carry on as we were.
+func StartSuccessor(cmd *exec.Cmd, listeners map[string]net.Listener, timeout time.Duration, logger *slog.Logger) error {
+	var names []string
+	for name, ln := range listeners {
+		l, ok := ln.(interface{ File() (*os.File, error) })
+		if !ok {
+			continue
+		}
+		f, err := l.File()
+		if err != nil {
+			return fmt.Errorf("passing on %s socket: %v", name, err)
+		}
+		defer f.Close()
+		names = append(names, fmt.Sprintf("%s:%d", name, listenFDsStart+len(cmd.ExtraFiles)))
+		cmd.ExtraFiles = append(cmd.ExtraFiles, f)
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	cmd.Env = append(cmd.Env, envListeners+"="+strings.Join(names, ","),
+		fmt.Sprintf("%s=%d", envReady, listenFDsStart+len(cmd.ExtraFiles)))
+	cmd.ExtraFiles = append(cmd.ExtraFiles, w)
+	err = cmd.Start()
+	w.Close()
+	if err != nil {
+		return err
+	}
+	logger.Info("Started new process, waiting for it to be ready", "pid", cmd.Process.Pid)
+	ready := make(chan error, 1)
+	go func() {
+		_, err := r.Read(make([]byte, 1))
+		ready <- err
+	}()
+	select {
+	case err = <-ready:
+	case <-time.After(timeout):
+		err = fmt.Errorf("not ready after %s", timeout)
+	}
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return fmt.Errorf("new process didn't take over: %v", err)
+	}
+	// It's got the sockets now, so closing ours mustn't remove them.
+	for _, ln := range listeners {
+		if l, ok := ln.(*net.UnixListener); ok {
+			l.SetUnlinkOnClose(false)
+		}
+	}
+	return cmd.Process.Release()
+}
+
+// The command to start a new copy of ourselves with: the same path and
+// arguments, so a binary replaced since we started gets picked up.
+func SuccessorCommand() *exec.Cmd {
+	cmd := exec.Command(os.Args[0], os.Args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.Env = os.Environ()
+	return cmd
+}
@@ -0,0 +1,163 @@
+package hookserver
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/config"
+	"github.com/UniversityRadioYork/CaptainHook/internal/ircbot"
+)
+
+// Answers deliveries as 202s saying who took them.
+func servedBy(name string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Served-By", name)
+		w.WriteHeader(http.StatusAccepted)
+	})
+}
+
+// Not really a test: TestRestartHandoff runs the test binary with this as
+// the new process, which serves on whatever it's handed until told to stop.
+func TestSuccessorProcess(t *testing.T) {
+	if os.Getenv("CAPTHOOK_TEST_SUCCESSOR") == "" {
+		return
+	}
+	h, err := TakeHandoff()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	state := ircbot.NewIRCState()
+	state.Connected("irc.example.org")
+	go h.Ready(context.Background(), state)
+	mux := http.NewServeMux()
+	mux.Handle("/", servedBy("new"))
+	mux.HandleFunc("/stop", func(w http.ResponseWriter, r *http.Request) { os.Exit(0) })
+	http.Serve(h.Listeners["webhooks"], mux)
+	os.Exit(1)
+}
+
+func TestRestartHandoff(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	url := "http://" + ln.Addr().String() + "/"
+	conns := &NewConns{}
+	old := &http.Server{Handler: servedBy("old"), ConnState: conns.ConnState}
+	go old.Serve(ln)
+
+	// Post the whole way through, each on its own connection so they're
+	// accepted by whoever's listening at the time.
+	var mu sync.Mutex
+	served := make(map[string]int)
+	var failed []string
+	stop, stopped := make(chan struct{}), make(chan struct{})
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}, Timeout: 5 * time.Second}
+	go func() {
+		defer close(stopped)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			resp, err := client.Post(url, contentTypeJSON, strings.NewReader("{}"))
+			mu.Lock()
+			if err != nil {
+				failed = append(failed, err.Error())
+			} else {
+				resp.Body.Close()
+				if resp.StatusCode != http.StatusAccepted {
+					failed = append(failed, resp.Status)
+				}
+				served[resp.Header.Get("X-Served-By")]++
+			}
+			mu.Unlock()
+		}
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	cmd := exec.Command(os.Args[0], "-test.run=^TestSuccessorProcess$")
+	cmd.Env = append(os.Environ(), "CAPTHOOK_TEST_SUCCESSOR=1")
+	if err := StartSuccessor(cmd, map[string]net.Listener{"webhooks": ln}, 10*time.Second, config.DiscardLogger); err != nil {
+		close(stop)
+		<-stopped
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := ShutdownGently(ctx, old, ln, conns); err != nil {
+		t.Error(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	close(stop)
+	<-stopped
+	client.Get(url + "stop")
+
+	if len(failed) > 0 {
+		t.Errorf("expected every delivery to be taken, %d weren't: %q", len(failed), failed)
+	}
+	if served["old"] == 0 || served["new"] == 0 {
+		t.Errorf("expected deliveries to go to both processes, got %v", served)
+	}
+}
+
+func TestStartSuccessorNotReady(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	for _, c := range []struct {
+		cmd  *exec.Cmd
+		want string
+	}{
+		{exec.Command("true"), "EOF"},
+		{exec.Command("sleep", "10"), "not ready after"},
+	} {
+		err := StartSuccessor(c.cmd, map[string]net.Listener{"webhooks": ln}, 100*time.Millisecond, config.DiscardLogger)
+		if err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("%s: expected an error with %q, got %v", c.cmd.Path, c.want, err)
+		}
+	}
+}
+
+// Shutting down before IRC connects hangs up on the old process rather than
+// leaving it to time out.
+func TestReadyCancelled(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	h := &Handoff{ready: w}
+	h.Ready(ctx, ircbot.NewIRCState())
+	if b, err := io.ReadAll(r); err != nil || len(b) != 0 {
+		t.Errorf("expected nothing but EOF, got %q, %v", b, err)
+	}
+}
+
+func TestTakeHandoffNothing(t *testing.T) {
+	h, err := TakeHandoff()
+	if err != nil || len(h.Listeners) > 0 || h.ready != nil {
+		t.Errorf("expected nothing handed over, got %v %v", h, err)
+	}
+	os.Setenv(envListeners, "webhooks")
+	defer os.Unsetenv(envListeners)
+	if _, err := TakeHandoff(); err == nil {
+		t.Error("expected an error for a listener without an fd")
+	}
+}
@@ -0,0 +1,147 @@
+package hookserver
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/config"
+	"github.com/UniversityRadioYork/CaptainHook/internal/format"
+	"github.com/UniversityRadioYork/CaptainHook/internal/metrics"
+)
+
+const SourceSentry = "sentry"
+
+// The resources we announce when Config.SentryEvents doesn't say otherwise.
+var defaultSentryEvents = []string{"issue.created", "error.created"}
+
+// How much of an exception's type and value we'll show.
+const maxSentryTitle = 100
+
+// What Sentry's webhook integration sends us (partially). Issue and error
+// resources put the interesting part in data.issue or data.error.
+type SentryEvent struct {
+	Action string
+	Data   struct {
+		Issue *sentryIssue
+		Error *sentryIssue
+	}
+}
+
+type sentryIssue struct {
+	Title    string
+	Culprit  string
+	WebURL   string `json:"web_url"`
+	Project  sentryProject
+	Metadata struct {
+		Type  string
+		Value string
+	}
+}
+
+// Issues carry their project as an object, errors only as an ID.
+type sentryProject struct {
+	Slug string
+}
+
+func (p *sentryProject) UnmarshalJSON(b []byte) error {
+	var id json.Number
+	if err := json.Unmarshal(b, &id); err == nil {
+		p.Slug = id.String()
+		return nil
+	}
+	var obj struct{ Slug string }
+	if err := json.Unmarshal(b, &obj); err != nil {
+		return err
+	}
+	p.Slug = obj.Slug
+	return nil
+}
+
+// Check Sentry's sentry-hook-signature header, which is a hex HMAC-SHA256 of
+// the body keyed with the integration's client secret.
+func CheckSentrySignature(body []byte, signature, secret string) bool {
+	sig, err := hex.DecodeString(signature)
+	if err != nil || len(sig) == 0 {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(sig, mac.Sum(nil))
+}
+
+// Turn a Sentry issue or error notification, ev being "resource.action",
+// into an Event.
+func ParseSentryEvent(ev string, body []byte) (*format.Event, error) {
+	var event SentryEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		return nil, err
+	}
+	resource := strings.SplitN(ev, ".", 2)[0]
+	issue := event.Data.Issue
+	if resource == "error" {
+		issue = event.Data.Error
+	}
+	if issue == nil {
+		return nil, nil
+	}
+	title := issue.Title
+	if issue.Metadata.Type != "" {
+		title = issue.Metadata.Type + ": " + issue.Metadata.Value
+	}
+	return &format.Event{
+		Source:  SourceSentry,
+		Type:    "sentry",
+		Action:  resource,
+		Repo:    issue.Project.Slug,
+		Title:   format.Truncate(title, maxSentryTitle),
+		Message: format.Truncate(issue.Culprit, maxSentryTitle),
+		URL:     issue.WebURL,
+	}, nil
+}
+
+// Handles Sentry's webhook integration. Deliveries are filtered on
+// "resource.action", e.g. issue.created.
+func SentryHandler(hook *config.Hook, in *Intake, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, _, ok := readBody(w, r, logger)
+		if !ok {
+			return
+		}
+		signature := r.Header.Get("Sentry-Hook-Signature")
+		verified := false
+		for _, s := range hook.Secrets {
+			if CheckSentrySignature(body, signature, s) {
+				verified = true
+				break
+			}
+		}
+		if !verified {
+			logger.Warn("Invalid signature", "hook", hook.Name, "ip", requestIP(r))
+			metrics.DeliveriesTotal.WithLabelValues(metrics.UnverifiedEvent, metrics.OutcomeBadSignature).Inc()
+			respond(w, http.StatusUnauthorized, "Invalid signature")
+			return
+		}
+		resource := r.Header.Get("Sentry-Hook-Resource")
+		var event SentryEvent
+		json.Unmarshal(body, &event) // enqueue complains about bad JSON
+		ev := resource + "." + event.Action
+		if !hook.Wants(ev) {
+			metrics.DeliveriesTotal.WithLabelValues(ev, metrics.OutcomeIgnored).Inc()
+			respond(w, http.StatusNoContent, "")
+			return
+		}
+		enqueue(w, r, Delivery{
+			Source:  SourceSentry,
+			Hook:    hook,
+			Event:   ev,
+			ID:      r.Header.Get("Request-ID"),
+			Header:  r.Header.Clone(),
+			Payload: body,
+		}, in, logger)
+	}
+}
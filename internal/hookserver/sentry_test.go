@@ -0,0 +1,83 @@
+package hookserver
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/config"
+)
+
+func signSentry(body []byte, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestCheckSentrySignature(t *testing.T) {
+	body := []byte(`{"action":"created"}`)
+	good := signSentry(body, "clientsecret")
+	for _, c := range []struct {
+		name, sig, secret string
+		want              bool
+	}{
+		{"valid", good, "clientsecret", true},
+		{"wrong secret", good, "other", false},
+		{"missing", "", "clientsecret", false},
+		{"not hex", "zz" + good[2:], "clientsecret", false},
+		{"sha1 github style", "sha1=" + good, "clientsecret", false},
+		{"truncated", good[:32], "clientsecret", false},
+	} {
+		if got := CheckSentrySignature(body, c.sig, c.secret); got != c.want {
+			t.Errorf("%s: expected %v, got %v", c.name, c.want, got)
+		}
+	}
+}
+
+func TestParseSentryEvent(t *testing.T) {
+
+	body, err := ioutil.ReadFile("testdata/sentry/issue_created.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := ParseSentryEvent("issue.created", body)
+	if err != nil || e == nil {
+		t.Fatalf("expected an event, got %v", err)
+	}
+	want := "[\x0306website\x0f] New \x0304issue\x0f TypeError: Cannot read properties of undefined (reading 'show') in schedule.loadNowPlaying(app/js/schedule). https://sentry.io/organizations/ury/issues/1170820242/\x0f"
+	if got := config.FormatEvent(e, config.DiscardLogger); got != want {
+		t.Errorf("\nexpected %q\n     got %q", want, got)
+	}
+}
+
+func TestSentryHandler(t *testing.T) {
+	config.SetConfig(&config.Config{MaxBodyBytes: 1 << 20})
+	hook := &config.Hook{Name: "sentry", Secrets: []string{"clientsecret"}, Events: map[string]bool{"issue.created": true}}
+	created := `{"action":"created","data":{}}`
+	resolved := `{"action":"resolved","data":{}}`
+	for _, c := range []struct {
+		name, body, resource, secret string
+		want                         int
+	}{
+		{"bad signature", created, "issue", "wrong", http.StatusUnauthorized},
+		{"wanted", created, "issue", "clientsecret", http.StatusAccepted},
+		{"unwanted action", resolved, "issue", "clientsecret", http.StatusNoContent},
+		{"unwanted resource", created, "installation", "clientsecret", http.StatusNoContent},
+	} {
+		work := make(chan Delivery, 1)
+		req := httptest.NewRequest("POST", "/sentry", strings.NewReader(c.body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Sentry-Hook-Resource", c.resource)
+		req.Header.Set("Sentry-Hook-Signature", signSentry([]byte(c.body), c.secret))
+		rec := httptest.NewRecorder()
+		SentryHandler(hook, &Intake{Work: work}, config.DiscardLogger)(rec, req)
+		if rec.Code != c.want {
+			t.Errorf("%s: expected %d, got %d", c.name, c.want, rec.Code)
+		}
+	}
+}
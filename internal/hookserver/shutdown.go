@@ -0,0 +1,62 @@
+package hookserver
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"time"
+)
+
+// What we exit with once we've shut down.
+const (
+	ExitClean   = 0
+	exitUnclean = 1 // Something didn't finish in time, or announcements were lost
+	exitForced  = 2
+)
+
+// Takes us through shutting down one phase at a time, each with its own time
+// limit, and keeps track of whether they all went cleanly.
+type Shutdown struct {
+	logger *slog.Logger
+	failed []string // Phases that errored or ran out of time
+}
+
+func NewShutdown(logger *slog.Logger) *Shutdown {
+	return &Shutdown{logger: logger}
+}
+
+// Run one phase, with a ctx that's done after timeout (or never, for 0). f
+// should give up and say so once ctx is done; whatever it says is logged and
+// we carry on with the next phase either way.
+func (s *Shutdown) Phase(name string, timeout time.Duration, f func(ctx context.Context) error) {
+	ctx, cancel := context.Background(), func() {}
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+	}
+	defer cancel()
+	start := time.Now()
+	err := f(ctx)
+	took := time.Since(start).Round(time.Millisecond)
+	if err != nil {
+		s.failed = append(s.failed, name)
+		s.logger.Error("Shutdown phase didn't finish cleanly", "phase", name, "took", took, "err", err)
+		return
+	}
+	s.logger.Info("Shutdown phase done", "phase", name, "took", took)
+}
+
+// What to exit with after the phases so far.
+func (s *Shutdown) ExitCode() int {
+	if len(s.failed) > 0 {
+		return exitUnclean
+	}
+	return ExitClean
+}
+
+// Exit straight away on the next signal, for when shutting down is taking
+// longer than whoever's sending them is willing to wait.
+func ForceExit(sigs <-chan os.Signal, logger *slog.Logger, exit func(int)) {
+	sig := <-sigs
+	logger.Warn("Got another signal, exiting without finishing shutting down", "signal", sig.String())
+	exit(exitForced)
+}
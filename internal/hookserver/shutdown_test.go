@@ -0,0 +1,66 @@
+package hookserver
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/config"
+)
+
+func TestShutdownPhases(t *testing.T) {
+	var buf bytes.Buffer
+	s := NewShutdown(config.NewLogger(&buf, "text"))
+	var order []string
+	s.Phase("first", time.Second, func(ctx context.Context) error {
+		order = append(order, "first")
+		return nil
+	})
+	if s.ExitCode() != ExitClean {
+		t.Errorf("expected a clean exit so far, got %d", s.ExitCode())
+	}
+	s.Phase("slow", 10*time.Millisecond, func(ctx context.Context) error {
+		order = append(order, "slow")
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	s.Phase("unlimited", 0, func(ctx context.Context) error {
+		order = append(order, "unlimited")
+		if _, ok := ctx.Deadline(); ok {
+			return errors.New("expected no deadline")
+		}
+		return nil
+	})
+	if got := strings.Join(order, ","); got != "first,slow,unlimited" {
+		t.Errorf("expected every phase in order, got %s", got)
+	}
+	if s.ExitCode() != exitUnclean {
+		t.Errorf("expected an unclean exit after a phase ran out of time, got %d", s.ExitCode())
+	}
+	logs := buf.String()
+	for _, want := range []string{"phase=first took=", "phase=slow took=", "err=\"context deadline exceeded\"", "phase=unlimited took="} {
+		if !strings.Contains(logs, want) {
+			t.Errorf("expected %q in the logs, got:\n%s", want, logs)
+		}
+	}
+}
+
+func TestForceExit(t *testing.T) {
+	sigs := make(chan os.Signal, 1)
+	code := make(chan int, 1)
+	go ForceExit(sigs, config.DiscardLogger, func(c int) { code <- c })
+	sigs <- syscall.SIGINT
+	select {
+	case c := <-code:
+		if c != exitForced {
+			t.Errorf("expected exit code %d, got %d", exitForced, c)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected a second signal to exit")
+	}
+}
@@ -0,0 +1,35 @@
+package hookserver
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/config"
+	"github.com/UniversityRadioYork/CaptainHook/internal/metrics"
+)
+
+// Count a delivery the workers have finished with.
+func countProcessed(d Delivery, outcome string) {
+	metrics.ProcessedTotal.WithLabelValues(d.Event, outcome).Inc()
+	_, action := config.PayloadSender(d.Payload)
+	metrics.EventsTotal.WithLabelValues(d.Event, action, config.PayloadRepo(d.Payload), outcome).Inc()
+}
+
+// Serves Stats as JSON.
+func StatsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			respond(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+		s, err := metrics.GatherStats()
+		if err != nil {
+			respond(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		json.NewEncoder(w).Encode(s)
+	}
+}
@@ -0,0 +1,56 @@
+package hookserver
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/metrics"
+)
+
+func TestStats(t *testing.T) {
+	metrics.EventsTotal.Reset()
+	defer metrics.EventsTotal.Reset()
+	push := func(repo string) Delivery {
+		return Delivery{Event: "push", Payload: []byte(`{"repository":{"full_name":"` + repo + `"}}`)}
+	}
+	for i := 0; i < 3; i++ {
+		countProcessed(push("ury/website"), metrics.OutcomeAnnounced)
+	}
+	countProcessed(push("ury/myradio"), metrics.OutcomeAnnounced)
+	countProcessed(Delivery{Event: "issues", Payload: []byte(`{"action":"labeled","repository":{"full_name":"ury/myradio"}}`)}, metrics.OutcomeIgnoredAction)
+
+	s, err := metrics.GatherStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Total != 5 || s.Announced != 4 || s.ByEvent["push"] != 4 || s.ByAction["issues.labeled"] != 1 || s.ByRepo["ury/myradio"] != 2 || s.ByOutcome[metrics.OutcomeIgnoredAction] != 1 {
+		t.Errorf("unexpected %+v", s)
+	}
+	if got := s.String(); !strings.HasPrefix(got, "5 deliveries, 4 announced, up ") || !strings.HasSuffix(got, "busiest: ury/website 3, ury/myradio 2") {
+		t.Errorf("unexpected summary %q", got)
+	}
+
+	rec := httptest.NewRecorder()
+	StatsHandler()(rec, httptest.NewRequest("GET", "/stats", nil))
+	var got metrics.Stats
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil || got.Total != 5 || got.ByRepo["ury/website"] != 3 {
+		t.Errorf("unexpected /stats %+v %v", got, err)
+	}
+
+	// Over a restart.
+	path := metrics.StatsPath(filepath.Join(t.TempDir(), "state.jsonl"))
+	if err := metrics.SaveStats(path); err != nil {
+		t.Fatal(err)
+	}
+	metrics.EventsTotal.Reset()
+	if err := metrics.LoadStats(path); err != nil {
+		t.Fatal(err)
+	}
+	countProcessed(push("ury/website"), metrics.OutcomeAnnounced)
+	if s, _ := metrics.GatherStats(); s.Total != 6 || s.ByRepo["ury/website"] != 4 {
+		t.Errorf("expected to carry on from where we were, got %+v", s)
+	}
+}
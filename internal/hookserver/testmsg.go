@@ -0,0 +1,74 @@
+package hookserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/format"
+	"github.com/UniversityRadioYork/CaptainHook/internal/ircbot"
+	"github.com/UniversityRadioYork/CaptainHook/internal/outputs"
+)
+
+// What POST /test takes, both optional.
+type testRequest struct {
+	Channel string `json:"channel"`
+	Message string `json:"message"`
+}
+
+// Lets an admin push a message through to IRC, to check the whole pipeline
+// after a deploy. Authenticated with Config.AdminToken as a bearer token, and
+// only able to talk in channels we're configured for.
+func TestMessageHandler(token string, channels []string, state *ircbot.IRCState, msgs Pusher, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			respond(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+		if !matchToken(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "), []string{token}) {
+			logger.Warn("Invalid admin token in test request", "ip", requestIP(r))
+			respond(w, http.StatusUnauthorized, "Invalid token")
+			return
+		}
+		var req testRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&req); err != nil && err != io.EOF {
+			respond(w, http.StatusBadRequest, "Error parsing request: "+err.Error())
+			return
+		}
+		if status := state.Status(); !status.Connected {
+			respond(w, http.StatusServiceUnavailable, fmt.Sprintf("IRC disconnected since %s, reconnecting",
+				status.Since.Format(time.RFC3339)))
+			return
+		}
+		targets := channels
+		if req.Channel != "" {
+			targets = nil
+			for _, ch := range channels {
+				if strings.EqualFold(ch, req.Channel) {
+					targets = []string{ch}
+				}
+			}
+			if targets == nil {
+				respond(w, http.StatusBadRequest, "Not a channel we announce in: "+req.Channel)
+				return
+			}
+		}
+		msg := format.Truncate(req.Message, 400)
+		if msg == "" {
+			msg = "Test message, sent " + time.Now().Format(time.RFC1123)
+		}
+		if !msgs.Push(outputs.Announcement{Channels: targets, Text: msg, Event: "test", Time: time.Now()}) {
+			respond(w, http.StatusServiceUnavailable, "Broadcast queue full")
+			return
+		}
+		logger.Info("Test message queued", "channels", targets, "ip", requestIP(r))
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(map[string][]string{"channels": targets})
+	}
+}
@@ -0,0 +1,48 @@
+package hookserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/config"
+	"github.com/UniversityRadioYork/CaptainHook/internal/ircbot"
+)
+
+func TestTestMessageHandler(t *testing.T) {
+	channels := []string{"#ury-ops", "#ury-dev"}
+	up := ircbot.NewIRCState()
+	up.Connected("irc.example.org")
+	for _, c := range []struct {
+		name, token, body string
+		state             *ircbot.IRCState
+		want              int
+		sent              []string
+	}{
+		{"no token", "", `{}`, up, http.StatusUnauthorized, nil},
+		{"everywhere", "admin", ``, up, http.StatusAccepted, channels},
+		{"one channel", "admin", `{"channel":"#URY-dev","message":"hello"}`, up, http.StatusAccepted, []string{"#ury-dev"}},
+		{"unknown channel", "admin", `{"channel":"#elsewhere"}`, up, http.StatusBadRequest, nil},
+		{"irc down", "admin", `{}`, ircbot.NewIRCState(), http.StatusServiceUnavailable, nil},
+	} {
+		p := &recordingPusher{}
+		req := httptest.NewRequest("POST", "/test", strings.NewReader(c.body))
+		req.Header.Set("Authorization", "Bearer "+c.token)
+		rec := httptest.NewRecorder()
+		TestMessageHandler("admin", channels, c.state, p, config.DiscardLogger)(rec, req)
+		if rec.Code != c.want {
+			t.Errorf("%s: expected %d, got %d: %s", c.name, c.want, rec.Code, rec.Body)
+			continue
+		}
+		if c.sent == nil {
+			if len(p.pushed) != 0 {
+				t.Errorf("%s: expected nothing sent, got %v", c.name, p.pushed)
+			}
+			continue
+		}
+		if len(p.pushed) != 1 || strings.Join(p.pushed[0].Channels, ",") != strings.Join(c.sent, ",") {
+			t.Errorf("%s: expected a message for %v, got %v", c.name, c.sent, p.pushed)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package hookserver
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// Serves a certificate from disk, picking up renewals without a restart.
+type CertReloader struct {
+	CertFile string
+	KeyFile  string
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+func NewCertReloader(certFile, keyFile string) (*CertReloader, error) {
+	c := &CertReloader{CertFile: certFile, KeyFile: keyFile}
+	if err := c.Reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// The newest modification time of the cert and key.
+func (c *CertReloader) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, f := range []string{c.CertFile, c.KeyFile} {
+		fi, err := os.Stat(f)
+		if err != nil {
+			return latest, err
+		}
+		if fi.ModTime().After(latest) {
+			latest = fi.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// Load the certificate and key from disk again. On failure we keep serving
+// whatever we had before.
+func (c *CertReloader) Reload() error {
+	modTime, err := c.latestModTime()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	if err != nil {
+		return err
+	}
+	if cert.Leaf == nil {
+		if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return err
+		}
+	}
+	c.mu.Lock()
+	c.cert = &cert
+	c.modTime = modTime
+	c.mu.Unlock()
+	return nil
+}
+
+// For tls.Config. Reloads first if the files have changed since we last
+// looked, so Let's Encrypt renewals get picked up on the next handshake.
+func (c *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.RLock()
+	cert, loaded := c.cert, c.modTime
+	c.mu.RUnlock()
+	if modTime, err := c.latestModTime(); err == nil && modTime.After(loaded) {
+		if err := c.Reload(); err == nil {
+			c.mu.RLock()
+			cert = c.cert
+			c.mu.RUnlock()
+		}
+	}
+	return cert, nil
+}
+
+// Describe the loaded certificate for the startup log.
+func (c *CertReloader) Describe() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	leaf := c.cert.Leaf
+	return leaf.Subject.CommonName + ", expires " + leaf.NotAfter.Format(time.RFC3339)
+}
+
+// Reload whenever something arrives on reload, e.g. a SIGHUP.
+func (c *CertReloader) Watch(reload <-chan os.Signal, logger *slog.Logger) {
+	for range reload {
+		if err := c.Reload(); err != nil {
+			logger.Error("Error reloading TLS certificate", "err", err)
+			continue
+		}
+		logger.Info("Reloaded TLS certificate", "cert", c.Describe())
+	}
+}
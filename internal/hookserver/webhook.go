@@ -0,0 +1,318 @@
+// Package hookserver is the HTTP side: the webhook endpoints for each source,
+// and the workers turning deliveries into announcements.
+package hookserver
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/config"
+	"github.com/UniversityRadioYork/CaptainHook/internal/format"
+	"github.com/UniversityRadioYork/CaptainHook/internal/metrics"
+	"github.com/UniversityRadioYork/CaptainHook/internal/panics"
+)
+
+func CheckHMAC(message, reqMAC, key []byte) bool {
+	mac := hmac.New(sha1.New, key)
+	mac.Write(message)
+	expectedMAC := mac.Sum(nil)
+	return hmac.Equal(reqMAC, expectedMAC) // It's the return of the mac
+}
+
+// Find which of secrets reqMAC was made with, trying each in order, or -1 if
+// none of them.
+func MatchSecret(message, reqMAC []byte, secrets []string) int {
+	for i, secret := range secrets {
+		if CheckHMAC(message, reqMAC, []byte(secret)) {
+			return i
+		}
+	}
+	return -1
+}
+
+// An HMAC per secret, to be written the body as it's read, so checking the
+// signature doesn't need another pass over it.
+type secretMACs []hash.Hash
+
+func newSecretMACs(h func() hash.Hash, secrets []string) secretMACs {
+	macs := make(secretMACs, len(secrets))
+	for i, secret := range secrets {
+		macs[i] = hmac.New(h, []byte(secret))
+	}
+	return macs
+}
+
+func (m secretMACs) Writers() []io.Writer {
+	w := make([]io.Writer, len(m))
+	for i, mac := range m {
+		w[i] = mac
+	}
+	return w
+}
+
+// Like MatchSecret, for what's been written so far.
+func (m secretMACs) Match(reqMAC []byte) int {
+	for i, mac := range m {
+		if hmac.Equal(reqMAC, mac.Sum(nil)) {
+			return i
+		}
+	}
+	return -1
+}
+
+// Errors returned by ExtractSignature.
+var (
+	ErrNoSignature        = errors.New("missing X-Hub-Signature header")
+	ErrMalformedSignature = errors.New("malformed X-Hub-Signature header")
+)
+
+// Pull the raw MAC bytes out of an X-Hub-Signature header, which looks like
+// "sha1=<hex digest>", or X-Hub-Signature-256's "sha256=<hex digest>". Never
+// panics, whatever junk the client sends.
+func ExtractSignature(header string) ([]byte, error) {
+	if header == "" {
+		return nil, ErrNoSignature
+	}
+	parts := strings.SplitN(header, "=", 2)
+	if len(parts) != 2 || (parts[0] != "sha1" && parts[0] != "sha256") || parts[1] == "" {
+		return nil, ErrMalformedSignature
+	}
+	mac, err := hex.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", ErrMalformedSignature, err)
+	}
+	return mac, nil
+}
+
+// Belt-and-braces: turn a panic in the wrapped handler into a 500 and a log
+// line with the stack instead of letting it unwind any further.
+func Recoverer(h http.Handler, logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if rec := recover(); rec != nil {
+				panics.Log("http", rec, logger.With("ip", r.RemoteAddr, "path", r.URL.Path))
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+			}
+		}()
+		h.ServeHTTP(w, r)
+	})
+}
+
+// Write a short plain-text description of what we did with a delivery, so
+// GitHub's "Recent Deliveries" page says something useful.
+func respond(w http.ResponseWriter, code int, msg string) {
+	if code == http.StatusNoContent {
+		w.WriteHeader(code)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(code)
+	fmt.Fprintln(w, msg)
+}
+
+const (
+	contentTypeJSON = "application/json"
+	contentTypeForm = "application/x-www-form-urlencoded"
+)
+
+// GitHub only ever sends JSON, either bare or form-encoded. Returns the media
+// type if it's one of those, or an empty string otherwise.
+func payloadContentType(header string) string {
+	mediatype, _, err := mime.ParseMediaType(header)
+	if err != nil {
+		return ""
+	}
+	if mediatype != contentTypeJSON && mediatype != contentTypeForm {
+		return ""
+	}
+	return mediatype
+}
+
+// Dig the JSON out of a form-encoded delivery, where it lives in the payload
+// field.
+func formPayload(body []byte) ([]byte, error) {
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		return nil, err
+	}
+	payload := form.Get("payload")
+	if payload == "" {
+		return nil, errors.New("form-encoded delivery has no payload field")
+	}
+	return []byte(payload), nil
+}
+
+// Handles incoming GitHub webhooks for a hook, verifying them against its
+// secret and queueing them through in for the workers to announce. Anything
+// slow happens over there so GitHub isn't left waiting on us.
+func WebhookHandler(hook *config.Hook, in *Intake, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sha1MACs, sha256MACs := newSecretMACs(sha1.New, hook.Secrets), newSecretMACs(sha256.New, hook.Secrets)
+		body, contentType, ok := readBody(w, r, logger, append(sha1MACs.Writers(), sha256MACs.Writers()...)...)
+		if !ok {
+			return
+		}
+		// GitHub signs with SHA-256 as well as SHA-1 these days, but older
+		// GitHub Enterprise Servers only do SHA-1, so that'll do when it's
+		// all there is.
+		header, macs, size := r.Header.Get("X-Hub-Signature-256"), sha256MACs, sha256.Size
+		if header == "" {
+			header, macs, size = r.Header.Get("X-Hub-Signature"), sha1MACs, sha1.Size
+		}
+		reqMAC, err := ExtractSignature(header)
+		if err != nil {
+			logger.Warn("Bad signature header", "hook", hook.Name, "ip", requestIP(r), "delivery", r.Header.Get("X-GitHub-Delivery"), "err", err)
+			metrics.DeliveriesTotal.WithLabelValues(metrics.UnverifiedEvent, metrics.OutcomeBadSignature).Inc()
+			if err == ErrNoSignature {
+				respond(w, http.StatusUnauthorized, err.Error())
+			} else {
+				respond(w, http.StatusBadRequest, err.Error())
+			}
+			return
+		}
+		matched := macs.Match(reqMAC)
+		if matched < 0 {
+			logger.Warn("Invalid signature", "hook", hook.Name, "ip", requestIP(r), "delivery", r.Header.Get("X-GitHub-Delivery"),
+				"presented_bytes", len(reqMAC), "computed_bytes", size)
+			metrics.DeliveriesTotal.WithLabelValues(metrics.UnverifiedEvent, metrics.OutcomeBadSignature).Inc()
+			respond(w, http.StatusUnauthorized, "Invalid signature")
+			return
+		}
+		if matched > 0 {
+			logger.Info("Delivery matched an older secret", "hook", hook.Name, "secret", matched, "delivery", r.Header.Get("X-GitHub-Delivery"))
+		}
+		metrics.SecretMatches.WithLabelValues(hook.Name, strconv.Itoa(matched)).Inc()
+		// The signature covers the raw body, so only unwrap form-encoded
+		// payloads after checking it.
+		payload := body
+		if contentType == contentTypeForm {
+			if payload, err = formPayload(body); err != nil {
+				logger.Warn("Error decoding form payload", "delivery", r.Header.Get("X-GitHub-Delivery"), "err", err)
+				metrics.DeliveriesTotal.WithLabelValues(r.Header.Get("X-Github-Event"), metrics.OutcomeParseError).Inc()
+				respond(w, http.StatusBadRequest, err.Error())
+				return
+			}
+		}
+		ev := r.Header.Get("X-Github-Event")
+		if !hook.Wants(ev) {
+			metrics.DeliveriesTotal.WithLabelValues(ev, metrics.OutcomeIgnored).Inc()
+			respond(w, http.StatusNoContent, "")
+			return
+		}
+		enqueue(w, r, Delivery{
+			Source:  format.SourceGitHub,
+			Hook:    hook,
+			Event:   ev,
+			ID:      r.Header.Get("X-GitHub-Delivery"),
+			Header:  r.Header.Clone(),
+			Payload: payload,
+		}, in, logger)
+	}
+}
+
+// Read a webhook body, making sure it's JSON of a sensible size first. If
+// not, responds accordingly and returns false. It's read once, into a buffer
+// sized from Content-Length where there is one, and copied to sums (HMACs,
+// say) on the way. Everything after, down to the archive and forwarding,
+// shares the one buffer.
+func readBody(w http.ResponseWriter, r *http.Request, logger *slog.Logger, sums ...io.Writer) (body []byte, contentType string, ok bool) {
+	contentType = payloadContentType(r.Header.Get("Content-Type"))
+	if contentType == "" {
+		metrics.DeliveriesTotal.WithLabelValues(metrics.UnverifiedEvent, metrics.OutcomeBadRequest).Inc()
+		respond(w, http.StatusUnsupportedMediaType, "Unsupported content type")
+		return nil, "", false
+	}
+	limit := config.CurrentConfig().MaxBodyBytes
+	var in io.Reader = http.MaxBytesReader(w, r.Body, limit)
+	if len(sums) > 0 {
+		in = io.TeeReader(in, io.MultiWriter(sums...))
+	}
+	var buf bytes.Buffer
+	if n := r.ContentLength; n > 0 && n <= limit {
+		// Room for the EOF too, or ReadFrom doubles the buffer to look for it.
+		buf.Grow(int(n) + bytes.MinRead)
+	}
+	_, err := buf.ReadFrom(in)
+	if err != nil {
+		logger.Warn("Error reading request body", "ip", requestIP(r), "err", err)
+		metrics.DeliveriesTotal.WithLabelValues(metrics.UnverifiedEvent, metrics.OutcomeBadRequest).Inc()
+		var tooBig *http.MaxBytesError
+		if errors.As(err, &tooBig) {
+			respond(w, http.StatusRequestEntityTooLarge, "Request body too large")
+		} else {
+			respond(w, http.StatusBadRequest, "Error reading request body")
+		}
+		return nil, "", false
+	}
+	return buf.Bytes(), contentType, true
+}
+
+// Where authenticated deliveries go: Work for the workers, with Seen to catch
+// replays, and copies to Archive and Forwarder. All but Work can be nil.
+type Intake struct {
+	Work      chan<- Delivery
+	Seen      *DeliveryLog
+	Archive   *Archive
+	Forwarder *Forwarder
+}
+
+// Hand an authenticated delivery over to the workers, and tell the sender how
+// that went.
+func enqueue(w http.ResponseWriter, r *http.Request, d Delivery, in *Intake, logger *slog.Logger) {
+	ev := d.Event
+	conf := config.CurrentConfig()
+	// Proper parsing is left to the workers, but a quick scan catches junk
+	// while we can still tell the sender about it.
+	if !json.Valid(d.Payload) {
+		logger.Warn("Invalid JSON in delivery", "event", ev, "delivery", d.ID)
+		metrics.DeliveriesTotal.WithLabelValues(ev, metrics.OutcomeParseError).Inc()
+		respond(w, http.StatusBadRequest, "Error parsing payload: invalid JSON")
+		return
+	}
+	if repo := config.PayloadRepo(d.Payload); repo != "" && !config.MatchRepo(repo, conf.RepoAllow, conf.RepoDeny) {
+		metrics.DeliveriesTotal.WithLabelValues(ev, metrics.OutcomeFiltered).Inc()
+		respond(w, http.StatusNoContent, "")
+		return
+	}
+	if sender, action := config.PayloadSender(d.Payload); conf.IgnoresSender(sender, ev, action) {
+		metrics.DeliveriesTotal.WithLabelValues(ev, metrics.OutcomeIgnoredSender).Inc()
+		respond(w, http.StatusNoContent, "")
+		return
+	}
+	if in.Seen != nil && d.ID != "" && !in.Seen.CheckAndRecord(d.ID) {
+		logger.Info("Ignoring replayed delivery", "delivery", d.ID, "event", ev, "ip", requestIP(r))
+		metrics.DeliveriesTotal.WithLabelValues(ev, metrics.OutcomeDuplicate).Inc()
+		respond(w, http.StatusOK, "Delivery already processed")
+		return
+	}
+	in.Archive.Save(d)
+	select {
+	case in.Work <- d:
+		if d.Source == format.SourceGitHub {
+			in.Forwarder.Forward(ev, d.ID, d.Payload) // Only once we've taken it, so replays and junk aren't passed on
+		}
+		metrics.DeliveriesTotal.WithLabelValues(ev, metrics.OutcomeAccepted).Inc()
+		respond(w, http.StatusAccepted, "Queued "+ev+" event")
+	default:
+		logger.Warn("Work queue full, turning away delivery", "event", ev, "delivery", d.ID, "repo", config.PayloadRepo(d.Payload))
+		if in.Seen != nil && d.ID != "" {
+			in.Seen.Forget(d.ID) // So the sender's retry isn't taken for a replay
+		}
+		metrics.DeliveriesTotal.WithLabelValues(ev, metrics.OutcomeQueueFull).Inc()
+		respond(w, http.StatusServiceUnavailable, "Too busy, try again later")
+	}
+}
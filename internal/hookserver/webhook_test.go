@@ -0,0 +1,334 @@
+package hookserver
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/config"
+	"github.com/UniversityRadioYork/CaptainHook/internal/testutil"
+)
+
+func TestWebhookMissingSignature(t *testing.T) {
+	conf := &config.Config{GHSecret: "hunter2", MaxBodyBytes: 1 << 20}
+	config.SetConfig(conf)
+	work := make(chan Delivery, 1)
+	h := Recoverer(WebhookHandler(conf.DefaultHook(), &Intake{Work: work}, config.DiscardLogger), config.DiscardLogger)
+
+	req := httptest.NewRequest("POST", "/", strings.NewReader(`{"action":"opened"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Github-Event", "issues")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401, got %d", rec.Code)
+	}
+	if len(work) != 0 {
+		t.Errorf("expected nothing to be queued, got %d", len(work))
+	}
+}
+
+func sign256(body, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// X-Hub-Signature-256 when there is one, otherwise X-Hub-Signature, as older
+// GitHub Enterprise Servers only send that.
+func TestWebhookSignatures(t *testing.T) {
+	conf := &config.Config{GHSecret: "hunter2", MaxBodyBytes: 1 << 20}
+	config.SetConfig(conf)
+	body := `{"action":"labeled"}`
+	for _, c := range []struct {
+		name, sha1, sha256 string
+		code               int
+	}{
+		{"both", testutil.Sign(body, "hunter2"), sign256(body, "hunter2"), http.StatusAccepted},
+		{"sha256 only", "", sign256(body, "hunter2"), http.StatusAccepted},
+		{"sha1 only", testutil.Sign(body, "hunter2"), "", http.StatusAccepted},
+		{"bad sha256", testutil.Sign(body, "hunter2"), sign256(body, "wrong"), http.StatusUnauthorized},
+		{"sha1 as sha256", "", testutil.Sign(body, "hunter2"), http.StatusUnauthorized},
+		{"neither", "", "", http.StatusUnauthorized},
+	} {
+		work := make(chan Delivery, 1)
+		req := httptest.NewRequest("POST", "/", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Github-Event", "issues")
+		if c.sha1 != "" {
+			req.Header.Set("X-Hub-Signature", c.sha1)
+		}
+		if c.sha256 != "" {
+			req.Header.Set("X-Hub-Signature-256", c.sha256)
+		}
+		rec := httptest.NewRecorder()
+		WebhookHandler(conf.DefaultHook(), &Intake{Work: work}, config.DiscardLogger).ServeHTTP(rec, req)
+		if rec.Code != c.code {
+			t.Errorf("%s: expected %d, got %d", c.name, c.code, rec.Code)
+		}
+	}
+}
+
+func TestWebhookStatusCodes(t *testing.T) {
+	conf := &config.Config{GHSecret: "hunter2", MaxBodyBytes: 1 << 20}
+	config.SetConfig(conf)
+	hook := conf.DefaultHook()
+	hook.Events = map[string]bool{"issues": true}
+	cases := []struct {
+		event string
+		body  string
+		code  int
+	}{
+		{"issues", `{"action":"labeled"}`, http.StatusAccepted},
+		{"watch", `{"action":"started"}`, http.StatusNoContent},
+		{"issues", `{"action":`, http.StatusBadRequest},
+	}
+	for _, c := range cases {
+		work := make(chan Delivery, 1)
+		h := WebhookHandler(hook, &Intake{Work: work}, config.DiscardLogger)
+		req := httptest.NewRequest("POST", "/", strings.NewReader(c.body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Github-Event", c.event)
+		req.Header.Set("X-Hub-Signature", testutil.Sign(c.body, "hunter2"))
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != c.code {
+			t.Errorf("%s %s: expected %d, got %d", c.event, c.body, c.code, rec.Code)
+		}
+	}
+}
+
+func TestWebhookContentTypes(t *testing.T) {
+	conf := &config.Config{Channels: "#test", GHSecret: "hunter2", MaxBodyBytes: 1 << 20}
+	config.SetConfig(conf)
+
+	var texts []string
+	for _, c := range []struct{ fixture, contentType string }{
+		{"testdata/issues_opened.json", "application/json"},
+		{"testdata/issues_opened.form", "application/x-www-form-urlencoded"},
+	} {
+		body, err := ioutil.ReadFile(c.fixture)
+		if err != nil {
+			t.Fatal(err)
+		}
+		work := make(chan Delivery, 1)
+		h := WebhookHandler(conf.DefaultHook(), &Intake{Work: work}, config.DiscardLogger)
+		req := httptest.NewRequest("POST", "/", strings.NewReader(string(body)))
+		req.Header.Set("Content-Type", c.contentType)
+		req.Header.Set("X-Github-Event", "issues")
+		req.Header.Set("X-Hub-Signature", testutil.Sign(string(body), "hunter2"))
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != http.StatusAccepted {
+			t.Fatalf("%s: expected 202, got %d: %s", c.contentType, rec.Code, rec.Body)
+		}
+		a, ok, err := new(Processor).ProcessDelivery(context.Background(), <-work, config.DiscardLogger)
+		if !ok || err != nil {
+			t.Fatalf("%s: expected an announcement, got %v", c.contentType, err)
+		}
+		texts = append(texts, a.Text)
+	}
+	if texts[0] != texts[1] {
+		t.Errorf("content types produced different messages:\n%q\n%q", texts[0], texts[1])
+	}
+}
+
+func TestWebhookFormMissingPayload(t *testing.T) {
+	conf := &config.Config{Channels: "#test", GHSecret: "hunter2", MaxBodyBytes: 1 << 20}
+	config.SetConfig(conf)
+	body := "notpayload=%7B%7D"
+	work := make(chan Delivery, 1)
+	h := WebhookHandler(conf.DefaultHook(), &Intake{Work: work}, config.DiscardLogger)
+	req := httptest.NewRequest("POST", "/", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("X-Github-Event", "issues")
+	req.Header.Set("X-Hub-Signature", testutil.Sign(body, "hunter2"))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected 400, got %d", rec.Code)
+	}
+}
+
+func TestWebhookQueueFull(t *testing.T) {
+	conf := &config.Config{GHSecret: "hunter2", MaxBodyBytes: 1 << 20}
+	config.SetConfig(conf)
+	body := `{"action":"opened"}`
+	work := make(chan Delivery)
+	h := WebhookHandler(conf.DefaultHook(), &Intake{Work: work}, config.DiscardLogger)
+	req := httptest.NewRequest("POST", "/", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Github-Event", "issues")
+	req.Header.Set("X-Hub-Signature", testutil.Sign(body, "hunter2"))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503, got %d", rec.Code)
+	}
+}
+
+func TestExtractSignature(t *testing.T) {
+	cases := []struct {
+		header string
+		err    bool
+	}{
+		{"", true},
+		{"sha1", true},
+		{"sha1=", true},
+		{"md5=abcd", true},
+		{"sha1=nothex", true},
+		{"sha1=0a1b2c", false},
+		{"sha256=0a1b2c", false},
+		{"sha512=0a1b2c", true},
+	}
+	for _, c := range cases {
+		_, err := ExtractSignature(c.header)
+		if (err != nil) != c.err {
+			t.Errorf("ExtractSignature(%q): unexpected error state %v", c.header, err)
+		}
+	}
+}
+
+func TestShutdownFinishesInFlight(t *testing.T) {
+	conf := &config.Config{GHSecret: "hunter2", MaxBodyBytes: 1 << 20}
+	config.SetConfig(conf)
+	work := make(chan Delivery, 1)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: WebhookHandler(conf.DefaultHook(), &Intake{Work: work}, config.DiscardLogger)}
+	go srv.Serve(ln)
+
+	// Start a delivery but hold back the end of the body, so it's still in
+	// flight when shutdown starts.
+	body := `{"action":"opened"}`
+	pr, pw := io.Pipe()
+	req, _ := http.NewRequest("POST", "http://"+ln.Addr().String()+"/", pr)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Github-Event", "issues")
+	req.Header.Set("X-Hub-Signature", testutil.Sign(body, "hunter2"))
+	codes := make(chan int, 1)
+	go func() {
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Error(err)
+			codes <- 0
+			return
+		}
+		resp.Body.Close()
+		codes <- resp.StatusCode
+	}()
+	pw.Write([]byte(body[:5]))
+	time.Sleep(50 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- srv.Shutdown(ctx) }()
+	time.Sleep(50 * time.Millisecond)
+	pw.Write([]byte(body[5:]))
+	pw.Close()
+
+	if code := <-codes; code != http.StatusAccepted {
+		t.Errorf("expected the in-flight delivery to get 202, got %d", code)
+	}
+	if err := <-shutdown; err != nil {
+		t.Errorf("shutdown failed: %v", err)
+	}
+}
+
+func TestReadBodySums(t *testing.T) {
+	config.SetConfig(&config.Config{MaxBodyBytes: 1 << 20})
+	body := `{"zen":"Keep it logically awesome."}`
+	mac, _ := ExtractSignature(testutil.Sign(body, "old"))
+	for _, size := range []int64{int64(len(body)), -1} {
+		req := httptest.NewRequest("POST", "/", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.ContentLength = size // -1 as if it were chunked
+		macs := newSecretMACs(sha1.New, []string{"new", "old"})
+		got, _, ok := readBody(httptest.NewRecorder(), req, config.DiscardLogger, macs.Writers()...)
+		if !ok || string(got) != body {
+			t.Errorf("Content-Length %d: expected the body back, got %q", size, got)
+		}
+		if i := macs.Match(mac); i != 1 {
+			t.Errorf("Content-Length %d: expected secret 1 to match, got %d", size, i)
+		}
+	}
+}
+
+// A push with lots of commits, the biggest thing GitHub usually sends us.
+func largePush() string {
+	var b strings.Builder
+	b.WriteString(`{"ref":"refs/heads/main","repository":{"full_name":"ury/website"},"sender":{"login":"alice"},"commits":[`)
+	for i := 0; i < 2000; i++ {
+		if i > 0 {
+			b.WriteString(",")
+		}
+		fmt.Fprintf(&b, `{"id":"%040d","message":"Commit number %d, with a longer message than most","author":{"name":"Alice","email":"alice@example.org"},"added":[],"removed":[],"modified":["README.md"]}`, i, i)
+	}
+	b.WriteString("]}")
+	return b.String()
+}
+
+// How much reading and checking a big delivery costs, against reading it all
+// and then going over it again for the HMAC as we used to.
+func BenchmarkReadBody(b *testing.B) {
+	config.SetConfig(&config.Config{MaxBodyBytes: 5 << 20})
+	body := largePush()
+	secrets := []string{"new", "old"}
+	mac, _ := ExtractSignature(testutil.Sign(body, "old"))
+	request := func() *http.Request {
+		req := httptest.NewRequest("POST", "/", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		return req
+	}
+	b.Run("ReadAll", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			got, _ := ioutil.ReadAll(http.MaxBytesReader(httptest.NewRecorder(), request().Body, 5<<20))
+			if MatchSecret(got, mac, secrets) != 1 {
+				b.Fatal("no match")
+			}
+		}
+	})
+	b.Run("readBody", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			macs := newSecretMACs(sha1.New, secrets)
+			readBody(httptest.NewRecorder(), request(), config.DiscardLogger, macs.Writers()...)
+			if macs.Match(mac) != 1 {
+				b.Fatal("no match")
+			}
+		}
+	})
+}
+
+func TestMatchSecret(t *testing.T) {
+	body := []byte(`{"zen":"Keep it logically awesome."}`)
+	mac, _ := ExtractSignature(testutil.Sign(string(body), "new"))
+	if i := MatchSecret(body, mac, []string{"new", "old"}); i != 0 {
+		t.Errorf("expected secret 0 to match, got %d", i)
+	}
+	if i := MatchSecret(body, mac, []string{"old", "new"}); i != 1 {
+		t.Errorf("expected secret 1 to match, got %d", i)
+	}
+	if i := MatchSecret(body, mac, []string{"old"}); i != -1 {
+		t.Errorf("expected no match, got %d", i)
+	}
+	if i := MatchSecret(body, mac, nil); i != -1 {
+		t.Errorf("expected no match with no secrets, got %d", i)
+	}
+}
@@ -0,0 +1,200 @@
+package hookserver
+
+import (
+	"context"
+	"hash/fnv"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/config"
+	"github.com/UniversityRadioYork/CaptainHook/internal/format"
+	"github.com/UniversityRadioYork/CaptainHook/internal/github"
+	"github.com/UniversityRadioYork/CaptainHook/internal/github/conflicts"
+	"github.com/UniversityRadioYork/CaptainHook/internal/ircbot"
+	"github.com/UniversityRadioYork/CaptainHook/internal/metrics"
+	"github.com/UniversityRadioYork/CaptainHook/internal/outputs"
+	"github.com/UniversityRadioYork/CaptainHook/internal/panics"
+)
+
+// A verified delivery waiting to be turned into an announcement.
+type Delivery struct {
+	Source  string // SourceGitHub, SourceGitLab, ...
+	Hook    *config.Hook
+	Event   string // X-GitHub-Event or equivalent
+	ID      string // X-GitHub-Delivery or equivalent
+	Header  http.Header
+	Payload []byte // The JSON, already unwrapped if it was form-encoded
+	Text    string // Already rendered, for generic hooks
+}
+
+// Parse a delivery's payload according to where it came from.
+func ParseDelivery(d Delivery) (*format.Event, error) {
+	conf := config.CurrentConfig()
+	switch d.Source {
+	case format.SourceGitLab:
+		return ParseGitLabEvent(d.Event, d.Payload)
+	case SourceJenkins:
+		return ParseJenkinsEvent(d.Payload, conf.JenkinsNotify)
+	case SourceAlertmanager:
+		return ParseAlertmanagerEvent(d.Payload)
+	case SourceGrafana:
+		return ParseGrafanaEvent(d.Payload, conf.GrafanaShortenURLs)
+	case SourceSentry:
+		return ParseSentryEvent(d.Event, d.Payload)
+	case SourceGeneric:
+		return &format.Event{Source: SourceGeneric, Type: "generic", Repo: d.Event, Message: d.Text, KeepURL: true}, nil
+	default:
+		return github.ParseGitHubEvent(d.Event, d.Payload)
+	}
+}
+
+// What the workers keep up to date besides the announcements themselves,
+// made by main. Any of it can be nil to go without.
+type Processor struct {
+	Feed          *outputs.Feed
+	UnknownEvents *ircbot.UnknownEvents
+	RemovedHooks  *ircbot.RemovedHooks
+	Conflicts     *conflicts.Tracker
+}
+
+// Parse and format a delivery. ok is false if there's nothing to announce.
+// Anything slow, like shortening, gives up once ctx is done.
+func (p *Processor) ProcessDelivery(ctx context.Context, d Delivery, logger *slog.Logger) (a outputs.Announcement, ok bool, err error) {
+	e, err := ParseDelivery(d)
+	conf := config.CurrentConfig()
+	if d.Source == format.SourceGitHub && err == nil {
+		if action, unknown := ircbot.UnknownGitHubEvent(d.Event, e, d.Payload); unknown {
+			p.UnknownEvents.Seen(conf, d.Event, action, d.ID, d.Payload, logger)
+		}
+	}
+	if err != nil || e == nil {
+		return a, false, err
+	}
+	repo := config.PayloadRepo(d.Payload)
+	conf.SeenRepos.Add(repo)
+	if repo == "" && e.Source == format.SourceGitHub {
+		repo = config.PayloadOrg(d.Payload) // Goes by the org's [repos] entry
+	}
+	a, ok = announceEvent(ctx, conf, d, e, repo, logger)
+	return a, ok, nil
+}
+
+// Turn an Event from d, about repo (owner/name, or just an org), into an announcement as its
+// repo's settings say. ok is false if they don't want it.
+func announceEvent(ctx context.Context, conf *config.Config, d Delivery, e *format.Event, repo string, logger *slog.Logger) (a outputs.Announcement, ok bool) {
+	settings := conf.ForRepo(repo)
+	if !settings.WantsAction(e) {
+		return a, false
+	}
+	if e.Ref != "" && !config.MatchBranch(e.Ref, settings.Branches) {
+		return a, false
+	}
+	e.Repo = conf.RepoLabel(e.Repo, repo)
+	channels := d.Hook.Channels
+	if settings.Channels != nil {
+		channels = settings.Channels
+	}
+	channels = conf.Subscriptions.Apply(repo, channels)
+	text := settings.Format(ctx, e, logger)
+	return outputs.Announcement{
+		Channels: channels,
+		Text:     text,
+		Age:      format.AgeSuffix(e),
+		Prefix:   outputs.RepoPrefix(text, e.Repo),
+		Event:    d.Event,
+		Repo:     e.Repo,
+		FullName: repo,
+		Title:    e.Title,
+		URL:      e.URL,
+		Color:    settings.ActionColors[format.EventAction(e)],
+		Priority: conf.IsPriority(e),
+		ID:       d.ID,
+		Private:  e.Private,
+		Action:   format.EventAction(e),
+		Sender:   e.Sender,
+		Number:   e.Number,
+		Time:     time.Now(),
+	}, true
+}
+
+// Somewhere for workers to put announcements.
+type Pusher interface {
+	Push(outputs.Announcement) bool
+}
+
+// Turn deliveries from work into announcements on msgs until work is closed.
+// Once ctx is done, what's left still gets announced, just without waiting on
+// anything.
+func (p *Processor) Worker(ctx context.Context, work <-chan Delivery, msgs Pusher, logger *slog.Logger) {
+	for d := range work {
+		p.handleDelivery(ctx, d, msgs, logger)
+	}
+}
+
+// One delivery's worth of Worker. A payload that makes us panic is logged
+// and dropped, and the worker gets on with the next.
+func (p *Processor) handleDelivery(ctx context.Context, d Delivery, msgs Pusher, logger *slog.Logger) {
+	defer panics.Recover("worker", logger.With("event", d.Event, "delivery", d.ID))
+	a, ok, err := p.ProcessDelivery(ctx, d, logger)
+	switch {
+	case err != nil:
+		logger.Error("Error processing delivery", "event", d.Event, "delivery", d.ID, "repo", config.PayloadRepo(d.Payload), "err", err)
+		countProcessed(d, metrics.OutcomeParseError)
+	case !ok:
+		countProcessed(d, metrics.OutcomeIgnoredAction)
+	default:
+		countProcessed(d, metrics.OutcomeAnnounced)
+		p.Feed.Add(a)
+		msgs.Push(a)
+	}
+	if d.Source == format.SourceGitHub {
+		p.RemovedHooks.Seen(d.Event, d.ID, d.Payload, logger)
+		conf := config.CurrentConfig()
+		repo, events := p.Conflicts.Check(ctx, conf, d.Event, d.Payload, logger)
+		for _, e := range events {
+			if a, ok := announceEvent(ctx, conf, d, e, repo, logger); ok {
+				p.Feed.Add(a)
+				msgs.Push(a)
+			}
+		}
+	}
+}
+
+// Run n workers on the deliveries from work until it's closed and they're all
+// done. Each repo's deliveries always go to the same worker, so they're
+// announced in the order they came in however long each takes to format (a
+// slow shortener, say), and a PR isn't merged before it's opened. A busy
+// worker holds up the rest of the queue, but only until it's free.
+func (p *Processor) RunWorkers(ctx context.Context, n int, work <-chan Delivery, msgs Pusher, logger *slog.Logger) {
+	lanes := make([]chan Delivery, n)
+	var wg sync.WaitGroup
+	for i := range lanes {
+		lanes[i] = make(chan Delivery)
+		wg.Add(1)
+		go func(lane <-chan Delivery) {
+			defer wg.Done()
+			p.Worker(ctx, lane, msgs, logger)
+		}(lanes[i])
+	}
+	for d := range work {
+		h := fnv.New32a()
+		h.Write([]byte(orderingKey(d)))
+		lanes[h.Sum32()%uint32(n)] <- d
+	}
+	for _, lane := range lanes {
+		close(lane)
+	}
+	wg.Wait()
+}
+
+// What a delivery needs to stay in order with: its repo, or where it came
+// from for things without one.
+func orderingKey(d Delivery) string {
+	if repo := config.PayloadRepo(d.Payload); repo != "" {
+		return strings.ToLower(repo)
+	}
+	return d.Source + "/" + d.Event
+}
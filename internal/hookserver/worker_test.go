@@ -0,0 +1,132 @@
+package hookserver
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/config"
+	"github.com/UniversityRadioYork/CaptainHook/internal/config/configtest"
+	"github.com/UniversityRadioYork/CaptainHook/internal/format"
+	"github.com/UniversityRadioYork/CaptainHook/internal/ircbot"
+)
+
+func TestWorker(t *testing.T) {
+	payload, err := ioutil.ReadFile("testdata/issues_opened.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hook := &config.Hook{Name: "test", Channels: []string{"#a", "#b"}}
+	work := make(chan Delivery, 2)
+	msgs := ircbot.NewQueue(2, config.DropNewest, 0, config.DiscardLogger)
+	work <- Delivery{Hook: hook, Event: "issues", Payload: []byte(`{"action":"labeled"}`)}
+	work <- Delivery{Hook: hook, Event: "issues", Payload: payload}
+	close(work)
+	new(Processor).Worker(context.Background(), work, msgs, config.DiscardLogger)
+
+	if depth := msgs.Stats().Depth; depth != 1 {
+		t.Fatalf("expected exactly one announcement, got %d", depth)
+	}
+	a := <-msgs.C()
+	want := "[\x0306website\x0f] Issue #42 \x0303opened\x0f by x1tot: Stream relay drops out every hour. https://github.com/UniversityRadioYork/website/issues/42\x0f"
+	if a.Text != want {
+		t.Errorf("expected %q, got %q", want, a.Text)
+	}
+	if len(a.Channels) != 2 || a.Channels[0] != "#a" || a.Channels[1] != "#b" {
+		t.Errorf("announcement went to the wrong channels: %v", a.Channels)
+	}
+}
+
+// Takes a while over some URLs, so later deliveries could overtake.
+type slowShortener struct{}
+
+func (slowShortener) Shorten(ctx context.Context, long string) (string, error) {
+	if strings.HasSuffix(long, "/1") {
+		time.Sleep(20 * time.Millisecond)
+	}
+	return long, nil
+}
+
+func TestRunWorkersOrdering(t *testing.T) {
+	c := configtest.Valid()
+	c.Events = map[string][]string{"issues": {"*"}}
+	if errs := c.Validate(); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	c.LinkShortener = slowShortener{}
+	config.SetConfig(c)
+
+	hook := &config.Hook{Name: "test", Channels: []string{"#a"}}
+	work := make(chan Delivery, 100)
+	for repo := 0; repo < 10; repo++ {
+		for step := 0; step < 4; step++ {
+			// Each repo's first is slow to shorten, the rest quick.
+			body := fmt.Sprintf(`{"action":"edited","issue":{"number":%d,"title":"%d","html_url":"https://example.org/%d"},`+
+				`"repository":{"name":"r%d","full_name":"ury/r%d"}}`, repo, step, step+1, repo, repo)
+			work <- Delivery{Hook: hook, Event: "issues", Payload: []byte(body)}
+		}
+	}
+	close(work)
+	msgs := &recordingPusher{}
+	new(Processor).RunWorkers(context.Background(), 4, work, msgs, config.DiscardLogger)
+
+	if len(msgs.pushed) != 40 {
+		t.Fatalf("expected 40 announcements, got %d", len(msgs.pushed))
+	}
+	last := make(map[string]int)
+	for _, a := range msgs.pushed {
+		var step int
+		fmt.Sscanf(a.Text[strings.LastIndex(a.Text, ": ")+2:], "%d", &step)
+		if prev, ok := last[a.Repo]; ok && step != prev+1 {
+			t.Errorf("%s: step %d announced after step %d", a.Repo, step, prev)
+		}
+		last[a.Repo] = step
+	}
+}
+
+// Never answers, waiting for whoever asked to give up.
+type stuckShortener struct{}
+
+func (stuckShortener) Shorten(ctx context.Context, long string) (string, error) {
+	select {
+	case <-time.After(10 * time.Second):
+		return "https://s/", nil
+	case <-ctx.Done():
+		return long, ctx.Err()
+	}
+}
+
+func TestWorkerCancelled(t *testing.T) {
+	payload, err := ioutil.ReadFile("testdata/issues_opened.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := configtest.Valid()
+	if errs := c.Validate(); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	breaker := format.NewBreakerShortener(stuckShortener{}, 1, time.Minute)
+	c.LinkShortener = breaker
+	config.SetConfig(c)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	work := make(chan Delivery, 1)
+	work <- Delivery{Hook: &config.Hook{Channels: []string{"#a"}}, Event: "issues", Payload: payload}
+	close(work)
+	msgs := &recordingPusher{}
+	start := time.Now()
+	time.AfterFunc(20*time.Millisecond, cancel)
+	new(Processor).Worker(ctx, work, msgs, config.DiscardLogger)
+	if took := time.Since(start); took < 20*time.Millisecond || took > time.Second {
+		t.Errorf("expected the shortener to be waited on until cancelled, took %v", took)
+	}
+	if len(msgs.pushed) != 1 || !strings.HasSuffix(msgs.pushed[0].Text, "/issues/42\x0f") {
+		t.Errorf("expected the announcement with its long URL, got %+v", msgs.pushed)
+	}
+	if !breaker.OpenUntil.IsZero() {
+		t.Errorf("expected being cancelled not to trip the breaker")
+	}
+}
@@ -0,0 +1,185 @@
+package ircbot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/config"
+	"github.com/UniversityRadioYork/CaptainHook/internal/format"
+	"github.com/UniversityRadioYork/CaptainHook/internal/github"
+)
+
+// Answers !ci with how the checks on the head of a repo's default branch
+// went, from commit statuses and check runs both. Answers are kept for a
+// minute, as "is main green?" tends to get asked by several people at once.
+type CIChecks struct {
+	api github.GitHubAPI
+	ttl time.Duration
+	now func() time.Time
+
+	mu      sync.Mutex
+	answers map[string]cachedCI // By lower case owner/name
+}
+
+type cachedCI struct {
+	lines []string
+	at    time.Time
+}
+
+func NewCIChecks(api github.GitHubAPI) *CIChecks {
+	return &CIChecks{
+		api:     api,
+		ttl:     time.Minute,
+		now:     time.Now,
+		answers: make(map[string]cachedCI),
+	}
+}
+
+// The most failing checks we'll list before "and N more".
+const maxCIFailures = 5
+
+// A check that's finished and not gone well, or is still going.
+type ciCheck struct {
+	name  string
+	state string // As format.DefaultStateColors has it
+	url   string
+}
+
+// Check run conclusions that are fine.
+var okConclusions = map[string]bool{"success": true, "neutral": true, "skipped": true}
+
+// The reply to "!ci [repo]" said in channel, a line or a few.
+func (c *CIChecks) Reply(ctx context.Context, conf *config.Config, channel string, args []string, logger *slog.Logger) []string {
+	if conf == nil || conf.GitHubToken == "" {
+		return []string{"Sorry, !ci needs a GitHubToken in my config, and I haven't got one"}
+	}
+	if len(args) > 1 {
+		return []string{"Usage: !ci [repo]"}
+	}
+	var repo string
+	if len(args) == 1 {
+		repo = args[0]
+	}
+	full := conf.LookupRepo(channel, repo)
+	if full == "" {
+		if repo == "" {
+			return []string{"Which repo? Try !ci owner/name"}
+		}
+		return []string{fmt.Sprintf("I don't know which %s you mean, try owner/name", repo)}
+	}
+
+	key := strings.ToLower(full)
+	c.mu.Lock()
+	cached, ok := c.answers[key]
+	c.mu.Unlock()
+	if ok && c.now().Sub(cached.at) < c.ttl {
+		return cached.lines
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, github.LookupTimeout)
+	defer cancel()
+	branch, failing, pending, total, err := c.checks(ctx, conf, full)
+	var status *github.StatusError
+	switch {
+	case errors.As(err, &status) && status.Code == http.StatusNotFound:
+		return []string{"I can't find " + full}
+	case github.Unavailable(err):
+		return []string{"Sorry, !ci is temporarily unavailable, GitHub's rate limiting me"}
+	case err != nil:
+		logger.Warn("Couldn't get a repo's checks", "repo", full, "err", err)
+		return []string{"Sorry, I couldn't get that from GitHub"}
+	}
+
+	prefix := "[" + format.IrcColorize(path.Base(full), format.ColorPurple) + "] " + branch
+	var lines []string
+	switch {
+	case total == 0:
+		lines = []string{prefix + " has no checks"}
+	case len(failing) > 0:
+		for i, f := range failing {
+			if i == maxCIFailures {
+				lines = append(lines, fmt.Sprintf("…and %d more", len(failing)-i))
+				break
+			}
+			line := prefix + ": " + f.name + " " + format.IrcColorize(strings.ToUpper(strings.Replace(f.state, "_", " ", -1)), format.ConclusionColor(f.state))
+			if f.url != "" {
+				line += " " + f.url
+			}
+			lines = append(lines, line)
+		}
+	case pending > 0:
+		lines = []string{fmt.Sprintf("%s is %s, %d of %d checks still going", prefix, format.IrcColorize("pending", format.ConclusionColor("pending")), pending, total)}
+	default:
+		lines = []string{prefix + " is " + format.IrcColorize("all green ✔", format.ConclusionColor("success"))}
+	}
+	c.mu.Lock()
+	c.answers[key] = cachedCI{lines: lines, at: c.now()}
+	c.mu.Unlock()
+	return lines
+}
+
+// What the checks on the head of repo's default branch say: those that have
+// failed, how many are still going, and how many there are altogether.
+func (c *CIChecks) checks(ctx context.Context, conf *config.Config, repo string) (branch string, failing []ciCheck, pending, total int, err error) {
+	get := func(path string, v interface{}) error {
+		return c.api.Get(ctx, conf.GitHubAPIURL, conf.GitHubToken, path, v)
+	}
+	var info struct {
+		DefaultBranch string `json:"default_branch"`
+	}
+	if err = get("/repos/"+repo, &info); err != nil {
+		return
+	}
+	branch = info.DefaultBranch
+	ref := url.PathEscape(branch)
+
+	var combined struct {
+		Statuses []struct {
+			Context   string
+			State     string
+			TargetURL string `json:"target_url"`
+		}
+	}
+	if err = get("/repos/"+repo+"/commits/"+ref+"/status", &combined); err != nil {
+		return
+	}
+	for _, s := range combined.Statuses {
+		total++
+		switch s.State {
+		case "pending":
+			pending++
+		case "failure", "error":
+			failing = append(failing, ciCheck{s.Context, s.State, s.TargetURL})
+		}
+	}
+
+	var runs struct {
+		CheckRuns []struct {
+			Name       string
+			Status     string
+			Conclusion string
+			HTMLURL    string `json:"html_url"`
+		} `json:"check_runs"`
+	}
+	if err = get("/repos/"+repo+"/commits/"+ref+"/check-runs?per_page=100", &runs); err != nil {
+		return
+	}
+	for _, r := range runs.CheckRuns {
+		total++
+		switch {
+		case r.Status != "completed":
+			pending++
+		case !okConclusions[r.Conclusion]:
+			failing = append(failing, ciCheck{r.Name, r.Conclusion, r.HTMLURL})
+		}
+	}
+	return
+}
@@ -0,0 +1,79 @@
+package ircbot
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/config"
+	"github.com/UniversityRadioYork/CaptainHook/internal/config/configtest"
+	"github.com/UniversityRadioYork/CaptainHook/internal/github"
+	"github.com/UniversityRadioYork/CaptainHook/internal/outputs"
+)
+
+// A GitHub API where ury/website's main has the given statuses and check
+// runs, as JSON.
+func fakeChecksAPI(statuses, runs string, asked *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(asked, 1)
+		switch r.URL.Path {
+		case "/repos/ury/website":
+			w.Write([]byte(`{"default_branch": "main"}`))
+		case "/repos/ury/website/commits/main/status":
+			fmt.Fprintf(w, `{"state": "whatever", "statuses": [%s]}`, statuses)
+		case "/repos/ury/website/commits/main/check-runs":
+			fmt.Fprintf(w, `{"check_runs": [%s]}`, runs)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+func TestCIChecks(t *testing.T) {
+	config.SetConfig(configtest.Valid())
+	for _, c := range []struct {
+		name, statuses, runs string
+		want                 []string
+	}{
+		{"green", `{"context": "jenkins", "state": "success"}`, `{"name": "test", "status": "completed", "conclusion": "success"}, {"name": "lint", "status": "completed", "conclusion": "skipped"}`,
+			[]string{"[%C06website%O] main is %C03all green ✔%O"}},
+		{"failing", `{"context": "jenkins", "state": "error", "target_url": "https://ci.ury.org.uk/1"}`, `{"name": "test", "status": "completed", "conclusion": "timed_out", "html_url": "https://github.com/ury/website/runs/2"}, {"name": "lint", "status": "in_progress"}`,
+			[]string{"[%C06website%O] main: jenkins %C04ERROR%O https://ci.ury.org.uk/1", "[%C06website%O] main: test %C04TIMED OUT%O https://github.com/ury/website/runs/2"}},
+		{"pending", ``, `{"name": "test", "status": "queued"}, {"name": "lint", "status": "completed", "conclusion": "success"}`,
+			[]string{"[%C06website%O] main is %C08pending%O, 1 of 2 checks still going"}},
+		{"none", ``, ``, []string{"[%C06website%O] main has no checks"}},
+		{"lots", ``, strings.Repeat(`{"name": "test", "status": "completed", "conclusion": "failure"}, `, 6) + `{"name": "test", "status": "completed", "conclusion": "failure"}`,
+			nil},
+	} {
+		var asked int32
+		api := fakeChecksAPI(c.statuses, c.runs, &asked)
+		conf := configtest.Valid()
+		conf.GitHubToken, conf.GitHubAPIURL = "tok", api.URL
+		checks := NewCIChecks(github.NewGitHubClient())
+		var got []string
+		for _, line := range checks.Reply(context.Background(), conf, "#web", []string{"ury/website"}, config.DiscardLogger) {
+			got = append(got, outputs.ShowFormatting(line))
+		}
+		if c.want == nil {
+			if len(got) != maxCIFailures+1 || got[maxCIFailures] != "…and 2 more" {
+				t.Errorf("%s: expected %d failures and how many more, got %q", c.name, maxCIFailures, got)
+			}
+		} else if strings.Join(got, "|") != strings.Join(c.want, "|") {
+			t.Errorf("%s: expected %q, got %q", c.name, c.want, got)
+		}
+		before := atomic.LoadInt32(&asked)
+		checks.Reply(context.Background(), conf, "#web", []string{"ury/website"}, config.DiscardLogger)
+		if asked != before {
+			t.Errorf("%s: expected the second ask answered from the cache", c.name)
+		}
+		api.Close()
+	}
+
+	if got := NewCIChecks(nil).Reply(context.Background(), configtest.Valid(), "#web", nil, config.DiscardLogger); len(got) != 1 || !strings.Contains(got[0], "GitHubToken") {
+		t.Errorf("expected to hear there's no token, got %q", got)
+	}
+}
@@ -0,0 +1,69 @@
+package ircbot
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/config"
+	"github.com/UniversityRadioYork/CaptainHook/internal/format"
+	"github.com/UniversityRadioYork/CaptainHook/internal/github"
+	"github.com/UniversityRadioYork/CaptainHook/internal/metrics"
+)
+
+// What /coverage says: the GitHub event types and actions we handle, from
+// github.Events, and those we've been sent since startup that we don't, so
+// we know which are worth handling next.
+type Coverage struct {
+	Since   time.Time           `json:"since"`
+	Handled map[string][]string `json:"handled"`
+	Unknown []UnknownEvent      `json:"unknown"`
+}
+
+// The most unknown events !coverage lists before "and N more".
+const maxCoverageListed = 8
+
+func GatherCoverage(u *UnknownEvents) Coverage {
+	c := Coverage{Since: metrics.StartTime.UTC(), Handled: make(map[string][]string), Unknown: u.List()}
+	for ev, h := range github.Events {
+		actions := append([]string{}, h.Actions...)
+		sort.Strings(actions)
+		c.Handled[ev] = actions
+	}
+	if c.Unknown == nil {
+		c.Unknown = []UnknownEvent{}
+	}
+	return c
+}
+
+// The reply to !coverage from mask: the unknown events we've had, most
+// often first.
+func CoverageCommand(conf *config.Config, u *UnknownEvents, mask string, now time.Time) string {
+	if !conf.IsIRCAdmin(mask) {
+		return "Sorry, only admins can do that"
+	}
+	list := u.List()
+	if len(list) == 0 {
+		return "Nothing I don't handle since I started, all covered!"
+	}
+	var parts []string
+	for i, e := range list {
+		if i == maxCoverageListed {
+			parts = append(parts, fmt.Sprintf("and %d more", len(list)-i))
+			break
+		}
+		name := e.Event
+		if e.Action != "" {
+			name += "." + e.Action
+		}
+		ago := format.HumanDuration(now.Sub(e.LastSeen))
+		if ago == "moments" || ago == "" {
+			ago = "just now"
+		} else {
+			ago += " ago"
+		}
+		parts = append(parts, fmt.Sprintf("%s %d (last %s)", name, e.Count, ago))
+	}
+	return "Not handled since I started: " + strings.Join(parts, ", ")
+}
@@ -0,0 +1,178 @@
+package ircbot
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/config"
+	"github.com/UniversityRadioYork/CaptainHook/internal/format"
+	"github.com/UniversityRadioYork/CaptainHook/internal/outputs"
+)
+
+// Batches up announcements when a repo gets busy, so a triage session
+// closing 40 issues doesn't take over the channel: past DigestThreshold
+// announcements for one repo in one channel within DigestWindow, the rest
+// are held and go out as a single summary once the window's up. Like the
+// Scheduler, it's for the broadcast loop alone.
+type Digester struct {
+	recent  map[string][]time.Time  // When announcements came, by channel and repo, for the last window
+	batches map[string]*digestBatch // Being held, by the same
+	now     func() time.Time
+}
+
+// What's been held for one channel and repo.
+type digestBatch struct {
+	channel  string
+	repo     string // As announced
+	fullName string
+	web      string // GitHub's, or our GHES's, so there's somewhere to send people for details
+	start    time.Time
+	types    []string                    // Event types, in the order they came
+	actions  map[string][]string         // By event type, in the order they came
+	counts   map[string]int              // By type.action
+	colors   map[string]format.MIRCColor // By action
+	senders  []string                    // Everyone involved, in order
+}
+
+func NewDigester() *Digester {
+	return &Digester{recent: make(map[string][]time.Time), batches: make(map[string]*digestBatch), now: time.Now}
+}
+
+// Cut an announcement down to the channels it should go to now, holding it
+// for any where its repo's being digested. Priority announcements, and
+// anything without a repo, are never held.
+func (d *Digester) Filter(c *config.Config, a outputs.Announcement) outputs.Announcement {
+	if a.Priority || a.FullName == "" {
+		return a
+	}
+	now := d.now()
+	cutoff := now.Add(-c.DigestWindow)
+	var open []string
+	for _, ch := range a.Channels {
+		threshold := c.ChannelDigestThreshold(ch)
+		if threshold <= 0 {
+			open = append(open, ch)
+			continue
+		}
+		key := strings.ToLower(ch) + " " + strings.ToLower(a.FullName)
+		recent := d.recent[key]
+		for len(recent) > 0 && !recent[0].After(cutoff) {
+			recent = recent[1:]
+		}
+		d.recent[key] = append(recent, now)
+		if b := d.batches[key]; b != nil {
+			b.add(c, a)
+		} else if len(d.recent[key]) > threshold {
+			b = &digestBatch{channel: ch, repo: a.Repo, fullName: a.FullName, start: now,
+				actions: make(map[string][]string), counts: make(map[string]int), colors: make(map[string]format.MIRCColor)}
+			b.add(c, a)
+			d.batches[key] = b
+		} else {
+			open = append(open, ch)
+		}
+	}
+	a.Channels = open
+	return a
+}
+
+// Summaries of the batches whose window is up, and forget about anything
+// that's been quiet for a window.
+func (d *Digester) Due(c *config.Config) []outputs.Announcement {
+	now := d.now()
+	var due []outputs.Announcement
+	for key, b := range d.batches {
+		if !now.Before(b.start.Add(c.DigestWindow)) {
+			due = append(due, b.summary(now))
+			delete(d.batches, key)
+		}
+	}
+	cutoff := now.Add(-c.DigestWindow)
+	for key, recent := range d.recent {
+		if _, held := d.batches[key]; !held && !recent[len(recent)-1].After(cutoff) {
+			delete(d.recent, key)
+		}
+	}
+	return due
+}
+
+// Summaries of everything being held, whatever the time, e.g. at shutdown.
+func (d *Digester) Flush() []outputs.Announcement {
+	now := d.now()
+	var all []outputs.Announcement
+	for key, b := range d.batches {
+		all = append(all, b.summary(now))
+		delete(d.batches, key)
+	}
+	return all
+}
+
+func (b *digestBatch) add(c *config.Config, a outputs.Announcement) {
+	if !config.Contains(b.types, a.Event) {
+		b.types = append(b.types, a.Event)
+	}
+	if !config.Contains(b.actions[a.Event], a.Action) {
+		b.actions[a.Event] = append(b.actions[a.Event], a.Action)
+	}
+	b.counts[a.Event+"."+a.Action]++
+	if a.Color != "" {
+		b.colors[a.Action] = a.Color
+	}
+	if a.Sender != "" && !config.Contains(b.senders, a.Sender) {
+		b.senders = append(b.senders, a.Sender)
+	}
+	if u, err := url.Parse(a.URL); b.web == "" && err == nil && config.Contains(c.GitHubHosts(), strings.ToLower(u.Host)) {
+		b.web = u.Scheme + "://" + u.Host
+	}
+}
+
+// Names for event types in summaries, singular and plural.
+var digestNouns = map[string][2]string{
+	"issues":       {"issue", "issues"},
+	"pull_request": {"PR", "PRs"},
+	"push":         {"push", "pushes"},
+}
+
+// Like "[website] 12 issues closed, 3 opened, 2 PRs merged by alice, bob —
+// details: https://github.com/...".
+func (b *digestBatch) summary(now time.Time) outputs.Announcement {
+	var parts []string
+	for _, ev := range b.types {
+		noun, ok := digestNouns[ev]
+		if !ok {
+			noun = [2]string{ev, ev}
+		}
+		for i, action := range b.actions[ev] {
+			n := b.counts[ev+"."+action]
+			part := fmt.Sprint(n)
+			if i == 0 {
+				part += " " + noun[1]
+				if n == 1 {
+					part = "1 " + noun[0]
+				}
+			}
+			if action != "" {
+				part += " " + format.IrcColorize(action, b.colors[action])
+			}
+			parts = append(parts, part)
+		}
+	}
+	text := "[" + format.IrcColorize(b.repo, format.ColorPurple) + "] " + strings.Join(parts, ", ")
+	if len(b.senders) > 0 {
+		text += " by " + strings.Join(b.senders, ", ")
+	}
+	a := outputs.Announcement{
+		Channels: []string{b.channel},
+		Event:    "digest",
+		Repo:     b.repo,
+		FullName: b.fullName,
+		Time:     now,
+	}
+	if b.web != "" {
+		a.URL = b.web + "/" + b.fullName + "/issues?q=" + url.QueryEscape("updated:>="+b.start.UTC().Format("2006-01-02T15:04:05Z"))
+		text += " — details: " + a.URL
+	}
+	a.Text = text
+	return a
+}
@@ -0,0 +1,156 @@
+package ircbot
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/config"
+	"github.com/UniversityRadioYork/CaptainHook/internal/config/configtest"
+	"github.com/UniversityRadioYork/CaptainHook/internal/format"
+	"github.com/UniversityRadioYork/CaptainHook/internal/outputs"
+)
+
+func digestConfig() *config.Config {
+	return &config.Config{DigestThreshold: 3, DigestWindow: 10 * time.Minute, ChannelSettings: map[string]config.ChannelConfig{
+		"#quiet": {DigestThreshold: 1},
+		"#all":   {DigestThreshold: -1},
+	}}
+}
+
+func issue(action, sender string) outputs.Announcement {
+	return outputs.Announcement{Channels: []string{"#dev"}, Text: action, Event: "issues", Action: action, Sender: sender,
+		Repo: "website", FullName: "ury/website", URL: "https://github.com/ury/website/issues/1"}
+}
+
+func TestDigestWindow(t *testing.T) {
+	c := digestConfig()
+	d := NewDigester()
+	t0 := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	at := func(offset time.Duration, a outputs.Announcement) []string {
+		d.now = func() time.Time { return t0.Add(offset) }
+		return d.Filter(c, a).Channels
+	}
+	due := func(offset time.Duration) []outputs.Announcement {
+		d.now = func() time.Time { return t0.Add(offset) }
+		return d.Due(c)
+	}
+
+	for i, offset := range []time.Duration{0, time.Minute, 2 * time.Minute} {
+		if got := at(offset, issue("closed", "alice")); len(got) != 1 {
+			t.Fatalf("%d: expected up to the threshold to go out, got %v", i, got)
+		}
+	}
+	if got := at(3*time.Minute, issue("closed", "bob")); len(got) != 0 {
+		t.Fatalf("expected the fourth to be held, got %v", got)
+	}
+	at(4*time.Minute, issue("opened", "alice"))
+	// Priority ones go straight out.
+	p := issue("closed", "carol")
+	p.Priority = true
+	if got := at(5*time.Minute, p); len(got) != 1 {
+		t.Errorf("expected priority to go out, got %v", got)
+	}
+
+	// The batch started at 3m, so it's due at 13m and not a moment before.
+	if got := due(13*time.Minute - time.Nanosecond); len(got) != 0 {
+		t.Fatalf("expected nothing due yet, got %+v", got)
+	}
+	got := due(13 * time.Minute)
+	if len(got) != 1 {
+		t.Fatalf("expected one summary, got %+v", got)
+	}
+	want := "[\x0306website\x0f] 1 issue closed, 1 opened by bob, alice — details: https://github.com/ury/website/issues?q=updated%3A%3E%3D2026-10-16T12%3A03%3A00Z"
+	if got[0].Text != want || got[0].Channels[0] != "#dev" {
+		t.Errorf("expected %q, got %q to %v", want, got[0].Text, got[0].Channels)
+	}
+
+	// Still busy, but the last window only has 4m in it (3m is exactly a
+	// window ago), so two more go out first.
+	for i := 0; i < 2; i++ {
+		if got := at(13*time.Minute, issue("closed", "alice")); len(got) != 1 {
+			t.Errorf("%d: expected to go out with %d in the window, got %v", i, i+2, got)
+		}
+	}
+	if got := at(13*time.Minute, issue("closed", "alice")); len(got) != 0 {
+		t.Errorf("expected to be held again, got %v", got)
+	}
+	if got := d.Flush(); len(got) != 1 || !strings.Contains(got[0].Text, "1 issue closed by alice") {
+		t.Errorf("expected the partial batch on flush, got %+v", got)
+	}
+	if got := d.Flush(); len(got) != 0 {
+		t.Errorf("expected nothing left, got %+v", got)
+	}
+}
+
+// The window rolls: anything exactly a window ago no longer counts.
+func TestDigestRollingWindow(t *testing.T) {
+	c := digestConfig()
+	d := NewDigester()
+	t0 := time.Now()
+	for i, offset := range []time.Duration{0, 5 * time.Minute, 10 * time.Minute, 10 * time.Minute} {
+		d.now = func() time.Time { return t0.Add(offset) }
+		if got := d.Filter(c, issue("closed", "alice")).Channels; len(got) != 1 {
+			t.Errorf("%d: expected it to go out, got %v", i, got)
+		}
+	}
+	if got := d.Filter(c, issue("closed", "alice")).Channels; len(got) != 0 {
+		t.Errorf("expected the fourth in the window to be held, got %v", got)
+	}
+	d.now = func() time.Time { return t0.Add(time.Hour) }
+	d.Due(c)
+	if len(d.recent) != 0 || len(d.batches) != 0 {
+		t.Errorf("expected everything forgotten after a quiet window, got %v %v", d.recent, d.batches)
+	}
+}
+
+// Each channel and repo is counted on its own, with its own threshold.
+func TestDigestPerChannel(t *testing.T) {
+	c := digestConfig()
+	d := NewDigester()
+	a := issue("closed", "alice")
+	a.Channels = []string{"#quiet", "#all", "#dev"}
+	if got := d.Filter(c, a).Channels; strings.Join(got, ",") != "#quiet,#all,#dev" {
+		t.Errorf("expected all at first, got %v", got)
+	}
+	if got := d.Filter(c, a).Channels; strings.Join(got, ",") != "#all,#dev" {
+		t.Errorf("expected #quiet to start holding, got %v", got)
+	}
+	other := a
+	other.FullName = "ury/myradio"
+	if got := d.Filter(c, other).Channels; strings.Join(got, ",") != "#quiet,#all,#dev" {
+		t.Errorf("expected another repo to be separate, got %v", got)
+	}
+	if (&config.Config{}).ChannelDigestThreshold("#dev") != 0 {
+		t.Error("expected no digests by default")
+	}
+}
+
+func TestDigestSummary(t *testing.T) {
+	c := configtest.Valid()
+	b := &digestBatch{channel: "#dev", repo: "website", fullName: "ury/website", start: time.Now(),
+		actions: make(map[string][]string), counts: make(map[string]int), colors: make(map[string]format.MIRCColor)}
+	for i := 0; i < 12; i++ {
+		b.add(c, issue("closed", "alice"))
+	}
+	for i := 0; i < 3; i++ {
+		b.add(c, issue("opened", "bob"))
+	}
+	pr := outputs.Announcement{Event: "pull_request", Action: "merged", Sender: "bob", Color: format.ColorBlue}
+	b.add(c, pr)
+	b.add(c, pr)
+	got := b.summary(time.Now()).Text
+	if !strings.HasPrefix(got, "[\x0306website\x0f] 12 issues closed, 3 opened, 2 PRs \x0302merged\x0f by alice, bob — details: https://github.com/ury/website/issues?q=") {
+		t.Errorf("unexpected summary %q", got)
+	}
+
+	c.GitHubURL = "https://github.ury.org.uk/"
+	b = &digestBatch{channel: "#dev", repo: "website", fullName: "ury/website", start: time.Now(),
+		actions: make(map[string][]string), counts: make(map[string]int), colors: make(map[string]format.MIRCColor)}
+	a := issue("closed", "alice")
+	a.URL = "https://github.ury.org.uk/ury/website/issues/1"
+	b.add(c, a)
+	if got := b.summary(time.Now()).URL; !strings.HasPrefix(got, "https://github.ury.org.uk/ury/website/issues?q=") {
+		t.Errorf("expected details on our GHES, got %q", got)
+	}
+}
@@ -0,0 +1,83 @@
+package ircbot
+
+import (
+	"crypto/sha256"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The most messages we remember for spotting duplicates, whatever the
+// window, so a flood can't eat all our memory.
+const MaxRemembered = 10000
+
+// Spots the same message going to the same place twice within a window, as
+// happens when a repo's hooked up at both the org and the repo level. Their
+// deliveries have different GUIDs, so the DeliveryLog can't tell.
+type DuplicateFilter struct {
+	mu    sync.Mutex
+	seen  map[[sha256.Size]byte]time.Time
+	order []sentMessage // Oldest first
+	max   int
+	now   func() time.Time
+}
+
+type sentMessage struct {
+	hash [sha256.Size]byte
+	at   time.Time
+}
+
+func NewDuplicateFilter(max int) *DuplicateFilter {
+	return &DuplicateFilter{seen: make(map[[sha256.Size]byte]time.Time), max: max, now: time.Now}
+}
+
+// Whether text has gone to target within the window, remembering it if not.
+// A window of 0 turns this off.
+func (f *DuplicateFilter) Duplicate(target, text string, window time.Duration) bool {
+	if f == nil || window <= 0 || text == "" {
+		return false
+	}
+	hash := sha256.Sum256([]byte(strings.ToLower(target) + "\x00" + text))
+	now := f.now()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.expire(now.Add(-window))
+	if _, ok := f.seen[hash]; ok {
+		return true
+	}
+	f.seen[hash] = now
+	f.order = append(f.order, sentMessage{hash, now})
+	if len(f.order) > f.max {
+		f.forget(f.order[0])
+		f.order = f.order[1:]
+	}
+	return false
+}
+
+// Whether text has gone to target within the window, without remembering it.
+func (f *DuplicateFilter) Seen(target, text string, window time.Duration) bool {
+	if f == nil || window <= 0 || text == "" {
+		return false
+	}
+	hash := sha256.Sum256([]byte(strings.ToLower(target) + "\x00" + text))
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.expire(f.now().Add(-window))
+	_, ok := f.seen[hash]
+	return ok
+}
+
+// Forget everything from before cutoff.
+func (f *DuplicateFilter) expire(cutoff time.Time) {
+	i := 0
+	for ; i < len(f.order) && !f.order[i].at.After(cutoff); i++ {
+		f.forget(f.order[i])
+	}
+	f.order = f.order[i:]
+}
+
+func (f *DuplicateFilter) forget(m sentMessage) {
+	if f.seen[m.hash] == m.at {
+		delete(f.seen, m.hash)
+	}
+}
@@ -0,0 +1,61 @@
+package ircbot
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/config"
+	"github.com/UniversityRadioYork/CaptainHook/internal/config/configtest"
+	"github.com/UniversityRadioYork/CaptainHook/internal/outputs"
+	"github.com/UniversityRadioYork/CaptainHook/internal/testutil"
+)
+
+func TestDuplicateFilter(t *testing.T) {
+	f := NewDuplicateFilter(3)
+	now := time.Now()
+	f.now = func() time.Time { return now }
+	if f.Duplicate("#a", "hi", time.Minute) {
+		t.Error("expected the first to go")
+	}
+	if !f.Duplicate("#A", "hi", time.Minute) {
+		t.Error("expected the same again to be a duplicate")
+	}
+	if f.Duplicate("#b", "hi", time.Minute) || f.Duplicate("#a", "bye", time.Minute) {
+		t.Error("expected other channels and text to go")
+	}
+	if f.Duplicate("#a", "hi", 0) {
+		t.Error("expected nothing to be a duplicate with no window")
+	}
+
+	now = now.Add(time.Minute)
+	if f.Duplicate("#a", "hi", time.Minute) {
+		t.Error("expected it to go again after the window")
+	}
+
+	// Only so many remembered.
+	for _, text := range []string{"1", "2", "3", "4"} {
+		f.Duplicate("#c", text, time.Hour)
+	}
+	if len(f.seen) > 3 || len(f.order) > 3 || f.Duplicate("#c", "1", time.Hour) {
+		t.Errorf("expected the oldest forgotten, got %d remembered", len(f.seen))
+	}
+}
+
+func TestBroadcastDropsDuplicates(t *testing.T) {
+	bot := &Bot{Duplicates: NewDuplicateFilter(10)}
+	c := configtest.Valid()
+	config.SetConfig(c)
+	s := &testutil.RecordingSender{}
+	var buf bytes.Buffer
+	logger := config.NewLogger(&buf, "text")
+	a := outputs.Announcement{Channels: []string{"#a"}, Text: "PR merged", ID: "one"}
+	bot.Broadcast(context.Background(), &outputs.Broadcaster{IRC: IRCOutput{Sender: s}}, a, NewIRCState(), logger)
+	a.ID = "two" // The org's hook, say
+	bot.Broadcast(context.Background(), &outputs.Broadcaster{IRC: IRCOutput{Sender: s}}, a, NewIRCState(), logger)
+	if len(s.Sent) != 1 || !strings.Contains(buf.String(), "Dropping duplicate announcement") {
+		t.Errorf("expected one sent and one dropped, got %q and %q", s.Sent, buf.String())
+	}
+}
@@ -0,0 +1,39 @@
+package ircbot
+
+import (
+	"context"
+	"sync"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/outputs"
+)
+
+// A message for target as Broadcast would make it.
+func testMessage(target string, a outputs.Announcement) outputs.Message {
+	return outputs.NewMessages(a, []string{target})[0]
+}
+
+// Remembers which targets it was given.
+type recordingOutput struct {
+	mu   sync.Mutex
+	sent []string
+}
+
+func (o *recordingOutput) Announce(ctx context.Context, msg outputs.Message) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.sent = append(o.sent, msg.Target)
+	return nil
+}
+
+// Panics for one target, and records the rest.
+type panickyOutput struct {
+	recordingOutput
+	target string
+}
+
+func (o *panickyOutput) Announce(ctx context.Context, msg outputs.Message) error {
+	if msg.Target == o.target {
+		panic("injected")
+	}
+	return o.recordingOutput.Announce(ctx, msg)
+}
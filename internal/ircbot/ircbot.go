@@ -0,0 +1,366 @@
+// Package ircbot is the IRC side: the connection, its commands, and
+// broadcasting announcements from the queue.
+package ircbot
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nickvanw/ircx"
+	"github.com/sorcix/irc"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/config"
+	"github.com/UniversityRadioYork/CaptainHook/internal/github"
+	"github.com/UniversityRadioYork/CaptainHook/internal/metrics"
+	"github.com/UniversityRadioYork/CaptainHook/internal/outputs"
+	"github.com/UniversityRadioYork/CaptainHook/internal/panics"
+	"github.com/UniversityRadioYork/CaptainHook/internal/version"
+)
+
+// What the bot keeps between messages, made by main. Anything left nil is
+// gone without: no mutes, no duplicate spotting, nothing for !last and so on.
+type Bot struct {
+	Mutes         *Mutes
+	RemovedHooks  *RemovedHooks
+	UnknownEvents *UnknownEvents
+	Duplicates    *DuplicateFilter
+	Feed          *outputs.Feed
+	EventLog      *outputs.EventLog
+
+	ci      *CIChecks
+	lookups *Lookups
+	issues  *IssueFiler
+}
+
+// A Bot whose GitHub commands go through api.
+func NewBot(api github.GitHubAPI) *Bot {
+	return &Bot{ci: NewCIChecks(api), lookups: NewLookups(api), issues: NewIssueFiler(api)}
+}
+
+func HandleConnected(s ircx.Sender, m *irc.Message, state *IRCState, logger *slog.Logger) {
+	conf := config.CurrentConfig()
+	logger.Info("Connected to IRC", "server", conf.Server)
+	server := conf.Server
+	if m.Prefix != nil {
+		server = m.Prefix.Name
+	}
+	if state.Status().Server != "" {
+		metrics.IRCReconnects.Inc()
+	}
+	state.Connected(server)
+	if len(m.Params) > 0 {
+		state.SetNick(m.Params[0])
+	}
+	if conf.Join {
+		channels := conf.IRCChannels()
+		logger.Info("Joining channels", "channels", channels)
+		for _, c := range channels {
+			s.Send(&irc.Message{
+				Command: irc.JOIN,
+				Params:  []string{c},
+			})
+		}
+	}
+
+}
+
+// The nick we're on, or the one we asked for if the server hasn't said yet.
+func ourNick(conf *config.Config, state *IRCState) string {
+	if nick := state.Nick(); nick != "" {
+		return nick
+	}
+	return conf.Nick
+}
+
+// How often to ask for our nick back while someone else has it.
+const reclaimInterval = 30 * time.Second
+
+// Keep asking for nick every so often until we've got it, in case whoever had
+// it went without us seeing them QUIT, or until ctx is done.
+func reclaimNick(ctx context.Context, s ircx.Sender, nick string, state *IRCState, every time.Duration) {
+	tick := time.NewTicker(every)
+	defer tick.Stop()
+	for !strings.EqualFold(state.Nick(), nick) {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+		}
+		if state.Status().Connected && !strings.EqualFold(state.Nick(), nick) {
+			s.Send(&irc.Message{Command: irc.NICK, Params: []string{nick}})
+		}
+	}
+}
+
+// Join channels in want but not had, and leave those in had but not want,
+// for when the config changes under us.
+func JoinChannels(s ircx.Sender, had, want []string, logger *slog.Logger) {
+	wanted := make(map[string]bool)
+	for _, c := range want {
+		wanted[strings.ToLower(c)] = true
+	}
+	current := make(map[string]bool)
+	for _, c := range had {
+		current[strings.ToLower(c)] = true
+		if !wanted[strings.ToLower(c)] {
+			logger.Info("Leaving channel", "channel", c)
+			s.Send(&irc.Message{Command: irc.PART, Params: []string{c}})
+		}
+	}
+	for _, c := range want {
+		if !current[strings.ToLower(c)] {
+			logger.Info("Joining channel", "channel", c)
+			s.Send(&irc.Message{Command: irc.JOIN, Params: []string{c}})
+		}
+	}
+}
+
+// Send an announcement to each of its channels, and anywhere that gets
+// everything.
+func (b *Bot) Broadcast(ctx context.Context, out outputs.Announcer, a outputs.Announcement, state *IRCState, logger *slog.Logger) {
+	defer panics.Recover("broadcast", logger)
+	targets := a.Channels
+	for _, t := range config.CurrentConfig().ExtraTargets(a.Event) {
+		if !config.Contains(targets, t) {
+			targets = append(targets, t)
+		}
+	}
+	var delivered []string
+	window := config.CurrentConfig().DuplicateWindow
+	for _, msg := range outputs.NewMessages(a, targets) {
+		if b.Duplicates.Duplicate(msg.Target, msg.Text, window) {
+			logger.Info("Dropping duplicate announcement", "channel", msg.Target, "event", a.Event, "delivery", a.ID, "repo", a.FullName)
+			metrics.DuplicatesSuppressed.Inc()
+			continue
+		}
+		if err := outputs.SafeAnnounce(ctx, out, msg, logger); err != nil {
+			logger.Error("Error sending announcement", "channel", msg.Target, "event", a.Event, "delivery", a.ID, "repo", a.FullName, "err", err)
+			continue
+		}
+		state.Delivered()
+		metrics.IRCMessagesSent.WithLabelValues(msg.Target).Inc()
+		delivered = append(delivered, msg.Target)
+	}
+	if len(delivered) > 0 {
+		announcedURLs.Duplicate("", a.URL, refCooldown) // So it isn't expanded if someone pastes it
+	}
+	b.EventLog.Record(a, delivered)
+}
+
+// Queue lines to go to target through replies, in order, giving up at the
+// first that won't fit.
+func queueReply(replies outputs.Announcer, target, command string, lines []string, logger *slog.Logger) {
+	if target == "" {
+		return
+	}
+	for _, line := range lines {
+		err := replies.Announce(context.Background(), outputs.Message{Target: target, Announcement: outputs.Announcement{Event: command, Text: line}})
+		if err != nil {
+			logger.Warn("Couldn't queue a reply", "to", target, "command", command, "err", err)
+			return
+		}
+	}
+}
+
+// Where to send a reply to m: the channel it was said in, or the sender if it
+// was said to us directly, as nick.
+func replyTarget(m *irc.Message, nick string) string {
+	if len(m.Params) > 0 && !strings.EqualFold(m.Params[0], nick) {
+		return m.Params[0]
+	}
+	if m.Prefix != nil {
+		return m.Prefix.Name
+	}
+	return ""
+}
+
+// Answer commands in m. replies is for those that run to several lines or
+// take a while, and should be paced to keep us from being kicked for flooding.
+func (b *Bot) HandlePrivMsg(s ircx.Sender, m *irc.Message, q *Queue, replies outputs.Announcer, state *IRCState, logger *slog.Logger) {
+	var from string
+	if m.Prefix != nil {
+		from = m.Prefix.Name
+	}
+	logger.Debug("IRC message", "from", from, "command", m.Command, "params", m.Params, "text", m.Trailing)
+	if m.Trailing == "\x01VERSION\x01" && from != "" {
+		s.Send(&irc.Message{
+			Command:  irc.NOTICE,
+			Params:   []string{from},
+			Trailing: "\x01VERSION CaptainHook " + version.Current().String() + "\x01",
+		})
+		return
+	}
+	// Whoever we are right now, which is nick_ for a while after a restart
+	nick := ourNick(config.CurrentConfig(), state)
+	var channel, mask string // Where it was said, "" if to us, and who by as nick!user@host
+	if len(m.Params) > 0 && !strings.EqualFold(m.Params[0], nick) {
+		channel = m.Params[0]
+	}
+	if m.Prefix != nil {
+		mask = m.Prefix.String()
+	}
+	var output string
+	switch args := strings.Fields(m.Trailing); {
+	case len(args) == 1 && args[0] == "!status":
+		output = "CaptainHook " + version.Current().String() + ": " + q.Stats().String() + b.RemovedHooks.String()
+	case len(args) == 1 && args[0] == "!stats":
+		if stats, err := metrics.GatherStats(); err == nil {
+			output = "CaptainHook: " + stats.String()
+		}
+	case len(args) == 1 && args[0] == "!coverage":
+		output = CoverageCommand(config.CurrentConfig(), b.UnknownEvents, mask, time.Now())
+	case len(args) == 2 && args[0] == "!status":
+		// What we'd do with a delivery for a repo, e.g. !status ury/website
+		output = args[1] + ": " + config.CurrentConfig().ForRepo(args[1]).String()
+	case len(args) > 0 && (args[0] == "!subscribe" || args[0] == "!unsubscribe" || args[0] == "!subscriptions"):
+		output = subscriptionCommand(config.CurrentConfig(), strings.TrimPrefix(args[0], "!"), channel, mask, args[1:], logger)
+	case len(args) > 0 && (args[0] == "!mute" || args[0] == "!unmute" || args[0] == "!mutes"):
+		output = muteCommand(config.CurrentConfig(), b.Mutes, strings.TrimPrefix(args[0], "!"), channel, mask, args[1:], logger)
+	case len(args) > 0 && args[0] == "!last" && from != "":
+		// Privately, to spare the channel, through replies so a big one
+		// goes out a line at a time
+		go func() {
+			defer panics.Recover("last", logger)
+			queueReply(replies, from, "last", lastCommand(b.Feed, channel, args[1:], time.Now()), logger)
+		}()
+	case len(args) > 0 && args[0] == "!newissue":
+		go func() {
+			defer panics.Recover("newissue", logger)
+			reply := b.issues.Reply(context.Background(), config.CurrentConfig(), channel, mask, args[1:], logger)
+			queueReply(replies, replyTarget(m, nick), "newissue", []string{reply}, logger)
+		}()
+	case len(args) > 0 && args[0] == "!ci":
+		go func() {
+			defer panics.Recover("ci", logger)
+			lines := b.ci.Reply(context.Background(), config.CurrentConfig(), channel, args[1:], logger)
+			queueReply(replies, replyTarget(m, nick), "ci", lines, logger)
+		}()
+	case len(args) > 0 && (args[0] == "!issue" || args[0] == "!pr"):
+		// GitHub can be slow, and we mustn't hold up reading from IRC
+		go func() {
+			defer panics.Recover("lookup", logger)
+			reply := b.lookups.Reply(context.Background(), config.CurrentConfig(), strings.TrimPrefix(args[0], "!"), channel, args[1:], logger)
+			if reply != "" {
+				queueReply(replies, replyTarget(m, nick), "lookup", []string{reply}, logger)
+			}
+		}()
+	}
+	if target := replyTarget(m, nick); output != "" && target != "" {
+		s.Send(&irc.Message{
+			Command:  irc.NOTICE,
+			Params:   []string{target},
+			Trailing: output,
+		})
+	}
+	if output == "" && !strings.HasPrefix(m.Trailing, "!") && channel != "" && strings.ContainsAny(m.Trailing, "#/") {
+		go func() {
+			defer panics.Recover("expanding references", logger)
+			lines := b.lookups.Expand(context.Background(), config.CurrentConfig(), channel, m.Trailing, logger)
+			queueReply(replies, channel, "refs", lines, logger)
+		}()
+	}
+	/*
+		if strings.HasPrefix(m), conf.Nick+":") { // Someone mentioned us
+			var output string
+			mention := strings.TrimSpace(
+				strings.TrimPrefix(line.Text(), conf.Nick+":"))
+			switch mention {
+			case "yo", "hi", "sup", "hello", "ohai", "wb", "evening", "morning", "afternoon":
+				output = "Well met, " + line.Nick
+			case "reload", "restart", "reboot", "eat toml":
+				// Reload config
+			}
+			channel := line.Args[0]
+			logger.Println("Sending " + output + " to " + channel)
+			conn.Privmsg(channel, output)
+		}
+	*/
+}
+
+// Connect to IRC, keeping state up to date and answering commands. Anything
+// it leaves running in the background stops once ctx is done.
+func (b *Bot) ConnectIRC(ctx context.Context, conf *config.Config, state *IRCState, q *Queue, logger *slog.Logger) (*ircx.Bot, error) {
+	bot := ircx.Classic(conf.Server, conf.Nick)
+	if err := bot.Connect(); err != nil {
+		return nil, err
+	}
+	bot.HandleFunc(irc.RPL_WELCOME, func(s ircx.Sender, m *irc.Message) {
+		HandleConnected(s, m, state, logger)
+	})
+
+	// Keep track of which channels we're actually in.
+	ours := func(m *irc.Message) bool {
+		return m.Prefix != nil && strings.EqualFold(m.Prefix.Name, ourNick(conf, state))
+	}
+	bot.HandleFunc(irc.JOIN, func(s ircx.Sender, m *irc.Message) {
+		if !ours(m) {
+			return
+		}
+		channel := m.Trailing
+		if len(m.Params) > 0 {
+			channel = m.Params[0]
+		}
+		state.Joined(channel)
+	})
+	bot.HandleFunc(irc.PART, func(s ircx.Sender, m *irc.Message) {
+		if ours(m) && len(m.Params) > 0 {
+			state.Parted(m.Params[0])
+		}
+	})
+	bot.HandleFunc(irc.KICK, func(s ircx.Sender, m *irc.Message) {
+		if len(m.Params) > 1 && strings.EqualFold(m.Params[1], ourNick(conf, state)) {
+			state.Parted(m.Params[0])
+		}
+	})
+	// Someone has our nick while we're registering, most likely the process
+	// we're taking over from in a restart. Make do with nick_ and take ours
+	// back when they've gone.
+	var reclaiming sync.Once
+	bot.HandleFunc(irc.ERR_NICKNAMEINUSE, func(s ircx.Sender, m *irc.Message) {
+		if state.Status().Connected || len(m.Params) < 2 {
+			return
+		}
+		logger.Warn("Nick in use, using another for now", "nick", m.Params[1])
+		s.Send(&irc.Message{Command: irc.NICK, Params: []string{m.Params[1] + "_"}})
+		reclaiming.Do(func() { go reclaimNick(ctx, s, conf.Nick, state, reclaimInterval) })
+	})
+	bot.HandleFunc(irc.NICK, func(s ircx.Sender, m *irc.Message) {
+		if ours(m) {
+			nick := m.Trailing
+			if len(m.Params) > 0 {
+				nick = m.Params[0]
+			}
+			state.SetNick(nick)
+		}
+	})
+	bot.HandleFunc(irc.QUIT, func(s ircx.Sender, m *irc.Message) {
+		if m.Prefix != nil && strings.EqualFold(m.Prefix.Name, conf.Nick) && !strings.EqualFold(state.Nick(), conf.Nick) {
+			s.Send(&irc.Message{Command: irc.NICK, Params: []string{conf.Nick}})
+		}
+	})
+	bot.HandleFunc(irc.ERROR, func(s ircx.Sender, m *irc.Message) {
+		logger.Warn("IRC server closed the connection", "reason", m.Trailing)
+		state.Disconnected()
+	})
+
+	// Queued for each person, a line every IRCInterval.
+	replies := outputs.NewQueuedAnnouncer(context.Background(), IRCOutput{Sender: bot.Sender}, logger)
+	replies.Interval = IRCInterval
+	bot.HandleFunc(irc.PRIVMSG, func(s ircx.Sender, m *irc.Message) {
+		b.HandlePrivMsg(s, m, q, replies, state, logger)
+	})
+
+	bot.HandleFunc(irc.PING, func(s ircx.Sender, m *irc.Message) {
+		s.Send(&irc.Message{
+			Command:  irc.PONG,
+			Params:   m.Params,
+			Trailing: m.Trailing,
+		})
+	})
+
+	go bot.HandleLoop()
+	return bot, nil
+}
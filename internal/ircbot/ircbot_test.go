@@ -0,0 +1,339 @@
+package ircbot
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sorcix/irc"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/config"
+	"github.com/UniversityRadioYork/CaptainHook/internal/config/configtest"
+	"github.com/UniversityRadioYork/CaptainHook/internal/format"
+	"github.com/UniversityRadioYork/CaptainHook/internal/metrics"
+	"github.com/UniversityRadioYork/CaptainHook/internal/outputs"
+	"github.com/UniversityRadioYork/CaptainHook/internal/testutil"
+)
+
+func TestJoinChannels(t *testing.T) {
+	s := &testutil.RecordingSender{}
+	JoinChannels(s, []string{"#a", "#B"}, []string{"#b", "#c"}, config.DiscardLogger)
+	if got := strings.Join(s.Sent, ","); got != "PART #a,JOIN #c" {
+		t.Errorf("expected PART #a,JOIN #c, got %s", got)
+	}
+}
+
+// While we're nick_ after a restart, messages to us are still private.
+func TestPrivMsgWhileReclaimingNick(t *testing.T) {
+	c := configtest.Valid()
+	c.Nick = "CaptHook"
+	config.SetConfig(c)
+	state := NewIRCState()
+	state.SetNick("CaptHook_")
+	s := &testutil.RecordingSender{}
+	new(Bot).HandlePrivMsg(s, &irc.Message{
+		Prefix:   &irc.Prefix{Name: "someone", User: "x", Host: "example.org"},
+		Command:  irc.PRIVMSG,
+		Params:   []string{"CaptHook_"},
+		Trailing: "!mutes",
+	}, NewQueue(1, config.DropNewest, 0, config.DiscardLogger), &recordingOutput{}, state, config.DiscardLogger)
+	if len(s.Sent) != 1 || s.Sent[0] != "NOTICE someone :Ask me that in the channel you mean" {
+		t.Errorf("expected a reply to someone, not a channel, got %q", s.Sent)
+	}
+}
+
+// Asking for our nick back stops once we've got it, or we're told to stop.
+func TestReclaimNick(t *testing.T) {
+	state := NewIRCState()
+	state.Connected("irc.example.org")
+	state.SetNick("CaptHook_")
+	s := &testutil.RecordingSender{}
+	done := make(chan struct{})
+	go func() {
+		reclaimNick(context.Background(), s, "CaptHook", state, 10*time.Millisecond)
+		close(done)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	state.SetNick("CaptHook")
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("still reclaiming after getting the nick back")
+	}
+	if len(s.Sent) == 0 || s.Sent[0] != "NICK CaptHook" {
+		t.Errorf("expected to ask for CaptHook, got %q", s.Sent)
+	}
+
+	state.SetNick("CaptHook_")
+	ctx, cancel := context.WithCancel(context.Background())
+	done = make(chan struct{})
+	go func() {
+		reclaimNick(ctx, &testutil.RecordingSender{}, "CaptHook", state, time.Hour)
+		close(done)
+	}()
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("still reclaiming after ctx was done")
+	}
+}
+
+// !last's lines go through replies, to whoever asked, not straight out.
+func TestLastGoesThroughReplies(t *testing.T) {
+	config.SetConfig(configtest.Valid())
+	s := &testutil.RecordingSender{}
+	replies := &recordingOutput{}
+	new(Bot).HandlePrivMsg(s, &irc.Message{
+		Prefix:   &irc.Prefix{Name: "someone", User: "x", Host: "example.org"},
+		Command:  irc.PRIVMSG,
+		Params:   []string{"#a"},
+		Trailing: "!last 3",
+	}, NewQueue(1, config.DropNewest, 0, config.DiscardLogger), replies, NewIRCState(), config.DiscardLogger)
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		replies.mu.Lock()
+		n := len(replies.sent)
+		replies.mu.Unlock()
+		if n > 0 {
+			break
+		}
+	}
+	replies.mu.Lock()
+	defer replies.mu.Unlock()
+	if len(replies.sent) == 0 || len(s.Sent) != 0 {
+		t.Errorf("expected a reply queued, got %q and %q sent directly", replies.sent, s.Sent)
+	}
+	for _, to := range replies.sent {
+		if to != "someone" {
+			t.Errorf("expected replies to someone, got one to %s", to)
+		}
+	}
+}
+
+// Replies that wait on GitHub are paced through replies too, to the channel
+// they were asked in.
+func TestLookupsGoThroughReplies(t *testing.T) {
+	c := configtest.Valid()
+	c.GitHubToken = "x"
+	config.SetConfig(c)
+	for _, command := range []string{"!ci a b", "!newissue", "!issue", "!pr"} {
+		s := &testutil.RecordingSender{}
+		replies := &recordingOutput{}
+		new(Bot).HandlePrivMsg(s, &irc.Message{
+			Prefix:   &irc.Prefix{Name: "someone", User: "x", Host: "example.org"},
+			Command:  irc.PRIVMSG,
+			Params:   []string{"#a"},
+			Trailing: command,
+		}, NewQueue(1, config.DropNewest, 0, config.DiscardLogger), replies, NewIRCState(), config.DiscardLogger)
+		var sent []string
+		for deadline := time.Now().Add(time.Second); len(sent) == 0 && time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			replies.mu.Lock()
+			sent = append([]string(nil), replies.sent...)
+			replies.mu.Unlock()
+		}
+		if strings.Join(sent, ",") != "#a" || len(s.Sent) != 0 {
+			t.Errorf("%s: expected a reply queued for #a, got %q and %q sent directly", command, sent, s.Sent)
+		}
+	}
+}
+
+func TestDiscordRouting(t *testing.T) {
+	c := configtest.Valid()
+	c.Discord = []config.DiscordTarget{
+		{Name: "ops", URL: "https://discord.com/api/webhooks/1/x", Events: []string{"alert", "grafana", "sentry"}},
+		{Name: "dev", URL: "https://discord.com/api/webhooks/2/y"},
+	}
+	c.Repos = map[string]config.RepoConfig{"ury/website": {Channels: "#web,discord:dev"}}
+	if errs := c.Validate(); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	config.SetConfig(&config.Config{Discord: c.Discord})
+	irc, discord := &recordingOutput{}, &recordingOutput{}
+	out := &outputs.Broadcaster{IRC: irc, Others: map[string]outputs.Announcer{"discord": discord}}
+	logger := config.DiscardLogger
+	new(Bot).Broadcast(context.Background(), out, outputs.Announcement{Channels: []string{"#web", "discord:dev"}, Event: "pull_request"}, NewIRCState(), logger)
+	new(Bot).Broadcast(context.Background(), out, outputs.Announcement{Channels: []string{"#ops"}, Event: "alert"}, NewIRCState(), logger)
+	if got := strings.Join(discord.sent, ","); got != "discord:dev,discord:ops" {
+		t.Errorf("expected dev then ops on Discord, got %s", got)
+	}
+
+	c.Discord[1].Events = []string{"[bad"}
+	c.Channels = "#a,discord:nope"
+	if errs := c.Validate(); len(errs) != 2 {
+		t.Errorf("expected a bad pattern and an unknown webhook, got %v", errs)
+	}
+}
+
+func TestBroadcastRouting(t *testing.T) {
+	c := configtest.Valid()
+	c.MatrixRooms = "!all:example.org"
+	config.SetConfig(c)
+	irc, matrix := &recordingOutput{}, &recordingOutput{}
+	out := &outputs.Broadcaster{IRC: irc, Others: map[string]outputs.Announcer{"matrix": matrix}}
+	new(Bot).Broadcast(context.Background(), out, outputs.Announcement{Channels: []string{"#a", "matrix:!web:example.org", "#b"}, Text: "hi"}, NewIRCState(), nil)
+	if got := strings.Join(irc.sent, ","); got != "#a,#b" {
+		t.Errorf("expected #a,#b on IRC, got %s", got)
+	}
+	if got := strings.Join(matrix.sent, ","); got != "matrix:!web:example.org,matrix:!all:example.org" {
+		t.Errorf("expected both rooms on Matrix, got %s", got)
+	}
+}
+
+func TestDryRunOutput(t *testing.T) {
+	config.SetConfig(configtest.Valid()) // No Matrix rooms etc. getting everything
+	var buf bytes.Buffer
+	msg := outputs.Announcement{
+		Channels: []string{"#a", "#b"},
+		Text:     "[" + format.IrcColorize("website", format.ColorPurple) + "] \x02Bold\x02 and \x0304,01red on black\x0F",
+	}
+	new(Bot).Broadcast(context.Background(), outputs.NewDryRunOutput(&buf), msg, NewIRCState(), config.DiscardLogger)
+	want := "#a [%C06website%O] %BBold%B and %C04,01red on black%O\n" +
+		"#b [%C06website%O] %BBold%B and %C04,01red on black%O\n"
+	if buf.String() != want {
+		t.Errorf("expected %q, got %q", want, buf.String())
+	}
+}
+
+func TestIRCOutputLines(t *testing.T) {
+	s := &testutil.RecordingSender{}
+	IRCOutput{Sender: s}.Announce(context.Background(), testMessage("#a", outputs.Announcement{Text: "pushed\nfirst\nsecond"}))
+	want := []string{"NOTICE #a :pushed", "NOTICE #a :first", "NOTICE #a :second"}
+	if strings.Join(s.Sent, "|") != strings.Join(want, "|") {
+		t.Errorf("expected %q, got %q", want, s.Sent)
+	}
+}
+
+// A commit message too long for one NOTICE is broken up between words, with
+// its colour carried on, and goes out through the paced queue like the rest.
+func TestIRCOutputLongLine(t *testing.T) {
+	message := strings.TrimSpace(strings.Repeat("fix the thing ", 72)) // 1007 bytes
+	text := "[" + format.IrcColorize("website", format.ColorPurple) + "] pushed 1 commit\n" +
+		format.IrcColorize("alice", format.ColorGrey) + " " + format.IrcColorize(message, format.ColorGreen)
+	s := &testutil.RecordingSender{}
+	q := outputs.NewQueuedAnnouncer(context.Background(), IRCOutput{Sender: s}, config.DiscardLogger)
+	if err := q.Announce(context.Background(), testMessage("#a", outputs.Announcement{Text: text})); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Drain(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Sent) != 4 {
+		t.Fatalf("expected the summary and the commit over 3 lines, got %q", s.Sent)
+	}
+	var words []string
+	for i, line := range s.Sent {
+		trailing := strings.TrimPrefix(line, "NOTICE #a :")
+		if len(trailing) > ircLineBytes {
+			t.Errorf("line %d is %d bytes", i, len(trailing))
+		}
+		if i > 1 && !strings.HasPrefix(trailing, "\x03"+string(format.ColorGreen)) {
+			t.Errorf("expected line %d to carry on in green, got %q", i, trailing)
+		}
+		if i > 0 {
+			words = append(words, strings.Fields(format.StripFormatting(trailing))...)
+		}
+	}
+	if got := strings.Join(words, " "); got != "alice "+message {
+		t.Errorf("expected the whole commit, got %q", got)
+	}
+}
+
+func TestSplitIRCLine(t *testing.T) {
+	cases := []struct {
+		name string
+		line string
+		want []string
+	}{
+		{"short", "hello you", []string{"hello you"}},
+		{"at a space", "hello there you", []string{"hello", "there you"}},
+		{"no spaces", "abcdefghijkl", []string{"abcdefghij", "kl"}},
+		{"not inside a character", "abcdefghi\u00e9", []string{"abcdefghi", "\u00e9"}},
+		{"not inside a colour", "abcdefgh\x0304,01red", []string{"abcdefgh", "\x0304,01red"}},
+		{"colour carried on", "\x0304abc defgh", []string{"\x0304abc", "\x0304defgh"}},
+		{"styles carried on", "\x02ab\x1fcdef gh\x02ij", []string{"\x02ab\x1fcdef", "\x02\x1fgh\x02ij"}},
+		{"reset not carried", "\x0304ab\x0f cdefghij", []string{"\x0304ab\x0f", "cdefghij"}},
+	}
+	for _, c := range cases {
+		if got := splitIRCLine(c.line, 10); strings.Join(got, "|") != strings.Join(c.want, "|") {
+			t.Errorf("%s: expected %q, got %q", c.name, c.want, got)
+		}
+	}
+}
+
+type failingSender struct {
+	testutil.RecordingSender
+	fail string // Channel to fail for
+}
+
+func (s *failingSender) Send(m *irc.Message) error {
+	if m.Params[0] == s.fail {
+		return errors.New("boom")
+	}
+	return s.RecordingSender.Send(m)
+}
+
+// IRC through the Broadcaster is the same as ever: a NOTICE per channel, in
+// order, with the formatting left in, and one channel failing doesn't stop
+// the rest.
+func TestBroadcastIRC(t *testing.T) {
+	config.SetConfig(configtest.Valid())
+	s := &failingSender{fail: "#b"}
+	state := NewIRCState()
+	before := promtestutil.ToFloat64(metrics.IRCMessagesSent.WithLabelValues("#c"))
+	text := "[" + format.IrcColorize("website", format.ColorPurple) + "] hi"
+	new(Bot).Broadcast(context.Background(), &outputs.Broadcaster{IRC: IRCOutput{Sender: s}}, outputs.Announcement{Channels: []string{"#a", "#b", "#c"}, Text: text}, state, config.DiscardLogger)
+	want := "NOTICE #a :" + text + ",NOTICE #c :" + text
+	if got := strings.Join(s.Sent, ","); got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+	if n := promtestutil.ToFloat64(metrics.IRCMessagesSent.WithLabelValues("#c")) - before; n != 1 {
+		t.Errorf("expected #c counted once, got %v", n)
+	}
+	if err := (&outputs.Broadcaster{IRC: IRCOutput{Sender: s}}).Announce(context.Background(), testMessage("slack:x", outputs.Announcement{})); err == nil {
+		t.Error("expected an error for an output that isn't set up")
+	}
+}
+
+func TestBroadcastPanic(t *testing.T) {
+	config.SetConfig(&config.Config{})
+	before := promtestutil.ToFloat64(metrics.PanicsTotal.WithLabelValues("broadcast"))
+	out := &panickyOutput{target: "#bad"}
+	new(Bot).Broadcast(context.Background(), out, outputs.Announcement{Channels: []string{"#bad", "#good"}, Text: "one"}, NewIRCState(), config.DiscardLogger)
+	new(Bot).Broadcast(context.Background(), out, outputs.Announcement{Channels: []string{"#good"}, Text: "two"}, NewIRCState(), config.DiscardLogger)
+	if strings.Join(out.sent, ",") != "#good,#good" {
+		t.Errorf("expected #good to get both, got %v", out.sent)
+	}
+	if got := promtestutil.ToFloat64(metrics.PanicsTotal.WithLabelValues("broadcast")) - before; got != 1 {
+		t.Errorf("expected 1 panic counted, got %v", got)
+	}
+}
+
+// A Slack that never answers mustn't hold up IRC.
+func TestBroadcastSlackDown(t *testing.T) {
+	hung := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-hung
+	}))
+	defer srv.Close()
+	defer close(hung)
+
+	c := configtest.Valid()
+	c.Slack = []config.SlackTarget{{Name: "all", URL: srv.URL, All: true}}
+	config.SetConfig(c)
+	logger := config.DiscardLogger
+	irc := &recordingOutput{}
+	out := &outputs.Broadcaster{IRC: irc}
+	out.Add(context.Background(), "slack", outputs.NewSlackOutput(c.Slack), 0, logger)
+	for i := 0; i < 150; i++ {
+		new(Bot).Broadcast(context.Background(), out, outputs.Announcement{Channels: []string{"#a"}, Text: "hi"}, NewIRCState(), logger)
+	}
+	if len(irc.sent) != 150 {
+		t.Errorf("expected all 150 on IRC, got %d", len(irc.sent))
+	}
+}
@@ -0,0 +1,124 @@
+package ircbot
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Keeps track of how our IRC connection is doing, for health checks and the
+// like. Safe for concurrent use.
+type IRCState struct {
+	mu           sync.RWMutex
+	connected    bool
+	server       string
+	nick         string    // What the server knows us as
+	changed      time.Time // When connected last flipped
+	channels     map[string]bool
+	lastDelivery time.Time
+}
+
+func NewIRCState() *IRCState {
+	return &IRCState{changed: time.Now(), channels: make(map[string]bool)}
+}
+
+func (s *IRCState) Connected(server string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.connected {
+		s.changed = time.Now()
+	}
+	s.connected = true
+	s.server = server
+	s.channels = make(map[string]bool)
+}
+
+// Wait up to timeout for IRC to connect, returning whether it has.
+func (s *IRCState) WaitConnected(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for !s.Status().Connected {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return true
+}
+
+// Wait until IRC's disconnected, e.g. after a QUIT, or ctx is done.
+func (s *IRCState) WaitDisconnected(ctx context.Context) error {
+	tick := time.NewTicker(100 * time.Millisecond)
+	defer tick.Stop()
+	for s.Status().Connected {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-tick.C:
+		}
+	}
+	return nil
+}
+
+func (s *IRCState) Disconnected() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.connected {
+		s.changed = time.Now()
+	}
+	s.connected = false
+	s.channels = make(map[string]bool)
+}
+
+func (s *IRCState) SetNick(nick string) {
+	s.mu.Lock()
+	s.nick = nick
+	s.mu.Unlock()
+}
+
+// Our nick as of the last time the server told us, or "" if it hasn't yet.
+func (s *IRCState) Nick() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.nick
+}
+
+func (s *IRCState) Joined(channel string) {
+	s.mu.Lock()
+	s.channels[strings.ToLower(channel)] = true
+	s.mu.Unlock()
+}
+
+func (s *IRCState) Parted(channel string) {
+	s.mu.Lock()
+	delete(s.channels, strings.ToLower(channel))
+	s.mu.Unlock()
+}
+
+// Note that we've just successfully sent an announcement.
+func (s *IRCState) Delivered() {
+	s.mu.Lock()
+	s.lastDelivery = time.Now()
+	s.mu.Unlock()
+}
+
+// A point in time copy of IRCState.
+type IRCStatus struct {
+	Connected    bool
+	Server       string
+	Since        time.Time // When Connected last changed
+	Channels     int
+	LastDelivery time.Time
+}
+
+func (s *IRCState) Status() IRCStatus {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return IRCStatus{
+		Connected:    s.connected,
+		Server:       s.server,
+		Since:        s.changed,
+		Channels:     len(s.channels),
+		LastDelivery: s.lastDelivery,
+	}
+}
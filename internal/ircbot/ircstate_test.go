@@ -0,0 +1,24 @@
+package ircbot
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestWaitDisconnected(t *testing.T) {
+	s := NewIRCState()
+	s.Connected("irc.example.org")
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := s.WaitDisconnected(ctx); err != context.DeadlineExceeded {
+		t.Errorf("expected to give up waiting, got %v", err)
+	}
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		s.Disconnected()
+	}()
+	if err := s.WaitDisconnected(context.Background()); err != nil {
+		t.Error(err)
+	}
+}
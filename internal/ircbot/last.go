@@ -0,0 +1,61 @@
+package ircbot
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/format"
+	"github.com/UniversityRadioYork/CaptainHook/internal/outputs"
+)
+
+// How many announcements !last gives by default, and at most.
+const (
+	lastDefault = 5
+	lastMax     = 20
+)
+
+// The reply to !last with args, asked in channel: a line for each
+// announcement, oldest first.
+func lastCommand(f *outputs.Feed, channel string, args []string, now time.Time) []string {
+	if !strings.HasPrefix(channel, "#") && !strings.HasPrefix(channel, "&") {
+		return []string{"Ask me that in the channel you want to catch up on"}
+	}
+	if f == nil {
+		return []string{"I'm not keeping track of announcements, FeedSize is 0"}
+	}
+	n, repo := lastDefault, ""
+	for _, arg := range args {
+		if i, err := strconv.Atoi(arg); err == nil {
+			n = i
+		} else {
+			repo = arg
+		}
+	}
+	if n < 1 || len(args) > 2 {
+		return []string{"Usage: !last [how many] [repo]"}
+	}
+	if n > lastMax {
+		n = lastMax
+	}
+	entries := f.Last(n, channel, repo)
+	if len(entries) == 0 {
+		if repo != "" {
+			return []string{"Nothing yet from " + repo + " in " + channel + ", all quiet!"}
+		}
+		return []string{"Nothing yet in " + channel + ", all quiet!"}
+	}
+	var lines []string
+	for i := len(entries) - 1; i >= 0; i-- {
+		e := entries[i]
+		text := strings.SplitN(e.Text, "\n", 2)[0]
+		ago := format.HumanDuration(now.Sub(e.Time))
+		if ago != "moments" && ago != "" {
+			ago += " ago"
+		} else {
+			ago = "just now"
+		}
+		lines = append(lines, "["+ago+"] "+text)
+	}
+	return lines
+}
@@ -0,0 +1,66 @@
+package ircbot
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/config"
+	"github.com/UniversityRadioYork/CaptainHook/internal/config/configtest"
+	"github.com/UniversityRadioYork/CaptainHook/internal/outputs"
+)
+
+func TestLast(t *testing.T) {
+	config.SetConfig(configtest.Valid())
+	f := outputs.NewFeed(50, "", config.DiscardLogger)
+	if got := lastCommand(f, "#web", nil, time.Now()); len(got) != 1 || got[0] != "Nothing yet in #web, all quiet!" {
+		t.Errorf("expected nothing yet, got %q", got)
+	}
+	var saved []string
+	for i, e := range []outputs.FeedEntry{
+		{ID: "1", Repo: "website", Channels: []string{"#web"}, Text: "[website] one\n  a commit"},
+		{ID: "2", Repo: "playout", Channels: []string{"#tech"}, Text: "[playout] two"},
+		{ID: "3", Repo: "ury/playout", Channels: []string{"#Web", "#tech"}, Text: "[ury/playout] three"},
+		{ID: "4", Repo: "website", Channels: []string{"#web"}, Text: "[website] four"},
+	} {
+		e.Time = time.Date(2024, 3, 4, 12, i, 0, 0, time.UTC)
+		line, _ := json.Marshal(e)
+		saved = append(saved, string(line)+"\n")
+	}
+	path := filepath.Join(t.TempDir(), "feed.jsonl")
+	if err := os.WriteFile(path, []byte(strings.Join(saved, "")), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f = outputs.NewFeed(50, path, config.DiscardLogger)
+	now := time.Date(2024, 3, 4, 14, 3, 30, 0, time.UTC)
+
+	for _, c := range []struct {
+		args string
+		want []string
+	}{
+		{"", []string{"[2h3m ago] [website] one", "[2h1m ago] [ury/playout] three", "[2h ago] [website] four"}},
+		{"2", []string{"[2h1m ago] [ury/playout] three", "[2h ago] [website] four"}},
+		{"playout", []string{"[2h1m ago] [ury/playout] three"}},
+		{"1 website", []string{"[2h ago] [website] four"}},
+		{"ury/website 1", []string{"[2h ago] [website] four"}},
+		{"sentry", []string{"Nothing yet from sentry in #web, all quiet!"}},
+		{"0", []string{"Usage: !last [how many] [repo]"}},
+	} {
+		if got := lastCommand(f, "#web", strings.Fields(c.args), now); strings.Join(got, "|") != strings.Join(c.want, "|") {
+			t.Errorf("!last %s: expected %q, got %q", c.args, c.want, got)
+		}
+	}
+
+	for i := 0; i < 30; i++ {
+		f.Add(outputs.Announcement{ID: "more" + string(rune('a'+i)), Repo: "website", Channels: []string{"#web"}, Text: "x"})
+	}
+	if got := lastCommand(f, "#web", []string{"100"}, now); len(got) != lastMax {
+		t.Errorf("expected at most %d, got %d", lastMax, len(got))
+	}
+	if got := lastCommand(nil, "#web", nil, now); !strings.Contains(got[0], "FeedSize") {
+		t.Errorf("expected to hear the feed's off, got %q", got)
+	}
+}
@@ -0,0 +1,172 @@
+package ircbot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/config"
+	"github.com/UniversityRadioYork/CaptainHook/internal/format"
+	"github.com/UniversityRadioYork/CaptainHook/internal/github"
+)
+
+// Answers !issue and !pr on IRC with a line about the issue or PR, from the
+// GitHub API. Answers are kept for a minute, so a channel all asking about
+// the same one at once doesn't use up the rate limit.
+type Lookups struct {
+	api github.GitHubAPI
+	ttl time.Duration
+	now func() time.Time
+
+	mu      sync.Mutex
+	answers map[string]cachedAnswer // By "issues owner/name/number", "pulls ..." or "commits owner/name/sha"
+}
+
+type cachedAnswer struct {
+	found *lookedUp // nil for not found
+	at    time.Time
+}
+
+func NewLookups(api github.GitHubAPI) *Lookups {
+	return &Lookups{
+		api:     api,
+		ttl:     time.Minute,
+		now:     time.Now,
+		answers: make(map[string]cachedAnswer),
+	}
+}
+
+// The bits of an issue or PR we say, as the API has them.
+type lookedUp struct {
+	Number      int
+	Title       string
+	HTMLURL     string `json:"html_url"`
+	State       string
+	Draft       bool
+	Merged      bool
+	User        github.User
+	Assignees   []github.User
+	PullRequest *struct{} `json:"pull_request"` // Issues that are really PRs have this
+	Commit      struct {
+		Message string
+	} // For commits, which have none of the above
+}
+
+// What to call an issue or PR, or an issue that's really a PR.
+func kindName(kind string, pr bool) string {
+	if kind == "pr" || pr {
+		return "PR"
+	}
+	return "Issue"
+}
+
+// The reply to "!issue repo number" or "!pr repo number" (kind is issue or
+// pr) said in channel, or "" when there's nothing to say. repo can be
+// owner/name, a name we only know one owner of, or left out if channel only
+// has one repo sent to it.
+func (l *Lookups) Reply(ctx context.Context, conf *config.Config, kind, channel string, args []string, logger *slog.Logger) string {
+	if conf == nil || conf.GitHubToken == "" {
+		return ""
+	}
+	var repo string
+	if len(args) == 2 {
+		repo = args[0]
+		args = args[1:]
+	}
+	if len(args) != 1 {
+		return "Usage: !" + kind + " [repo] <number>"
+	}
+	number, err := strconv.Atoi(strings.TrimPrefix(args[0], "#"))
+	if err != nil || number < 1 {
+		return fmt.Sprintf("%q isn't an issue or PR number", args[0])
+	}
+	full := conf.LookupRepo(channel, repo)
+	if full == "" {
+		if repo == "" {
+			return "Which repo? Try !" + kind + " owner/name " + strconv.Itoa(number)
+		}
+		return fmt.Sprintf("I don't know which %s you mean, try owner/name", repo)
+	}
+
+	endpoint := "issues"
+	if kind == "pr" {
+		endpoint = "pulls"
+	}
+	found, err := l.get(ctx, conf, endpoint, full, strconv.Itoa(number))
+	switch {
+	case github.Unavailable(err):
+		return "Sorry, !" + kind + " is temporarily unavailable, GitHub's rate limiting me"
+	case err != nil:
+		logger.Warn("Couldn't look up an issue or PR", "repo", full, "number", number, "err", err)
+		return "Sorry, I couldn't get that from GitHub"
+	case found == nil:
+		return fmt.Sprintf("I can't find %s#%d", full, number)
+	}
+	return l.describe(ctx, conf, full, kind, found)
+}
+
+// Issue, PR or commit id in repo (owner/name) from the API's endpoint,
+// issues, pulls or commits: the one we have from the last minute, or GitHub's.
+// nil if there's no such thing, or it's somewhere our token can't see.
+func (l *Lookups) get(ctx context.Context, conf *config.Config, endpoint, repo, id string) (*lookedUp, error) {
+	key := fmt.Sprintf("%s %s/%s", endpoint, strings.ToLower(repo), id)
+	l.mu.Lock()
+	cached, ok := l.answers[key]
+	l.mu.Unlock()
+	if ok && l.now().Sub(cached.at) < l.ttl {
+		return cached.found, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, github.LookupTimeout)
+	defer cancel()
+	found := &lookedUp{}
+	err := l.api.Get(ctx, conf.GitHubAPIURL, conf.GitHubToken, fmt.Sprintf("/repos/%s/%s/%s", repo, endpoint, id), found)
+	var status *github.StatusError
+	switch {
+	case errors.As(err, &status) && status.Code == http.StatusNotFound:
+		found = nil
+	case err != nil:
+		return nil, err
+	}
+	l.mu.Lock()
+	l.answers[key] = cachedAnswer{found: found, at: l.now()}
+	l.mu.Unlock()
+	return found, nil
+}
+
+// One line about an issue or PR: title, state, author, assignees and link.
+func (l *Lookups) describe(ctx context.Context, conf *config.Config, repo, kind string, found *lookedUp) string {
+	what := kindName(kind, found.PullRequest != nil)
+	state := found.State
+	switch {
+	case found.Merged:
+		state = "merged"
+	case found.Draft && state == "open":
+		state = "draft"
+	}
+	var assignees []string
+	for _, u := range found.Assignees {
+		assignees = append(assignees, u.Login)
+	}
+	assigned := "unassigned"
+	if len(assignees) > 0 {
+		assigned = "assigned to " + strings.Join(assignees, ", ")
+	}
+	title := github.CleanName(format.StripFormatting(found.Title))
+	return fmt.Sprintf("[%s] %s #%d: %s (%s, by %s, %s) %s", path.Base(repo), what, found.Number, title, state, found.User.Login, assigned, l.link(ctx, conf, repo, found.HTMLURL))
+}
+
+// u shortened if repo's announcements would be.
+func (l *Lookups) link(ctx context.Context, conf *config.Config, repo, u string) string {
+	if s := conf.ForRepo(repo); s.Shorten {
+		u, _ = format.Shorten(ctx, s.Shortener, u, false) // Falls back to the long URL
+	}
+	return u
+}
@@ -0,0 +1,86 @@
+package ircbot
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/config"
+	"github.com/UniversityRadioYork/CaptainHook/internal/config/configtest"
+	"github.com/UniversityRadioYork/CaptainHook/internal/github"
+	"github.com/UniversityRadioYork/CaptainHook/internal/github/githubtest"
+)
+
+func TestLookups(t *testing.T) {
+	var asked int32
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&asked, 1)
+		switch r.URL.Path {
+		case "/repos/ury/website/issues/214":
+			w.Write([]byte(`{"number": 214, "title": "Schedule page \u0002broken\u0002", "state": "open", "html_url": "https://github.com/ury/website/issues/214",
+				"user": {"login": "x1tot"}, "assignees": [{"login": "lordalex"}, {"login": "mstratford"}]}`))
+		case "/repos/ury/website/pulls/215":
+			w.Write([]byte(`{"number": 215, "title": "Fix the schedule page", "state": "closed", "merged": true, "html_url": "https://github.com/ury/website/pull/215",
+				"user": {"login": "lordalex"}, "assignees": []}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer api.Close()
+	c := configtest.Valid()
+	c.GitHubToken, c.GitHubAPIURL, c.ShortenURLs = "tok", api.URL, false
+	c.Repos = map[string]config.RepoConfig{"ury/website": {Channels: "#web"}, "ury/*": {Channels: "#all"}}
+	if errs := c.Validate(); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	l := NewLookups(github.NewGitHubClient())
+	now := time.Now()
+	l.now = func() time.Time { return now }
+	reply := func(kind, channel string, args ...string) string {
+		return l.Reply(context.Background(), c, kind, channel, args, config.DiscardLogger)
+	}
+
+	for _, tc := range []struct {
+		kind, channel string
+		args          []string
+		want          string
+	}{
+		{"issue", "#all", []string{"ury/website", "214"}, "[website] Issue #214: Schedule page broken (open, by x1tot, assigned to lordalex, mstratford) https://github.com/ury/website/issues/214"},
+		{"pr", "#web", []string{"#215"}, "[website] PR #215: Fix the schedule page (merged, by lordalex, unassigned) https://github.com/ury/website/pull/215"},
+		{"pr", "#web", []string{"website", "215"}, "[website] PR #215: Fix the schedule page (merged, by lordalex, unassigned) https://github.com/ury/website/pull/215"},
+		{"issue", "#web", []string{"ury/website", "9"}, "I can't find ury/website#9"},
+		{"issue", "#all", []string{"214"}, "Which repo? Try !issue owner/name 214"},
+		{"issue", "#all", []string{"nowhere", "214"}, "I don't know which nowhere you mean, try owner/name"},
+		{"issue", "#all", []string{"../users", "214"}, "I don't know which ../users you mean, try owner/name"},
+		{"issue", "#all", []string{"ury/website", "two"}, `"two" isn't an issue or PR number`},
+		{"issue", "#all", nil, "Usage: !issue [repo] <number>"},
+	} {
+		if got := reply(tc.kind, tc.channel, tc.args...); got != tc.want {
+			t.Errorf("%s %v in %s: expected %q, got %q", tc.kind, tc.args, tc.channel, tc.want, got)
+		}
+	}
+
+	before := atomic.LoadInt32(&asked)
+	reply("issue", "#web", "214")
+	if n := atomic.LoadInt32(&asked) - before; n != 0 {
+		t.Errorf("expected the answer from a minute ago kept, got %d more lookups", n)
+	}
+	now = now.Add(2 * time.Minute)
+	reply("issue", "#web", "214")
+	if n := atomic.LoadInt32(&asked) - before; n != 1 {
+		t.Errorf("expected it looked up again after a minute, got %d more lookups", n)
+	}
+
+	l.api = &githubtest.FakeAPI{Err: github.ErrBackingOff}
+	if got, want := reply("pr", "#web", "216"), "Sorry, !pr is temporarily unavailable, GitHub's rate limiting me"; got != want {
+		t.Errorf("expected %q while backing off, got %q", want, got)
+	}
+
+	c.GitHubToken = ""
+	if got := reply("issue", "#web", "214"); got != "" {
+		t.Errorf("expected no reply without a token, got %q", got)
+	}
+}
@@ -0,0 +1,213 @@
+package ircbot
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/config"
+	"github.com/UniversityRadioYork/CaptainHook/internal/outputs"
+)
+
+// Repos silenced in a channel for a while from IRC with !mute, say during a
+// big refactor. Muted announcements are dropped, or with MuteMode defer held
+// until the mute's over. Priority ones go out anyway. Mutes are kept next to
+// the StateFile with when they end, so one that ended while we were down is
+// just gone.
+type Mutes struct {
+	path string // "" to keep them in memory only
+	now  func() time.Time
+
+	mu    sync.Mutex
+	until map[muteKey]time.Time
+}
+
+// A repo as it was muted, owner/name or just the name, and the channel, both
+// lower case.
+type muteKey struct {
+	Channel string `json:"channel"`
+	Repo    string `json:"repo"`
+}
+
+// The longest a repo can be muted for; any longer belongs in the config.
+const maxMute = 7 * 24 * time.Hour
+
+func NewMutes(path string) *Mutes {
+	return &Mutes{path: path, now: time.Now, until: make(map[muteKey]time.Time)}
+}
+
+// Bump this if mutesFile changes.
+const mutesVersion = 1
+
+type mutesFile struct {
+	Version int         `json:"version"`
+	Mutes   []savedMute `json:"mutes"`
+}
+
+type savedMute struct {
+	muteKey
+	Until time.Time `json:"until"`
+}
+
+func MutesPath(stateFile string) string {
+	return stateFile + ".mutes"
+}
+
+// Pick up the mutes saved at path that haven't ended yet.
+func LoadMutes(path string, logger *slog.Logger) *Mutes {
+	m := NewMutes(path)
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logger.Error("Error reading mutes", "path", path, "err", err)
+		}
+		return m
+	}
+	var f mutesFile
+	if err := json.Unmarshal(data, &f); err != nil || f.Version != mutesVersion {
+		logger.Warn("Skipping mutes we can't read", "path", path, "version", f.Version, "err", err)
+		return m
+	}
+	now := m.now()
+	for _, s := range f.Mutes {
+		if s.Until.After(now) {
+			m.until[s.muteKey] = s.Until
+		}
+	}
+	return m
+}
+
+// Mute repo in channel until then, or unmute it with a zero time.
+func (m *Mutes) Set(channel, repo string, until time.Time) error {
+	key := muteKey{strings.ToLower(channel), strings.ToLower(repo)}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if until.IsZero() {
+		delete(m.until, key)
+	} else {
+		m.until[key] = until
+	}
+	return m.save()
+}
+
+// Write the mutes out, if we've somewhere to. Call with mu held.
+func (m *Mutes) save() error {
+	if m.path == "" {
+		return nil
+	}
+	f := mutesFile{Version: mutesVersion, Mutes: []savedMute{}}
+	for key, until := range m.until {
+		f.Mutes = append(f.Mutes, savedMute{key, until.UTC()})
+	}
+	data, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+	tmp := m.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, m.path)
+}
+
+// Whether a's repo is muted in channel right now.
+func (m *Mutes) Muted(channel string, a outputs.Announcement) bool {
+	if m == nil || (a.Repo == "" && a.FullName == "") {
+		return false
+	}
+	now := m.now()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.until) == 0 {
+		return false
+	}
+	channel = strings.ToLower(channel)
+	for _, repo := range []string{a.FullName, path.Base(a.FullName), a.Repo} {
+		if until, ok := m.until[muteKey{channel, strings.ToLower(repo)}]; ok && repo != "" && until.After(now) {
+			return true
+		}
+	}
+	return false
+}
+
+// The mutes in channel that haven't ended, soonest ending first.
+func (m *Mutes) For(channel string) []savedMute {
+	now := m.now()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var list []savedMute
+	for key, until := range m.until {
+		if key.Channel == strings.ToLower(channel) && until.After(now) {
+			list = append(list, savedMute{key, until})
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Until.Before(list[j].Until) })
+	return list
+}
+
+// The reply to !mute, !unmute or !mutes (command, without the !) with args,
+// from mask in channel.
+func muteCommand(conf *config.Config, mutes *Mutes, command, channel, mask string, args []string, logger *slog.Logger) string {
+	if !strings.HasPrefix(channel, "#") && !strings.HasPrefix(channel, "&") {
+		return "Ask me that in the channel you mean"
+	}
+	if mutes == nil {
+		return "Sorry, I've nowhere to keep mutes"
+	}
+	when := func(t time.Time) string {
+		loc := conf.Location
+		if loc == nil {
+			loc = time.Local
+		}
+		return t.In(loc).Format("Mon 15:04")
+	}
+	if command == "mutes" {
+		list := mutes.For(channel)
+		if len(list) == 0 {
+			return "Nothing's muted in " + channel
+		}
+		var parts []string
+		for _, m := range list {
+			parts = append(parts, m.Repo+" until "+when(m.Until))
+		}
+		return "Muted in " + channel + ": " + strings.Join(parts, ", ")
+	}
+
+	if !conf.IsIRCAdmin(mask) {
+		return "Sorry, only admins can do that"
+	}
+	var until time.Time
+	switch {
+	case command == "unmute" && len(args) == 1:
+	case command == "mute" && len(args) == 2:
+		d, err := time.ParseDuration(args[1])
+		if err != nil || d <= 0 || d > maxMute {
+			return fmt.Sprintf("Expected how long, like 2h or 30m, up to a week, not %q", args[1])
+		}
+		until = mutes.now().Add(d)
+	default:
+		return "Usage: !mute repo 2h, or !unmute repo"
+	}
+	repo := args[0]
+	if !config.FullRepoName.MatchString(repo) && !config.FullRepoName.MatchString("owner/"+repo) {
+		return fmt.Sprintf("%q isn't a repo, try its name or owner/name", repo)
+	}
+	err := mutes.Set(channel, repo, until)
+	logger.Info("Mutes changed from IRC", "by", mask, "command", command, "repo", repo, "channel", channel, "until", until)
+	reply := fmt.Sprintf("%s is no longer muted in %s", repo, channel)
+	if command == "mute" {
+		reply = fmt.Sprintf("Muted %s in %s until %s", repo, channel, when(until))
+	}
+	if err != nil {
+		logger.Error("Couldn't save mutes", "err", err)
+		reply += ", but I couldn't save it, so it won't last a restart"
+	}
+	return reply
+}
@@ -0,0 +1,77 @@
+package ircbot
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/config"
+	"github.com/UniversityRadioYork/CaptainHook/internal/config/configtest"
+	"github.com/UniversityRadioYork/CaptainHook/internal/outputs"
+)
+
+func TestMutes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mutes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := MutesPath(filepath.Join(dir, "state.jsonl"))
+	now := time.Date(2024, 3, 4, 12, 0, 0, 0, time.UTC)
+	mutes := LoadMutes(path, config.DiscardLogger)
+	mutes.now = func() time.Time { return now }
+
+	c := configtest.Valid()
+	c.Timezone = "UTC"
+	c.IRCAdmins = []string{"marky!*@ury.org.uk"}
+	if errs := c.Validate(); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	admin := "marky!mark@ury.org.uk"
+	command := func(mask, line string) string {
+		args := strings.Fields(line)
+		return muteCommand(c, mutes, args[0], "#web", mask, args[1:], config.DiscardLogger)
+	}
+	for _, tc := range []struct{ mask, line, want string }{
+		{"someone!x@example.org", "mute website 2h", "Sorry, only admins can do that"},
+		{admin, "mute website soon", `Expected how long, like 2h or 30m, up to a week, not "soon"`},
+		{admin, "mute website 2h", "Muted website in #web until Mon 14:00"},
+		{admin, "mute ury/playout 30m", "Muted ury/playout in #web until Mon 12:30"},
+		{"someone!x@example.org", "mutes", "Muted in #web: ury/playout until Mon 12:30, website until Mon 14:00"},
+		{admin, "unmute ury/playout", "ury/playout is no longer muted in #web"},
+	} {
+		if got := command(tc.mask, tc.line); got != tc.want {
+			t.Errorf("%s: expected %q, got %q", tc.line, tc.want, got)
+		}
+	}
+
+	a := outputs.Announcement{Channels: []string{"#web", "#tech"}, Repo: "website", FullName: "ury/website", Text: "hello"}
+	s := NewScheduler(mutes)
+	if got := s.Filter(c, a, config.DiscardLogger).Channels; len(got) != 1 || got[0] != "#tech" {
+		t.Errorf("expected it only in #tech while muted in #web, got %v", got)
+	}
+	prio := a
+	prio.Priority = true
+	if got := s.Filter(c, prio, config.DiscardLogger).Channels; len(got) != 2 {
+		t.Errorf("expected priority announcements everywhere, got %v", got)
+	}
+	c.MuteMode = config.OutsideWindowDefer
+	s.Filter(c, a, config.DiscardLogger)
+	if due := s.Due(c); len(due) > 0 || s.Len() != 1 {
+		t.Errorf("expected one held while muted, got %d due and %d held", len(due), s.Len())
+	}
+
+	// Past the end, after a restart, it's gone without anything being sent
+	// for it but what was held.
+	now = now.Add(3 * time.Hour)
+	mutes = LoadMutes(path, config.DiscardLogger)
+	if got := command(admin, "mutes"); got != "Nothing's muted in #web" {
+		t.Errorf("expected nothing muted after it ended, got %q", got)
+	}
+	if due := s.Due(c); len(due) != 1 || due[0].Channels[0] != "#web" {
+		t.Errorf("expected the held one due in #web, got %+v", due)
+	}
+}
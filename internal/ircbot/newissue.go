@@ -0,0 +1,127 @@
+package ircbot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/config"
+	"github.com/UniversityRadioYork/CaptainHook/internal/format"
+	"github.com/UniversityRadioYork/CaptainHook/internal/github"
+)
+
+// Files GitHub issues from IRC with "!newissue website Mixer channel 3
+// crackles", for problems that come up mid-broadcast and would otherwise be
+// forgotten. Only IRCAdmins and IRCReporters can, each at most once a
+// minute, and short titles (most likely someone trying it out) need
+// confirming with "!newissue confirm".
+type IssueFiler struct {
+	api github.GitHubAPI
+	now func() time.Time
+
+	mu      sync.Mutex
+	pending map[string]pendingIssue // Waiting for confirming, by who asked
+	last    map[string]time.Time    // When each person last filed one
+}
+
+type pendingIssue struct {
+	repo, title, channel string
+	at                   time.Time
+}
+
+func NewIssueFiler(api github.GitHubAPI) *IssueFiler {
+	return &IssueFiler{
+		api:     api,
+		now:     time.Now,
+		pending: make(map[string]pendingIssue),
+		last:    make(map[string]time.Time),
+	}
+}
+
+// Titles shorter than this need confirming, how long there is to, and how
+// often each person can file one.
+const (
+	minIssueTitle  = 15
+	confirmTimeout = 2 * time.Minute
+	issueCooldown  = time.Minute
+)
+
+// The reply to "!newissue repo title" or "!newissue confirm" from mask, said
+// in channel.
+func (f *IssueFiler) Reply(ctx context.Context, conf *config.Config, channel, mask string, args []string, logger *slog.Logger) string {
+	if conf == nil || conf.GitHubToken == "" {
+		return "Sorry, !newissue needs a GitHubToken in my config, and I haven't got one"
+	}
+	if !conf.IsIRCReporter(mask) {
+		return "Sorry, only admins and reporters can do that"
+	}
+	if !strings.HasPrefix(channel, "#") && !strings.HasPrefix(channel, "&") {
+		return "Ask me that in a channel, so everyone knows it's been filed"
+	}
+	if len(args) == 1 && args[0] == "confirm" {
+		f.mu.Lock()
+		p, ok := f.pending[mask]
+		delete(f.pending, mask)
+		f.mu.Unlock()
+		if !ok || f.now().Sub(p.at) > confirmTimeout || !strings.EqualFold(p.channel, channel) {
+			return "There's nothing waiting for you to confirm"
+		}
+		return f.file(ctx, conf, p, mask, logger)
+	}
+	if len(args) < 2 {
+		return "Usage: !newissue repo what's wrong"
+	}
+	p := pendingIssue{repo: conf.LookupRepo(channel, args[0]), title: github.CleanName(format.StripFormatting(strings.Join(args[1:], " "))), channel: channel, at: f.now()}
+	if p.repo == "" {
+		return fmt.Sprintf("I don't know which %s you mean, try owner/name", args[0])
+	}
+	if len(p.title) < minIssueTitle {
+		f.mu.Lock()
+		f.pending[mask] = p
+		f.mu.Unlock()
+		return fmt.Sprintf("File %q in %s? That's a short title, so say !newissue confirm within %s if you meant it", p.title, p.repo, format.HumanDuration(confirmTimeout))
+	}
+	return f.file(ctx, conf, p, mask, logger)
+}
+
+// File p for mask, if they haven't filed one too recently.
+func (f *IssueFiler) file(ctx context.Context, conf *config.Config, p pendingIssue, mask string, logger *slog.Logger) string {
+	now := f.now()
+	f.mu.Lock()
+	if last, ok := f.last[mask]; ok && now.Sub(last) < issueCooldown {
+		f.mu.Unlock()
+		return "Steady on, you can only file one a minute"
+	}
+	f.last[mask] = now
+	f.mu.Unlock()
+
+	nick := mask
+	if i := strings.Index(mask, "!"); i >= 0 {
+		nick = mask[:i]
+	}
+	loc := conf.Location
+	if loc == nil {
+		loc = time.Local
+	}
+	body := fmt.Sprintf("Filed from IRC by %s in %s at %s.", nick, p.channel, now.In(loc).Format("2006-01-02 15:04 MST"))
+	ctx, cancel := context.WithTimeout(ctx, github.LookupTimeout)
+	defer cancel()
+	var created lookedUp
+	err := f.api.Post(ctx, conf.GitHubAPIURL, conf.GitHubToken, "/repos/"+p.repo+"/issues", map[string]string{"title": p.title, "body": body}, &created)
+	var status *github.StatusError
+	switch {
+	case github.Unavailable(err):
+		return "Sorry, !newissue is temporarily unavailable, GitHub's rate limiting me"
+	case errors.As(err, &status):
+		return "Sorry, GitHub wouldn't file it: " + strings.TrimPrefix(status.Error(), "GitHub said ")
+	case err != nil:
+		logger.Warn("Couldn't file an issue from IRC", "repo", p.repo, "by", mask, "err", err)
+		return "Sorry, I couldn't get through to GitHub to file it"
+	}
+	logger.Info("Filed an issue from IRC", "repo", p.repo, "number", created.Number, "by", mask, "channel", p.channel)
+	return fmt.Sprintf("Filed %s#%d: %s", p.repo, created.Number, created.HTMLURL)
+}
@@ -0,0 +1,81 @@
+package ircbot
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/config"
+	"github.com/UniversityRadioYork/CaptainHook/internal/config/configtest"
+	"github.com/UniversityRadioYork/CaptainHook/internal/github"
+	"github.com/UniversityRadioYork/CaptainHook/internal/github/githubtest"
+)
+
+func TestNewIssue(t *testing.T) {
+	var filed []map[string]string
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/repos/ury/website/issues" {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"message": "Not Found"}`))
+			return
+		}
+		var issue map[string]string
+		json.NewDecoder(r.Body).Decode(&issue)
+		filed = append(filed, issue)
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"number": 300, "html_url": "https://github.com/ury/website/issues/300"}`))
+	}))
+	defer api.Close()
+	c := configtest.Valid()
+	c.GitHubToken, c.GitHubAPIURL, c.Timezone = "tok", api.URL, "UTC"
+	c.IRCReporters = []string{"*!*@studio.ury.org.uk"}
+	if errs := c.Validate(); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	f := NewIssueFiler(github.NewGitHubClient())
+	now := time.Date(2024, 3, 4, 19, 30, 0, 0, time.UTC)
+	f.now = func() time.Time { return now }
+	studio := "presenter!p@studio.ury.org.uk"
+	reply := func(mask, line string) string {
+		return f.Reply(context.Background(), c, "#studio", mask, strings.Fields(line), config.DiscardLogger)
+	}
+
+	if got := reply("someone!x@example.org", "ury/website Mixer channel 3 crackles"); got != "Sorry, only admins and reporters can do that" {
+		t.Errorf("expected only reporters allowed, got %q", got)
+	}
+	if got, want := reply(studio, "ury/website Mixer channel 3 crackles"), "Filed ury/website#300: https://github.com/ury/website/issues/300"; got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+	if len(filed) != 1 || filed[0]["title"] != "Mixer channel 3 crackles" || filed[0]["body"] != "Filed from IRC by presenter in #studio at 2024-03-04 19:30 UTC." {
+		t.Errorf("expected the issue filed, got %v", filed)
+	}
+
+	now = now.Add(2 * time.Minute)
+	if got := reply(studio, "ury/website oops"); !strings.Contains(got, "!newissue confirm") {
+		t.Errorf("expected a short title to need confirming, got %q", got)
+	}
+	if got := reply(studio, "confirm"); !strings.HasPrefix(got, "Filed ") || len(filed) != 2 {
+		t.Errorf("expected it filed once confirmed, got %q", got)
+	}
+	if got := reply(studio, "confirm"); got != "There's nothing waiting for you to confirm" {
+		t.Errorf("expected nothing left to confirm, got %q", got)
+	}
+	if got := reply(studio, "ury/website The jingle player froze"); got != "Steady on, you can only file one a minute" || len(filed) != 2 {
+		t.Errorf("expected to be held off for a minute, got %q", got)
+	}
+
+	now = now.Add(2 * time.Minute)
+	if got, want := reply(studio, "ury/nowhere The jingle player froze"), "Sorry, GitHub wouldn't file it: 404 Not Found: Not Found"; got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+
+	now = now.Add(2 * time.Minute)
+	f.api = &githubtest.FakeAPI{Err: github.ErrBackingOff}
+	if got, want := reply(studio, "ury/website The jingle player froze"), "Sorry, !newissue is temporarily unavailable, GitHub's rate limiting me"; got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
@@ -0,0 +1,133 @@
+package ircbot
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/nickvanw/ircx"
+	"github.com/sorcix/irc"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/format"
+	"github.com/UniversityRadioYork/CaptainHook/internal/outputs"
+)
+
+// Most of a line's text we put in one NOTICE. IRC allows 512 bytes all told,
+// and the server adds our nick!user@host when it passes it on.
+const ircLineBytes = 400
+
+// Between lines to the same channel or person, so a burst of them doesn't get
+// us kicked for flooding.
+const IRCInterval = time.Second
+
+// Announces with NOTICEs.
+type IRCOutput struct {
+	Sender ircx.Sender
+}
+
+// IRC lines can't have newlines in, so each line of a message, like a push's
+// commits, goes on its own, and ones too long for a NOTICE are broken up.
+func (o IRCOutput) Split(msg outputs.Message) []outputs.Message {
+	var msgs []outputs.Message
+	for _, line := range strings.Split(msg.Text, "\n") {
+		for _, part := range splitIRCLine(line, ircLineBytes) {
+			m := msg
+			m.Text = part
+			msgs = append(msgs, m)
+		}
+	}
+	return msgs
+}
+
+// Send each of msg's lines as a NOTICE, in order.
+func (o IRCOutput) Announce(ctx context.Context, msg outputs.Message) error {
+	for _, m := range o.Split(msg) {
+		slog.Debug("Sending to IRC", "channel", m.Target, "text", m.Text)
+		err := o.Sender.Send(&irc.Message{
+			Command:  irc.NOTICE,
+			Params:   []string{m.Target},
+			Trailing: m.Text,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Break line into pieces of at most max bytes, at a space if there is one and
+// never in the middle of a character or formatting code. Each piece after the
+// first starts with the colours and styles that were on where it was cut, so
+// it looks the same as it would have in one.
+func splitIRCLine(line string, max int) []string {
+	var parts []string
+	carry := ""
+	for len(carry)+len(line) > max {
+		cut := ircCutPoint(line, max-len(carry))
+		if cut <= 0 {
+			break // Not even the formatting fits, so it'll have to go long
+		}
+		part := carry + line[:cut]
+		parts = append(parts, part)
+		carry = activeFormatting(part)
+		line = strings.TrimLeft(line[cut:], " ")
+	}
+	return append(parts, carry+line)
+}
+
+// Where to cut line so the first piece is no more than n bytes.
+func ircCutPoint(line string, n int) int {
+	for _, loc := range format.Formatting.FindAllStringIndex(line, -1) {
+		if loc[0] < n && n < loc[1] {
+			n = loc[0]
+		}
+	}
+	for n > 0 && !utf8.RuneStart(line[n]) {
+		n--
+	}
+	if i := strings.LastIndexByte(line[:n], ' '); i > 0 {
+		return i
+	}
+	return n
+}
+
+// The codes to carry on where s leaves off: its colour, if it ends in one, and
+// any of bold, reverse, italic and underline still on.
+func activeFormatting(s string) string {
+	var fg, bg string
+	on := map[byte]bool{}
+	for _, code := range format.Formatting.FindAllString(s, -1) {
+		switch code[0] {
+		case '\x0F':
+			fg, bg = "", ""
+			on = map[byte]bool{}
+		case '\x03':
+			if len(code) == 1 {
+				fg, bg = "", ""
+				continue
+			}
+			colours := strings.SplitN(code[1:], ",", 2)
+			fg = colours[0]
+			if len(colours) == 2 {
+				bg = colours[1]
+			}
+		default:
+			on[code[0]] = !on[code[0]]
+		}
+	}
+	var out string
+	if fg != "" {
+		out = "\x03" + fg
+		if bg != "" {
+			out += "," + bg
+		}
+	}
+	for _, c := range []byte{'\x02', '\x16', '\x1D', '\x1F'} {
+		if on[c] {
+			out += string(c)
+		}
+	}
+	return out
+}
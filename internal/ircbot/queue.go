@@ -0,0 +1,123 @@
+package ircbot
+
+import (
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/config"
+	"github.com/UniversityRadioYork/CaptainHook/internal/metrics"
+	"github.com/UniversityRadioYork/CaptainHook/internal/outputs"
+)
+
+// A bounded queue of announcements waiting for the broadcast loop, which
+// never blocks producers for longer than its policy allows.
+type Queue struct {
+	policy  config.OverflowPolicy
+	timeout time.Duration
+	logger  *slog.Logger
+
+	c        chan outputs.Announcement
+	mu       sync.Mutex // Serialises drop-oldest's pop-then-push
+	enqueued uint64
+	dropped  uint64
+}
+
+func NewQueue(capacity int, policy config.OverflowPolicy, timeout time.Duration, logger *slog.Logger) *Queue {
+	return &Queue{
+		policy:  policy,
+		timeout: timeout,
+		logger:  logger,
+		c:       make(chan outputs.Announcement, capacity),
+	}
+}
+
+// The channel the broadcast loop reads from.
+func (q *Queue) C() <-chan outputs.Announcement {
+	return q.c
+}
+
+// Queue an announcement, returning false if it was dropped.
+func (q *Queue) Push(a outputs.Announcement) bool {
+	select {
+	case q.c <- a:
+		atomic.AddUint64(&q.enqueued, 1)
+		return true
+	default:
+	}
+
+	switch q.policy {
+	case config.DropOldest:
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		for {
+			select {
+			case q.c <- a:
+				atomic.AddUint64(&q.enqueued, 1)
+				return true
+			default:
+			}
+			select {
+			case old := <-q.c:
+				q.drop(old, "oldest")
+			default:
+			}
+		}
+	case config.BlockWithTimeout:
+		select {
+		case q.c <- a:
+			atomic.AddUint64(&q.enqueued, 1)
+			return true
+		case <-time.After(q.timeout):
+		}
+	}
+	q.drop(a, "newest")
+	return false
+}
+
+func (q *Queue) drop(a outputs.Announcement, which string) {
+	atomic.AddUint64(&q.dropped, 1)
+	q.logger.Warn("Broadcast queue full, dropped message", "which", which, "event", a.Event, "delivery", a.ID, "repo", a.FullName)
+}
+
+type QueueStats struct {
+	Depth    int
+	Capacity int
+	Enqueued uint64
+	Dropped  uint64
+}
+
+func (q *Queue) Stats() QueueStats {
+	return QueueStats{
+		Depth:    len(q.c),
+		Capacity: cap(q.c),
+		Enqueued: atomic.LoadUint64(&q.enqueued),
+		Dropped:  atomic.LoadUint64(&q.dropped),
+	}
+}
+
+func (s QueueStats) String() string {
+	return fmt.Sprintf("queue %d/%d, %d sent to queue, %d dropped", s.Depth, s.Capacity, s.Enqueued, s.Dropped)
+}
+
+// Export the broadcast queue's stats. Only call this once per registry.
+func RegisterQueueMetrics(q *Queue) {
+	metrics.Registry.MustRegister(
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "capthook_queue_depth",
+			Help: "Announcements waiting to be sent to IRC.",
+		}, func() float64 { return float64(q.Stats().Depth) }),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "capthook_queue_enqueued_total",
+			Help: "Announcements added to the broadcast queue.",
+		}, func() float64 { return float64(q.Stats().Enqueued) }),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "capthook_queue_dropped_total",
+			Help: "Announcements dropped because the broadcast queue was full.",
+		}, func() float64 { return float64(q.Stats().Dropped) }),
+	)
+}
@@ -0,0 +1,40 @@
+package ircbot
+
+import (
+	"testing"
+	"time"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/config"
+	"github.com/UniversityRadioYork/CaptainHook/internal/outputs"
+)
+
+func TestQueueOverflow(t *testing.T) {
+	logger := config.DiscardLogger
+	cases := []struct {
+		policy   config.OverflowPolicy
+		enqueued uint64
+		want     []string
+	}{
+		{config.DropOldest, 3, []string{"b", "c"}},
+		{config.DropNewest, 2, []string{"a", "b"}},
+		{config.BlockWithTimeout, 2, []string{"a", "b"}},
+	}
+	for _, c := range cases {
+		q := NewQueue(2, c.policy, 10*time.Millisecond, logger)
+		for _, text := range []string{"a", "b", "c"} {
+			q.Push(outputs.Announcement{Text: text})
+		}
+		stats := q.Stats()
+		if stats.Enqueued != c.enqueued {
+			t.Errorf("%s: expected %d enqueued, got %d", c.policy, c.enqueued, stats.Enqueued)
+		}
+		if stats.Dropped != 1 {
+			t.Errorf("%s: expected 1 dropped, got %d", c.policy, stats.Dropped)
+		}
+		for _, want := range c.want {
+			if got := (<-q.C()).Text; got != want {
+				t.Errorf("%s: expected %s, got %s", c.policy, want, got)
+			}
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package ircbot
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/config"
+	"github.com/UniversityRadioYork/CaptainHook/internal/format"
+	"github.com/UniversityRadioYork/CaptainHook/internal/github"
+)
+
+// An issue or PR mentioned in passing, like "see #123" or "ury/website#123".
+// Go has no lookbehind, so the character before it is matched too.
+var issueRef = regexp.MustCompile(`(?:^|[^\w/#&.-])(?:([\w.-]+/[\w.-]+))?#(\d+)\b`)
+
+// The most references we'll expand from one message, and how long before
+// the same one's expanded again in a channel.
+const (
+	maxRefExpansions = 3
+	refCooldown      = 10 * time.Minute
+)
+
+// References expanded lately, by channel, and links we've announced lately,
+// which there's no need to expand when someone pastes them.
+var (
+	expandedRefs  = NewDuplicateFilter(1000)
+	announcedURLs = NewDuplicateFilter(1000)
+)
+
+// Lines about the issues, PRs and commits text (said in channel) mentions,
+// like "#123: Fix the stream relay (open) https://...". Bare numbers are for
+// the one repo the channel's sent, and ones below ExpandRefsMin are left
+// alone, as they're more often "#1 fan" than an issue. Pasted GitHub links
+// get their title and state, but not if we've just announced them
+// ourselves. Anything we can't find is left alone too.
+func (l *Lookups) Expand(ctx context.Context, conf *config.Config, channel, text string, logger *slog.Logger) []string {
+	if conf == nil || conf.GitHubToken == "" || !conf.ExpandRefs(channel) {
+		return nil
+	}
+	text = format.StripFormatting(text)
+	var lines []string
+	for _, m := range mentions(conf, channel, text) {
+		if len(lines) == maxRefExpansions {
+			break
+		}
+		if expandedRefs.Duplicate(channel, strings.ToLower(m.key()), refCooldown) {
+			continue
+		}
+		found, err := l.get(ctx, conf, m.endpoint, m.repo, m.id)
+		if err != nil {
+			logger.Warn("Couldn't look up something mentioned on IRC", "channel", channel, "repo", m.repo, "id", m.id, "err", err)
+			continue
+		}
+		switch {
+		case found == nil:
+		case m.endpoint == "commits":
+			subject := strings.SplitN(found.Commit.Message, "\n", 2)[0]
+			lines = append(lines, fmt.Sprintf("%s: %s", m.label, github.CleanName(format.StripFormatting(subject))))
+		case m.pasted:
+			lines = append(lines, fmt.Sprintf("%s: %s (%s)", m.label, github.CleanName(format.StripFormatting(found.Title)), found.State))
+		default:
+			lines = append(lines, fmt.Sprintf("%s: %s (%s) %s", m.label, github.CleanName(format.StripFormatting(found.Title)), found.State, l.link(ctx, conf, m.repo, found.HTMLURL)))
+		}
+	}
+	return lines
+}
+
+// Something on GitHub someone mentioned.
+type mention struct {
+	repo     string // owner/name
+	endpoint string // issues, which has PRs too, or commits
+	id       string // Its number or hash
+	label    string // What to call it, like "#123" or "ury/website@abc1234"
+	pasted   bool   // As a link, so there's no need to give it back
+}
+
+// What the cooldown goes by, so a link and a #123 for the same thing count
+// as the same.
+func (m mention) key() string {
+	return m.repo + "/" + m.endpoint + "/" + m.id
+}
+
+// A link to an issue, PR or commit, with the host checked separately.
+var githubLink = regexp.MustCompile(`https?://([\w.-]+(?::\d+)?)/([\w.-]+/[\w.-]+)/(issues|pull|commit)/([0-9a-fA-F]+)\b`)
+
+// Everything text (said in channel) mentions that we might expand: pasted
+// links first, then references.
+func mentions(c *config.Config, channel, text string) []mention {
+	var ms []mention
+	hosts := c.GitHubHosts()
+	for _, m := range githubLink.FindAllStringSubmatch(text, -1) {
+		host, repo, kind, id := strings.ToLower(m[1]), m[2], m[3], m[4]
+		if !config.Contains(hosts, host) || !config.FullRepoName.MatchString(repo) || strings.Contains(repo, "..") || announcedURLs.Seen("", m[0], refCooldown) {
+			continue
+		}
+		if kind == "commit" {
+			if len(id) < 7 {
+				continue
+			}
+			ms = append(ms, mention{repo: repo, endpoint: "commits", id: strings.ToLower(id), label: repo + "@" + strings.ToLower(id[:7]), pasted: true})
+			continue
+		}
+		if _, err := strconv.Atoi(id); err != nil {
+			continue
+		}
+		ms = append(ms, mention{repo: repo, endpoint: "issues", id: id, label: repo + "#" + id, pasted: true})
+	}
+	for _, m := range issueRef.FindAllStringSubmatch(text, -1) {
+		repo, label := m[1], "#"+m[2]
+		if repo == "" {
+			repo = c.ChannelRepo(channel)
+		} else if !config.FullRepoName.MatchString(repo) || strings.Contains(repo, "..") {
+			continue
+		} else {
+			label = repo + label
+		}
+		number, err := strconv.Atoi(m[2])
+		if repo == "" || err != nil || number < c.ExpandRefsMin {
+			continue
+		}
+		ms = append(ms, mention{repo: repo, endpoint: "issues", id: m[2], label: label})
+	}
+	return ms
+}
@@ -0,0 +1,121 @@
+package ircbot
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/config"
+	"github.com/UniversityRadioYork/CaptainHook/internal/config/configtest"
+	"github.com/UniversityRadioYork/CaptainHook/internal/github"
+)
+
+func TestExpandRefs(t *testing.T) {
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/repos/ury/website/issues/123":
+			w.Write([]byte(`{"number": 123, "title": "Fix the stream relay", "state": "open", "html_url": "https://github.com/ury/website/issues/123"}`))
+		case "/repos/ury/playout/issues/14":
+			w.Write([]byte(`{"number": 14, "title": "Silence detector", "state": "closed", "html_url": "https://github.com/ury/playout/pull/14"}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer api.Close()
+	c := configtest.Valid()
+	c.GitHubToken, c.GitHubAPIURL, c.ShortenURLs = "tok", api.URL, false
+	c.Repos = map[string]config.RepoConfig{"ury/website": {Channels: "#web"}}
+	c.ChannelSettings = map[string]config.ChannelConfig{"#web": {ExpandRefs: true}}
+	if errs := c.Validate(); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	now := time.Now()
+	expandedRefs = NewDuplicateFilter(1000)
+	expandedRefs.now = func() time.Time { return now }
+	defer func() { expandedRefs = NewDuplicateFilter(1000) }()
+	l := NewLookups(github.NewGitHubClient())
+	expand := func(channel, text string) string {
+		return strings.Join(l.Expand(context.Background(), c, channel, text, config.DiscardLogger), " | ")
+	}
+
+	for _, tc := range []struct{ channel, text, want string }{
+		{"#web", "see #123", "#123: Fix the stream relay (open) https://github.com/ury/website/issues/123"},
+		{"#web", "yes, #123 again", ""},
+		{"#web", "I'm the #1 fan", ""},
+		{"#web", "#999 or ury/playout#14?", "ury/playout#14: Silence detector (closed) https://github.com/ury/playout/pull/14"},
+		{"#web", "https://example.org/page#150 and #web", ""},
+		{"#other", "see #123", ""},
+	} {
+		if got := expand(tc.channel, tc.text); got != tc.want {
+			t.Errorf("%s %q: expected %q, got %q", tc.channel, tc.text, tc.want, got)
+		}
+	}
+
+	now = now.Add(11 * time.Minute)
+	if got := expand("#web", "back to #123"); !strings.HasPrefix(got, "#123: ") {
+		t.Errorf("expected it expanded again after the cooldown, got %q", got)
+	}
+	c.ExpandRefsMin = 200
+	now = now.Add(11 * time.Minute)
+	if got := expand("#web", "back to #123"); got != "" {
+		t.Errorf("expected nothing below ExpandRefsMin, got %q", got)
+	}
+}
+
+func TestExpandLinks(t *testing.T) {
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v3/repos/ury/website/issues/215", "/api/v3/repos/ury/website/issues/216":
+			w.Write([]byte(`{"number": 215, "title": "Fix the schedule page", "state": "closed"}`))
+		case "/api/v3/repos/ury/website/commits/abc1234def":
+			w.Write([]byte(`{"sha": "abc1234def", "commit": {"message": "Move the relay to the new box\n\nIt was getting old."}}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer api.Close()
+	c := configtest.Valid()
+	c.GitHubToken, c.GitHubAPIURL = "tok", api.URL+"/api/v3"
+	c.ChannelSettings = map[string]config.ChannelConfig{"#web": {ExpandRefs: true}}
+	if errs := c.Validate(); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	ghes := strings.TrimPrefix(api.URL, "http://")
+	expandedRefs = NewDuplicateFilter(1000)
+	announcedURLs = NewDuplicateFilter(1000)
+	defer func() {
+		expandedRefs = NewDuplicateFilter(1000)
+		announcedURLs = NewDuplicateFilter(1000)
+	}()
+	l := NewLookups(github.NewGitHubClient())
+	expand := func(text string) string {
+		return strings.Join(l.Expand(context.Background(), c, "#web", text, config.DiscardLogger), " | ")
+	}
+
+	announcedURLs.Duplicate("", "http://"+ghes+"/ury/website/pull/216", refCooldown)
+	for _, tc := range []struct{ text, want string }{
+		{"http://" + ghes + "/ury/website/pull/215 is the one", "ury/website#215: Fix the schedule page (closed)"},
+		{"did you see http://" + ghes + "/ury/website/issues/215?", ""},
+		{"http://" + ghes + "/ury/website/commit/ABC1234DEF", "ury/website@abc1234: Move the relay to the new box"},
+		{"http://" + ghes + "/ury/website/pull/216", ""},
+		{"https://gitlab.com/ury/website/issues/300", ""},
+	} {
+		if got := expand(tc.text); got != tc.want {
+			t.Errorf("%q: expected %q, got %q", tc.text, tc.want, got)
+		}
+	}
+}
+
+func TestGitHubHosts(t *testing.T) {
+	c := configtest.Valid()
+	if got := strings.Join(c.GitHubHosts(), ","); got != "github.com,www.github.com" {
+		t.Errorf("expected just github.com by default, got %s", got)
+	}
+	c.GitHubURL, c.GitHubAPIURL = "https://GitHub.compsoc.example.ac.uk", "https://github.compsoc.example.ac.uk/api/v3"
+	if got := strings.Join(c.GitHubHosts(), ","); got != "github.com,www.github.com,github.compsoc.example.ac.uk" {
+		t.Errorf("expected our GHES once, got %s", got)
+	}
+}
@@ -0,0 +1,168 @@
+package ircbot
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/config"
+	"github.com/UniversityRadioYork/CaptainHook/internal/format"
+)
+
+// Repos whose webhooks have been deleted, going by the meta event GitHub
+// sends as they go, so !status can say why one's gone quiet. They're kept
+// next to the StateFile, and a repo's taken off again as soon as we hear
+// anything else from it, as then it's got a hook again.
+type RemovedHooks struct {
+	path string // "" to keep them in memory only
+	now  func() time.Time
+
+	mu      sync.Mutex
+	removed map[string]removedHook // By lower case owner/name, or org
+}
+
+type removedHook struct {
+	Repo   string    `json:"repo"`
+	By     string    `json:"by"`
+	HookID int64     `json:"hook_id"`
+	At     time.Time `json:"at"`
+}
+
+func NewRemovedHooks(path string) *RemovedHooks {
+	return &RemovedHooks{path: path, now: time.Now, removed: make(map[string]removedHook)}
+}
+
+// Bump this if removedHooksFile changes.
+const removedHooksVersion = 1
+
+type removedHooksFile struct {
+	Version int           `json:"version"`
+	Removed []removedHook `json:"removed"`
+}
+
+func RemovedHooksPath(stateFile string) string {
+	return stateFile + ".removed-hooks"
+}
+
+// Pick up the removed hooks saved at path.
+func LoadRemovedHooks(path string, logger *slog.Logger) *RemovedHooks {
+	r := NewRemovedHooks(path)
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logger.Error("Error reading removed webhooks", "path", path, "err", err)
+		}
+		return r
+	}
+	var f removedHooksFile
+	if err := json.Unmarshal(data, &f); err != nil || f.Version != removedHooksVersion {
+		logger.Warn("Skipping removed webhooks we can't read", "path", path, "version", f.Version, "err", err)
+		return r
+	}
+	for _, h := range f.Removed {
+		r.removed[strings.ToLower(h.Repo)] = h
+	}
+	return r
+}
+
+// Keep track of a GitHub delivery, id: a meta event saying its hook's been
+// deleted adds its repo, and anything else from one takes it off.
+func (r *RemovedHooks) Seen(event, id string, payload []byte, logger *slog.Logger) {
+	if r == nil {
+		return
+	}
+	repo := config.PayloadRepo(payload)
+	if repo == "" {
+		repo = config.PayloadOrg(payload)
+	}
+	if repo == "" {
+		return
+	}
+	sender, action := config.PayloadSender(payload)
+	key := strings.ToLower(repo)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if event == "meta" && action == "deleted" {
+		var p struct {
+			HookID int64 `json:"hook_id"`
+		}
+		json.Unmarshal(payload, &p)
+		logger.Warn("Webhook removed", "repo", repo, "by", sender, "hook", p.HookID, "delivery", id)
+		r.removed[key] = removedHook{Repo: repo, By: sender, HookID: p.HookID, At: r.now()}
+	} else if _, ok := r.removed[key]; ok {
+		delete(r.removed, key)
+	} else {
+		return
+	}
+	if err := r.save(); err != nil {
+		logger.Error("Couldn't save removed webhooks", "err", err)
+	}
+}
+
+// Write the removed hooks out, if we've somewhere to. Call with mu held.
+func (r *RemovedHooks) save() error {
+	if r.path == "" {
+		return nil
+	}
+	f := removedHooksFile{Version: removedHooksVersion, Removed: []removedHook{}}
+	for _, h := range r.removed {
+		h.At = h.At.UTC()
+		f.Removed = append(f.Removed, h)
+	}
+	data, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+	tmp := r.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, r.path)
+}
+
+// The removed hooks, oldest first, then by repo.
+func (r *RemovedHooks) List() []removedHook {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var list []removedHook
+	for _, h := range r.removed {
+		list = append(list, h)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].At.Equal(list[j].At) {
+			return list[i].At.Before(list[j].At)
+		}
+		return strings.ToLower(list[i].Repo) < strings.ToLower(list[j].Repo)
+	})
+	return list
+}
+
+// For the end of !status: which repos have lost their hooks, by who and
+// how long ago, or "" if none have.
+func (r *RemovedHooks) String() string {
+	list := r.List()
+	if len(list) == 0 {
+		return ""
+	}
+	now := r.now()
+	var parts []string
+	for _, h := range list {
+		ago := format.HumanDuration(now.Sub(h.At))
+		if ago == "moments" || ago == "" {
+			ago = "just now"
+		} else {
+			ago += " ago"
+		}
+		parts = append(parts, fmt.Sprintf("%s (by %s, %s)", h.Repo, h.By, ago))
+	}
+	return "; webhooks removed from " + strings.Join(parts, ", ")
+}
@@ -0,0 +1,72 @@
+package ircbot
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/config"
+	"github.com/UniversityRadioYork/CaptainHook/internal/config/configtest"
+	"github.com/UniversityRadioYork/CaptainHook/internal/github"
+)
+
+func TestRemovedHooks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "removedhooks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := RemovedHooksPath(filepath.Join(dir, "state.jsonl"))
+	now := time.Date(2024, 3, 4, 12, 0, 0, 0, time.UTC)
+	r := LoadRemovedHooks(path, config.DiscardLogger)
+	r.now = func() time.Time { return now }
+
+	meta, err := ioutil.ReadFile("testdata/meta_deleted.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Seen("meta", "", meta, config.DiscardLogger)
+	r.Seen("meta", "", []byte(`{"action":"deleted","hook_id":9,"organization":{"login":"ury-bots"},"sender":{"login":"x1tot"}}`), config.DiscardLogger)
+	r.Seen("issues", "", []byte(`{"action":"opened","repository":{"full_name":"ury/playout"}}`), config.DiscardLogger)
+	now = now.Add(3 * time.Hour)
+	want := "; webhooks removed from UniversityRadioYork/website (by mstratford, 3h ago), ury-bots (by x1tot, 3h ago)"
+	if got := r.String(); got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+
+	// They last a restart, until we hear from the repo again
+	r = LoadRemovedHooks(path, config.DiscardLogger)
+	r.now = func() time.Time { return now }
+	if got := r.List(); len(got) != 2 || got[0].HookID != 452718930 {
+		t.Fatalf("expected both back after a restart, got %+v", got)
+	}
+	r.Seen("push", "", []byte(`{"repository":{"full_name":"universityradioyork/website"}}`), config.DiscardLogger)
+	r = LoadRemovedHooks(path, config.DiscardLogger)
+	r.now = func() time.Time { return now }
+	if got := r.String(); got != "; webhooks removed from ury-bots (by x1tot, 3h ago)" {
+		t.Errorf("expected website gone once it's heard from again, got %q", got)
+	}
+
+	var none *RemovedHooks
+	none.Seen("meta", "", meta, config.DiscardLogger)
+	if none.String() != "" || NewRemovedHooks("").String() != "" {
+		t.Error("expected nothing to say with nothing removed")
+	}
+}
+
+// Webhooks going are announced whatever the channels' schedules and mutes.
+func TestMetaDeletedPriority(t *testing.T) {
+	meta, err := ioutil.ReadFile("testdata/meta_deleted.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := github.ParseGitHubEvent("meta", meta)
+	if err != nil || e == nil {
+		t.Fatalf("expected an event, got %v", err)
+	}
+	if !configtest.Valid().IsPriority(e) {
+		t.Error("expected a removed webhook to be priority")
+	}
+}
@@ -0,0 +1,46 @@
+package ircbot
+
+import (
+	"context"
+	"testing"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/config"
+	"github.com/UniversityRadioYork/CaptainHook/internal/format"
+	"github.com/UniversityRadioYork/CaptainHook/internal/outputs"
+)
+
+func TestUserFormatting(t *testing.T) {
+	s := &config.RepoSettings{Templates: format.EventTemplates, Colors: true, ActionColors: format.DefaultActionColors, CommitLines: 3, SHALength: 7}
+	for _, c := range []struct {
+		name string
+		e    format.Event
+		want string // With the formatting spelled out, see ShowFormatting
+	}{
+		{"rainbow title", format.Event{Type: "issues", Action: "opened", Repo: "website", Number: 1, Sender: "x", URL: "https://u/",
+			Title: "\x0304R\x0307a\x0308i\x0303n\x0302b\x0306o\x0313w"},
+			"[%C06website%O] Issue #1 %C03opened%O by x: Rainbow. https://u/%O"},
+		{"left running", format.Event{Type: "issues", Action: "opened", Repo: "website", Number: 1, Sender: "x", URL: "https://u/",
+			Title: "\x02\x1d\x1f\x16Loud\x0304,08"},
+			"[%C06website%O] Issue #1 %C03opened%O by x: Loud. https://u/%O"},
+		{"nested", format.Event{Type: "issues", Action: "opened", Repo: "web\x0fsite", Number: 1, Sender: "\x0312,01x\x03", URL: "https://u/",
+			Title: "\x02\x0304,01a\x0303b\x02c\x0f\x0f"},
+			"[%C06website%O] Issue #1 %C03opened%O by x: abc. https://u/%O"},
+		{"cut short", format.Event{Type: "issues", Action: "opened", Repo: "website", Number: 1, Sender: "x", URL: "https://u/",
+			Title: "Issue 4\x03"},
+			"[%C06website%O] Issue #1 %C03opened%O by x: Issue 4. https://u/%O"},
+		{"eats digits", format.Event{Type: "issues", Action: "opened", Repo: "website", Number: 1, Sender: "x", URL: "https://u/",
+			Title: "\x031234 errors"},
+			"[%C06website%O] Issue #1 %C03opened%O by x: 34 errors. https://u/%O"},
+		{"commits", format.Event{Type: "push", Action: "pushed", Repo: "website", Sender: "x", Ref: "ma\x02in", Commits: 1, URL: "https://u/",
+			Log: []format.Commit{{ID: "1111111aaaa", Author: "\x0304bob", Subject: "Fix \x1fall\x03 the things"}}},
+			"[%C06website%O] x pushed 1 commit to main. https://u/%O\nbob %C141111111%O Fix all the things%O"},
+	} {
+		e := c.e
+		if got := outputs.ShowFormatting(s.Format(context.Background(), &e, config.DiscardLogger)); got != c.want {
+			t.Errorf("%s: expected %q, got %q", c.name, c.want, got)
+		}
+		if e.Title != c.e.Title || (len(e.Log) > 0 && e.Log[0] != c.e.Log[0]) {
+			t.Errorf("%s: expected the event left alone", c.name)
+		}
+	}
+}
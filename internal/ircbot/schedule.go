@@ -0,0 +1,138 @@
+package ircbot
+
+import (
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/config"
+	"github.com/UniversityRadioYork/CaptainHook/internal/outputs"
+)
+
+// The most announcements we'll hold for a channel waiting for its window.
+const maxDeferred = 100
+
+// Holds back announcements for channels outside their schedules, for the
+// broadcast loop. Decisions are made as announcements go out rather than as
+// they come in, with whatever the config is at the time.
+type Scheduler struct {
+	mutes    *Mutes
+	deferred map[string][]outputs.Announcement // By channel, one channel each
+	muted    map[string][]outputs.Announcement // Held for a mute to end, likewise
+	now      func() time.Time
+}
+
+// A Scheduler going by mutes, or no mutes if that's nil.
+func NewScheduler(mutes *Mutes) *Scheduler {
+	return &Scheduler{mutes: mutes, deferred: make(map[string][]outputs.Announcement), muted: make(map[string][]outputs.Announcement), now: time.Now}
+}
+
+// Cut an announcement down to the channels that can have it now, holding
+// on to or dropping it for the rest, including those it's muted in.
+// Priority announcements go everywhere.
+func (s *Scheduler) Filter(c *config.Config, a outputs.Announcement, logger *slog.Logger) outputs.Announcement {
+	if a.Priority {
+		return a
+	}
+	now := s.now()
+	var open []string
+	for _, ch := range a.Channels {
+		if s.mutes.Muted(ch, a) {
+			if c.MuteMode == config.OutsideWindowDefer {
+				s.holdMuted(ch, a, logger)
+			}
+			continue
+		}
+		if c.ChannelOpen(ch, now) {
+			open = append(open, ch)
+			continue
+		}
+		if c.OutsideWindow(ch) == config.OutsideWindowDefer {
+			s.hold(ch, a, logger)
+		}
+	}
+	a.Channels = open
+	return a
+}
+
+func (s *Scheduler) hold(channel string, a outputs.Announcement, logger *slog.Logger) {
+	key := strings.ToLower(channel)
+	a.Channels = []string{channel}
+	if len(s.deferred[key]) >= maxDeferred {
+		logger.Warn("Too many announcements waiting for schedule, dropping the oldest", "channel", channel)
+		s.deferred[key] = s.deferred[key][1:]
+	}
+	s.deferred[key] = append(s.deferred[key], a)
+}
+
+func (s *Scheduler) holdMuted(channel string, a outputs.Announcement, logger *slog.Logger) {
+	key := strings.ToLower(channel)
+	a.Channels = []string{channel}
+	if len(s.muted[key]) >= maxDeferred {
+		logger.Warn("Too many announcements waiting for a mute to end, dropping the oldest", "channel", channel)
+		s.muted[key] = s.muted[key][1:]
+	}
+	s.muted[key] = append(s.muted[key], a)
+}
+
+// Announcements held for channels that are now open, or mutes that have
+// ended, in the order they came. Anything held for a channel that's since
+// been switched to dropping is dropped.
+func (s *Scheduler) Due(c *config.Config) []outputs.Announcement {
+	now := s.now()
+	var due []outputs.Announcement
+	for key, held := range s.muted {
+		var still []outputs.Announcement
+		for _, a := range held {
+			switch {
+			case !s.mutes.Muted(a.Channels[0], a):
+				if a = s.Filter(c, a, config.DiscardLogger); len(a.Channels) > 0 {
+					due = append(due, a)
+				}
+			case c.MuteMode == config.OutsideWindowDefer:
+				still = append(still, a)
+			}
+		}
+		if len(still) > 0 {
+			s.muted[key] = still
+		} else {
+			delete(s.muted, key)
+		}
+	}
+	for key, held := range s.deferred {
+		channel := held[0].Channels[0]
+		if c.ChannelOpen(channel, now) {
+			due = append(due, held...)
+			delete(s.deferred, key)
+		} else if c.OutsideWindow(channel) != config.OutsideWindowDefer {
+			delete(s.deferred, key)
+		}
+	}
+	return due
+}
+
+// How many announcements are being held.
+func (s *Scheduler) Len() int {
+	n := 0
+	for _, held := range s.deferred {
+		n += len(held)
+	}
+	for _, held := range s.muted {
+		n += len(held)
+	}
+	return n
+}
+
+// Everything being held, taking it off our hands, e.g. to save at shutdown.
+func (s *Scheduler) Held() []outputs.Announcement {
+	var held []outputs.Announcement
+	for key, msgs := range s.deferred {
+		held = append(held, msgs...)
+		delete(s.deferred, key)
+	}
+	for key, msgs := range s.muted {
+		held = append(held, msgs...)
+		delete(s.muted, key)
+	}
+	return held
+}
@@ -0,0 +1,57 @@
+package ircbot
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/config"
+	"github.com/UniversityRadioYork/CaptainHook/internal/config/configtest"
+	"github.com/UniversityRadioYork/CaptainHook/internal/outputs"
+)
+
+func TestScheduler(t *testing.T) {
+	c := configtest.Valid()
+	c.Channels = "#dev,#training,#quiet"
+	c.Timezone = "UTC"
+	c.ChannelSettings = map[string]config.ChannelConfig{
+		"#training": {Schedule: []string{"Wed 18:00-21:00"}, OutsideWindow: "defer"},
+		"#quiet":    {Schedule: []string{"Wed 18:00-21:00"}},
+	}
+	if errs := c.Validate(); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	logger := config.DiscardLogger
+	s := NewScheduler(nil)
+	now := time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC) // Wednesday lunchtime
+	s.now = func() time.Time { return now }
+
+	a := s.Filter(c, outputs.Announcement{Channels: []string{"#dev", "#training", "#quiet"}, Text: "one"}, logger)
+	if got := strings.Join(a.Channels, ","); got != "#dev" {
+		t.Errorf("expected only #dev open, got %s", got)
+	}
+	if p := s.Filter(c, outputs.Announcement{Channels: []string{"#quiet"}, Priority: true}, logger); len(p.Channels) != 1 {
+		t.Errorf("expected priority announcements through regardless")
+	}
+	if due := s.Due(c); len(due) != 0 || s.Len() != 1 {
+		t.Fatalf("expected one held and nothing due yet, got %d due, %d held", len(due), s.Len())
+	}
+
+	now = now.Add(6 * time.Hour)
+	due := s.Due(c)
+	if len(due) != 1 || due[0].Text != "one" || strings.Join(due[0].Channels, ",") != "#training" {
+		t.Errorf("expected the held announcement for #training once open, got %+v", due)
+	}
+	if s.Len() != 0 {
+		t.Errorf("expected nothing left held")
+	}
+}
+
+func TestSchedulerHeld(t *testing.T) {
+	s := NewScheduler(nil)
+	s.hold("#a", outputs.Announcement{Text: "one"}, config.DiscardLogger)
+	s.hold("#b", outputs.Announcement{Text: "two"}, config.DiscardLogger)
+	if held := s.Held(); len(held) != 2 || s.Len() != 0 {
+		t.Errorf("expected both, and none left, got %+v and %d", held, s.Len())
+	}
+}
@@ -0,0 +1,64 @@
+package ircbot
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/config"
+)
+
+// The reply to !subscribe, !unsubscribe or !subscriptions (command, without
+// the !) with args, from mask in channel.
+func subscriptionCommand(conf *config.Config, command, channel, mask string, args []string, logger *slog.Logger) string {
+	if !strings.HasPrefix(channel, "#") && !strings.HasPrefix(channel, "&") {
+		return "Ask me that in the channel you mean"
+	}
+	if command == "subscriptions" {
+		subscribed, unsubscribed := conf.Subscriptions.For(channel)
+		var static []string
+		for _, name := range conf.RepoNames() {
+			for _, ch := range config.SplitChannels(conf.Repos[name].Channels) {
+				if strings.EqualFold(ch, channel) && !config.Contains(subscribed, strings.ToLower(name)) && !config.Contains(unsubscribed, strings.ToLower(name)) {
+					static = append(static, name)
+				}
+			}
+		}
+		gets := append(static, subscribed...)
+		for _, ch := range conf.DefaultHook().Channels {
+			if strings.EqualFold(ch, channel) {
+				gets = append(gets, "everything else on the default hook")
+			}
+		}
+		reply := channel + " gets nothing"
+		if len(gets) > 0 {
+			reply = channel + " gets " + strings.Join(gets, ", ")
+		}
+		if len(unsubscribed) > 0 {
+			reply += "; unsubscribed from " + strings.Join(unsubscribed, ", ")
+		}
+		return reply
+	}
+
+	if !conf.IsIRCAdmin(mask) {
+		return "Sorry, only admins can do that"
+	}
+	if len(args) != 1 || !config.FullRepoName.MatchString(args[0]) || strings.Contains(args[0], "..") {
+		return "Usage: !" + command + " owner/name"
+	}
+	repo := args[0]
+	if err := conf.Subscriptions.Set(repo, channel, command == "subscribe"); err != nil {
+		logger.Error("Couldn't save subscriptions", "err", err)
+		return "Done, but I couldn't save it, so it won't last a restart"
+	}
+	logger.Info("Subscriptions changed from IRC", "by", mask, "command", command, "repo", repo, "channel", channel)
+	channels := conf.RepoChannels(repo)
+	where := "nowhere"
+	if len(channels) > 0 {
+		where = strings.Join(channels, ", ")
+	}
+	if command == "subscribe" {
+		return fmt.Sprintf("%s now gets %s, which goes to %s", channel, repo, where)
+	}
+	return fmt.Sprintf("%s no longer gets %s, which goes to %s", channel, repo, where)
+}
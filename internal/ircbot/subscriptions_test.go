@@ -0,0 +1,61 @@
+package ircbot
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/config"
+	"github.com/UniversityRadioYork/CaptainHook/internal/config/configtest"
+)
+
+func TestSubscriptions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "subs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := config.SubscriptionsPath(filepath.Join(dir, "state.jsonl"))
+	c := configtest.Valid()
+	c.Subscriptions = config.LoadSubscriptions(path, config.DiscardLogger)
+	c.Channels = "#tech"
+	c.IRCAdmins = []string{"marky!*@ury.org.uk"}
+	c.Repos = map[string]config.RepoConfig{"ury/playout": {Channels: "#playout,#tech"}}
+	if errs := c.Validate(); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	command := func(channel, mask, line string) string {
+		args := strings.Fields(line)
+		return subscriptionCommand(c, args[0], channel, mask, args[1:], config.DiscardLogger)
+	}
+	admin := "marky!mark@ury.org.uk"
+
+	for _, tc := range []struct{ channel, mask, line, want string }{
+		{"#web", "someone!x@example.org", "subscribe ury/website", "Sorry, only admins can do that"},
+		{"#web", admin, "subscribe", "Usage: !subscribe owner/name"},
+		{"marky", admin, "subscribe ury/website", "Ask me that in the channel you mean"},
+		{"#web", admin, "subscribe UniversityRadioYork/website", "#web now gets UniversityRadioYork/website, which goes to #tech, #web"},
+		{"#tech", admin, "unsubscribe ury/playout", "#tech no longer gets ury/playout, which goes to #playout"},
+		{"#web", "someone!x@example.org", "subscriptions", "#web gets universityradioyork/website"},
+		{"#tech", "someone!x@example.org", "subscriptions", "#tech gets everything else on the default hook; unsubscribed from ury/playout"},
+		{"#playout", "someone!x@example.org", "subscriptions", "#playout gets ury/playout"},
+	} {
+		if got := command(tc.channel, tc.mask, tc.line); got != tc.want {
+			t.Errorf("%s in %s: expected %q, got %q", tc.line, tc.channel, tc.want, got)
+		}
+	}
+
+	// They go over the config, and last a reload and a restart.
+	if got := strings.Join(c.Subscriptions.Apply("ury/playout", []string{"#playout", "#tech"}), ","); got != "#playout" {
+		t.Errorf("expected #tech taken off ury/playout, got %s", got)
+	}
+	c.Subscriptions = config.LoadSubscriptions(path, config.DiscardLogger)
+	if got := strings.Join(c.Subscriptions.Apply("universityradioyork/WEBSITE", []string{"#tech"}), ","); got != "#tech,#web" {
+		t.Errorf("expected #web added to the website after a restart, got %s", got)
+	}
+	if !config.Contains(c.IRCChannels(), "#web") {
+		t.Errorf("expected to be in #web, got %v", c.IRCChannels())
+	}
+}
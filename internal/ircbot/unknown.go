@@ -0,0 +1,135 @@
+package ircbot
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/config"
+	"github.com/UniversityRadioYork/CaptainHook/internal/format"
+	"github.com/UniversityRadioYork/CaptainHook/internal/github"
+	"github.com/UniversityRadioYork/CaptainHook/internal/metrics"
+)
+
+// How often to log each unknown event type and action, so one that GitHub
+// suddenly sends a lot of doesn't drown everything else out.
+const unknownLogInterval = time.Hour
+
+// Keeps track of the GitHub events we get but don't handle, so we find out
+// about them: see Config.LogUnknownEvents and Config.DebugDumpDir, and
+// /coverage and !coverage for how many of each we've had since startup.
+type UnknownEvents struct {
+	mu     sync.Mutex
+	logged map[string]time.Time // When each event.action was last logged
+	dumped map[string]bool
+	seen   map[string]*UnknownEvent // By event.action
+	Now    func() time.Time
+}
+
+// One event type and action we don't handle, and how often it's turned up.
+type UnknownEvent struct {
+	Event    string    `json:"event"`
+	Action   string    `json:"action,omitempty"`
+	Count    int       `json:"count"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+func NewUnknownEvents() *UnknownEvents {
+	return &UnknownEvents{logged: make(map[string]time.Time), dumped: make(map[string]bool), seen: make(map[string]*UnknownEvent), Now: time.Now}
+}
+
+// The action, and whether the type or action isn't one we handle, going by
+// github.Events. e is what ParseGitHubEvent made of it, if anything.
+func UnknownGitHubEvent(ev string, e *format.Event, payload []byte) (action string, unknown bool) {
+	_, action = config.PayloadSender(payload)
+	h, ok := github.Events[ev]
+	if !ok || e == nil {
+		return action, true
+	}
+	return action, action != "" && !config.Contains(h.Actions, action)
+}
+
+// Note an unknown event, from delivery id: count it, log it if it's been a
+// while, and save the payload if it's the first we've had like it.
+func (u *UnknownEvents) Seen(conf *config.Config, event, action, id string, payload []byte, logger *slog.Logger) {
+	metrics.UnknownEventsTotal.WithLabelValues(event, action).Inc()
+	if u == nil {
+		return
+	}
+	key := event + "." + action
+	now := u.Now()
+	u.mu.Lock()
+	seen := u.seen[key]
+	if seen == nil {
+		seen = &UnknownEvent{Event: event, Action: action}
+		u.seen[key] = seen
+	}
+	seen.Count++
+	seen.LastSeen = now
+	log := conf.LogUnknownEvents && now.Sub(u.logged[key]) >= unknownLogInterval
+	if log {
+		u.logged[key] = now
+	}
+	dump := conf.DebugDumpDir != "" && !u.dumped[key]
+	if dump {
+		u.dumped[key] = true
+	}
+	u.mu.Unlock()
+	if log {
+		logger.Info("Unknown event", "event", event, "action", action, "delivery", id, "repo", config.PayloadRepo(payload))
+	}
+	if dump {
+		if path, err := dumpPayload(conf.DebugDumpDir, key, payload); err != nil {
+			logger.Error("Error saving unknown event payload", "event", event, "action", action, "err", err)
+		} else if path != "" {
+			logger.Info("Saved unknown event payload", "event", event, "action", action, "path", path)
+		}
+	}
+}
+
+var unsafeFilename = regexp.MustCompile(`[^A-Za-z0-9_.-]+`)
+
+// Write a payload to dir as <event>.<action>.json, unless there's one
+// already from an earlier run. Returns where it went, or "" if it didn't.
+func dumpPayload(dir, key string, payload []byte) (string, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, unsafeFilename.ReplaceAllString(key, "_")+".json")
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if os.IsExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	_, err = f.Write(payload)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return path, err
+}
+
+// The unknown events we've had since startup, most often first.
+func (u *UnknownEvents) List() []UnknownEvent {
+	if u == nil {
+		return nil
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	var list []UnknownEvent
+	for _, e := range u.seen {
+		list = append(list, *e)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Count != list[j].Count {
+			return list[i].Count > list[j].Count
+		}
+		return list[i].Event+"."+list[i].Action < list[j].Event+"."+list[j].Action
+	})
+	return list
+}
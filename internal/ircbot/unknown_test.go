@@ -0,0 +1,65 @@
+package ircbot
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/config"
+	"github.com/UniversityRadioYork/CaptainHook/internal/github"
+)
+
+func TestUnknownGitHubEvent(t *testing.T) {
+	for _, c := range []struct {
+		ev, payload string
+		unknown     bool
+	}{
+		{"issues", `{"action":"opened"}`, false},
+		{"issues", `{"action":"typed"}`, true},
+		{"pull_request", `{"action":"closed"}`, false},
+		{"discussion", `{"action":"created"}`, true},
+		{"push", `{}`, true},
+	} {
+		e, _ := github.ParseGitHubEvent(c.ev, []byte(c.payload))
+		if _, unknown := UnknownGitHubEvent(c.ev, e, []byte(c.payload)); unknown != c.unknown {
+			t.Errorf("%s %s: expected unknown %v", c.ev, c.payload, c.unknown)
+		}
+	}
+}
+
+func TestUnknownEventsSeen(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "unknown")
+	conf := &config.Config{LogUnknownEvents: true, DebugDumpDir: dir}
+	u := NewUnknownEvents()
+	now := time.Now()
+	u.Now = func() time.Time { return now }
+	var buf bytes.Buffer
+	logger := config.NewLogger(&buf, "text")
+	payload := func(id string) []byte {
+		return []byte(`{"action":"created","n":"` + id + `"}`)
+	}
+
+	u.Seen(conf, "discussion", "created", "1", payload("1"), logger)
+	u.Seen(conf, "discussion", "created", "2", payload("2"), logger)
+	if n := strings.Count(buf.String(), `msg="Unknown event"`); n != 1 {
+		t.Errorf("expected one line within the hour, got %d:\n%s", n, buf.String())
+	}
+	now = now.Add(unknownLogInterval)
+	u.Seen(conf, "discussion", "created", "3", payload("3"), logger)
+	if n := strings.Count(buf.String(), `msg="Unknown event"`); n != 2 {
+		t.Errorf("expected another line after an hour, got %d", n)
+	}
+	got, err := ioutil.ReadFile(filepath.Join(dir, "discussion.created.json"))
+	if err != nil || !strings.Contains(string(got), `"n":"1"`) {
+		t.Errorf("expected the first payload saved, got %q %v", got, err)
+	}
+
+	// Nor after a restart.
+	NewUnknownEvents().Seen(conf, "discussion", "created", "4", payload("4"), config.DiscardLogger)
+	if got, _ := ioutil.ReadFile(filepath.Join(dir, "discussion.created.json")); !strings.Contains(string(got), `"n":"1"`) {
+		t.Errorf("expected the first payload kept, got %q", got)
+	}
+}
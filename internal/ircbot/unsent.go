@@ -0,0 +1,121 @@
+package ircbot
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/format"
+	"github.com/UniversityRadioYork/CaptainHook/internal/outputs"
+)
+
+// Bump this whenever unsentMessage changes in a way older files can't be
+// read as, so they're skipped rather than misread.
+const unsentVersion = 1
+
+// What's left unsent at shutdown, kept next to the StateFile until the next
+// start.
+type unsentFile struct {
+	Version  int             `json:"version"`
+	Saved    time.Time       `json:"saved"`
+	Messages []unsentMessage `json:"messages"`
+}
+
+// An Announcement as saved, spelled out so it doesn't change under us when
+// Announcement does.
+type unsentMessage struct {
+	Time     time.Time `json:"time"`
+	Channels []string  `json:"channels"`
+	Text     string    `json:"text"`
+	Event    string    `json:"event"`
+	Repo     string    `json:"repo,omitempty"`
+	FullName string    `json:"full_name,omitempty"`
+	Title    string    `json:"title,omitempty"`
+	URL      string    `json:"url,omitempty"`
+	Color    string    `json:"color,omitempty"`
+	Priority bool      `json:"priority,omitempty"`
+	ID       string    `json:"id,omitempty"`
+	Private  bool      `json:"private,omitempty"`
+	Action   string    `json:"action,omitempty"`
+	Sender   string    `json:"sender,omitempty"`
+	Number   int       `json:"number,omitempty"`
+	Age      string    `json:"age,omitempty"`
+	Prefix   string    `json:"prefix,omitempty"`
+}
+
+func UnsentPath(stateFile string) string {
+	return stateFile + ".unsent"
+}
+
+// Save announcements that didn't get out, for LoadUnsent to pick up on the
+// next start. Nothing to save means no file.
+func SaveUnsent(path string, msgs []outputs.Announcement) error {
+	if len(msgs) == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	f := unsentFile{Version: unsentVersion, Saved: time.Now().UTC()}
+	for _, a := range msgs {
+		f.Messages = append(f.Messages, unsentMessage{
+			Time: a.Time, Channels: a.Channels, Text: a.Text, Event: a.Event, Repo: a.Repo,
+			FullName: a.FullName, Title: a.Title, URL: a.URL, Color: string(a.Color), Priority: a.Priority,
+			ID: a.ID, Private: a.Private, Action: a.Action, Sender: a.Sender, Number: a.Number, Age: a.Age,
+			Prefix: a.Prefix,
+		})
+	}
+	data, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Load what SaveUnsent left, dropping anything older than maxAge and marking
+// the rest as late. The file's removed once read, so nothing goes out twice.
+// A file we can't make sense of, like one from an older version, is skipped.
+func LoadUnsent(path string, maxAge time.Duration, logger *slog.Logger) []outputs.Announcement {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logger.Error("Error reading unsent messages", "path", path, "err", err)
+		}
+		return nil
+	}
+	os.Remove(path)
+	var f unsentFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		logger.Warn("Skipping unsent messages we can't read", "path", path, "err", err)
+		return nil
+	}
+	if f.Version != unsentVersion {
+		logger.Warn("Skipping unsent messages from another version", "path", path, "version", f.Version)
+		return nil
+	}
+	cutoff := time.Now().Add(-maxAge)
+	var msgs []outputs.Announcement
+	for _, m := range f.Messages {
+		made := m.Time
+		if made.IsZero() {
+			made = f.Saved
+		}
+		if made.Before(cutoff) {
+			logger.Info("Dropping unsent message, it's too old", "event", m.Event, "delivery", m.ID, "repo", m.FullName)
+			continue
+		}
+		msgs = append(msgs, outputs.Announcement{
+			Time: made, Channels: m.Channels, Text: m.Text + " (delayed)", Event: m.Event, Repo: m.Repo,
+			FullName: m.FullName, Title: m.Title, URL: m.URL, Color: format.MIRCColor(m.Color), Priority: m.Priority,
+			ID: m.ID, Private: m.Private, Action: m.Action, Sender: m.Sender, Number: m.Number, Age: m.Age,
+			Prefix: m.Prefix,
+		})
+	}
+	return msgs
+}
@@ -0,0 +1,59 @@
+package ircbot
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/config"
+	"github.com/UniversityRadioYork/CaptainHook/internal/outputs"
+)
+
+func TestUnsentRoundTrip(t *testing.T) {
+	path := UnsentPath(filepath.Join(t.TempDir(), "state.jsonl"))
+	fresh := outputs.Announcement{Channels: []string{"#a"}, Text: "new", Event: "push", FullName: "ury/website", Color: "04", ID: "1", Number: 7, Time: time.Now()}
+	stale := outputs.Announcement{Channels: []string{"#b"}, Text: "old", Event: "issues", ID: "2", Time: time.Now().Add(-2 * time.Hour)}
+	if err := SaveUnsent(path, []outputs.Announcement{fresh, stale}); err != nil {
+		t.Fatal(err)
+	}
+	got := LoadUnsent(path, time.Hour, config.DiscardLogger)
+	if len(got) != 1 {
+		t.Fatalf("expected just the fresh one, got %+v", got)
+	}
+	a := got[0]
+	if a.Text != "new (delayed)" || a.Channels[0] != "#a" || a.FullName != "ury/website" || a.Color != "04" || a.Number != 7 || !a.Time.Equal(fresh.Time) {
+		t.Errorf("unexpected %+v", a)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("expected the file to go once loaded")
+	}
+	if got := LoadUnsent(path, time.Hour, config.DiscardLogger); got != nil {
+		t.Errorf("expected nothing the second time, got %+v", got)
+	}
+
+	// Nothing to save, and nothing left from last time.
+	ioutil.WriteFile(path, []byte("{}"), 0600)
+	if err := SaveUnsent(path, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("expected no file with nothing to save")
+	}
+}
+
+// Files from other versions, or that are just broken, are skipped.
+func TestUnsentUnreadable(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.jsonl.unsent")
+	for _, data := range []string{
+		`{"version":99,"messages":[{"text":"from the future","channels":["#a"]}]}`,
+		`{"version":1,"messages":[{"text":`,
+		`[]`,
+	} {
+		ioutil.WriteFile(path, []byte(data), 0600)
+		if got := LoadUnsent(path, time.Hour, config.DiscardLogger); got != nil {
+			t.Errorf("%s: expected nothing, got %+v", data, got)
+		}
+	}
+}
@@ -0,0 +1,24 @@
+package ircbot
+
+import (
+	"testing"
+
+	"github.com/sorcix/irc"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/config"
+	"github.com/UniversityRadioYork/CaptainHook/internal/testutil"
+	"github.com/UniversityRadioYork/CaptainHook/internal/version"
+)
+
+func TestCTCPVersion(t *testing.T) {
+	s := &testutil.RecordingSender{}
+	new(Bot).HandlePrivMsg(s, &irc.Message{
+		Prefix:   &irc.Prefix{Name: "someone"},
+		Command:  irc.PRIVMSG,
+		Params:   []string{"CaptainHook"},
+		Trailing: "\x01VERSION\x01",
+	}, NewQueue(1, config.DropNewest, 0, config.DiscardLogger), &recordingOutput{}, NewIRCState(), config.DiscardLogger)
+	if len(s.Sent) != 1 || s.Sent[0] != "NOTICE someone :\x01VERSION CaptainHook "+version.Current().String()+"\x01" {
+		t.Errorf("unexpected reply %q", s.Sent)
+	}
+}
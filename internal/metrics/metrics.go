@@ -0,0 +1,193 @@
+// Package metrics has the Prometheus metrics everything else keeps.
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Everything we export lives in here rather than the global default registry,
+// so tests can look at it without tripping over each other.
+var Registry = prometheus.NewRegistry()
+
+var (
+	// Outcomes of webhook requests as seen by the HTTP handler. The event
+	// label is only trusted once the signature checks out.
+	DeliveriesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "capthook_webhook_deliveries_total",
+		Help: "Webhook deliveries received, by event type and outcome.",
+	}, []string{"event", "outcome"})
+
+	// What the workers made of the deliveries the handler accepted.
+	ProcessedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "capthook_webhook_processed_total",
+		Help: "Accepted deliveries processed by the workers, by event type and outcome.",
+	}, []string{"event", "outcome"})
+
+	// The same again in more detail, for /stats and !stats as well as
+	// Prometheus. The action is the payload's, if it has one.
+	EventsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "capthook_events_total",
+		Help: "Accepted deliveries processed by the workers, by event type, action, repo and outcome.",
+	}, []string{"event", "action", "repo", "outcome"})
+
+	// GitHub events whose type or action we don't handle, see ircbot/unknown.go.
+	UnknownEventsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "capthook_unknown_events_total",
+		Help: "Verified GitHub deliveries of an event type or action we don't handle, by event type and action.",
+	}, []string{"event", "action"})
+
+	// Build results and alert states with no colour, see format.ConclusionColor.
+	UnknownConclusions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "capthook_unknown_conclusions_total",
+		Help: "Build results, conclusions and alert states we have no colour for, by state.",
+	}, []string{"state"})
+
+	// Which secret verified deliveries, so we can tell when an old one is
+	// no longer in use and can be retired.
+	SecretMatches = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "capthook_webhook_secret_matches_total",
+		Help: "Verified deliveries, by hook and index of the secret that matched.",
+	}, []string{"hook", "index"})
+
+	IRCMessagesSent = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "capthook_irc_messages_sent_total",
+		Help: "Announcements sent to IRC, by channel.",
+	}, []string{"channel"})
+
+	IRCReconnects = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "capthook_irc_reconnects_total",
+		Help: "Times we've had to reconnect to IRC.",
+	})
+
+	ShortenerDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "capthook_shortener_duration_seconds",
+		Help:    "Time spent shortening URLs.",
+		Buckets: []float64{.05, .1, .25, .5, 1, 2.5, 5, 10},
+	})
+
+	ShortenerFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "capthook_shortener_failures_total",
+		Help: "URL shortening attempts that failed.",
+	})
+
+	ShortenerRetries = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "capthook_shortener_retries_total",
+		Help: "Requests to the URL shortener tried again after a temporary failure.",
+	})
+
+	ShortenerTrips = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "capthook_shortener_circuit_trips_total",
+		Help: "Times the URL shortener failed enough in a row that we stopped asking it for a while.",
+	})
+
+	ShortenerSkipped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "capthook_shortener_skipped_total",
+		Help: "URLs deliberately left unshortened, by reason: private (repo) or host.",
+	}, []string{"reason"})
+
+	ShortenerCache = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "capthook_shortener_cache_total",
+		Help: "Shortened URL cache lookups, by result: hit, miss or failed (a recent failure, passed through).",
+	}, []string{"result"})
+
+	OutputMessages = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "capthook_output_messages_total",
+		Help: "Announcements to outputs that queue them, like Slack, by target and outcome: sent, failed or dropped.",
+	}, []string{"target", "outcome"})
+
+	OutputRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "capthook_output_retries_total",
+		Help: "Attempts to send an announcement again after failing, by target.",
+	}, []string{"target"})
+
+	DuplicatesSuppressed = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "capthook_duplicates_suppressed_total",
+		Help: "Announcements not sent because the same one went to the same place within DuplicateWindow.",
+	})
+
+	RateLimited = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "capthook_rate_limited_total",
+		Help: "Webhook requests turned away for coming too often from one address.",
+	})
+
+	ForwardsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "capthook_forwards_total",
+		Help: "Deliveries forwarded downstream, by target and outcome.",
+	}, []string{"target", "outcome"})
+
+	PanicsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "capthook_panics_total",
+		Help: "Panics recovered from, by where they happened.",
+	}, []string{"where"})
+
+	GitHubRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "capthook_github_requests_total",
+		Help: "Requests to GitHub's API, by outcome; backing_off ones were never sent.",
+	}, []string{"outcome"})
+
+	GitHubRateRemaining = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "capthook_github_rate_limit_remaining",
+		Help: "Requests GitHub's API said we had left, as of its last answer.",
+	})
+)
+
+// Delivery outcomes, for the outcome label.
+const (
+	OutcomeAccepted      = "accepted"
+	OutcomeBadSignature  = "bad_signature"
+	OutcomeBadRequest    = "bad_request"
+	OutcomeIgnored       = "ignored"
+	OutcomeIgnoredAction = "ignored_action"
+	OutcomeFiltered      = "filtered"
+	OutcomeIgnoredSender = "ignored_sender"
+	OutcomeParseError    = "parse_error"
+	OutcomeQueueFull     = "queue_full"
+	OutcomeDuplicate     = "duplicate"
+	OutcomeAnnounced     = "announced"
+)
+
+// Used as the event label until a delivery's signature has been checked, so
+// randoms can't mint new label values.
+const UnverifiedEvent = "unverified"
+
+func init() {
+	Registry.MustRegister(
+		DeliveriesTotal,
+		ProcessedTotal,
+		EventsTotal,
+		UnknownEventsTotal,
+		UnknownConclusions,
+		SecretMatches,
+		IRCMessagesSent,
+		IRCReconnects,
+		ShortenerDuration,
+		ShortenerFailures,
+		ShortenerRetries,
+		ShortenerTrips,
+		ShortenerSkipped,
+		ShortenerCache,
+		ForwardsTotal,
+		OutputMessages,
+		OutputRetries,
+		RateLimited,
+		DuplicatesSuppressed,
+		PanicsTotal,
+		GitHubRequests,
+		GitHubRateRemaining,
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "capthook_uptime_seconds",
+			Help: "How long we've been running.",
+		}, func() float64 { return time.Since(StartTime).Seconds() }),
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+func MetricsHandler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
@@ -0,0 +1,191 @@
+package metrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+var StartTime = time.Now()
+
+// What the bot's been up to, for people without a Prometheus to ask. It's
+// all read back out of Registry, so it always agrees with /metrics.
+type Stats struct {
+	Uptime     float64            `json:"uptime_seconds"`
+	QueueDepth int                `json:"queue_depth"`
+	Total      int                `json:"total"`      // Deliveries processed
+	Announced  int                `json:"announced"`  // Of those, how many we said something about
+	ByEvent    map[string]int     `json:"by_event"`   // By event type
+	ByAction   map[string]int     `json:"by_action"`  // By event.action
+	ByRepo     map[string]int     `json:"by_repo"`    // By owner/name, "" for anything without one
+	ByOutcome  map[string]int     `json:"by_outcome"` // announced, ignored_action or parse_error
+	Outputs    map[string]float64 `json:"outputs,omitempty"`
+}
+
+// Add up what's in the registry.
+func GatherStats() (Stats, error) {
+	s := Stats{
+		Uptime:    time.Since(StartTime).Seconds(),
+		ByEvent:   make(map[string]int),
+		ByAction:  make(map[string]int),
+		ByRepo:    make(map[string]int),
+		ByOutcome: make(map[string]int),
+	}
+	families, err := Registry.Gather()
+	if err != nil {
+		return s, err
+	}
+	for _, f := range families {
+		switch f.GetName() {
+		case "capthook_events_total":
+			for _, m := range f.GetMetric() {
+				l := labelMap(m)
+				n := int(m.GetCounter().GetValue())
+				s.Total += n
+				s.ByEvent[l["event"]] += n
+				key := l["event"]
+				if l["action"] != "" {
+					key += "." + l["action"]
+				}
+				s.ByAction[key] += n
+				s.ByRepo[l["repo"]] += n
+				s.ByOutcome[l["outcome"]] += n
+			}
+		case "capthook_queue_depth":
+			for _, m := range f.GetMetric() {
+				s.QueueDepth = int(m.GetGauge().GetValue())
+			}
+		case "capthook_output_messages_total":
+			for _, m := range f.GetMetric() {
+				if s.Outputs == nil {
+					s.Outputs = make(map[string]float64)
+				}
+				l := labelMap(m)
+				s.Outputs[l["target"]+" "+l["outcome"]] += m.GetCounter().GetValue()
+			}
+		}
+	}
+	s.Announced = s.ByOutcome[OutcomeAnnounced]
+	return s, nil
+}
+
+func labelMap(m *dto.Metric) map[string]string {
+	l := make(map[string]string)
+	for _, p := range m.GetLabel() {
+		l[p.GetName()] = p.GetValue()
+	}
+	return l
+}
+
+// The n busiest repos, busiest first.
+func (s Stats) TopRepos(n int) []string {
+	var repos []string
+	for repo := range s.ByRepo {
+		if repo != "" {
+			repos = append(repos, repo)
+		}
+	}
+	sort.Slice(repos, func(i, j int) bool {
+		if s.ByRepo[repos[i]] != s.ByRepo[repos[j]] {
+			return s.ByRepo[repos[i]] > s.ByRepo[repos[j]]
+		}
+		return repos[i] < repos[j]
+	})
+	if len(repos) > n {
+		repos = repos[:n]
+	}
+	return repos
+}
+
+// For !stats.
+func (s Stats) String() string {
+	out := fmt.Sprintf("%d deliveries, %d announced, up %s", s.Total, s.Announced, (time.Duration(s.Uptime) * time.Second).Round(time.Minute))
+	if top := s.TopRepos(5); len(top) > 0 {
+		var parts []string
+		for _, repo := range top {
+			parts = append(parts, fmt.Sprintf("%s %d", repo, s.ByRepo[repo]))
+		}
+		out += "; busiest: " + strings.Join(parts, ", ")
+	}
+	return out
+}
+
+// Bump this if statsCount changes.
+const statsVersion = 1
+
+// The counts saved with PersistStats, kept next to the StateFile.
+type statsFile struct {
+	Version int          `json:"version"`
+	Saved   time.Time    `json:"saved"`
+	Counts  []statsCount `json:"counts"`
+}
+
+type statsCount struct {
+	Event   string  `json:"event"`
+	Action  string  `json:"action,omitempty"`
+	Repo    string  `json:"repo,omitempty"`
+	Outcome string  `json:"outcome"`
+	Value   float64 `json:"value"`
+}
+
+func StatsPath(stateFile string) string {
+	return stateFile + ".stats"
+}
+
+// Save the event counts, for LoadStats after a restart.
+func SaveStats(path string) error {
+	families, err := Registry.Gather()
+	if err != nil {
+		return err
+	}
+	f := statsFile{Version: statsVersion, Saved: time.Now().UTC()}
+	for _, fam := range families {
+		if fam.GetName() != "capthook_events_total" {
+			continue
+		}
+		for _, m := range fam.GetMetric() {
+			l := labelMap(m)
+			f.Counts = append(f.Counts, statsCount{l["event"], l["action"], l["repo"], l["outcome"], m.GetCounter().GetValue()})
+		}
+	}
+	data, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Pick up the counts from before a restart. Call before anything's counted.
+// A file from another version is skipped.
+func LoadStats(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	var f statsFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return err
+	}
+	if f.Version != statsVersion {
+		return fmt.Errorf("%s is from another version (%d), skipping it", path, f.Version)
+	}
+	for _, c := range f.Counts {
+		if c.Value > 0 {
+			EventsTotal.WithLabelValues(c.Event, c.Action, c.Repo, c.Outcome).Add(c.Value)
+		}
+	}
+	return nil
+}
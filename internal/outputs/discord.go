@@ -0,0 +1,138 @@
+package outputs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/config"
+	"github.com/UniversityRadioYork/CaptainHook/internal/format"
+)
+
+// Posts announcements to Discord webhooks as embeds, keeping to Discord's
+// limits. Broadcaster queues them, at most one every DiscordInterval.
+type DiscordOutput struct {
+	client  *http.Client
+	targets map[string]config.DiscordTarget // By lower case name
+	mu      sync.Mutex
+	ready   map[string]time.Time // When each webhook's rate limit resets, once it's used up
+}
+
+// Webhooks get about 30 messages a minute.
+const DiscordInterval = 2 * time.Second
+
+func NewDiscordOutput(targets []config.DiscordTarget) *DiscordOutput {
+	o := &DiscordOutput{
+		client:  &http.Client{Timeout: 10 * time.Second},
+		targets: make(map[string]config.DiscordTarget),
+		ready:   make(map[string]time.Time),
+	}
+	for _, t := range targets {
+		o.targets[strings.ToLower(t.Name)] = t
+	}
+	return o
+}
+
+// Post msg to the webhook in its target (discord:name), once, waiting first
+// if its rate limit was used up last time.
+func (o *DiscordOutput) Announce(ctx context.Context, msg Message) error {
+	name := strings.ToLower(strings.TrimPrefix(msg.Target, config.DiscordPrefix))
+	t, ok := o.targets[name]
+	if !ok {
+		return fmt.Errorf("no Discord webhook called %q", strings.TrimPrefix(msg.Target, config.DiscordPrefix))
+	}
+	o.mu.Lock()
+	wait := time.Until(o.ready[name])
+	o.mu.Unlock()
+	if wait > 0 {
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	body, _ := json.Marshal(discordMessage(msg))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentTypeJSON)
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return retryLater(0, err)
+	}
+	reply, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.Header.Get("X-RateLimit-Remaining") == "0" {
+		o.mu.Lock()
+		o.ready[name] = time.Now().Add(discordSeconds(resp.Header.Get("X-RateLimit-Reset-After")))
+		o.mu.Unlock()
+	}
+	switch {
+	case resp.StatusCode/100 == 2:
+		return nil
+	case resp.StatusCode == http.StatusTooManyRequests:
+		var limit struct {
+			RetryAfter float64 `json:"retry_after"`
+		}
+		json.Unmarshal(reply, &limit)
+		wait := time.Duration(limit.RetryAfter * float64(time.Second))
+		if w := discordSeconds(resp.Header.Get("Retry-After")); w > wait {
+			wait = w
+		}
+		return retryLater(wait, fmt.Errorf("rate limited"))
+	case resp.StatusCode >= 500:
+		return retryLater(0, fmt.Errorf("got %s", resp.Status))
+	}
+	// A deleted webhook or a payload it didn't like won't get better.
+	return fmt.Errorf("got %s: %s", resp.Status, format.Truncate(string(reply), 100))
+}
+
+// Discord gives times in seconds, sometimes with a fraction.
+func discordSeconds(s string) time.Duration {
+	secs, _ := strconv.ParseFloat(s, 64)
+	return time.Duration(secs * float64(time.Second))
+}
+
+// Turn an announcement into a webhook message with one embed: the title
+// linking to the URL, the announcement as the description, the repo as the
+// author and the action's colour down the side. Mentions are turned off, so
+// an @everyone in an issue title stays as text.
+func discordMessage(msg Message) map[string]interface{} {
+	embed := map[string]interface{}{
+		"description": format.Truncate(msg.Plain, 4096),
+	}
+	if msg.Title != "" {
+		embed["title"] = format.Truncate(format.StripFormatting(msg.Title), 256)
+	}
+	if msg.URL != "" {
+		embed["url"] = msg.URL
+	}
+	if msg.Repo != "" {
+		embed["author"] = map[string]string{"name": format.Truncate(format.StripFormatting(msg.Repo), 256)}
+	}
+	if rgb, ok := discordColor(msg.Color); ok {
+		embed["color"] = rgb
+	}
+	return map[string]interface{}{
+		"embeds":           []interface{}{embed},
+		"allowed_mentions": map[string][]string{"parse": {}},
+	}
+}
+
+// A mIRC colour as the RGB number Discord wants.
+func discordColor(c format.MIRCColor) (int, bool) {
+	hex, ok := mircHex[string(c)]
+	if !ok {
+		return 0, false
+	}
+	rgb, err := strconv.ParseInt(strings.TrimPrefix(hex, "#"), 16, 32)
+	return int(rgb), err == nil
+}
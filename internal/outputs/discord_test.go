@@ -0,0 +1,95 @@
+package outputs
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/config"
+	"github.com/UniversityRadioYork/CaptainHook/internal/format"
+)
+
+func TestDiscordMessage(t *testing.T) {
+	b, _ := json.Marshal(discordMessage(testMessage("discord:dev", Announcement{
+		Text:  "[" + format.IrcColorize("website", format.ColorPurple) + "] bob " + format.IrcColorize("merged", format.ColorBlue) + " #1: @everyone look",
+		Repo:  "website",
+		Title: "@everyone look",
+		URL:   "https://github.com/ury/website/pull/1",
+		Color: format.ColorBlue,
+	})))
+	var msg struct {
+		Embeds []struct {
+			Title, Description, URL string
+			Color                   int
+			Author                  struct{ Name string }
+		}
+		AllowedMentions struct{ Parse []string } `json:"allowed_mentions"`
+	}
+	json.Unmarshal(b, &msg)
+	if strings.ContainsAny(string(b), "\x02\x03\x0F") {
+		t.Errorf("IRC formatting leaked through: %s", b)
+	}
+	if len(msg.Embeds) != 1 || msg.AllowedMentions.Parse == nil || len(msg.AllowedMentions.Parse) != 0 {
+		t.Fatalf("expected one embed with mentions off, got %s", b)
+	}
+	e := msg.Embeds[0]
+	if e.Title != "@everyone look" || e.URL != "https://github.com/ury/website/pull/1" || e.Author.Name != "website" || e.Description != "[website] bob merged #1: @everyone look" {
+		t.Errorf("unexpected embed %+v", e)
+	}
+	if e.Color != 0x00007f {
+		t.Errorf("expected blue, got %06x", e.Color)
+	}
+	if b, _ := json.Marshal(discordMessage(testMessage("discord:dev", Announcement{Text: "plain"}))); strings.Contains(string(b), "color") {
+		t.Errorf("expected no colour without one, got %s", b)
+	}
+}
+
+func TestDiscordOutput(t *testing.T) {
+	var hits int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch atomic.AddInt32(&hits, 1) {
+		case 1:
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte(`{"message":"You are being rate limited.","retry_after":1.5,"global":false}`))
+		case 2:
+			w.Header().Set("X-RateLimit-Remaining", "0")
+			w.Header().Set("X-RateLimit-Reset-After", "3.25")
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	o := NewDiscordOutput([]config.DiscordTarget{{Name: "dev", URL: srv.URL}})
+	q := newOutputQueue("discord:dev", o, config.DiscardLogger)
+	var waits []time.Duration
+	q.Backoff, q.Interval, q.sleep = time.Millisecond, time.Second, func(d time.Duration) { waits = append(waits, d) }
+	msg := testMessage("discord:dev", Announcement{Text: "hi"})
+	q.deliver(context.Background(), msg)
+	if len(waits) != 1 || waits[0] != 1500*time.Millisecond {
+		t.Errorf("expected to wait as asked after a 429, got %v", waits)
+	}
+	if d := time.Until(o.ready["dev"]); d < 3*time.Second || d > 3250*time.Millisecond {
+		t.Errorf("expected to hold off for the reset, got %v", d)
+	}
+
+	// Waiting for the reset gives up with the context.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := o.Announce(ctx, msg); err != context.Canceled || atomic.LoadInt32(&hits) != 2 {
+		t.Errorf("expected to give up waiting, got %v", err)
+	}
+
+	// A deleted webhook gets one try.
+	o.ready["dev"] = time.Time{}
+	q.deliver(context.Background(), msg)
+	if n := atomic.LoadInt32(&hits); n != 3 {
+		t.Errorf("expected one more attempt, got %d", n-2)
+	}
+}
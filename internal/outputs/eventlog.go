@@ -0,0 +1,149 @@
+package outputs
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/format"
+)
+
+// One line of the event log.
+type EventLogEntry struct {
+	Time     time.Time `json:"time"`
+	Event    string    `json:"event"`
+	Action   string    `json:"action,omitempty"`
+	Repo     string    `json:"repo,omitempty"`
+	Sender   string    `json:"sender,omitempty"`
+	Number   int       `json:"number,omitempty"`
+	Title    string    `json:"title,omitempty"`
+	Channels []string  `json:"channels"` // Where it went
+}
+
+// Appends a JSON line for everything announced to a file, for statistics.
+// Lines are written one at a time by a single goroutine, and the file is
+// rotated to .1, .2 and so on once it gets past maxSize.
+type EventLog struct {
+	path    string
+	maxSize int64
+	keep    int // Rotated files to keep
+	logger  *slog.Logger
+
+	lines chan []byte
+	done  chan struct{}
+	file  *os.File
+	size  int64
+}
+
+func NewEventLog(path string, maxSize int64, keep int, logger *slog.Logger) (*EventLog, error) {
+	l := &EventLog{
+		path:    path,
+		maxSize: maxSize,
+		keep:    keep,
+		logger:  logger,
+		lines:   make(chan []byte, 256),
+		done:    make(chan struct{}),
+	}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	go l.run()
+	return l, nil
+}
+
+// Queue a line for an announcement that went to channels, never blocking.
+// Deliveries without an ID, like test messages, aren't events. Safe to call
+// on a nil EventLog.
+func (l *EventLog) Record(a Announcement, channels []string) {
+	if l == nil || a.ID == "" || len(channels) == 0 {
+		return
+	}
+	line, err := json.Marshal(EventLogEntry{
+		Time:     time.Now().UTC(),
+		Event:    a.Event,
+		Action:   a.Action,
+		Repo:     format.StripFormatting(a.Repo),
+		Sender:   a.Sender,
+		Number:   a.Number,
+		Title:    format.StripFormatting(a.Title),
+		Channels: channels,
+	})
+	if err != nil {
+		l.logger.Error("Error encoding event log entry", "delivery", a.ID, "err", err)
+		return
+	}
+	select {
+	case l.lines <- append(line, '\n'):
+	default:
+		l.logger.Warn("Event log writer backed up, not logging", "event", a.Event, "delivery", a.ID)
+	}
+}
+
+func (l *EventLog) run() {
+	defer close(l.done)
+	for line := range l.lines {
+		if l.file == nil || l.size > 0 && l.size+int64(len(line)) > l.maxSize {
+			if err := l.rotate(); err != nil {
+				l.logger.Error("Error rotating event log", "err", err)
+				if l.file == nil {
+					continue
+				}
+			}
+		}
+		n, err := l.file.Write(line)
+		l.size += int64(n)
+		if err != nil {
+			l.logger.Error("Error writing event log", "err", err)
+		}
+	}
+	if l.file != nil {
+		l.file.Close()
+	}
+}
+
+func (l *EventLog) open() error {
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.file, l.size = f, info.Size()
+	return nil
+}
+
+// Shuffle the old files along, dropping the oldest, and start afresh.
+func (l *EventLog) rotate() error {
+	if l.file != nil {
+		l.file.Close()
+		l.file = nil
+	}
+	var err error
+	if l.keep > 0 {
+		os.Remove(fmt.Sprintf("%s.%d", l.path, l.keep))
+		for i := l.keep - 1; i > 0; i-- {
+			os.Rename(fmt.Sprintf("%s.%d", l.path, i), fmt.Sprintf("%s.%d", l.path, i+1))
+		}
+		err = os.Rename(l.path, l.path+".1")
+	} else {
+		err = os.Remove(l.path)
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return l.open()
+}
+
+// Write out anything queued and close the file.
+func (l *EventLog) Close() {
+	if l == nil {
+		return
+	}
+	close(l.lines)
+	<-l.done
+}
@@ -0,0 +1,89 @@
+package outputs
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/config"
+)
+
+// Every line in path, which must all be whole entries.
+func readEventLog(t *testing.T, path string) []EventLogEntry {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var entries []EventLogEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e EventLogEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			t.Fatalf("bad line %q: %v", scanner.Text(), err)
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+func TestEventLog(t *testing.T) {
+	var nilLog *EventLog
+	nilLog.Record(Announcement{ID: "1"}, []string{"#a"}) // Mustn't panic
+	nilLog.Close()
+
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	l, err := NewEventLog(path, 1<<20, 2, config.DiscardLogger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Record(Announcement{Event: "test"}, []string{"#a"}) // No ID, so not an event
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			l.Record(Announcement{
+				ID: strconv.Itoa(i), Event: "pull_request", Action: "merged", Repo: "\x0306website\x0F",
+				Sender: "LordAlex", Number: i, Title: "Fix it",
+			}, []string{"#a", "slack:ops"})
+		}(i)
+	}
+	wg.Wait()
+	l.Close()
+	entries := readEventLog(t, path)
+	if len(entries) != 10 {
+		t.Fatalf("expected 10 entries, got %d", len(entries))
+	}
+	e := entries[0]
+	if e.Event != "pull_request" || e.Action != "merged" || e.Repo != "website" || e.Sender != "LordAlex" ||
+		len(e.Channels) != 2 || e.Channels[1] != "slack:ops" || e.Time.IsZero() {
+		t.Errorf("unexpected entry %+v", e)
+	}
+}
+
+func TestEventLogRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	l, err := NewEventLog(path, 1, 2, config.DiscardLogger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 4; i++ {
+		l.Record(Announcement{ID: strconv.Itoa(i), Event: "push", Number: i}, []string{"#a"})
+	}
+	l.Close()
+	// Each line is over the limit by itself, so gets a file to itself.
+	for suffix, want := range map[string]int{"": 4, ".1": 3, ".2": 2} {
+		entries := readEventLog(t, path+suffix)
+		if len(entries) != 1 || entries[0].Number != want {
+			t.Errorf("expected events%s to have event %d, got %+v", suffix, want, entries)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("expected only 2 old files kept, got %v", err)
+	}
+}
@@ -0,0 +1,218 @@
+package outputs
+
+import (
+	"bufio"
+	"encoding/json"
+	"encoding/xml"
+	"log/slog"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/config"
+	"github.com/UniversityRadioYork/CaptainHook/internal/format"
+)
+
+// One announcement, as the feeds show it.
+type FeedEntry struct {
+	ID    string    `json:"id"` // The delivery's GUID
+	Repo  string    `json:"repo,omitempty"`
+	Event string    `json:"event"`
+	Title string    `json:"title,omitempty"`
+	Text  string    `json:"text"` // Without the IRC formatting
+	URL   string    `json:"url,omitempty"`
+	Time  time.Time `json:"time"`
+
+	Channels []string `json:"channels,omitempty"` // Where it went, for !last
+}
+
+// The last so many announcements, for people who don't idle in IRC to catch
+// up with at /feed.atom and /feed.json. Optionally kept in a file, rewritten
+// in the background, so they survive restarts.
+type Feed struct {
+	size   int
+	path   string
+	logger *slog.Logger
+
+	mu      sync.Mutex
+	entries []FeedEntry // Oldest first, at most size of them
+
+	dirty chan struct{}
+}
+
+// Set up a feed of size entries, loading any kept at path. An empty path
+// means it's only kept in memory.
+func NewFeed(size int, path string, logger *slog.Logger) *Feed {
+	f := &Feed{size: size, path: path, logger: logger}
+	if path == "" {
+		return f
+	}
+	if err := f.load(); err != nil && !os.IsNotExist(err) {
+		logger.Error("Error reading feed file, starting the feed afresh", "err", err)
+	}
+	f.dirty = make(chan struct{}, 1)
+	go f.run()
+	return f
+}
+
+func (f *Feed) load() error {
+	file, err := os.Open(f.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var e FeedEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil || e.ID == "" {
+			continue // Most likely a line cut short by a crash
+		}
+		f.entries = append(f.entries, e)
+	}
+	if len(f.entries) > f.size {
+		f.entries = f.entries[len(f.entries)-f.size:]
+	}
+	return scanner.Err()
+}
+
+// Add an announcement, dropping the oldest if the feed's full. Test messages
+// and the like, without a delivery ID, aren't worth keeping, and neither are
+// private repos' unless FeedPrivate says so. Safe to call on a nil Feed.
+func (f *Feed) Add(a Announcement) {
+	if f == nil || a.ID == "" || (a.Private && !config.CurrentConfig().FeedPrivate) {
+		return
+	}
+	e := FeedEntry{
+		ID:    a.ID,
+		Repo:  format.StripFormatting(a.Repo),
+		Event: a.Event,
+		Title: format.StripFormatting(a.Title),
+		Text:  format.StripFormatting(a.Text),
+		URL:   a.URL,
+		Time:  time.Now().UTC(),
+
+		Channels: a.Channels,
+	}
+	f.mu.Lock()
+	for _, old := range f.entries {
+		if old.ID == e.ID {
+			f.mu.Unlock()
+			return // Redelivered
+		}
+	}
+	f.entries = append(f.entries, e)
+	if len(f.entries) > f.size {
+		f.entries = append(f.entries[:0:0], f.entries[len(f.entries)-f.size:]...)
+	}
+	f.mu.Unlock()
+	if f.dirty != nil {
+		select {
+		case f.dirty <- struct{}{}:
+		default: // Already due a write
+		}
+	}
+}
+
+// Newest first.
+func (f *Feed) Entries() []FeedEntry {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	entries := make([]FeedEntry, len(f.entries))
+	for i, e := range f.entries {
+		entries[len(entries)-1-i] = e
+	}
+	return entries
+}
+
+// Rewrite the file whenever something's been added, at most once a second.
+func (f *Feed) run() {
+	for range f.dirty {
+		f.save()
+		time.Sleep(time.Second)
+	}
+}
+
+func (f *Feed) save() {
+	f.mu.Lock()
+	entries := append([]FeedEntry(nil), f.entries...)
+	f.mu.Unlock()
+	tmp := f.path + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		f.logger.Error("Error writing feed file", "err", err)
+		return
+	}
+	w := bufio.NewWriter(file)
+	enc := json.NewEncoder(w)
+	for _, e := range entries {
+		if err = enc.Encode(e); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, f.path)
+	}
+	if err != nil {
+		f.logger.Error("Error writing feed file", "err", err)
+		os.Remove(tmp)
+	}
+}
+
+type AtomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Author  string      `xml:"author>name"`
+	Entries []AtomEntry `xml:"entry"`
+}
+
+type AtomEntry struct {
+	ID         string         `xml:"id"`
+	Title      string         `xml:"title"`
+	Updated    string         `xml:"updated"`
+	Link       *AtomLink      `xml:"link,omitempty"`
+	Categories []AtomCategory `xml:"category"`
+	Content    AtomContent    `xml:"content"`
+}
+
+type AtomLink struct {
+	Href string `xml:"href,attr"`
+}
+
+type AtomCategory struct {
+	Term  string `xml:"term,attr"`
+	Label string `xml:"label,attr,omitempty"`
+}
+
+type AtomContent struct {
+	Type string `xml:"type,attr"`
+	Text string `xml:",chardata"`
+}
+
+// The newest n entries that went to channel, newest first, only repo's if
+// repo isn't "". repo can be owner/name or just the name.
+func (f *Feed) Last(n int, channel, repo string) []FeedEntry {
+	var last []FeedEntry
+	for _, e := range f.Entries() {
+		if len(last) == n {
+			break
+		}
+		if !config.ContainsFold(e.Channels, channel) {
+			continue
+		}
+		if repo != "" && !strings.EqualFold(e.Repo, repo) && !strings.EqualFold(path.Base(e.Repo), path.Base(repo)) {
+			continue
+		}
+		last = append(last, e)
+	}
+	return last
+}
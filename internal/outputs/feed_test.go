@@ -0,0 +1,68 @@
+package outputs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/config"
+	"github.com/UniversityRadioYork/CaptainHook/internal/config/configtest"
+)
+
+func TestFeed(t *testing.T) {
+	config.SetConfig(configtest.Valid())
+	var nilFeed *Feed
+	nilFeed.Add(Announcement{ID: "1", Text: "hi"}) // Mustn't panic
+
+	f := NewFeed(2, "", config.DiscardLogger)
+	f.Add(Announcement{Text: "no ID"})
+	f.Add(Announcement{ID: "1", Text: "secret", Private: true})
+	for _, id := range []string{"2", "3", "3", "4"} {
+		f.Add(Announcement{ID: id, Event: "push", Repo: "\x0306website\x0F", Text: "[\x0306website\x0F] " + id})
+	}
+	entries := f.Entries()
+	if len(entries) != 2 || entries[0].ID != "4" || entries[1].ID != "3" {
+		t.Fatalf("expected the newest two, newest first, got %+v", entries)
+	}
+	if entries[0].Repo != "website" || entries[0].Text != "[website] 4" {
+		t.Errorf("expected the formatting stripped, got %+v", entries[0])
+	}
+
+	c := configtest.Valid()
+	c.FeedPrivate = true
+	config.SetConfig(c)
+	f.Add(Announcement{ID: "5", Text: "secret", Private: true})
+	if entries := f.Entries(); entries[0].ID != "5" {
+		t.Errorf("expected private announcements with FeedPrivate, got %+v", entries)
+	}
+}
+
+func TestFeedSurvivesRestart(t *testing.T) {
+	config.SetConfig(configtest.Valid())
+	path := filepath.Join(t.TempDir(), "feed.jsonl")
+	logger := config.DiscardLogger
+	f := NewFeed(10, path, logger)
+	f.Add(Announcement{ID: "1", Event: "push", Text: "one"})
+	f.Add(Announcement{ID: "2", Event: "push", Text: "two"})
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if b, err := ioutil.ReadFile(path); err == nil && strings.Count(string(b), "\n") == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("feed file never written")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("expected the temporary file gone, got %v", err)
+	}
+
+	entries := NewFeed(1, path, logger).Entries()
+	if len(entries) != 1 || entries[0].ID != "2" || entries[0].Text != "two" {
+		t.Errorf("expected the newest entry back, got %+v", entries)
+	}
+}
@@ -0,0 +1,170 @@
+package outputs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/config"
+	"github.com/UniversityRadioYork/CaptainHook/internal/format"
+)
+
+// Sends announcements to Matrix rooms as notices, using the client-server API
+// with an access token for the bot's account.
+type MatrixOutput struct {
+	Homeserver string // e.g. https://matrix.org
+	Token      string
+	MaxWait    time.Duration // Longest we'll wait when rate limited, rather than give up
+
+	client *http.Client
+	txnID  string // Unique to this run, plus a counter per message
+	txns   uint64
+	sleep  func(time.Duration)
+}
+
+func NewMatrixOutput(homeserver, token string) *MatrixOutput {
+	return &MatrixOutput{
+		Homeserver: strings.TrimSuffix(homeserver, "/"),
+		Token:      token,
+		MaxWait:    30 * time.Second,
+		client:     &http.Client{Timeout: 10 * time.Second},
+		txnID:      strconv.FormatInt(time.Now().UnixNano(), 36),
+		sleep:      time.Sleep,
+	}
+}
+
+// Send msg to the room in its target (matrix:!room:server), as HTML. The same
+// transaction ID is used if we have to try again, so the homeserver won't
+// post it twice.
+func (o *MatrixOutput) Announce(ctx context.Context, msg Message) error {
+	room := strings.TrimPrefix(msg.Target, config.MatrixPrefix)
+	slog.Debug("Sending to Matrix", "room", room, "text", msg.Plain)
+	body, _ := json.Marshal(map[string]string{
+		"msgtype":        "m.notice",
+		"body":           msg.Plain,
+		"format":         "org.matrix.custom.html",
+		"formatted_body": msg.HTML,
+	})
+	txn := o.txnID + "." + strconv.FormatUint(atomic.AddUint64(&o.txns, 1), 10)
+	u := o.Homeserver + "/_matrix/client/v3/rooms/" + url.PathEscape(room) + "/send/m.room.message/" + txn
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPut, u, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+o.Token)
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := o.client.Do(req)
+		if err != nil {
+			return err
+		}
+		reply, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusOK {
+			return nil
+		}
+		var merr struct {
+			ErrCode      string
+			Error        string
+			RetryAfterMs int64 `json:"retry_after_ms"`
+		}
+		json.Unmarshal(reply, &merr)
+		if resp.StatusCode != http.StatusTooManyRequests {
+			return fmt.Errorf("matrix returned %s: %s %s", resp.Status, merr.ErrCode, merr.Error)
+		}
+		wait := time.Duration(merr.RetryAfterMs) * time.Millisecond
+		if attempt >= 3 || wait > o.MaxWait {
+			return fmt.Errorf("matrix is rate limiting us, giving up after %d tries (asked to wait %v)", attempt+1, wait)
+		}
+		o.sleep(wait)
+	}
+}
+
+// The mIRC colours as HTML, for Matrix.
+var mircHex = map[string]string{
+	"00": "#ffffff", "01": "#000000", "02": "#00007f", "03": "#009300",
+	"04": "#ff0000", "05": "#7f0000", "06": "#9c009c", "07": "#fc7f00",
+	"08": "#ffff00", "09": "#00fc00", "10": "#009393", "11": "#00ffff",
+	"12": "#0000fc", "13": "#ff00ff", "14": "#7f7f7f", "15": "#d2d2d2",
+}
+
+// Turn IRC formatting into HTML: colours into <font color>, and bold, italic
+// and underline into their tags. Everything else is escaped.
+func IRCToHTML(s string) string {
+	var out strings.Builder
+	var color string
+	var bold, italic, underline bool
+	open := ""     // Closing tags for what's open, innermost first
+	dirty := false // Whether the tags need changing before the next text
+	// Close everything, then open it again as it now is. Simpler than
+	// keeping track of how they nest.
+	restyle := func() {
+		out.WriteString(open)
+		open = ""
+		if color != "" {
+			out.WriteString(`<font color="` + color + `">`)
+			open = "</font>" + open
+		}
+		for _, tag := range []struct {
+			on   bool
+			name string
+		}{{bold, "b"}, {italic, "i"}, {underline, "u"}} {
+			if tag.on {
+				out.WriteString("<" + tag.name + ">")
+				open = "</" + tag.name + ">" + open
+			}
+		}
+	}
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\x03':
+			code := format.Formatting.FindString(s[i:])
+			color = ""
+			if fg := strings.SplitN(code[1:], ",", 2)[0]; fg != "" {
+				if len(fg) == 1 {
+					fg = "0" + fg
+				}
+				color = mircHex[fg]
+			}
+			i += len(code) - 1
+		case '\x02':
+			bold = !bold
+		case '\x1D':
+			italic = !italic
+		case '\x1F':
+			underline = !underline
+		case '\x0F':
+			color, bold, italic, underline = "", false, false, false
+		case '\x16':
+			continue // Reverse, which HTML has no good way to do
+		case '\n':
+			out.WriteString("<br>")
+			continue
+		default:
+			j := i
+			for j < len(s) && !strings.ContainsRune("\x03\x02\x1D\x1F\x0F\x16\n", rune(s[j])) {
+				j++
+			}
+			if dirty {
+				restyle()
+				dirty = false
+			}
+			out.WriteString(html.EscapeString(s[i:j]))
+			i = j - 1
+			continue
+		}
+		dirty = true
+	}
+	out.WriteString(open)
+	return out.String()
+}
@@ -0,0 +1,77 @@
+package outputs
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/format"
+)
+
+func TestIRCToHTML(t *testing.T) {
+	for _, c := range []struct{ in, want string }{
+		{"plain <text> & that", "plain &lt;text&gt; &amp; that"},
+		{"[" + format.IrcColorize("website", format.ColorPurple) + "] done", `[<font color="#9c009c">website</font>] done`},
+		{"\x02bold\x02 \x1Ditalic\x1D \x1Funder\x0F", "<b>bold</b> <i>italic</i> <u>under</u>"},
+		{"\x0304,01red\x03 \x034also red", `<font color="#ff0000">red</font> <font color="#ff0000">also red</font>`},
+		{"\x02\x0303both\x0F", `<font color="#009300"><b>both</b></font>`},
+		{"\x0399nope", "nope"},
+		{"two\n\x02lines\x02", "two<br><b>lines</b>"},
+	} {
+		if got := IRCToHTML(c.in); got != c.want {
+			t.Errorf("%q: expected %q, got %q", c.in, c.want, got)
+		}
+	}
+}
+
+func TestMatrixOutput(t *testing.T) {
+	var paths []string
+	limited := 1
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.EscapedPath())
+		if r.Method != http.MethodPut || r.Header.Get("Authorization") != "Bearer tok" {
+			t.Errorf("unexpected %s with %q", r.Method, r.Header.Get("Authorization"))
+		}
+		var msg map[string]string
+		json.NewDecoder(r.Body).Decode(&msg)
+		if msg["msgtype"] != "m.notice" || msg["body"] != "[website] hi" || msg["formatted_body"] != `[<font color="#9c009c">website</font>] hi` {
+			t.Errorf("unexpected message %v", msg)
+		}
+		if limited > 0 {
+			limited--
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte(`{"errcode":"M_LIMIT_EXCEEDED","error":"Too many requests","retry_after_ms":1500}`))
+			return
+		}
+		w.Write([]byte(`{"event_id":"$abc"}`))
+	}))
+	defer srv.Close()
+
+	o := NewMatrixOutput(srv.URL+"/", "tok")
+	var waited time.Duration
+	o.sleep = func(d time.Duration) { waited += d }
+	text := "[" + format.IrcColorize("website", format.ColorPurple) + "] hi"
+	if err := o.Announce(context.Background(), testMessage("matrix:!room:example.org", Announcement{Text: text})); err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) != 2 || paths[0] != paths[1] || !strings.HasPrefix(paths[0], "/_matrix/client/v3/rooms/%21room:example.org/send/m.room.message/") {
+		t.Errorf("expected the same transaction retried, got %q", paths)
+	}
+	if waited != 1500*time.Millisecond {
+		t.Errorf("expected to wait as asked, waited %v", waited)
+	}
+
+	o.Announce(context.Background(), testMessage("matrix:!room:example.org", Announcement{Text: text}))
+	if len(paths) != 3 || paths[2] == paths[0] {
+		t.Errorf("expected a new transaction for a new message, got %q", paths)
+	}
+
+	limited = 10
+	if err := o.Announce(context.Background(), testMessage("matrix:!room:example.org", Announcement{Text: text})); err == nil || !strings.Contains(err.Error(), "rate limiting") {
+		t.Errorf("expected to give up eventually, got %v", err)
+	}
+}
@@ -0,0 +1,304 @@
+package outputs
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/config"
+	"github.com/UniversityRadioYork/CaptainHook/internal/format"
+)
+
+// How long a connection can sit idle before the broker may have dropped it,
+// after which we reconnect rather than find out the hard way.
+const mqttKeepAlive = 60 * time.Second
+
+// What gets published for each announcement: the event itself, for
+// things like signage to lay out their own way.
+type mqttPayload struct {
+	ID     string    `json:"id,omitempty"`
+	Event  string    `json:"event"`
+	Action string    `json:"action,omitempty"`
+	Repo   string    `json:"repo,omitempty"` // owner/name, where there is one
+	Label  string    `json:"label,omitempty"`
+	Sender string    `json:"sender,omitempty"`
+	Number int       `json:"number,omitempty"`
+	Title  string    `json:"title,omitempty"`
+	URL    string    `json:"url,omitempty"`
+	Text   string    `json:"text"`
+	Time   time.Time `json:"time"`
+}
+
+// Publishes announcements to an MQTT broker, speaking just enough MQTT 3.1.1
+// to do so. It connects when there's something to send, and again whenever
+// the connection breaks; Broadcaster queues and retries.
+type MQTTOutput struct {
+	Broker   *url.URL // tcp://host:1883, or ssl:// or mqtts:// for TLS
+	Username string
+	Password string
+	ClientID string
+	Topic    string // With {owner}, {repo}, {event} and {action} filled in
+	QoS      byte
+	Retain   bool
+
+	mu       sync.Mutex
+	conn     net.Conn
+	r        *bufio.Reader
+	lastUsed time.Time
+	packetID uint16
+}
+
+func NewMQTTOutput(c *config.Config) (*MQTTOutput, error) {
+	u, err := url.Parse(c.MQTTBroker)
+	if err != nil {
+		return nil, err
+	}
+	return &MQTTOutput{
+		Broker:   u,
+		Username: c.MQTTUsername,
+		Password: c.MQTTPassword,
+		ClientID: c.MQTTClientID,
+		Topic:    c.MQTTTopic,
+		QoS:      byte(c.MQTTQoS),
+		Retain:   c.MQTTRetain,
+	}, nil
+}
+
+// Publish msg, once, connecting first if need be. Any error drops the
+// connection, so the retry starts afresh.
+func (o *MQTTOutput) Announce(ctx context.Context, msg Message) error {
+	body, _ := json.Marshal(mqttPayload{
+		ID:     msg.ID,
+		Event:  msg.Event,
+		Action: msg.Action,
+		Repo:   msg.FullName,
+		Label:  format.StripFormatting(msg.Repo),
+		Sender: msg.Sender,
+		Number: msg.Number,
+		Title:  format.StripFormatting(msg.Title),
+		URL:    msg.URL,
+		Text:   msg.Plain,
+		Time:   time.Now().UTC(),
+	})
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.conn != nil && time.Since(o.lastUsed) > mqttKeepAlive {
+		o.disconnect()
+	}
+	if o.conn == nil {
+		if err := o.connect(ctx); err != nil {
+			o.disconnect()
+			return retryLater(0, err)
+		}
+	}
+	if err := o.publish(mqttTopic(o.Topic, msg.Announcement), body); err != nil {
+		o.disconnect()
+		return retryLater(0, err)
+	}
+	o.lastUsed = time.Now()
+	return nil
+}
+
+func (o *MQTTOutput) connect(ctx context.Context) error {
+	host := o.Broker.Host
+	secure := o.Broker.Scheme == "ssl" || o.Broker.Scheme == "mqtts" || o.Broker.Scheme == "tls"
+	if o.Broker.Port() == "" {
+		if secure {
+			host = net.JoinHostPort(host, "8883")
+		} else {
+			host = net.JoinHostPort(host, "1883")
+		}
+	}
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	var err error
+	if secure {
+		o.conn, err = (&tls.Dialer{NetDialer: dialer}).DialContext(ctx, "tcp", host)
+	} else {
+		o.conn, err = dialer.DialContext(ctx, "tcp", host)
+	}
+	if err != nil {
+		return err
+	}
+	o.r = bufio.NewReader(o.conn)
+
+	flags := byte(0x02) // Clean session
+	payload := mqttString(o.ClientID)
+	if o.Username != "" {
+		flags |= 0x80
+		payload = append(payload, mqttString(o.Username)...)
+		if o.Password != "" {
+			flags |= 0x40
+			payload = append(payload, mqttString(o.Password)...)
+		}
+	}
+	body := append(mqttString("MQTT"), 4, flags)
+	body = append(body, mqttUint16(int(mqttKeepAlive/time.Second))...)
+	if err := o.send(0x10, append(body, payload...)); err != nil {
+		return err
+	}
+	reply, err := o.expect(0x20)
+	if err != nil {
+		return err
+	}
+	if len(reply) < 2 || reply[1] != 0 {
+		return fmt.Errorf("broker refused connection: %s", mqttRefusal(reply))
+	}
+	return nil
+}
+
+// Publish, waiting for the broker to acknowledge it for QoS 1 and 2.
+func (o *MQTTOutput) publish(topic string, payload []byte) error {
+	header := byte(0x30) | o.QoS<<1
+	if o.Retain {
+		header |= 0x01
+	}
+	body := mqttString(topic)
+	var id uint16
+	if o.QoS > 0 {
+		o.packetID++
+		if o.packetID == 0 {
+			o.packetID = 1
+		}
+		id = o.packetID
+		body = append(body, mqttUint16(int(id))...)
+	}
+	if err := o.send(header, append(body, payload...)); err != nil {
+		return err
+	}
+	switch o.QoS {
+	case 1:
+		_, err := o.expect(0x40)
+		return err
+	case 2:
+		if _, err := o.expect(0x50); err != nil {
+			return err
+		}
+		if err := o.send(0x62, mqttUint16(int(id))); err != nil {
+			return err
+		}
+		_, err := o.expect(0x70)
+		return err
+	}
+	return nil
+}
+
+// Write a packet: its header byte, the length, then the rest.
+func (o *MQTTOutput) send(header byte, body []byte) error {
+	packet := []byte{header}
+	n := len(body)
+	for {
+		b := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			b |= 0x80
+		}
+		packet = append(packet, b)
+		if n == 0 {
+			break
+		}
+	}
+	o.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	_, err := o.conn.Write(append(packet, body...))
+	return err
+}
+
+// Read packets until one of the given type, returning its body.
+func (o *MQTTOutput) expect(header byte) ([]byte, error) {
+	o.conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	for {
+		h, err := o.r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		n, shift := 0, 0
+		for {
+			b, err := o.r.ReadByte()
+			if err != nil {
+				return nil, err
+			}
+			n |= int(b&0x7f) << shift
+			if b&0x80 == 0 {
+				break
+			}
+			if shift += 7; shift > 21 {
+				return nil, errors.New("bad packet length from broker")
+			}
+		}
+		body := make([]byte, n)
+		if _, err := io.ReadFull(o.r, body); err != nil {
+			return nil, err
+		}
+		if h&0xf0 == header&0xf0 {
+			return body, nil
+		}
+	}
+}
+
+func (o *MQTTOutput) disconnect() {
+	if o.conn != nil {
+		o.conn.Close()
+	}
+	o.conn, o.r = nil, nil
+}
+
+func mqttUint16(n int) []byte {
+	return []byte{byte(n >> 8), byte(n)}
+}
+
+func mqttString(s string) []byte {
+	return append(mqttUint16(len(s)), s...)
+}
+
+func mqttRefusal(connack []byte) string {
+	if len(connack) < 2 {
+		return "bad CONNACK"
+	}
+	switch connack[1] {
+	case 1:
+		return "unacceptable protocol version"
+	case 2:
+		return "client ID rejected"
+	case 3:
+		return "server unavailable"
+	case 4:
+		return "bad username or password"
+	case 5:
+		return "not authorised"
+	}
+	return fmt.Sprintf("code %d", connack[1])
+}
+
+// The topic for an announcement, from a template like
+// capthook/{owner}/{repo}/{event}. Each part becomes one level, with
+// anything that'd mean something else to MQTT swapped for _.
+func mqttTopic(template string, a Announcement) string {
+	owner, repo := "", format.StripFormatting(a.Repo)
+	if a.FullName != "" {
+		owner, repo = path.Dir(a.FullName), path.Base(a.FullName)
+	}
+	return strings.NewReplacer(
+		"{owner}", mqttLevel(owner),
+		"{repo}", mqttLevel(repo),
+		"{event}", mqttLevel(a.Event),
+		"{action}", mqttLevel(a.Action),
+	).Replace(template)
+}
+
+var mqttUnsafe = strings.NewReplacer("/", "_", "+", "_", "#", "_", "\x00", "_")
+
+func mqttLevel(s string) string {
+	if s == "" || s == "." {
+		return "_"
+	}
+	return mqttUnsafe.Replace(s)
+}
@@ -0,0 +1,152 @@
+package outputs
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/url"
+	"testing"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/config"
+)
+
+// A packet as the fake broker saw it.
+type mqttPacket struct {
+	header byte
+	body   []byte
+}
+
+func readMQTTPacket(r *bufio.Reader) (mqttPacket, error) {
+	h, err := r.ReadByte()
+	if err != nil {
+		return mqttPacket{}, err
+	}
+	n, shift := 0, 0
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return mqttPacket{}, err
+		}
+		n |= int(b&0x7f) << shift
+		shift += 7
+		if b&0x80 == 0 {
+			break
+		}
+	}
+	body := make([]byte, n)
+	_, err = io.ReadFull(r, body)
+	return mqttPacket{h, body}, err
+}
+
+// Accepts connections, acknowledging CONNECTs and QoS 1 PUBLISHes, and
+// passing on what gets published. Each connection is hung up on after
+// hangUpAfter publishes.
+func fakeMQTTBroker(t *testing.T, hangUpAfter int) (string, <-chan mqttPacket, <-chan mqttPacket) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	connects, publishes := make(chan mqttPacket, 10), make(chan mqttPacket, 10)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				for n := 0; n < hangUpAfter; {
+					p, err := readMQTTPacket(r)
+					if err != nil {
+						return
+					}
+					switch p.header & 0xf0 {
+					case 0x10:
+						connects <- p
+						conn.Write([]byte{0x20, 2, 0, 0})
+					case 0x30:
+						n++
+						publishes <- p
+						if p.header&0x06 == 0x02 {
+							topicLen := int(p.body[0])<<8 | int(p.body[1])
+							id := p.body[2+topicLen : 4+topicLen]
+							conn.Write([]byte{0x40, 2, id[0], id[1]})
+						}
+					}
+				}
+			}()
+		}
+	}()
+	return ln.Addr().String(), connects, publishes
+}
+
+func TestMQTTOutput(t *testing.T) {
+	addr, connects, publishes := fakeMQTTBroker(t, 1)
+	u, _ := url.Parse("tcp://" + addr)
+	o := &MQTTOutput{Broker: u, ClientID: "capthook", Username: "ury", Password: "sekrit", Topic: "capthook/{owner}/{repo}/{event}", QoS: 1, Retain: true}
+	a := Announcement{
+		ID: "abc", Event: "pull_request", Action: "merged", Repo: "\x0306website\x0F", FullName: "UniversityRadioYork/website",
+		Sender: "LordAlex", Number: 7, Title: "Fix it", URL: "https://github.com/UniversityRadioYork/website/pull/7",
+		Text: "[\x0306website\x0F] LordAlex merged #7",
+	}
+	// The broker hangs up after each publish, so the second has to connect
+	// again; the first attempt at it may fail, which the queue would retry.
+	for i := 0; i < 2; i++ {
+		if err := o.Announce(context.Background(), testMessage(config.MQTTTarget, a)); err != nil {
+			if err = o.Announce(context.Background(), testMessage(config.MQTTTarget, a)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		c := <-connects
+		if c.body[7]&0xc2 != 0xc2 {
+			t.Errorf("expected a clean session with a username and password, got flags %x", c.body[7])
+		}
+		p := <-publishes
+		if p.header != 0x33 {
+			t.Errorf("expected a retained QoS 1 publish, got header %x", p.header)
+		}
+		topicLen := int(p.body[0])<<8 | int(p.body[1])
+		if topic := string(p.body[2 : 2+topicLen]); topic != "capthook/UniversityRadioYork/website/pull_request" {
+			t.Errorf("unexpected topic %q", topic)
+		}
+		var payload mqttPayload
+		if err := json.Unmarshal(p.body[4+topicLen:], &payload); err != nil {
+			t.Fatal(err)
+		}
+		if payload.Repo != "UniversityRadioYork/website" || payload.Label != "website" || payload.Action != "merged" ||
+			payload.Number != 7 || payload.Text != "[website] LordAlex merged #7" || payload.Sender != "LordAlex" {
+			t.Errorf("unexpected payload %+v", payload)
+		}
+	}
+}
+
+func TestMQTTOutputDown(t *testing.T) {
+	ln, _ := net.Listen("tcp", "127.0.0.1:0")
+	addr := ln.Addr().String()
+	ln.Close()
+	u, _ := url.Parse("tcp://" + addr)
+	o := &MQTTOutput{Broker: u, ClientID: "capthook", Topic: "capthook"}
+	err := o.Announce(context.Background(), testMessage(config.MQTTTarget, Announcement{Event: "push"}))
+	if _, ok := err.(*retryableError); !ok {
+		t.Errorf("expected a retryable error, got %v", err)
+	}
+}
+
+func TestMQTTTopic(t *testing.T) {
+	for _, test := range []struct {
+		a    Announcement
+		want string
+	}{
+		{Announcement{Event: "push", FullName: "UniversityRadioYork/website"}, "capthook/UniversityRadioYork/website/push/_"},
+		{Announcement{Event: "issues", Action: "opened", FullName: "ury/sub/group#1"}, "capthook/ury_sub/group_1/issues/opened"},
+		{Announcement{Event: "build", Action: "failed", Repo: "\x02jenkins\x02"}, "capthook/_/jenkins/build/failed"},
+	} {
+		if got := mqttTopic("capthook/{owner}/{repo}/{event}/{action}", test.a); got != test.want {
+			t.Errorf("expected %q, got %q", test.want, got)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+// Package outputs has the places other than IRC that announcements go:
+// Matrix, Slack, Discord, Telegram and MQTT, plus the feed and the event log.
+package outputs
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/config"
+	"github.com/UniversityRadioYork/CaptainHook/internal/format"
+)
+
+// Somewhere announcements go: IRC, Matrix, Slack, Discord, Telegram, MQTT,
+// or a file for a dry run. Announcers pick whichever of the message's renderings
+// suits them. Anything that might take a while should watch ctx.
+type Announcer interface {
+	Announce(ctx context.Context, msg Message) error
+}
+
+// An announcement on its way to one target, rendered every way an Announcer
+// might want it.
+type Message struct {
+	Announcement        // What it's about, with Text in IRC formatting
+	Target       string // One of Channels, or one that gets everything
+	Plain        string // Text without the formatting
+	HTML         string // Text with the formatting as HTML
+}
+
+// What Slack, Discord and Telegram are sent.
+const contentTypeJSON = "application/json"
+
+// The messages for an announcement, one per target. Brief channels get it
+// without the extras, and channels with a PrefixStyle get the repo their way.
+func NewMessages(a Announcement, targets []string) []Message {
+	conf := config.CurrentConfig()
+	rendered := make(map[string]Message) // By text, so each way it reads is only rendered once
+	msgs := make([]Message, len(targets))
+	for i, t := range targets {
+		v := a
+		if a.Age != "" && conf.Brief(t) {
+			v.Text = strings.Replace(v.Text, a.Age, "", 1)
+		}
+		v.Text = restylePrefix(v, conf.PrefixStyle(t))
+		m, ok := rendered[v.Text]
+		if !ok {
+			m = Message{Announcement: v, Plain: format.StripFormatting(v.Text), HTML: IRCToHTML(v.Text)}
+			rendered[v.Text] = m
+		}
+		m.Target = t
+		msgs[i] = m
+	}
+	return msgs
+}
+
+// Writes announcements out a line at a time as "#channel text", with the
+// formatting codes spelled out so they can be read.
+type DryRunOutput struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewDryRunOutput(w io.Writer) *DryRunOutput {
+	return &DryRunOutput{w: w}
+}
+
+func (o *DryRunOutput) Announce(ctx context.Context, msg Message) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for _, line := range strings.Split(msg.Text, "\n") {
+		if _, err := fmt.Fprintln(o.w, msg.Target+" "+ShowFormatting(line)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Spell out IRC formatting codes, e.g. %C04 for red and %O for reset.
+var formattingNames = strings.NewReplacer(
+	"\x03", "%C",
+	"\x02", "%B",
+	"\x0F", "%O",
+	"\x16", "%R",
+	"\x1D", "%I",
+	"\x1F", "%U",
+)
+
+func ShowFormatting(s string) string {
+	return formattingNames.Replace(s)
+}
+
+// Where a dry run writes to: stdout, or the end of file.
+func OpenDryRun(file string) (*os.File, error) {
+	if file == "" || file == "-" {
+		return os.Stdout, nil
+	}
+	return os.OpenFile(file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+}
+
+// Hands each message to the Announcer for its target's kind: channels to
+// IRC, matrix:!room to Matrix and so on. Apart from IRC, which is up to
+// whoever sets it, they're queued, each target on its own, so one that's down
+// or slow can't hold up the rest.
+type Broadcaster struct {
+	IRC    Announcer
+	Others map[string]Announcer // By kind, e.g. "matrix"
+}
+
+func (b *Broadcaster) Announce(ctx context.Context, msg Message) error {
+	kind := config.TargetKind(msg.Target)
+	if kind == "" {
+		return b.IRC.Announce(ctx, msg)
+	}
+	if a, ok := b.Others[kind]; ok {
+		return a.Announce(ctx, msg)
+	}
+	return fmt.Errorf("%s isn't set up", kind)
+}
+
+// Add an Announcer for targets like kind:..., behind a queue per target that
+// sends until ctx is done.
+func (b *Broadcaster) Add(ctx context.Context, kind string, a Announcer, interval time.Duration, logger *slog.Logger) {
+	if b.Others == nil {
+		b.Others = make(map[string]Announcer)
+	}
+	q := NewQueuedAnnouncer(ctx, a, logger)
+	q.Interval = interval
+	b.Others[kind] = q
+}
+
+// Wait for the queued outputs to send everything they've been given, or
+// until ctx is done.
+func (b *Broadcaster) Drain(ctx context.Context) error {
+	all := []Announcer{b.IRC}
+	for _, a := range b.Others {
+		all = append(all, a)
+	}
+	for _, a := range all {
+		if q, ok := a.(*QueuedAnnouncer); ok {
+			if err := q.Drain(ctx); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// A message for the broadcast loop, along with where it should go.
+type Announcement struct {
+	Channels []string
+	Text     string
+	Event    string // For logging, the GitHub event type and repo
+	Repo     string
+	FullName string           // The repo as owner/name, where there is one
+	Title    string           // The issue, PR etc.'s, for outputs that show it apart from Text
+	URL      string           // Its link, unshortened
+	Color    format.MIRCColor // The action's, for outputs that colour messages their own way
+	Priority bool             // Goes out whatever the channel's schedule
+	ID       string           // The delivery's, for the feed and event log
+	Private  bool             // From a private repo
+	Action   string           // The rest are for the event log
+	Sender   string
+	Number   int
+	Time     time.Time // When it was made, for how late it is if it was held over a restart
+	Age      string    // The end of Text that says how long it was open, for channels that would rather not
+	Prefix   string    // The start of Text naming the repo, like "[website] ", for channels that want it another way
+}
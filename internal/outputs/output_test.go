@@ -0,0 +1,32 @@
+package outputs
+
+import (
+	"testing"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/config"
+	"github.com/UniversityRadioYork/CaptainHook/internal/config/configtest"
+	"github.com/UniversityRadioYork/CaptainHook/internal/format"
+)
+
+func TestNewMessages(t *testing.T) {
+	a := Announcement{Text: "[" + format.IrcColorize("website", format.ColorPurple) + "] <hi>", Title: "hi"}
+	msgs := NewMessages(a, []string{"#a", "matrix:!b:example.org"})
+	if len(msgs) != 2 || msgs[1].Target != "matrix:!b:example.org" || msgs[1].Title != "hi" {
+		t.Fatalf("unexpected messages %+v", msgs)
+	}
+	if m := msgs[0]; m.Text != a.Text || m.Plain != "[website] <hi>" || m.HTML != `[<font color="#9c009c">website</font>] &lt;hi&gt;` {
+		t.Errorf("unexpected renderings %+v", m)
+	}
+}
+
+func TestNewMessagesBrief(t *testing.T) {
+	c := configtest.Valid()
+	c.ChannelSettings = map[string]config.ChannelConfig{"#Brief": {Brief: true}}
+	config.SetConfig(c)
+	defer config.SetConfig(configtest.Valid())
+	a := Announcement{Text: "closed https://example.org/1 (after 2d4h) (delayed)", Age: " (after 2d4h)"}
+	msgs := NewMessages(a, []string{"#a", "#brief"})
+	if msgs[0].Text != a.Text || msgs[1].Text != "closed https://example.org/1 (delayed)" || msgs[1].Plain != msgs[1].Text {
+		t.Errorf("expected only #brief to lose the age, got %q and %q", msgs[0].Text, msgs[1].Text)
+	}
+}
@@ -0,0 +1,208 @@
+package outputs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/metrics"
+	"github.com/UniversityRadioYork/CaptainHook/internal/panics"
+)
+
+// An error from an Announcer that's worth trying again, after wait if the
+// service said how long to leave it.
+type retryableError struct {
+	err  error
+	wait time.Duration
+}
+
+func (e *retryableError) Error() string { return e.err.Error() }
+
+func retryLater(wait time.Duration, err error) error {
+	return &retryableError{err: err, wait: wait}
+}
+
+// Announcers that have to split some messages up to send them, like
+// Telegram with long ones.
+type splitter interface {
+	Split(msg Message) []Message
+}
+
+// Puts an Announcer behind a queue per target, sending in the background so a
+// slow or broken target can't hold up anything else, and retrying retryable
+// errors with backoff. Sending gives up once the context it was made with is
+// done.
+type QueuedAnnouncer struct {
+	Announcer
+	Retries  int           // Attempts per message after the first
+	Backoff  time.Duration // Wait before the first retry, doubling each time
+	Interval time.Duration // Least time between messages to a target, for services that limit them
+
+	ctx    context.Context
+	logger *slog.Logger
+	mu     sync.Mutex
+	queues map[string]*outputQueue // By target, made as they're needed
+}
+
+func NewQueuedAnnouncer(ctx context.Context, a Announcer, logger *slog.Logger) *QueuedAnnouncer {
+	return &QueuedAnnouncer{
+		Announcer: a,
+		Retries:   5,
+		Backoff:   time.Second,
+		ctx:       ctx,
+		logger:    logger,
+		queues:    make(map[string]*outputQueue),
+	}
+}
+
+// Queue msg for its target without blocking, dropping it if the queue's full.
+func (a *QueuedAnnouncer) Announce(ctx context.Context, msg Message) error {
+	q := a.queue(msg.Target)
+	msgs := []Message{msg}
+	if s, ok := a.Announcer.(splitter); ok {
+		msgs = s.Split(msg)
+	}
+	for _, m := range msgs {
+		if err := q.Push(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Wait until everything queued so far has been sent or given up on, or ctx is
+// done.
+func (a *QueuedAnnouncer) Drain(ctx context.Context) error {
+	a.mu.Lock()
+	queues := make([]*outputQueue, 0, len(a.queues))
+	for _, q := range a.queues {
+		queues = append(queues, q)
+	}
+	a.mu.Unlock()
+	for _, q := range queues {
+		if err := q.Drain(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// The queue for a target, started the first time it's needed.
+func (a *QueuedAnnouncer) queue(target string) *outputQueue {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	q, ok := a.queues[target]
+	if !ok {
+		q = newOutputQueue(target, a.Announcer, a.logger)
+		q.Retries, q.Backoff, q.Interval = a.Retries, a.Backoff, a.Interval
+		q.Run(a.ctx)
+		a.queues[target] = q
+	}
+	return q
+}
+
+// Messages waiting to go to one target, sent one at a time.
+type outputQueue struct {
+	Name     string // The target, e.g. slack:committee, for logs and metrics
+	Retries  int
+	Backoff  time.Duration
+	Interval time.Duration
+
+	queue   chan Message
+	pending int64 // Queued or being sent
+	out     Announcer
+	ready   time.Time // Don't send anything before this
+	logger  *slog.Logger
+	sleep   func(time.Duration)
+}
+
+func newOutputQueue(name string, out Announcer, logger *slog.Logger) *outputQueue {
+	return &outputQueue{
+		Name:    name,
+		Retries: 5,
+		Backoff: time.Second,
+		queue:   make(chan Message, 100),
+		out:     out,
+		logger:  logger,
+		sleep:   time.Sleep,
+	}
+}
+
+// Start sending in the background, until ctx is done.
+func (q *outputQueue) Run(ctx context.Context) {
+	go func() {
+		for msg := range q.queue {
+			q.deliver(ctx, msg)
+			atomic.AddInt64(&q.pending, -1)
+		}
+	}()
+}
+
+// Queue a message without blocking, dropping it if the queue's full.
+func (q *outputQueue) Push(msg Message) error {
+	atomic.AddInt64(&q.pending, 1)
+	select {
+	case q.queue <- msg:
+		return nil
+	default:
+		atomic.AddInt64(&q.pending, -1)
+		metrics.OutputMessages.WithLabelValues(q.Name, "dropped").Inc()
+		return fmt.Errorf("queue for %s is full, dropped %s announcement", q.Name, msg.Event)
+	}
+}
+
+// Wait until there's nothing queued or being sent, or ctx is done.
+func (q *outputQueue) Drain(ctx context.Context) error {
+	tick := time.NewTicker(50 * time.Millisecond)
+	defer tick.Stop()
+	for atomic.LoadInt64(&q.pending) > 0 {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%d announcements still queued for %s: %v", atomic.LoadInt64(&q.pending), q.Name, ctx.Err())
+		case <-tick.C:
+		}
+	}
+	return nil
+}
+
+// Try to get a message through, backing off between attempts. The target
+// asking us to wait goes over our own backoff.
+func (q *outputQueue) deliver(ctx context.Context, msg Message) {
+	defer panics.Recover("output", q.logger.With("target", q.Name))
+	if d := time.Until(q.ready); d > 0 {
+		q.sleep(d)
+	}
+	backoff := q.Backoff
+	var err error
+	for attempt := 0; attempt <= q.Retries; attempt++ {
+		if attempt > 0 {
+			metrics.OutputRetries.WithLabelValues(q.Name).Inc()
+			q.sleep(backoff)
+			backoff *= 2
+		}
+		err = q.out.Announce(ctx, msg)
+		wait := q.Interval
+		var retry *retryableError
+		retryable := errors.As(err, &retry)
+		if retryable && retry.wait > wait {
+			wait = retry.wait
+		}
+		q.ready = time.Now().Add(wait)
+		if err == nil || !retryable || ctx.Err() != nil {
+			break
+		}
+		if wait > backoff {
+			backoff = wait
+		}
+	}
+	if err != nil {
+		q.logger.Error("Error sending announcement", "event", msg.Event, "delivery", msg.ID, "repo", msg.FullName, "target", q.Name, "err", err)
+		metrics.OutputMessages.WithLabelValues(q.Name, "failed").Inc()
+		return
+	}
+	metrics.OutputMessages.WithLabelValues(q.Name, "sent").Inc()
+}
@@ -0,0 +1,98 @@
+package outputs
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/config"
+)
+
+// Fails for one target until told otherwise, and hangs for another.
+type flakyOutput struct {
+	mu       sync.Mutex
+	attempts map[string]int
+	sent     []string
+	fails    int // How many times to fail first
+	hung     chan struct{}
+}
+
+func (o *flakyOutput) Announce(ctx context.Context, msg Message) error {
+	if msg.Target == "x:hung" {
+		<-o.hung
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.attempts[msg.Target]++
+	switch {
+	case msg.Target == "x:broken":
+		return errors.New("not worth trying again")
+	case o.attempts[msg.Target] <= o.fails:
+		return retryLater(0, errors.New("try again"))
+	}
+	o.sent = append(o.sent, msg.Target+" "+msg.Plain)
+	return nil
+}
+
+// Split on "|".
+func (o *flakyOutput) Split(msg Message) []Message {
+	var parts []Message
+	for _, p := range strings.Split(msg.Plain, "|") {
+		part := msg
+		part.Plain = p
+		parts = append(parts, part)
+	}
+	return parts
+}
+
+func TestQueuedAnnouncer(t *testing.T) {
+	out := &flakyOutput{attempts: make(map[string]int), fails: 2, hung: make(chan struct{})}
+	defer close(out.hung)
+	q := NewQueuedAnnouncer(context.Background(), out, config.DiscardLogger)
+	q.Backoff = time.Millisecond
+	for _, target := range []string{"x:hung", "x:broken", "x:ok"} {
+		if err := q.Announce(context.Background(), testMessage(target, Announcement{Text: "one|two"})); err != nil {
+			t.Fatal(err)
+		}
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		out.mu.Lock()
+		sent, attempts := strings.Join(out.sent, ","), out.attempts["x:broken"]
+		out.mu.Unlock()
+		if sent == "x:ok one,x:ok two" && attempts == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected x:ok to get both parts in order despite the others, got %q and %d tries of x:broken", sent, attempts)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestQueuedAnnouncerDrain(t *testing.T) {
+	out := &flakyOutput{attempts: make(map[string]int), hung: make(chan struct{})}
+	q := NewQueuedAnnouncer(context.Background(), out, config.DiscardLogger)
+	for _, target := range []string{"x:ok", "x:hung"} {
+		if err := q.Announce(context.Background(), testMessage(target, Announcement{Text: "one|two"})); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := q.Drain(ctx); err == nil || !strings.Contains(err.Error(), "2 announcements still queued for x:hung") {
+		t.Errorf("expected x:hung to hold up draining, got %v", err)
+	}
+	close(out.hung)
+	if err := q.Drain(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	out.mu.Lock()
+	defer out.mu.Unlock()
+	if len(out.sent) != 4 {
+		t.Errorf("expected everything sent once drained, got %q", out.sent)
+	}
+}
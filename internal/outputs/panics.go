@@ -0,0 +1,21 @@
+package outputs
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/panics"
+)
+
+// Send msg, turning a panic in the Announcer into an error, so the message's
+// other targets still get it.
+func SafeAnnounce(ctx context.Context, out Announcer, msg Message, logger *slog.Logger) (err error) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			panics.Log("broadcast", rec, logger)
+			err = fmt.Errorf("panicked: %v", rec)
+		}
+	}()
+	return out.Announce(ctx, msg)
+}
@@ -0,0 +1,19 @@
+package outputs
+
+import (
+	"context"
+	"testing"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/config"
+)
+
+func TestOutputQueuePanic(t *testing.T) {
+	out := &panickyOutput{target: "slack:a"}
+	q := newOutputQueue("slack:a", out, config.DiscardLogger)
+	q.deliver(context.Background(), Message{Target: "slack:a"})
+	out.target = ""
+	q.deliver(context.Background(), Message{Target: "slack:a"})
+	if len(out.sent) != 1 {
+		t.Errorf("expected the queue to carry on after a panic, got %v", out.sent)
+	}
+}
@@ -0,0 +1,56 @@
+package outputs
+
+import (
+	"strings"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/config"
+	"github.com/UniversityRadioYork/CaptainHook/internal/format"
+)
+
+// The start of text that names repo the way the default templates do, with
+// the space after it, or "" if it doesn't start that way.
+func RepoPrefix(text, repo string) string {
+	for _, p := range []string{
+		"[" + format.IrcColorize(repo, format.ColorPurple) + "] ",
+		"[" + repo + "] ", // Colours are off
+	} {
+		if repo != "" && strings.HasPrefix(text, p) {
+			return p
+		}
+	}
+	return ""
+}
+
+// a's Text with its Prefix in style instead.
+func restylePrefix(a Announcement, style string) string {
+	if a.Prefix == "" || style == "" || style == config.PrefixRepo || !strings.HasPrefix(a.Text, a.Prefix) {
+		return a.Text
+	}
+	colored := strings.Contains(a.Prefix, "\x03")
+	name := func(s string) string {
+		if colored {
+			return format.IrcColorize(s, format.ColorPurple)
+		}
+		return s
+	}
+	full := a.FullName
+	if full == "" {
+		full = a.Repo
+	}
+	var prefix string
+	switch style {
+	case config.PrefixNone:
+	case config.PrefixOwnerRepo:
+		prefix = "[" + name(full) + "]"
+	default:
+		owner := ""
+		if i := strings.LastIndex(full, "/"); i >= 0 {
+			owner = full[:i]
+		}
+		prefix = strings.NewReplacer("{owner}", name(owner), "{repo}", name(a.Repo)).Replace(style)
+	}
+	if prefix != "" {
+		prefix += " "
+	}
+	return prefix + strings.TrimPrefix(a.Text, a.Prefix)
+}
@@ -0,0 +1,63 @@
+package outputs
+
+import (
+	"testing"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/config"
+	"github.com/UniversityRadioYork/CaptainHook/internal/config/configtest"
+	"github.com/UniversityRadioYork/CaptainHook/internal/format"
+)
+
+func TestRestylePrefix(t *testing.T) {
+	colored := "[" + format.IrcColorize("website", format.ColorPurple) + "] "
+	a := Announcement{Text: colored + "Issue #1 opened\nmore", Repo: "website", FullName: "UniversityRadioYork/website"}
+	a.Prefix = RepoPrefix(a.Text, a.Repo)
+	if a.Prefix != colored {
+		t.Fatalf("expected the prefix found, got %q", a.Prefix)
+	}
+	plain := Announcement{Text: "[website] Issue #1 opened", Repo: "website", FullName: "UniversityRadioYork/website"}
+	plain.Prefix = RepoPrefix(plain.Text, plain.Repo)
+	for _, c := range []struct {
+		a     Announcement
+		style string
+		want  string // With the formatting spelled out, see ShowFormatting
+	}{
+		{a, "", "[%C06website%O] Issue #1 opened\nmore"},
+		{a, config.PrefixRepo, "[%C06website%O] Issue #1 opened\nmore"},
+		{a, config.PrefixOwnerRepo, "[%C06UniversityRadioYork/website%O] Issue #1 opened\nmore"},
+		{a, config.PrefixNone, "Issue #1 opened\nmore"},
+		{a, "{repo}:", "%C06website%O: Issue #1 opened\nmore"},
+		{plain, "{owner}/{repo}:", "UniversityRadioYork/website: Issue #1 opened"},
+		{plain, config.PrefixOwnerRepo, "[UniversityRadioYork/website] Issue #1 opened"},
+		{Announcement{Text: "x1tot created playout", Repo: "playout"}, config.PrefixNone, "x1tot created playout"},
+	} {
+		if got := ShowFormatting(restylePrefix(c.a, c.style)); got != c.want {
+			t.Errorf("%q: expected %q, got %q", c.style, c.want, got)
+		}
+	}
+}
+
+func TestRepoPrefix(t *testing.T) {
+	for text, want := range map[string]string{
+		"[website] Issue":                "[website] ",
+		"[websites] Issue":               "",
+		"x1tot created website":          "",
+		"[\x0306jenkins\x0f] website #1": "",
+	} {
+		if got := RepoPrefix(text, "website"); got != want {
+			t.Errorf("%q: expected %q, got %q", text, want, got)
+		}
+	}
+}
+
+func TestNewMessagesPrefixStyle(t *testing.T) {
+	c := configtest.Valid()
+	c.ChannelSettings = map[string]config.ChannelConfig{"#studio": {PrefixStyle: config.PrefixNone, Brief: true}}
+	config.SetConfig(c)
+	defer config.SetConfig(configtest.Valid())
+	a := Announcement{Text: "[website] Issue #1 closed (after 2d)", Repo: "website", Prefix: "[website] ", Age: " (after 2d)"}
+	msgs := NewMessages(a, []string{"#a", "#Studio"})
+	if msgs[0].Text != a.Text || msgs[1].Text != "Issue #1 closed" || msgs[1].Plain != "Issue #1 closed" {
+		t.Errorf("expected only #studio to lose the prefix and age, got %q and %q", msgs[0].Text, msgs[1].Text)
+	}
+}
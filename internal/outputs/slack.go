@@ -0,0 +1,94 @@
+package outputs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/config"
+	"github.com/UniversityRadioYork/CaptainHook/internal/format"
+)
+
+// Posts announcements to Slack webhooks. Broadcaster queues them.
+type SlackOutput struct {
+	client  *http.Client
+	targets map[string]config.SlackTarget // By lower case name
+}
+
+func NewSlackOutput(targets []config.SlackTarget) *SlackOutput {
+	o := &SlackOutput{
+		client:  &http.Client{Timeout: 10 * time.Second},
+		targets: make(map[string]config.SlackTarget),
+	}
+	for _, t := range targets {
+		o.targets[strings.ToLower(t.Name)] = t
+	}
+	return o
+}
+
+// Post msg to the webhook in its target (slack:name), once.
+func (o *SlackOutput) Announce(ctx context.Context, msg Message) error {
+	t, ok := o.targets[strings.ToLower(strings.TrimPrefix(msg.Target, config.SlackPrefix))]
+	if !ok {
+		return fmt.Errorf("no Slack webhook called %q", strings.TrimPrefix(msg.Target, config.SlackPrefix))
+	}
+	body, _ := json.Marshal(slackMessage(msg, t.Channel))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentTypeJSON)
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return retryLater(0, err)
+	}
+	reply, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		return nil
+	case resp.StatusCode == http.StatusTooManyRequests:
+		secs, _ := strconv.Atoi(resp.Header.Get("Retry-After"))
+		return retryLater(time.Duration(secs)*time.Second, fmt.Errorf("rate limited"))
+	case resp.StatusCode >= 500:
+		return retryLater(0, fmt.Errorf("got %s", resp.Status))
+	}
+	// Anything else (invalid_payload, no_service...) won't go away by itself.
+	return fmt.Errorf("got %s: %s", resp.Status, format.Truncate(string(reply), 100))
+}
+
+// Turn an announcement into Block Kit: the repo as context, then the title
+// linking to the URL, with the announcement itself under it. Text is the
+// plain version, for notifications.
+func slackMessage(msg Message, channel string) map[string]interface{} {
+	text := msg.Plain
+	section := slackEscape(text)
+	if msg.Title != "" && msg.URL != "" {
+		section = "*<" + msg.URL + "|" + slackEscape(msg.Title) + ">*\n" + section
+	}
+	blocks := []interface{}{}
+	if msg.Repo != "" {
+		blocks = append(blocks, map[string]interface{}{
+			"type":     "context",
+			"elements": []interface{}{map[string]string{"type": "mrkdwn", "text": "*" + slackEscape(format.StripFormatting(msg.Repo)) + "*"}},
+		})
+	}
+	blocks = append(blocks, map[string]interface{}{
+		"type": "section",
+		"text": map[string]string{"type": "mrkdwn", "text": section},
+	})
+	payload := map[string]interface{}{"text": text, "blocks": blocks}
+	if channel != "" {
+		payload["channel"] = channel
+	}
+	return payload
+}
+
+// Escape what Slack's mrkdwn treats as markup.
+var slackEscape = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace
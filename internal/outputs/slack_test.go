@@ -0,0 +1,90 @@
+package outputs
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/config"
+	"github.com/UniversityRadioYork/CaptainHook/internal/format"
+)
+
+func TestSlackMessage(t *testing.T) {
+	a := Announcement{
+		Text:  "[" + format.IrcColorize("website", format.ColorPurple) + "] bob \x02opened\x02 #1: Fix <b> & co https://s/1",
+		Repo:  "website",
+		Title: "Fix <b> & co",
+		URL:   "https://github.com/ury/website/pull/1",
+	}
+	b, _ := json.Marshal(slackMessage(testMessage("slack:committee", a), "#committee"))
+	var msg struct {
+		Text    string
+		Channel string
+		Blocks  []struct {
+			Type     string
+			Text     struct{ Text string }
+			Elements []struct{ Text string }
+		}
+	}
+	json.Unmarshal(b, &msg)
+	if strings.ContainsAny(string(b), "\x02\x03\x0F") {
+		t.Errorf("IRC formatting leaked through: %s", b)
+	}
+	if msg.Channel != "#committee" || msg.Text != "[website] bob opened #1: Fix <b> & co https://s/1" {
+		t.Errorf("unexpected channel or fallback text: %+v", msg)
+	}
+	if len(msg.Blocks) != 2 || msg.Blocks[0].Type != "context" || msg.Blocks[0].Elements[0].Text != "*website*" {
+		t.Fatalf("expected the repo as context, got %s", b)
+	}
+	if want := "*<https://github.com/ury/website/pull/1|Fix &lt;b&gt; &amp; co>*\n"; !strings.HasPrefix(msg.Blocks[1].Text.Text, want) {
+		t.Errorf("expected the title linked, got %q", msg.Blocks[1].Text.Text)
+	}
+}
+
+func TestSlackOutput(t *testing.T) {
+	var hits int32
+	status := int32(http.StatusOK)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&hits, 1)
+		switch {
+		case n == 1:
+			w.Header().Set("Retry-After", "2")
+			w.WriteHeader(http.StatusTooManyRequests)
+		case n == 2:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			w.WriteHeader(int(atomic.LoadInt32(&status)))
+		}
+	}))
+	defer srv.Close()
+
+	o := NewSlackOutput([]config.SlackTarget{{Name: "Committee", URL: srv.URL}})
+	q := newOutputQueue("slack:committee", o, config.DiscardLogger)
+	var waits []time.Duration
+	q.Backoff, q.Retries, q.sleep = time.Millisecond, 3, func(d time.Duration) { waits = append(waits, d) }
+	msg := testMessage("slack:committee", Announcement{Text: "hi"})
+	q.deliver(context.Background(), msg)
+	if n := atomic.LoadInt32(&hits); n != 3 {
+		t.Errorf("expected 3 attempts, got %d", n)
+	}
+	if len(waits) != 2 || waits[0] != 2*time.Second || waits[1] != 4*time.Second {
+		t.Errorf("expected to wait as long as asked, then back off from there, got %v", waits)
+	}
+
+	// Nothing to gain from trying a bad webhook again.
+	atomic.StoreInt32(&status, http.StatusNotFound)
+	atomic.StoreInt32(&hits, 2)
+	q.deliver(context.Background(), msg)
+	if n := atomic.LoadInt32(&hits); n != 3 {
+		t.Errorf("expected one attempt at a 404, got %d", n-2)
+	}
+
+	if err := o.Announce(context.Background(), testMessage("slack:nope", Announcement{})); err == nil {
+		t.Error("expected an unknown webhook to be an error")
+	}
+}
@@ -0,0 +1,166 @@
+package outputs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/config"
+	"github.com/UniversityRadioYork/CaptainHook/internal/format"
+)
+
+// Telegram's longest message, in UTF-16 code units once the markup and
+// escaping are gone.
+const telegramMaxLength = 4096
+
+// Sends announcements to Telegram chats through the Bot API. Broadcaster
+// queues them, at most one every TelegramInterval.
+type TelegramOutput struct {
+	API   string // For tests, default https://api.telegram.org
+	Token string
+
+	client *http.Client
+}
+
+// Groups get about 20 messages a minute.
+const TelegramInterval = 3 * time.Second
+
+func NewTelegramOutput(token string) *TelegramOutput {
+	return &TelegramOutput{
+		API:    "https://api.telegram.org",
+		Token:  token,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Long messages go as several, one after the other.
+func (o *TelegramOutput) Split(msg Message) []Message {
+	return splitForTelegram(msg, telegramMaxLength)
+}
+
+// Send msg to the chat in its target (telegram:id), once.
+func (o *TelegramOutput) Announce(ctx context.Context, msg Message) error {
+	body, _ := json.Marshal(map[string]interface{}{
+		"chat_id":              strings.TrimPrefix(msg.Target, config.TelegramPrefix),
+		"text":                 telegramMessage(msg),
+		"parse_mode":           "MarkdownV2",
+		"link_preview_options": map[string]bool{"is_disabled": true},
+	})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.API+"/bot"+o.Token+"/sendMessage", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentTypeJSON)
+	resp, err := o.client.Do(req)
+	if err != nil {
+		// The URL has the token in, so keep it out of the logs.
+		return retryLater(0, fmt.Errorf("couldn't reach Telegram: %v", strings.Replace(err.Error(), o.Token, "<token>", -1)))
+	}
+	reply, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	var result struct {
+		OK          bool
+		Description string
+		Parameters  struct {
+			RetryAfter int `json:"retry_after"`
+		}
+	}
+	json.Unmarshal(reply, &result)
+	switch {
+	case resp.StatusCode == http.StatusOK && result.OK:
+		return nil
+	case resp.StatusCode == http.StatusTooManyRequests:
+		return retryLater(time.Duration(result.Parameters.RetryAfter)*time.Second, fmt.Errorf("rate limited"))
+	case resp.StatusCode >= 500:
+		return retryLater(0, fmt.Errorf("got %s", resp.Status))
+	}
+	return fmt.Errorf("got %s: %s", resp.Status, format.Truncate(result.Description, 100))
+}
+
+// An announcement in MarkdownV2: the title in bold linking to the URL, if
+// there is one, then the announcement without its IRC formatting.
+func telegramMessage(msg Message) string {
+	text := telegramEscape(msg.Plain)
+	if h := telegramHeader(msg.Announcement); h != "" {
+		return h + "\n" + text
+	}
+	return text
+}
+
+func telegramHeader(a Announcement) string {
+	if a.Title == "" || a.URL == "" {
+		return ""
+	}
+	return "*[" + telegramEscape(telegramTitle(a)) + "](" + telegramEscapeURL(a.URL) + ")*"
+}
+
+func telegramTitle(a Announcement) string {
+	return format.Truncate(format.StripFormatting(a.Title), 200)
+}
+
+// Escape everything MarkdownV2 would otherwise take as markup. Backslashes
+// need escaping too.
+var telegramEscape = strings.NewReplacer(
+	`\`, `\\`, "_", `\_`, "*", `\*`, "[", `\[`, "]", `\]`, "(", `\(`, ")", `\)`,
+	"~", `\~`, "`", "\\`", ">", `\>`, "#", `\#`, "+", `\+`, "-", `\-`, "=", `\=`,
+	"|", `\|`, "{", `\{`, "}", `\}`, ".", `\.`, "!", `\!`,
+).Replace
+
+// Inside a link's (...) only ) and \ are special.
+var telegramEscapeURL = strings.NewReplacer(`\`, `\\`, ")", `\)`).Replace
+
+// Split an announcement into ones short enough for Telegram, at a space or
+// newline where there is one. Only the first keeps the title and URL, so
+// the link isn't repeated.
+func splitForTelegram(msg Message, limit int) []Message {
+	text := msg.Plain
+	budget := limit
+	if telegramHeader(msg.Announcement) != "" {
+		budget -= utf16Len(telegramTitle(msg.Announcement)) + 1 // And the newline
+	}
+	var parts []Message
+	for {
+		cut, size, lastSpace := 0, 0, -1
+		for i, r := range text {
+			n := utf16Len(string(r))
+			if size+n > budget {
+				break
+			}
+			size += n
+			cut = i + len(string(r))
+			if r == ' ' || r == '\n' {
+				lastSpace = i
+			}
+		}
+		part := msg
+		if cut == len(text) {
+			part.Plain = text
+			return append(parts, part)
+		}
+		// Don't throw away most of a part to split at a space.
+		if lastSpace > cut/2 {
+			cut = lastSpace + 1
+		}
+		part.Plain = strings.TrimRight(text[:cut], " \n")
+		parts = append(parts, part)
+		text = text[cut:]
+		msg.Title, msg.URL, budget = "", "", limit
+	}
+}
+
+// How long s is to Telegram, which counts UTF-16 code units.
+func utf16Len(s string) int {
+	n := 0
+	for _, r := range s {
+		n++
+		if r >= 0x10000 {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,162 @@
+package outputs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/config"
+	"github.com/UniversityRadioYork/CaptainHook/internal/config/configtest"
+	"github.com/UniversityRadioYork/CaptainHook/internal/format"
+)
+
+func TestTelegramEscape(t *testing.T) {
+	for _, c := range []struct{ in, want string }{
+		{"plain words", "plain words"},
+		{`_*[]()~` + "`" + `>#+-=|{}.!`, `\_\*\[\]\(\)\~\` + "`" + `\>\#\+\-\=\|\{\}\.\!`},
+		{`back\slash`, `back\\slash`},
+		{`\_`, `\\\_`},
+		{"[website] bob opened #12: Fix the log-in page (again!)", `\[website\] bob opened \#12: Fix the log\-in page \(again\!\)`},
+		{"https://github.com/ury/web_site/pull/1?a=b", `https://github\.com/ury/web\_site/pull/1?a\=b`},
+		{"ünïcødé 🏴‍☠️ & <stuff> 'quotes' \"double\" $ % ^ ; , ? @ :", "ünïcødé 🏴‍☠️ & <stuff\\> 'quotes' \"double\" $ % ^ ; , ? @ :"},
+		{"", ""},
+	} {
+		if got := telegramEscape(c.in); got != c.want {
+			t.Errorf("%q: expected %q, got %q", c.in, c.want, got)
+		}
+	}
+	// Every reserved character, and nothing else ASCII, gets a backslash.
+	for r := rune(0x20); r < 0x7f; r++ {
+		escaped := telegramEscape(string(r)) != string(r)
+		if reserved := strings.ContainsRune("_*[]()~`>#+-=|{}.!\\", r); escaped != reserved {
+			t.Errorf("%q: expected escaped %v, got %q", r, reserved, telegramEscape(string(r)))
+		}
+	}
+	if got := telegramEscapeURL(`https://en.wikipedia.org/wiki/Radio_(disambiguation)\x`); got != `https://en.wikipedia.org/wiki/Radio_(disambiguation\)\\x` {
+		t.Errorf("unexpected URL escaping %q", got)
+	}
+}
+
+func TestTelegramMessage(t *testing.T) {
+	a := Announcement{
+		Text:  "[" + format.IrcColorize("website", format.ColorPurple) + "] bob \x02opened\x02 #1: Fix it. https://s/1",
+		Title: "Fix it.",
+		URL:   "https://github.com/ury/website/pull/1",
+	}
+	want := "*[Fix it\\.](https://github.com/ury/website/pull/1)*\n\\[website\\] bob opened \\#1: Fix it\\. https://s/1"
+	if got := telegramMessage(testMessage("telegram:1", a)); got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+	if got := telegramMessage(testMessage("telegram:1", Announcement{Text: "no title", URL: "https://x"})); got != "no title" {
+		t.Errorf("expected just the text without a title, got %q", got)
+	}
+}
+
+func TestSplitForTelegram(t *testing.T) {
+	msg := testMessage("telegram:1", Announcement{Text: "one two three four five six", Title: "T", URL: "https://x"})
+	parts := splitForTelegram(msg, 12)
+	var texts []string
+	for _, p := range parts {
+		texts = append(texts, p.Plain)
+	}
+	// The first has the title and a newline to fit in too.
+	if got := strings.Join(texts, "|"); got != "one two|three four|five six" {
+		t.Errorf("unexpected split %q", got)
+	}
+	if parts[0].Title != "T" || parts[1].Title != "" || parts[1].URL != "" {
+		t.Errorf("expected only the first part to keep the title, got %+v", parts)
+	}
+
+	// No spaces to split at, and emoji count double.
+	long := strings.Repeat("🏴", 5) + strings.Repeat("x", 10)
+	for _, p := range splitForTelegram(Message{Plain: long}, 4) {
+		if utf16Len(p.Plain) > 4 || p.Plain == "" {
+			t.Errorf("part %q too long or empty", p.Plain)
+		}
+	}
+
+	big := strings.Repeat("word. ", 2000)
+	texts = nil
+	for _, p := range splitForTelegram(Message{Plain: big}, telegramMaxLength) {
+		if n := utf16Len(p.Plain); n > telegramMaxLength {
+			t.Errorf("part of %d", n)
+		}
+		texts = append(texts, p.Plain)
+	}
+	if len(texts) != 3 || strings.Join(texts, " ") != big {
+		t.Error("expected splitting to lose nothing but the spaces split at")
+	}
+	if got := splitForTelegram(Message{Plain: "short"}, telegramMaxLength); len(got) != 1 || got[0].Plain != "short" {
+		t.Errorf("expected short messages left alone, got %+v", got)
+	}
+}
+
+func TestTelegramOutput(t *testing.T) {
+	var hits int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		if r.URL.Path != "/botsekrit/sendMessage" || body["chat_id"] != "-100123" || body["parse_mode"] != "MarkdownV2" {
+			t.Errorf("unexpected request %s %v", r.URL.Path, body)
+		}
+		switch atomic.AddInt32(&hits, 1) {
+		case 1:
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte(`{"ok":false,"error_code":429,"description":"Too Many Requests: retry after 7","parameters":{"retry_after":7}}`))
+		case 2:
+			w.Write([]byte(`{"ok":true,"result":{}}`))
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"ok":false,"error_code":400,"description":"Bad Request: can't parse entities"}`))
+		}
+	}))
+	defer srv.Close()
+
+	o := NewTelegramOutput("sekrit")
+	o.API = srv.URL
+	q := newOutputQueue("telegram:-100123", o, config.DiscardLogger)
+	var waits []time.Duration
+	q.sleep = func(d time.Duration) { waits = append(waits, d) }
+	msg := testMessage("telegram:-100123", Announcement{Text: "hi"})
+	q.deliver(context.Background(), msg)
+	if n := atomic.LoadInt32(&hits); len(waits) != 1 || waits[0] != 7*time.Second || n != 2 {
+		t.Errorf("expected one wait of 7s then success, got %v and %d tries", waits, n)
+	}
+	q.deliver(context.Background(), msg)
+	if n := atomic.LoadInt32(&hits); n != 3 {
+		t.Errorf("expected no retry on a 400, got %d tries", n-2)
+	}
+
+	srv.Close()
+	var retry *retryableError
+	if err := o.Announce(context.Background(), msg); !errors.As(err, &retry) || strings.Contains(err.Error(), "sekrit") {
+		t.Errorf("expected a retryable error without the token in, got %v", err)
+	}
+}
+
+func TestTelegramConfig(t *testing.T) {
+	c := configtest.Valid()
+	c.Channels = "#a,telegram:-1001234567890"
+	c.TelegramChats = "@urytech,telegram:42"
+	if errs := c.Validate(); len(errs) != 1 || !strings.Contains(errs[0].Error(), "TelegramToken") {
+		t.Errorf("expected the token to be needed, got %v", errs)
+	}
+	c.TelegramToken = "123:abc"
+	if errs := c.Validate(); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	if got := strings.Join(c.TelegramTargets(), ","); got != "telegram:@urytech,telegram:42" {
+		t.Errorf("unexpected chats %s", got)
+	}
+	c.Channels = "#a,telegram:ury tech"
+	c.TelegramChats = "@ury"
+	if errs := c.Validate(); len(errs) != 2 {
+		t.Errorf("expected two bad chats, got %v", errs)
+	}
+}
@@ -0,0 +1,32 @@
+// Package panics keeps one bad delivery or announcement from taking the rest
+// down with it.
+package panics
+
+import (
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/metrics"
+)
+
+// Count a panic we've recovered from, and log it with its stack. Only useful
+// from a deferred function, while the stack still goes down to the panic.
+func Log(where string, rec interface{}, logger *slog.Logger) {
+	metrics.PanicsTotal.WithLabelValues(where).Inc()
+	logger.Error("Recovered from a panic", "where", where, "panic", fmt.Sprint(rec), "stack", string(debug.Stack()))
+}
+
+// For loops that should carry on past a panic, deferred around each go
+// round, as in defer panics.Recover("worker", logger).
+func Recover(where string, logger *slog.Logger) {
+	if rec := recover(); rec != nil {
+		Log(where, rec, logger)
+	}
+}
+
+// Run one go round a loop that should outlive a panic in it.
+func Survive(where string, logger *slog.Logger, f func()) {
+	defer Recover(where, logger)
+	f()
+}
@@ -0,0 +1,80 @@
+// Package testutil has the fakes the tests share, so they don't need the
+// network or an IRC server. It doesn't use any of our packages, so their
+// own tests can use it too.
+package testutil
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/sorcix/irc"
+)
+
+// Remembers what it was asked to send, as raw lines.
+type RecordingSender struct {
+	Sent []string
+}
+
+func (s *RecordingSender) Send(m *irc.Message) error {
+	line := m.Command + " " + strings.Join(m.Params, " ")
+	if m.Trailing != "" {
+		line += " :" + m.Trailing
+	}
+	s.Sent = append(s.Sent, line)
+	return nil
+}
+
+// Shortens everything to the same URL, so tests don't need the network.
+type FakeShortener string
+
+func (s FakeShortener) Shorten(ctx context.Context, long string) (string, error) {
+	return string(s), nil
+}
+
+// Panics shortening the URLs in Shorts.
+type PanickyShortener struct {
+	mu     sync.Mutex
+	Shorts map[string]bool
+}
+
+func (s *PanickyShortener) Shorten(ctx context.Context, long string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Shorts[long] {
+		panic("injected")
+	}
+	return long, nil
+}
+
+// A GitHub API that knows one person's name, counting how often it's asked.
+func FakeUsersAPI(t *testing.T, asked *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(asked, 1)
+		if r.Header.Get("Authorization") != "Bearer tok" {
+			t.Errorf("expected the token, got %q", r.Header.Get("Authorization"))
+		}
+		switch r.URL.Path {
+		case "/users/x1tot":
+			w.Write([]byte(`{"login": "x1tot", "name": "Joe\r\nBloggs"}`))
+		case "/users/noname":
+			w.Write([]byte(`{"login": "noname", "name": null}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+// The X-Hub-Signature GitHub would send with body.
+func Sign(body, secret string) string {
+	mac := hmac.New(sha1.New, []byte(secret))
+	mac.Write([]byte(body))
+	return "sha1=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,86 @@
+// Package version says which build of CaptainHook this is.
+package version
+
+import (
+	"runtime/debug"
+	"strings"
+)
+
+// Set for release builds with
+//
+//	v=github.com/UniversityRadioYork/CaptainHook/internal/version
+//	-ldflags "-X $v.Build=1.2.0 -X $v.Revision=$(git rev-parse HEAD) -X $v.Date=$(date -u +%FT%TZ)"
+//
+// Anything left out comes from what Go recorded in the binary.
+var (
+	Build    = ""
+	Revision = ""
+	Date     = ""
+)
+
+// Which CaptainHook this is: what -version prints, and what the startup log,
+// /healthz, !status and CTCP VERSION all say.
+type Info struct {
+	Version  string `json:"version"`
+	Revision string `json:"revision,omitempty"`
+	Date     string `json:"date,omitempty"`
+	Modified bool   `json:"modified,omitempty"` // Built from a tree with changes in it
+}
+
+// Which build this is.
+func Current() Info {
+	return readBuildInfo(debug.ReadBuildInfo())
+}
+
+// Our ldflags over what Go knows: the module version if we were installed
+// with go install, and the commit and its time if built from a checkout.
+func readBuildInfo(bi *debug.BuildInfo, ok bool) Info {
+	b := Info{Version: "dev"}
+	if ok {
+		if v := bi.Main.Version; v != "" && v != "(devel)" {
+			b.Version = v
+		}
+		for _, s := range bi.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				b.Revision = s.Value
+			case "vcs.time":
+				b.Date = s.Value
+			case "vcs.modified":
+				b.Modified = s.Value == "true"
+			}
+		}
+	}
+	if Build != "" {
+		b.Version = Build
+	}
+	if Revision != "" {
+		b.Revision, b.Modified = Revision, false
+	}
+	if Date != "" {
+		b.Date = Date
+	}
+	return b
+}
+
+// Like "1.2.0 (3f2a9c1e0b7d, 2026-10-16T12:00:00Z)", with the revision
+// marked -dirty if there were changes.
+func (b Info) String() string {
+	var extra []string
+	if rev := b.Revision; rev != "" {
+		if len(rev) > 12 {
+			rev = rev[:12]
+		}
+		if b.Modified {
+			rev += "-dirty"
+		}
+		extra = append(extra, rev)
+	}
+	if b.Date != "" {
+		extra = append(extra, b.Date)
+	}
+	if len(extra) == 0 {
+		return b.Version
+	}
+	return b.Version + " (" + strings.Join(extra, ", ") + ")"
+}
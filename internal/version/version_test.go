@@ -0,0 +1,29 @@
+package version
+
+import (
+	"runtime/debug"
+	"testing"
+)
+
+func TestReadBuildInfo(t *testing.T) {
+	bi := &debug.BuildInfo{
+		Main: debug.Module{Version: "(devel)"},
+		Settings: []debug.BuildSetting{
+			{Key: "vcs.revision", Value: "3f2a9c1e0b7d5a6c8e9f"},
+			{Key: "vcs.time", Value: "2026-10-16T12:00:00Z"},
+			{Key: "vcs.modified", Value: "true"},
+		},
+	}
+	if got := readBuildInfo(bi, true).String(); got != "dev (3f2a9c1e0b7d-dirty, 2026-10-16T12:00:00Z)" {
+		t.Errorf("from a checkout: got %q", got)
+	}
+	if got := readBuildInfo(nil, false).String(); got != "dev" {
+		t.Errorf("nothing known: got %q", got)
+	}
+
+	defer func() { Build, Revision, Date = "", "", "" }()
+	Build, Revision, Date = "1.2.0", "abc123", "2026-10-17T09:00:00Z"
+	if got := readBuildInfo(bi, true).String(); got != "1.2.0 (abc123, 2026-10-17T09:00:00Z)" {
+		t.Errorf("with ldflags: got %q", got)
+	}
+}
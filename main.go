@@ -1,314 +1,559 @@
 package main
 
 import (
-	"crypto/hmac"
-	"crypto/sha1"
-	"encoding/hex"
-	"encoding/json"
+	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
-	"io/ioutil"
-	"log"
+	"log/slog"
+	"net"
 	"net/http"
-	"net/url"
 	"os"
 	"os/signal"
-	"strings"
+	"sync"
 	"syscall"
+	"time"
 
-	"github.com/koding/multiconfig"
 	"github.com/nickvanw/ircx"
 	"github.com/sorcix/irc"
-)
-
-// These are in string format as not having a leading zero can mess
-// up some clients when the string to colorize starts with a number.
-type MIRCColor string
 
-const (
-	ColorWhite      MIRCColor = "00"
-	ColorBlack                = "01"
-	ColorBlue                 = "02"
-	ColorGreen                = "03"
-	ColorRed                  = "04"
-	ColorBrown                = "05"
-	ColorPurple               = "06"
-	ColorOrange               = "07"
-	ColorYellow               = "08"
-	ColorLightGreen           = "09"
-	ColorCyan                 = "10"
-	ColorLightCyan            = "11"
-	ColorLightBlue            = "12"
-	ColorPink                 = "13"
-	ColorGrey                 = "14"
-	ColorLightGrey            = "15"
+	"github.com/UniversityRadioYork/CaptainHook/internal/config"
+	"github.com/UniversityRadioYork/CaptainHook/internal/github"
+	"github.com/UniversityRadioYork/CaptainHook/internal/github/conflicts"
+	"github.com/UniversityRadioYork/CaptainHook/internal/github/hooksync"
+	"github.com/UniversityRadioYork/CaptainHook/internal/hookserver"
+	"github.com/UniversityRadioYork/CaptainHook/internal/ircbot"
+	"github.com/UniversityRadioYork/CaptainHook/internal/metrics"
+	"github.com/UniversityRadioYork/CaptainHook/internal/outputs"
+	"github.com/UniversityRadioYork/CaptainHook/internal/panics"
+	"github.com/UniversityRadioYork/CaptainHook/internal/version"
 )
 
-// Take a string and insert irc formatting codes around it.
-func IrcColorize(in string, fg MIRCColor) string {
-	return string('\x03') + string(fg) + in + string('\x0F')
-}
-
-// Various (partial!) Github object structs, JSON is parsed into these
-
-type User struct {
-	Login string
-}
-
-type Repo struct {
-	Name    string
-	HTMLURL string `json:"html_url"`
-}
-
-type Issue struct {
-	Number  int
-	Title   string
-	HTMLURL string `json:"html_url"`
-}
-
-type PRQ struct {
-	Number  int
-	Title   string
-	HTMLURL string `json:"html_url"`
-	Merged  bool
-}
-
-type IssueEvent struct {
-	Action     string
-	Issue      Issue
-	Sender     User
-	Repository Repo
-}
-
-type PRQEvent struct {
-	Action     string
-	Sender     User
-	PRQ        PRQ `json:"pull_request"`
-	Repository Repo
-}
-
-type RepositoryEvent struct {
-	Action     string
-	Sender     User
-	Repository Repo
-}
-
-// CaptainHook's config struct
-type Config struct {
-	Channels string `required`
-	Server   string `default:"chat.freenode.net:6667"`
-	Nick     string `default:"CaptHook"`
-	Ident    string `default:"capthook"`
-	Name     string `default:"The Captain"`
-	HostPort string `default:":4665"` // HTTP listen host and port
-	Join     bool   `default:"true"`
-	GHSecret string `required` // The Github webhook secret
-}
-
-// Maps GitHub event strings (e.g. for PRQs, issues) to colors, for make
-// benefit beautiful IRC channel times/optical assault
-var act2color = map[string]MIRCColor{
-	"opened":   ColorGreen,
-	"reopened": ColorGreen,
-	"closed":   ColorRed,
-	"created":  ColorGreen,
-}
-
-// So Github's sweet small urls in their official webhook payloads are
-// available for anyone to use. Who knew? Form posts to git.io, gets a short
-// URL in a location header back. Cool.
-func ShortenGHUrl(url2shorten string) (shorturl string, err error) {
-	shorturl = url2shorten // Initially set the return url to this, in case of error
-	resp, err := http.PostForm("http://git.io", url.Values{"url": {url2shorten}})
+func main() {
+	logger := config.NewLogger(os.Stdout, "text")
+	opts, err := config.ParseArgs(os.Args[1:], os.Getenv)
 	if err != nil {
+		config.Fatal(logger, err.Error())
+	}
+	if opts.Version {
+		fmt.Println("CaptainHook " + version.Current().String())
 		return
 	}
-	if resp.StatusCode != 201 {
-		err = errors.New("git.io returned non 201 status: " + resp.Status)
+	config.Source = opts.Config
+	conf, err := config.LoadConfig()
+	if err != nil {
+		config.Fatal(logger, "Config load failed", "err", err)
+	}
+	config.SetConfig(conf)
+	config.Level.Set(conf.SlogLevel)
+	logger, logFile, err := config.OpenLogger(conf)
+	slog.SetDefault(logger)
+	if err != nil {
+		logger.Warn(err.Error())
+	}
+	if !opts.Check && !opts.SyncHooks {
+		logger.Info("Starting CaptainHook", "version", version.Current().String())
+	}
+	for _, w := range conf.Warnings {
+		logger.Warn(w)
+	}
+	if opts.Check {
+		file, _ := opts.Config.File()
+		if file == "" {
+			file = "from the environment"
+		}
+		logger.Info("Config looks OK", "file", file)
 		return
 	}
-	shorturl = resp.Header.Get("Location")
-	return
-}
-
-func CheckHMAC(message, reqMAC, key []byte) bool {
-	mac := hmac.New(sha1.New, key)
-	mac.Write(message)
-	expectedMAC := mac.Sum(nil)
-	return hmac.Equal(reqMAC, expectedMAC) // It's the return of the mac
-}
-
-var conf *Config
-
-func HandleConnected(s ircx.Sender, m *irc.Message, logger *log.Logger) {
-	logger.Println("Connected to " + conf.Server)
-	if conf.Join {
-		logger.Println("Joining " + conf.Channels)
-		for _, c := range strings.Split(conf.Channels, ",") {
-			s.Send(&irc.Message{
-				Command: irc.JOIN,
-				Params:  []string{c},
-			})
+	// Shared by everything that asks GitHub anything, so between them they
+	// stay within the one rate limit.
+	api := github.NewGitHubClient()
+	if opts.SyncHooks {
+		ctx, cancel := context.WithTimeout(context.Background(), hooksync.HookSyncTimeout)
+		sum, err := hooksync.SyncHooks(ctx, api, conf, opts.DryRun || conf.ManageHooksDryRun, logger)
+		cancel()
+		if err != nil || sum.Failed > 0 {
+			config.Fatal(logger, "Couldn't sync all the webhooks", "err", err)
 		}
+		return
 	}
-
-}
-
-func HandlePrivMsg(s ircx.Sender, m *irc.Message, logger *log.Logger) {
-	logger.Println(m)
-	/*
-		if strings.HasPrefix(m), conf.Nick+":") { // Someone mentioned us
-			var output string
-			mention := strings.TrimSpace(
-				strings.TrimPrefix(line.Text(), conf.Nick+":"))
-			switch mention {
-			case "yo", "hi", "sup", "hello", "ohai", "wb", "evening", "morning", "afternoon":
-				output = "Well met, " + line.Nick
-			case "reload", "restart", "reboot", "eat toml":
-				// Reload config
-			}
-			channel := line.Args[0]
-			logger.Println("Sending " + output + " to " + channel)
-			conn.Privmsg(channel, output)
+	// Sockets from the process we're taking over from, if this is a restart.
+	handoff, err := hookserver.TakeHandoff()
+	if err != nil {
+		config.Fatal(logger, "Unable to take over from the old process", "err", err)
+	}
+	broadcastmsgs := ircbot.NewQueue(conf.QueueSize, config.OverflowPolicy(conf.QueuePolicy), conf.QueueTimeout, logger)
+	ircbot.RegisterQueueMetrics(broadcastmsgs)
+	// The numbers for /stats carry on from the last run, and get saved once
+	// a day in case we don't get to shut down cleanly.
+	var saveStats <-chan time.Time
+	if conf.PersistStats {
+		if err := metrics.LoadStats(metrics.StatsPath(conf.StateFile)); err != nil {
+			logger.Warn("Starting stats afresh", "err", err)
 		}
-	*/
-}
-
-func main() {
-	logger := log.New(os.Stdout, "", log.Lshortfile)
-	conf = new(Config)
-	var m *multiconfig.DefaultLoader
-	if _, err := os.Stat("config.toml"); os.IsNotExist(err) {
-		m = multiconfig.New()
-	} else {
-		m = multiconfig.NewWithPath("config.toml")
+		statsTicker := time.NewTicker(24 * time.Hour)
+		defer statsTicker.Stop()
+		saveStats = statsTicker.C
 	}
-	if err := m.Load(conf); err != nil {
-		logger.Fatal("Config load failed!" + err.Error())
+	// Channels subscribed to repos, repos muted from IRC and repos that have
+	// lost their webhooks, which the config doesn't know about.
+	subs := config.NewSubscriptions("")
+	bot := ircbot.NewBot(api)
+	bot.Mutes, bot.RemovedHooks = ircbot.NewMutes(""), ircbot.NewRemovedHooks("")
+	if conf.StateFile != "" {
+		subs = config.LoadSubscriptions(config.SubscriptionsPath(conf.StateFile), logger)
+		bot.Mutes = ircbot.LoadMutes(ircbot.MutesPath(conf.StateFile), logger)
+		bot.RemovedHooks = ircbot.LoadRemovedHooks(ircbot.RemovedHooksPath(conf.StateFile), logger)
 	}
-	broadcastmsgs := make(chan string, 10)
-
-	sigs := make(chan os.Signal)
-	signal.Notify(sigs, syscall.SIGINT)
-
-	bot := ircx.Classic(conf.Server, conf.Nick)
-	if err := bot.Connect(); err != nil {
-		logger.Fatalln("Unable to dial IRC Server ", err)
+	conf.Share(api, subs, config.NewRepoRegistry())
+	// Kept for !coverage, spotting duplicates, !last and the feeds, and
+	// statistics.
+	bot.UnknownEvents = ircbot.NewUnknownEvents()
+	bot.Duplicates = ircbot.NewDuplicateFilter(ircbot.MaxRemembered)
+	if conf.FeedSize > 0 {
+		bot.Feed = outputs.NewFeed(conf.FeedSize, conf.FeedFile, logger)
 	}
+	if conf.EventLogPath != "" {
+		l, err := outputs.NewEventLog(conf.EventLogPath, conf.EventLogMaxBytes, conf.EventLogKeep, logger)
+		if err != nil {
+			logger.Error("Error opening event log, not logging events", "err", err)
+		} else {
+			bot.EventLog = l
+		}
+	}
+	// Cancelled as soon as we start shutting down, so nothing's left waiting
+	// on a shortener, a forward target or an output that's gone quiet.
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+	// The outputs other than IRC get until they've been drained at
+	// shutdown instead.
+	outputCtx, stopOutputs := context.WithCancel(context.Background())
+	defer stopOutputs()
+	work := make(chan hookserver.Delivery, conf.WorkQueueSize)
+	proc := &hookserver.Processor{
+		Feed:          bot.Feed,
+		UnknownEvents: bot.UnknownEvents,
+		RemovedHooks:  bot.RemovedHooks,
+		Conflicts:     conflicts.NewTracker(api),
+	}
+	var workers sync.WaitGroup
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+		proc.RunWorkers(ctx, conf.Workers, work, broadcastmsgs, logger)
+	}()
 
-	bot.HandleFunc(irc.RPL_WELCOME, func(s ircx.Sender, m *irc.Message) {
-		HandleConnected(s, m, logger)
-	})
-
-	bot.HandleFunc(irc.PING, func(s ircx.Sender, m *irc.Message) {
-		s.Send(&irc.Message{
-			Command:  irc.PONG,
-			Params:   m.Params,
-			Trailing: m.Trailing,
-		})
-	})
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	reloads := make(chan os.Signal, 1)
+	signal.Notify(reloads, syscall.SIGHUP)
+	if logFile != nil {
+		reopens := make(chan os.Signal, 1)
+		signal.Notify(reopens, syscall.SIGUSR1)
+		go logFile.Watch(reopens, logger)
+	}
+	restarts := make(chan os.Signal, 1)
+	signal.Notify(restarts, syscall.SIGUSR2)
 
-	go bot.HandleLoop()
+	ircState := ircbot.NewIRCState()
+	var out outputs.Announcer
+	var conn *ircx.Bot
+	if conf.DryRun || opts.DryRun {
+		w, err := outputs.OpenDryRun(conf.DryRunFile)
+		if err != nil {
+			config.Fatal(logger, "Unable to open DryRunFile", "err", err)
+		}
+		defer w.Close()
+		out = outputs.NewDryRunOutput(w)
+		ircState.Connected("dry run") // So /healthz and /test carry on as usual
+		logger.Info("Dry run, writing announcements to a file instead of IRC", "file", w.Name())
+	} else {
+		conn, err = bot.ConnectIRC(ctx, conf, ircState, broadcastmsgs, logger)
+		if err != nil {
+			config.Fatal(logger, "Unable to dial IRC server", "server", conf.Server, "err", err)
+		}
+		// Paced like the others, long lines and all, so a big push doesn't
+		// get us kicked for flooding
+		ircOut := outputs.NewQueuedAnnouncer(outputCtx, ircbot.IRCOutput{Sender: conn.Sender}, logger)
+		ircOut.Interval = ircbot.IRCInterval
+		b := &outputs.Broadcaster{IRC: ircOut}
+		if conf.MatrixHomeserver != "" {
+			b.Add(outputCtx, "matrix", outputs.NewMatrixOutput(conf.MatrixHomeserver, conf.MatrixToken), 0, logger)
+			logger.Info("Announcing to Matrix too", "homeserver", conf.MatrixHomeserver)
+		}
+		if len(conf.Slack) > 0 {
+			b.Add(outputCtx, "slack", outputs.NewSlackOutput(conf.Slack), 0, logger)
+			logger.Info("Announcing to Slack too", "webhooks", len(conf.Slack))
+		}
+		if len(conf.Discord) > 0 {
+			b.Add(outputCtx, "discord", outputs.NewDiscordOutput(conf.Discord), outputs.DiscordInterval, logger)
+			logger.Info("Announcing to Discord too", "webhooks", len(conf.Discord))
+		}
+		if conf.TelegramToken != "" {
+			b.Add(outputCtx, "telegram", outputs.NewTelegramOutput(conf.TelegramToken), outputs.TelegramInterval, logger)
+			logger.Info("Announcing to Telegram too")
+		}
+		if conf.MQTTBroker != "" {
+			m, err := outputs.NewMQTTOutput(conf)
+			if err != nil {
+				config.Fatal(logger, "Invalid MQTTBroker", "err", err)
+			}
+			b.Add(outputCtx, "mqtt", m, 0, logger)
+			logger.Info("Publishing to MQTT too", "broker", m.Broker.Host)
+		}
+		out = b
+	}
 
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		body, err := ioutil.ReadAll(r.Body)
+	in := &hookserver.Intake{Work: work, Seen: hookserver.NewDeliveryLog(conf.StateFile, conf.DeliveryRetention, logger)}
+	if conf.PayloadArchiveDir != "" {
+		a, err := hookserver.NewArchive(conf.PayloadArchiveDir, conf.ArchiveMaxBytes, conf.ArchiveMaxAge, logger)
 		if err != nil {
-			logger.Println("Error reading response body: " + err.Error())
+			logger.Error("Error setting up payload archive, not archiving", "err", err)
+		} else {
+			in.Archive = a
 		}
-		reqMAC, err := hex.DecodeString(strings.Split(r.Header.Get("X-Hub-Signature"), "=")[1])
+	}
+	if len(conf.ForwardURLs) > 0 {
+		in.Forwarder = hookserver.NewForwarder(conf.ForwardURLs, logger)
+		in.Forwarder.Run(ctx)
+	}
+	hookMux, err := hookserver.NewHookMux(conf, in, logger)
+	if err != nil {
+		config.Fatal(logger, "Unable to set up webhooks", "err", err)
+	}
+	// Swapped out for a new one when the config is reloaded.
+	routes := new(hookserver.SwappableHandler)
+	routes.Swap(hookMux)
+	var hooks http.Handler = routes
+	if conf.RestrictToGitHubIPs {
+		allow, err := hookserver.NewIPAllowlist(conf)
 		if err != nil {
-			logger.Println("Error decoding HMAC header: " + err.Error())
+			config.Fatal(logger, "Unable to set up IP allowlist", "err", err)
 		}
-		if CheckHMAC(body, reqMAC, []byte(conf.GHSecret)) {
-			if ev := r.Header.Get("X-Github-Event"); ev != "" {
-				switch ev {
-				case "pull_request":
-					var event PRQEvent
-					if err := json.Unmarshal(body, &event); err != nil {
-						logger.Println("Error unmarshalling JSON: " + err.Error())
-					}
-					switch event.Action {
-					case "opened", "closed", "reopened":
-						logger.Println(event.PRQ.HTMLURL)
-						url, err := ShortenGHUrl(event.PRQ.HTMLURL)
-						if err != nil {
-							logger.Println("Error shortening URL: " + err.Error())
-						}
-						// PRQs are a bit special -_-
-						// The PRQ has a 'merged' key instead of a merged
-						// event, so we explicitly check for that.
-						action := IrcColorize(event.Action, act2color[event.Action])
-						if event.PRQ.Merged {
-							action = IrcColorize("Merged", ColorBlue)
-						}
-						broadcastmsgs <- fmt.Sprintf("[%s] PRQ #%d %s by %s: %s. %s",
-							IrcColorize(event.Repository.Name, ColorPurple),
-							event.PRQ.Number,
-							action,
-							event.Sender.Login,
-							event.PRQ.Title,
-							url)
-					}
-				case "issues":
-					var event IssueEvent
-					if err := json.Unmarshal(body, &event); err != nil {
-						logger.Println(err)
-					}
-					switch event.Action {
-					case "opened", "closed", "reopened":
-						url, err := ShortenGHUrl(event.Issue.HTMLURL)
-						if err != nil {
-							logger.Println("Error shortening URL: " + err.Error())
-						}
-						broadcastmsgs <- fmt.Sprintf("[%s] Issue #%d %s by %s: %s. %s",
-							IrcColorize(event.Repository.Name, ColorPurple),
-							event.Issue.Number,
-							IrcColorize(event.Action, act2color[event.Action]),
-							event.Sender.Login,
-							event.Issue.Title,
-							url)
-					}
-				case "repository":
-					var event RepositoryEvent
-					if err := json.Unmarshal(body, &event); err != nil {
-						logger.Println(err)
-					}
-					switch event.Action {
-					case "created":
-						url, err := ShortenGHUrl(event.Repository.HTMLURL)
-						if err != nil {
-							logger.Println("Error shortening URL: " + err.Error())
-						}
-						broadcastmsgs <- fmt.Sprintf("%s %s %s: %s",
-							event.Sender.Login,
-							IrcColorize(event.Action, act2color[event.Action]),
-							IrcColorize(event.Repository.Name, ColorPurple),
-							url)
+		go allow.Run(6*time.Hour, logger)
+		hooks = allow.Middleware(hooks, logger)
+	}
+	go hooksync.RunHookSync(ctx, api, logger)
+	// Always in place, even if it's off, so a reload can turn it on.
+	exempt, err := config.ParseCIDRs(conf.RateLimitExempt)
+	if err != nil {
+		config.Fatal(logger, "Invalid RateLimitExempt", "err", err)
+	}
+	limiter := hookserver.NewRateLimiter(conf.RateLimit, conf.RateLimitBurst, exempt)
+	hooks = limiter.Middleware(hooks)
+	// Anything that isn't a webhook lives up here, away from the secrets and
+	// allowlisting.
+	mux := http.NewServeMux()
+	mux.Handle("/healthz", hookserver.HealthHandler(ircState, broadcastmsgs, conf.HealthGracePeriod))
+	if bot.Feed != nil {
+		mux.Handle("/feed.atom", hookserver.FeedAtomHandler(bot.Feed))
+		mux.Handle("/feed.json", hookserver.FeedJSONHandler(bot.Feed))
+	}
+	if conf.AdminToken != "" {
+		mux.Handle("/test", hookserver.TestMessageHandler(conf.AdminToken, conf.AllChannels(), ircState, broadcastmsgs, logger))
+	}
+	var metricsSrv *http.Server
+	var metricsLn, replayLn net.Listener
+	if conf.MetricsListen == "" {
+		mux.Handle("/metrics", metrics.MetricsHandler())
+		mux.Handle("/stats", hookserver.StatsHandler())
+		mux.Handle("/coverage", hookserver.CoverageHandler(bot.UnknownEvents))
+	} else {
+		metricsMux := http.NewServeMux()
+		metricsMux.Handle("/metrics", metrics.MetricsHandler())
+		metricsMux.Handle("/stats", hookserver.StatsHandler())
+		metricsMux.Handle("/coverage", hookserver.CoverageHandler(bot.UnknownEvents))
+		metricsSrv = &http.Server{
+			Addr:              conf.MetricsListen,
+			Handler:           metricsMux,
+			ReadHeaderTimeout: 10 * time.Second,
+			BaseContext:       hookserver.BaseContext(ctx),
+		}
+		if metricsLn, err = handoff.Listen("metrics", conf.MetricsListen); err != nil {
+			logger.Error("Unable to listen for metrics", "err", err)
+		} else {
+			go func() {
+				if err := metricsSrv.Serve(metricsLn); err != nil && err != http.ErrServerClosed {
+					logger.Error("Metrics listener stopped", "err", err)
+				}
+			}()
+		}
+	}
+	var replaySrv *http.Server
+	if conf.DevMode || conf.ReplayListen != "" {
+		allHooks, _ := conf.AllHooks() // Already checked by NewHookMux
+		replay := hookserver.ReplayHandler(allHooks, in.Archive, proc, broadcastmsgs, logger)
+		if conf.DevMode {
+			mux.Handle("/replay", replay)
+		}
+		if conf.ReplayListen != "" {
+			replayMux := http.NewServeMux()
+			replayMux.Handle("/replay", replay)
+			replaySrv = &http.Server{
+				Addr:              conf.ReplayListen,
+				Handler:           replayMux,
+				ReadHeaderTimeout: 10 * time.Second,
+				BaseContext:       hookserver.BaseContext(ctx),
+			}
+			if replayLn, err = handoff.Listen("replay", conf.ReplayListen); err != nil {
+				logger.Error("Unable to listen for replays", "err", err)
+			} else {
+				go func() {
+					if err := replaySrv.Serve(replayLn); err != nil && err != http.ErrServerClosed {
+						logger.Error("Replay listener stopped", "err", err)
 					}
+				}()
+			}
+		}
+	}
+	mux.Handle("/", hooks)
+	var handler http.Handler = mux
+	trusted, err := config.ParseCIDRs(conf.TrustedProxies)
+	if err != nil {
+		config.Fatal(logger, "Invalid TrustedProxies", "err", err)
+	}
+	conns := &hookserver.NewConns{}
+	srv := &http.Server{
+		Addr:              conf.HostPort,
+		Handler:           hookserver.AccessLog(hookserver.Recoverer(handler, logger), trusted, logger.With("log", "access")),
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       30 * time.Second,
+		WriteTimeout:      60 * time.Second,
+		IdleTimeout:       120 * time.Second,
+		BaseContext:       hookserver.BaseContext(ctx),
+		ConnState:         conns.ConnState,
+	}
+	ln, where := handoff.Listeners["webhooks"], "socket from the old process"
+	if ln == nil {
+		ln, where, err = hookserver.Listen(conf.HostPort, conf.SocketMode, conf.SocketGroup)
+	}
+	if err != nil {
+		config.Fatal(logger, "Unable to listen for webhooks", "err", err)
+	}
+	if conf.TLSCert != "" && conf.TLSKey != "" {
+		certs, err := hookserver.NewCertReloader(conf.TLSCert, conf.TLSKey)
+		if err != nil {
+			config.Fatal(logger, "Unable to load TLS certificate", "err", err)
+		}
+		hups := make(chan os.Signal, 1)
+		signal.Notify(hups, syscall.SIGHUP)
+		go certs.Watch(hups, logger)
+		srv.TLSConfig = &tls.Config{
+			MinVersion:     tls.VersionTLS12,
+			GetCertificate: certs.GetCertificate,
+		}
+		logger.Info("Listening for webhooks over HTTPS", "address", where, "cert", certs.Describe())
+		go func() {
+			if err := srv.ServeTLS(ln, "", ""); err != nil && err != http.ErrServerClosed && !errors.Is(err, net.ErrClosed) {
+				logger.Error("HTTPS listener stopped", "err", err)
+			}
+		}()
+	} else {
+		logger.Info("Listening for webhooks over HTTP", "address", where)
+		go func() {
+			if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed && !errors.Is(err, net.ErrClosed) {
+				logger.Error("HTTP listener stopped", "err", err)
+			}
+		}()
+	}
+	go handoff.Ready(ctx, ircState)
+	// Channels with a schedule get checked every minute for anything held
+	// back that can now go out.
+	schedule := ircbot.NewScheduler(bot.Mutes)
+	scheduleTicker := time.NewTicker(time.Minute)
+	defer scheduleTicker.Stop()
+	// Busy repos get summed up, each summary going out when its window's up.
+	digest := ircbot.NewDigester()
+	digestTicker := time.NewTicker(10 * time.Second)
+	defer digestTicker.Stop()
+	// Anything left over from before a restart goes first, once IRC's there
+	// to take it.
+	if conf.StateFile != "" {
+		if unsent := ircbot.LoadUnsent(ircbot.UnsentPath(conf.StateFile), conf.UnsentMaxAge, logger); len(unsent) > 0 {
+			if !ircState.WaitConnected(30 * time.Second) {
+				logger.Warn("IRC still isn't connected, sending left over announcements anyway")
+			}
+			logger.Info("Sending announcements left over from before the restart", "count", len(unsent))
+			for _, msg := range unsent {
+				bot.Broadcast(ctx, out, schedule.Filter(config.CurrentConfig(), msg, logger), ircState, logger)
+			}
+		}
+	}
+	// A panic in here loses the announcement it was on, not the queue, the
+	// IRC connection or anything else.
+	successor := make(chan error, 1)
+	restarting := false
+loop:
+	for {
+		select {
+		case msg := <-broadcastmsgs.C():
+			panics.Survive("broadcast", logger, func() {
+				c := config.CurrentConfig()
+				bot.Broadcast(ctx, out, digest.Filter(c, schedule.Filter(c, msg, logger)), ircState, logger)
+			})
+		case <-scheduleTicker.C:
+			panics.Survive("broadcast", logger, func() {
+				for _, msg := range schedule.Due(config.CurrentConfig()) {
+					bot.Broadcast(ctx, out, msg, ircState, logger)
 				}
+			})
+		case <-digestTicker.C:
+			panics.Survive("broadcast", logger, func() {
+				for _, msg := range digest.Due(config.CurrentConfig()) {
+					bot.Broadcast(ctx, out, msg, ircState, logger)
+				}
+			})
+		case <-saveStats:
+			if err := metrics.SaveStats(metrics.StatsPath(conf.StateFile)); err != nil {
+				logger.Error("Error saving stats", "err", err)
 			}
-		} else {
-			logger.Println("Invalid/missing HMAC in request")
+		case <-reloads:
+			logger.Info("Got SIGHUP, reloading config")
+			old, c, err := config.ReloadConfig(func(c *config.Config) error {
+				mux, err := hookserver.NewHookMux(c, in, logger)
+				if err != nil {
+					return err
+				}
+				exempt, err := config.ParseCIDRs(c.RateLimitExempt)
+				if err != nil {
+					return fmt.Errorf("invalid RateLimitExempt: %v", err)
+				}
+				routes.Swap(mux)
+				limiter.SetLimits(c.RateLimit, c.RateLimitBurst, exempt)
+				config.Level.Set(c.SlogLevel)
+				return nil
+			}, logger)
+			if err != nil {
+				logger.Error("Error reloading config, keeping the old one", "err", err)
+				continue
+			}
+			if conn != nil && c.Join && ircState.Status().Connected {
+				ircbot.JoinChannels(conn.Sender, old.IRCChannels(), c.IRCChannels(), logger)
+			}
+		case <-restarts:
+			if restarting {
+				logger.Warn("Got SIGUSR2, but already restarting")
+				continue
+			}
+			logger.Info("Got SIGUSR2, starting a new process to take over")
+			restarting = true
+			go func() {
+				successor <- hookserver.StartSuccessor(hookserver.SuccessorCommand(), map[string]net.Listener{
+					"webhooks": ln, "metrics": metricsLn, "replay": replayLn,
+				}, config.CurrentConfig().RestartTimeout, logger)
+			}()
+		case err := <-successor:
+			restarting = false
+			if err != nil {
+				logger.Error("Restart failed, carrying on", "err", err)
+				continue
+			}
+			logger.Info("New process is ready, handing over to it")
+			break loop
+		case sig := <-sigs:
+			logger.Info("Shutting down", "signal", sig.String())
+			break loop
 		}
+	}
+	go hookserver.ForceExit(sigs, logger, os.Exit)
+	// Stop waiting on anything slow first, then stop taking
+	// deliveries, letting any in flight finish and get their 202,
+	// then flush everything through to IRC and the other outputs.
+	// Whatever can't go out, because IRC's down or we're out of
+	// time, is kept for next time if there's a StateFile.
+	stop()
+	sd := hookserver.NewShutdown(logger)
+	sd.Phase("http", conf.ShutdownTimeout, func(ctx context.Context) error {
+		err := hookserver.ShutdownGently(ctx, srv, ln, conns)
+		if metricsSrv != nil {
+			metricsSrv.Shutdown(ctx)
+		}
+		if replaySrv != nil {
+			replaySrv.Shutdown(ctx)
+		}
+		in.Seen.Close()
+		in.Archive.Close()
+		return err
 	})
-	go http.ListenAndServe(conf.HostPort, nil)
-	for {
+	sd.Phase("workers", conf.ShutdownWorkersTimeout, func(ctx context.Context) error {
+		close(work)
+		done := make(chan struct{})
+		go func() {
+			workers.Wait()
+			close(done)
+		}()
 		select {
-		case msg := <-broadcastmsgs:
-			fmt.Println("Sending: " + msg)
-			for _, c := range strings.Split(conf.Channels, ",") {
-				bot.Sender.Send(&irc.Message{
-					Command:  irc.NOTICE,
-					Params:   []string{c},
-					Trailing: msg,
-				})
+		case <-done:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+	var unsent []outputs.Announcement
+	sd.Phase("drain", conf.ShutdownDrainTimeout, func(ctx context.Context) error {
+		for drained := false; !drained; {
+			select {
+			case msg := <-broadcastmsgs.C():
+				if ctx.Err() != nil || !ircState.Status().Connected {
+					unsent = append(unsent, msg)
+					continue
+				}
+				c := config.CurrentConfig()
+				bot.Broadcast(ctx, out, digest.Filter(c, schedule.Filter(c, msg, logger)), ircState, logger)
+			default:
+				drained = true
+			}
+		}
+		for _, msg := range digest.Flush() {
+			if ctx.Err() != nil || !ircState.Status().Connected {
+				unsent = append(unsent, msg)
+				continue
+			}
+			bot.Broadcast(ctx, out, msg, ircState, logger)
+		}
+		if b, ok := out.(*outputs.Broadcaster); ok {
+			if err := b.Drain(ctx); err != nil {
+				stopOutputs()
+				return err
+			}
+		}
+		if ctx.Err() != nil {
+			return fmt.Errorf("ran out of time, %d announcements not sent: %v", len(unsent), ctx.Err())
+		}
+		return nil
+	})
+	sd.Phase("save", 0, func(ctx context.Context) error {
+		bot.EventLog.Close()
+		if conf.PersistStats {
+			if err := metrics.SaveStats(metrics.StatsPath(conf.StateFile)); err != nil {
+				logger.Error("Error saving stats", "err", err)
 			}
-		case <-sigs:
-			logger.Println("Sending quit")
-			bot.Sender.Send(&irc.Message{
+		}
+		unsent = append(unsent, schedule.Held()...)
+		if conf.StateFile == "" || conf.UnsentMaxAge == 0 {
+			if len(unsent) > 0 {
+				return fmt.Errorf("dropping %d announcements that haven't gone out", len(unsent))
+			}
+		} else if err := ircbot.SaveUnsent(ircbot.UnsentPath(conf.StateFile), unsent); err != nil {
+			return fmt.Errorf("saving %d unsent announcements: %v", len(unsent), err)
+		} else if len(unsent) > 0 {
+			logger.Info("Saved announcements that haven't gone out for next time", "count", len(unsent))
+		}
+		return nil
+	})
+	if conn != nil && ircState.Status().Connected {
+		sd.Phase("quit", conf.ShutdownQuitTimeout, func(ctx context.Context) error {
+			logger.Info("Sending quit")
+			conn.Sender.Send(&irc.Message{
 				Command:  irc.QUIT,
 				Trailing: "RIP in pepparoni",
 			})
-		}
+			return ircState.WaitDisconnected(ctx)
+		})
+	}
+	// Deferred closes are skipped by os.Exit, but there's nothing
+	// left in them that matters by now.
+	if code := sd.ExitCode(); code != hookserver.ExitClean {
+		os.Exit(code)
 	}
 }
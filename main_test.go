@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/UniversityRadioYork/CaptainHook/internal/config"
+	"github.com/UniversityRadioYork/CaptainHook/internal/config/configtest"
+	"github.com/UniversityRadioYork/CaptainHook/internal/hookserver"
+	"github.com/UniversityRadioYork/CaptainHook/internal/ircbot"
+	"github.com/UniversityRadioYork/CaptainHook/internal/outputs"
+	"github.com/UniversityRadioYork/CaptainHook/internal/testutil"
+)
+
+// Fixture deliveries, through the webhook handler, workers, queue and
+// Broadcast as main wires them up, to exactly what goes to IRC.
+func TestEndToEnd(t *testing.T) {
+	c := configtest.Valid()
+	c.Channels = "#ury-dev,#ury-ops"
+	c.ChannelSettings = map[string]config.ChannelConfig{"#ury-ops": {Brief: true}}
+	config.SetConfig(c)
+	logger := config.DiscardLogger
+	work := make(chan hookserver.Delivery, 10)
+	mux, err := hookserver.NewHookMux(c, &hookserver.Intake{Work: work}, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgs := ircbot.NewQueue(10, config.DropNewest, 0, logger)
+
+	for _, d := range []struct {
+		event, file, contentType string
+	}{
+		{"issues", "issues_opened.json", "application/json"},
+		{"pull_request", "pull_request_merged.json", "application/json"},
+		{"repository", "repository_created.json", "application/json"},
+		{"issues", "issues_opened.form", "application/x-www-form-urlencoded"},
+		{"star", "repository_created.json", "application/json"}, // Not announced
+	} {
+		body, err := ioutil.ReadFile(filepath.Join("internal", "hookserver", "testdata", d.file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(string(body)))
+		req.Header.Set("Content-Type", d.contentType)
+		req.Header.Set("X-GitHub-Event", d.event)
+		req.Header.Set("X-Hub-Signature", testutil.Sign(string(body), c.GHSecret))
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		if w.Code != http.StatusAccepted {
+			t.Fatalf("%s: expected 202, got %d %s", d.file, w.Code, w.Body)
+		}
+	}
+	close(work)
+	new(hookserver.Processor).RunWorkers(context.Background(), 1, work, msgs, logger) // One, so they stay in order across repos
+
+	s := &testutil.RecordingSender{}
+	out := &outputs.Broadcaster{IRC: ircbot.IRCOutput{Sender: s}}
+	for msgs.Stats().Depth > 0 {
+		new(ircbot.Bot).Broadcast(context.Background(), out, <-msgs.C(), ircbot.NewIRCState(), logger)
+	}
+	issue := ":[\x0306website\x0f] Issue #42 \x0303opened\x0f by x1tot: Stream relay drops out every hour. https://github.com/UniversityRadioYork/website/issues/42"
+	pr := ":[\x0306website\x0f] PRQ #97 \x0302Merged\x0f by LordAlex: Add the new studio to the schedule page. https://github.com/UniversityRadioYork/website/pull/97"
+	age := " (after 2d4h)" // Only for #ury-dev, #ury-ops is brief
+	end := "\x0f"
+	repo := ":x1tot \x0303created\x0f \x0306playout\x0f: https://github.com/UniversityRadioYork/playout"
+	want := []string{
+		"NOTICE #ury-dev " + issue + end, "NOTICE #ury-ops " + issue + end,
+		"NOTICE #ury-dev " + pr + age + end, "NOTICE #ury-ops " + pr + end,
+		"NOTICE #ury-dev " + repo + end, "NOTICE #ury-ops " + repo + end,
+		"NOTICE #ury-dev " + issue + end, "NOTICE #ury-ops " + issue + end,
+	}
+	if got := strings.Join(s.Sent, "\n"); got != strings.Join(want, "\n") {
+		t.Errorf("expected:\n%q\ngot:\n%q", want, s.Sent)
+	}
+}